@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+type contextKey int
+
+const (
+	userIdContextKey contextKey = iota
+	// accessLogContextKey keeps *accessLog of request
+	accessLogContextKey
+)
+
+// newToken generates random API token. Only its hash is stored, so token is shown to user once.
+func newToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// hashToken returns hash of token that is stored in database.
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// withUserId returns copy of request with authenticated user id in context.
+// The id is added to logger of request and recorded for access log.
+func withUserId(r *http.Request, userId int64) *http.Request {
+	return r.WithContext(withUserIdContext(r.Context(), userId))
+}
+
+// withUserIdContext is withUserId for context, gRPC calls use it directly.
+func withUserIdContext(ctx context.Context, userId int64) context.Context {
+	ctx = context.WithValue(ctx, userIdContextKey, userId)
+	if logger := storage.LoggerFromContext(ctx); logger != nil {
+		ctx = storage.WithLogger(ctx, logger.WithField("user_id", userId))
+	}
+	if access, ok := ctx.Value(accessLogContextKey).(*accessLog); ok {
+		access.userId = userId
+	}
+	return ctx
+}
+
+// getUserId returns id of authenticated user. It must be used only in handlers wrapped with authenticate.
+func getUserId(r *http.Request) int64 {
+	return r.Context().Value(userIdContextKey).(int64)
+}
+
+// requestToken extracts token from "Authorization: Bearer <token>" header.
+func requestToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	return ""
+}
+
+// streamToken is requestToken that also accepts "token" query parameter, because browsers can't set headers
+// for WebSocket and EventSource. Only stream routes accept it, so tokens don't get to urls of other requests.
+func streamToken(r *http.Request) string {
+	if token := requestToken(r); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("token")
+}
+
+// authenticate is middleware that resolves user by token from header and responds with
+// http.StatusUnauthorized if token is missing or unknown.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return s.authenticateBy(requestToken, next)
+}
+
+// authenticateStream is authenticate for stream routes, where token can be passed in query as well.
+func (s *Server) authenticateStream(next http.Handler) http.Handler {
+	return s.authenticateBy(streamToken, next)
+}
+
+func (s *Server) authenticateBy(tokenOf func(r *http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := tokenOf(r)
+		if token == "" {
+			s.respondWithError(w, r, nil, errUnauthorized)
+			return
+		}
+		// streams and long polling aren't limited by limitDuration
+		ctx, cancel := s.storageContext(r.Context(), 0)
+		defer cancel()
+		userId, err := s.Storage.GetUserIdByToken(ctx, hashToken(token))
+		if errors.Is(err, storage.ErrNotFound) {
+			s.respondWithError(w, r, nil, errUnauthorized)
+			return
+		}
+		if err != nil {
+			s.respondWithStorageError(w, r, nil, fmt.Errorf("GetUserIdByToken failed: %w", err))
+			return
+		}
+		next.ServeHTTP(w, withUserId(r, userId))
+	})
+}
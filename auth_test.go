@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+func TestAuthenticate(t *testing.T) {
+	type TestCase struct {
+		TestName           string
+		SetupRequest       func(request *http.Request)
+		ExpectedStatusCode int
+		ExpectedUserId     int64
+		SetupStorage       func(mock *mocks.Storage)
+	}
+
+	testCases := []*TestCase{
+		&TestCase{
+			TestName: "Token in header",
+			SetupRequest: func(request *http.Request) {
+				request.Header.Set("Authorization", "Bearer token_1")
+			},
+			ExpectedStatusCode: http.StatusOK,
+			ExpectedUserId:     1,
+			SetupStorage: func(mock *mocks.Storage) {
+				mock.On("GetUserIdByToken", testifyMock.Anything, hashToken("token_1")).Return(int64(1), nil)
+			},
+		},
+		&TestCase{
+			TestName: "Token in query is ignored",
+			SetupRequest: func(request *http.Request) {
+				request.URL.RawQuery = "token=token_2"
+			},
+			ExpectedStatusCode: http.StatusUnauthorized,
+			SetupStorage:       func(mock *mocks.Storage) {},
+		},
+		&TestCase{
+			TestName:           "Without token",
+			SetupRequest:       func(request *http.Request) {},
+			ExpectedStatusCode: http.StatusUnauthorized,
+			SetupStorage:       func(mock *mocks.Storage) {},
+		},
+		&TestCase{
+			TestName: "Unknown token",
+			SetupRequest: func(request *http.Request) {
+				request.Header.Set("Authorization", "Bearer unknown")
+			},
+			ExpectedStatusCode: http.StatusUnauthorized,
+			SetupStorage: func(mock *mocks.Storage) {
+				mock.On("GetUserIdByToken", testifyMock.Anything, hashToken("unknown")).Return(int64(0), storage.ErrNotFound)
+			},
+		},
+		&TestCase{
+			TestName: "Storage failure",
+			SetupRequest: func(request *http.Request) {
+				request.Header.Set("Authorization", "Bearer token_1")
+			},
+			ExpectedStatusCode: http.StatusInternalServerError,
+			SetupStorage: func(mock *mocks.Storage) {
+				mock.On("GetUserIdByToken", testifyMock.Anything, hashToken("token_1")).
+					Return(int64(0), errors.New("disk is on fire"))
+			},
+		},
+	}
+	server := NewServer(nil, nil, true)
+	for _, testCase := range testCases {
+		t.Run(testCase.TestName, func(t *testing.T) {
+			mockStorage := &mocks.Storage{}
+			server.Storage = mockStorage
+			testCase.SetupStorage(mockStorage)
+
+			request, err := http.NewRequest(http.MethodPost, "/chats/get", strings.NewReader("{}"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			testCase.SetupRequest(request)
+			recorder := httptest.NewRecorder()
+
+			var actualUserId int64
+			handler := server.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				actualUserId = getUserId(r)
+			}))
+			handler.ServeHTTP(recorder, request)
+
+			mockStorage.AssertExpectations(t)
+			assert.Equal(t, testCase.ExpectedStatusCode, recorder.Code)
+			assert.Equal(t, testCase.ExpectedUserId, actualUserId)
+		})
+	}
+}
+
+func TestAuthenticateStream(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	mockStorage.On("GetUserIdByToken", testifyMock.Anything, hashToken("token_1")).Return(int64(1), nil)
+	mockStorage.On("GetUserIdByToken", testifyMock.Anything, hashToken("token_2")).Return(int64(2), nil)
+	server := NewServer(mockStorage, nil, true)
+	var actualUserId int64
+	handler := server.authenticateStream(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actualUserId = getUserId(r)
+	}))
+
+	request := httptest.NewRequest(http.MethodGet, "/events?token=token_2", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, int64(2), actualUserId)
+
+	// header takes precedence over query
+	request = httptest.NewRequest(http.MethodGet, "/events?token=token_2", nil)
+	request.Header.Set("Authorization", "Bearer token_1")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, int64(1), actualUserId)
+}
+
+func TestRoutesRequireAuthentication(t *testing.T) {
+	server := NewServer(&mocks.Storage{}, nil, true)
+	paths := []string{
+		"/chats/add", "/chats/get", "/chats/read", "/chats/rename", "/chats/slowmode", "/chats/members", "/chats/members/add",
+		"/chats/members/remove", "/chats/members/role", "/chats/transfer", "/chats/leave",
+		"/messages/add", "/messages/get", "/messages/search", "/messages/edit", "/messages/delete",
+		"/messages/pin", "/messages/unpin", "/messages/history", "/messages/readers",
+		"/webhooks/add", "/webhooks/get", "/webhooks/delete", "/webhooks/deliveries",
+	}
+	for _, path := range paths {
+		request, err := http.NewRequest(http.MethodPost, path, strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code, path)
+	}
+}
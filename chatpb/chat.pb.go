@@ -0,0 +1,1203 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: chat.proto
+
+package chatpb
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	timestamp "github.com/golang/protobuf/ptypes/timestamp"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type SystemEvent struct {
+	Type                 string   `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	User                 int64    `protobuf:"varint,2,opt,name=user,proto3" json:"user,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SystemEvent) Reset()         { *m = SystemEvent{} }
+func (m *SystemEvent) String() string { return proto.CompactTextString(m) }
+func (*SystemEvent) ProtoMessage()    {}
+func (*SystemEvent) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{0}
+}
+
+func (m *SystemEvent) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SystemEvent.Unmarshal(m, b)
+}
+func (m *SystemEvent) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SystemEvent.Marshal(b, m, deterministic)
+}
+func (m *SystemEvent) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SystemEvent.Merge(m, src)
+}
+func (m *SystemEvent) XXX_Size() int {
+	return xxx_messageInfo_SystemEvent.Size(m)
+}
+func (m *SystemEvent) XXX_DiscardUnknown() {
+	xxx_messageInfo_SystemEvent.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SystemEvent proto.InternalMessageInfo
+
+func (m *SystemEvent) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *SystemEvent) GetUser() int64 {
+	if m != nil {
+		return m.User
+	}
+	return 0
+}
+
+type Message struct {
+	Id        int64                `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Chat      int64                `protobuf:"varint,2,opt,name=chat,proto3" json:"chat,omitempty"`
+	Author    int64                `protobuf:"varint,3,opt,name=author,proto3" json:"author,omitempty"`
+	Text      string               `protobuf:"bytes,4,opt,name=text,proto3" json:"text,omitempty"`
+	CreatedAt *timestamp.Timestamp `protobuf:"bytes,5,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	// edited_at is unset if message was never edited
+	EditedAt *timestamp.Timestamp `protobuf:"bytes,6,opt,name=edited_at,json=editedAt,proto3" json:"edited_at,omitempty"`
+	Deleted  bool                 `protobuf:"varint,7,opt,name=deleted,proto3" json:"deleted,omitempty"`
+	Pinned   bool                 `protobuf:"varint,8,opt,name=pinned,proto3" json:"pinned,omitempty"`
+	// system is set for messages that record events in chat
+	System               *SystemEvent `protobuf:"bytes,9,opt,name=system,proto3" json:"system,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
+	XXX_unrecognized     []byte       `json:"-"`
+	XXX_sizecache        int32        `json:"-"`
+}
+
+func (m *Message) Reset()         { *m = Message{} }
+func (m *Message) String() string { return proto.CompactTextString(m) }
+func (*Message) ProtoMessage()    {}
+func (*Message) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{1}
+}
+
+func (m *Message) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Message.Unmarshal(m, b)
+}
+func (m *Message) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Message.Marshal(b, m, deterministic)
+}
+func (m *Message) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Message.Merge(m, src)
+}
+func (m *Message) XXX_Size() int {
+	return xxx_messageInfo_Message.Size(m)
+}
+func (m *Message) XXX_DiscardUnknown() {
+	xxx_messageInfo_Message.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Message proto.InternalMessageInfo
+
+func (m *Message) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *Message) GetChat() int64 {
+	if m != nil {
+		return m.Chat
+	}
+	return 0
+}
+
+func (m *Message) GetAuthor() int64 {
+	if m != nil {
+		return m.Author
+	}
+	return 0
+}
+
+func (m *Message) GetText() string {
+	if m != nil {
+		return m.Text
+	}
+	return ""
+}
+
+func (m *Message) GetCreatedAt() *timestamp.Timestamp {
+	if m != nil {
+		return m.CreatedAt
+	}
+	return nil
+}
+
+func (m *Message) GetEditedAt() *timestamp.Timestamp {
+	if m != nil {
+		return m.EditedAt
+	}
+	return nil
+}
+
+func (m *Message) GetDeleted() bool {
+	if m != nil {
+		return m.Deleted
+	}
+	return false
+}
+
+func (m *Message) GetPinned() bool {
+	if m != nil {
+		return m.Pinned
+	}
+	return false
+}
+
+func (m *Message) GetSystem() *SystemEvent {
+	if m != nil {
+		return m.System
+	}
+	return nil
+}
+
+type Chat struct {
+	Id                int64                `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name              string               `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	CreatedAt         *timestamp.Timestamp `protobuf:"bytes,3,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	Users             []int64              `protobuf:"varint,4,rep,packed,name=users,proto3" json:"users,omitempty"`
+	LastReadMessageId int64                `protobuf:"varint,5,opt,name=last_read_message_id,json=lastReadMessageId,proto3" json:"last_read_message_id,omitempty"`
+	UnreadCount       int64                `protobuf:"varint,6,opt,name=unread_count,json=unreadCount,proto3" json:"unread_count,omitempty"`
+	// last_message_at and last_message are unset for chat without messages
+	LastMessageAt *timestamp.Timestamp `protobuf:"bytes,7,opt,name=last_message_at,json=lastMessageAt,proto3" json:"last_message_at,omitempty"`
+	LastMessage   *Message             `protobuf:"bytes,8,opt,name=last_message,json=lastMessage,proto3" json:"last_message,omitempty"`
+	// slow_mode_seconds is zero if slow mode is off
+	SlowModeSeconds      int32    `protobuf:"varint,9,opt,name=slow_mode_seconds,json=slowModeSeconds,proto3" json:"slow_mode_seconds,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Chat) Reset()         { *m = Chat{} }
+func (m *Chat) String() string { return proto.CompactTextString(m) }
+func (*Chat) ProtoMessage()    {}
+func (*Chat) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{2}
+}
+
+func (m *Chat) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Chat.Unmarshal(m, b)
+}
+func (m *Chat) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Chat.Marshal(b, m, deterministic)
+}
+func (m *Chat) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Chat.Merge(m, src)
+}
+func (m *Chat) XXX_Size() int {
+	return xxx_messageInfo_Chat.Size(m)
+}
+func (m *Chat) XXX_DiscardUnknown() {
+	xxx_messageInfo_Chat.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Chat proto.InternalMessageInfo
+
+func (m *Chat) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *Chat) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Chat) GetCreatedAt() *timestamp.Timestamp {
+	if m != nil {
+		return m.CreatedAt
+	}
+	return nil
+}
+
+func (m *Chat) GetUsers() []int64 {
+	if m != nil {
+		return m.Users
+	}
+	return nil
+}
+
+func (m *Chat) GetLastReadMessageId() int64 {
+	if m != nil {
+		return m.LastReadMessageId
+	}
+	return 0
+}
+
+func (m *Chat) GetUnreadCount() int64 {
+	if m != nil {
+		return m.UnreadCount
+	}
+	return 0
+}
+
+func (m *Chat) GetLastMessageAt() *timestamp.Timestamp {
+	if m != nil {
+		return m.LastMessageAt
+	}
+	return nil
+}
+
+func (m *Chat) GetLastMessage() *Message {
+	if m != nil {
+		return m.LastMessage
+	}
+	return nil
+}
+
+func (m *Chat) GetSlowModeSeconds() int32 {
+	if m != nil {
+		return m.SlowModeSeconds
+	}
+	return 0
+}
+
+type AddUserRequest struct {
+	Username             string   `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AddUserRequest) Reset()         { *m = AddUserRequest{} }
+func (m *AddUserRequest) String() string { return proto.CompactTextString(m) }
+func (*AddUserRequest) ProtoMessage()    {}
+func (*AddUserRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{3}
+}
+
+func (m *AddUserRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AddUserRequest.Unmarshal(m, b)
+}
+func (m *AddUserRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AddUserRequest.Marshal(b, m, deterministic)
+}
+func (m *AddUserRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AddUserRequest.Merge(m, src)
+}
+func (m *AddUserRequest) XXX_Size() int {
+	return xxx_messageInfo_AddUserRequest.Size(m)
+}
+func (m *AddUserRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_AddUserRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AddUserRequest proto.InternalMessageInfo
+
+func (m *AddUserRequest) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+type AddUserResponse struct {
+	Id                   int64    `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Token                string   `protobuf:"bytes,2,opt,name=token,proto3" json:"token,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AddUserResponse) Reset()         { *m = AddUserResponse{} }
+func (m *AddUserResponse) String() string { return proto.CompactTextString(m) }
+func (*AddUserResponse) ProtoMessage()    {}
+func (*AddUserResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{4}
+}
+
+func (m *AddUserResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AddUserResponse.Unmarshal(m, b)
+}
+func (m *AddUserResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AddUserResponse.Marshal(b, m, deterministic)
+}
+func (m *AddUserResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AddUserResponse.Merge(m, src)
+}
+func (m *AddUserResponse) XXX_Size() int {
+	return xxx_messageInfo_AddUserResponse.Size(m)
+}
+func (m *AddUserResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_AddUserResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AddUserResponse proto.InternalMessageInfo
+
+func (m *AddUserResponse) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *AddUserResponse) GetToken() string {
+	if m != nil {
+		return m.Token
+	}
+	return ""
+}
+
+type AddChatRequest struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Users                []int64  `protobuf:"varint,2,rep,packed,name=users,proto3" json:"users,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AddChatRequest) Reset()         { *m = AddChatRequest{} }
+func (m *AddChatRequest) String() string { return proto.CompactTextString(m) }
+func (*AddChatRequest) ProtoMessage()    {}
+func (*AddChatRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{5}
+}
+
+func (m *AddChatRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AddChatRequest.Unmarshal(m, b)
+}
+func (m *AddChatRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AddChatRequest.Marshal(b, m, deterministic)
+}
+func (m *AddChatRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AddChatRequest.Merge(m, src)
+}
+func (m *AddChatRequest) XXX_Size() int {
+	return xxx_messageInfo_AddChatRequest.Size(m)
+}
+func (m *AddChatRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_AddChatRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AddChatRequest proto.InternalMessageInfo
+
+func (m *AddChatRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *AddChatRequest) GetUsers() []int64 {
+	if m != nil {
+		return m.Users
+	}
+	return nil
+}
+
+type AddChatResponse struct {
+	Id                   int64    `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AddChatResponse) Reset()         { *m = AddChatResponse{} }
+func (m *AddChatResponse) String() string { return proto.CompactTextString(m) }
+func (*AddChatResponse) ProtoMessage()    {}
+func (*AddChatResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{6}
+}
+
+func (m *AddChatResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AddChatResponse.Unmarshal(m, b)
+}
+func (m *AddChatResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AddChatResponse.Marshal(b, m, deterministic)
+}
+func (m *AddChatResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AddChatResponse.Merge(m, src)
+}
+func (m *AddChatResponse) XXX_Size() int {
+	return xxx_messageInfo_AddChatResponse.Size(m)
+}
+func (m *AddChatResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_AddChatResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AddChatResponse proto.InternalMessageInfo
+
+func (m *AddChatResponse) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+type GetChatsRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetChatsRequest) Reset()         { *m = GetChatsRequest{} }
+func (m *GetChatsRequest) String() string { return proto.CompactTextString(m) }
+func (*GetChatsRequest) ProtoMessage()    {}
+func (*GetChatsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{7}
+}
+
+func (m *GetChatsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetChatsRequest.Unmarshal(m, b)
+}
+func (m *GetChatsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetChatsRequest.Marshal(b, m, deterministic)
+}
+func (m *GetChatsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetChatsRequest.Merge(m, src)
+}
+func (m *GetChatsRequest) XXX_Size() int {
+	return xxx_messageInfo_GetChatsRequest.Size(m)
+}
+func (m *GetChatsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetChatsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetChatsRequest proto.InternalMessageInfo
+
+type GetChatsResponse struct {
+	Chats                []*Chat  `protobuf:"bytes,1,rep,name=chats,proto3" json:"chats,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetChatsResponse) Reset()         { *m = GetChatsResponse{} }
+func (m *GetChatsResponse) String() string { return proto.CompactTextString(m) }
+func (*GetChatsResponse) ProtoMessage()    {}
+func (*GetChatsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{8}
+}
+
+func (m *GetChatsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetChatsResponse.Unmarshal(m, b)
+}
+func (m *GetChatsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetChatsResponse.Marshal(b, m, deterministic)
+}
+func (m *GetChatsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetChatsResponse.Merge(m, src)
+}
+func (m *GetChatsResponse) XXX_Size() int {
+	return xxx_messageInfo_GetChatsResponse.Size(m)
+}
+func (m *GetChatsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetChatsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetChatsResponse proto.InternalMessageInfo
+
+func (m *GetChatsResponse) GetChats() []*Chat {
+	if m != nil {
+		return m.Chats
+	}
+	return nil
+}
+
+type AddMessageRequest struct {
+	Chat                 int64    `protobuf:"varint,1,opt,name=chat,proto3" json:"chat,omitempty"`
+	Text                 string   `protobuf:"bytes,2,opt,name=text,proto3" json:"text,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AddMessageRequest) Reset()         { *m = AddMessageRequest{} }
+func (m *AddMessageRequest) String() string { return proto.CompactTextString(m) }
+func (*AddMessageRequest) ProtoMessage()    {}
+func (*AddMessageRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{9}
+}
+
+func (m *AddMessageRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AddMessageRequest.Unmarshal(m, b)
+}
+func (m *AddMessageRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AddMessageRequest.Marshal(b, m, deterministic)
+}
+func (m *AddMessageRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AddMessageRequest.Merge(m, src)
+}
+func (m *AddMessageRequest) XXX_Size() int {
+	return xxx_messageInfo_AddMessageRequest.Size(m)
+}
+func (m *AddMessageRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_AddMessageRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AddMessageRequest proto.InternalMessageInfo
+
+func (m *AddMessageRequest) GetChat() int64 {
+	if m != nil {
+		return m.Chat
+	}
+	return 0
+}
+
+func (m *AddMessageRequest) GetText() string {
+	if m != nil {
+		return m.Text
+	}
+	return ""
+}
+
+type AddMessageResponse struct {
+	Id                   int64    `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AddMessageResponse) Reset()         { *m = AddMessageResponse{} }
+func (m *AddMessageResponse) String() string { return proto.CompactTextString(m) }
+func (*AddMessageResponse) ProtoMessage()    {}
+func (*AddMessageResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{10}
+}
+
+func (m *AddMessageResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AddMessageResponse.Unmarshal(m, b)
+}
+func (m *AddMessageResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AddMessageResponse.Marshal(b, m, deterministic)
+}
+func (m *AddMessageResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AddMessageResponse.Merge(m, src)
+}
+func (m *AddMessageResponse) XXX_Size() int {
+	return xxx_messageInfo_AddMessageResponse.Size(m)
+}
+func (m *AddMessageResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_AddMessageResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AddMessageResponse proto.InternalMessageInfo
+
+func (m *AddMessageResponse) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+type GetMessagesRequest struct {
+	Chat                 int64    `protobuf:"varint,1,opt,name=chat,proto3" json:"chat,omitempty"`
+	Limit                int32    `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"`
+	BeforeId             int64    `protobuf:"varint,3,opt,name=before_id,json=beforeId,proto3" json:"before_id,omitempty"`
+	AfterId              int64    `protobuf:"varint,4,opt,name=after_id,json=afterId,proto3" json:"after_id,omitempty"`
+	Cursor               string   `protobuf:"bytes,5,opt,name=cursor,proto3" json:"cursor,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetMessagesRequest) Reset()         { *m = GetMessagesRequest{} }
+func (m *GetMessagesRequest) String() string { return proto.CompactTextString(m) }
+func (*GetMessagesRequest) ProtoMessage()    {}
+func (*GetMessagesRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{11}
+}
+
+func (m *GetMessagesRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetMessagesRequest.Unmarshal(m, b)
+}
+func (m *GetMessagesRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetMessagesRequest.Marshal(b, m, deterministic)
+}
+func (m *GetMessagesRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetMessagesRequest.Merge(m, src)
+}
+func (m *GetMessagesRequest) XXX_Size() int {
+	return xxx_messageInfo_GetMessagesRequest.Size(m)
+}
+func (m *GetMessagesRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetMessagesRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetMessagesRequest proto.InternalMessageInfo
+
+func (m *GetMessagesRequest) GetChat() int64 {
+	if m != nil {
+		return m.Chat
+	}
+	return 0
+}
+
+func (m *GetMessagesRequest) GetLimit() int32 {
+	if m != nil {
+		return m.Limit
+	}
+	return 0
+}
+
+func (m *GetMessagesRequest) GetBeforeId() int64 {
+	if m != nil {
+		return m.BeforeId
+	}
+	return 0
+}
+
+func (m *GetMessagesRequest) GetAfterId() int64 {
+	if m != nil {
+		return m.AfterId
+	}
+	return 0
+}
+
+func (m *GetMessagesRequest) GetCursor() string {
+	if m != nil {
+		return m.Cursor
+	}
+	return ""
+}
+
+type GetMessagesResponse struct {
+	Messages             []*Message `protobuf:"bytes,1,rep,name=messages,proto3" json:"messages,omitempty"`
+	NextCursor           string     `protobuf:"bytes,2,opt,name=next_cursor,json=nextCursor,proto3" json:"next_cursor,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *GetMessagesResponse) Reset()         { *m = GetMessagesResponse{} }
+func (m *GetMessagesResponse) String() string { return proto.CompactTextString(m) }
+func (*GetMessagesResponse) ProtoMessage()    {}
+func (*GetMessagesResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{12}
+}
+
+func (m *GetMessagesResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetMessagesResponse.Unmarshal(m, b)
+}
+func (m *GetMessagesResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetMessagesResponse.Marshal(b, m, deterministic)
+}
+func (m *GetMessagesResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetMessagesResponse.Merge(m, src)
+}
+func (m *GetMessagesResponse) XXX_Size() int {
+	return xxx_messageInfo_GetMessagesResponse.Size(m)
+}
+func (m *GetMessagesResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetMessagesResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetMessagesResponse proto.InternalMessageInfo
+
+func (m *GetMessagesResponse) GetMessages() []*Message {
+	if m != nil {
+		return m.Messages
+	}
+	return nil
+}
+
+func (m *GetMessagesResponse) GetNextCursor() string {
+	if m != nil {
+		return m.NextCursor
+	}
+	return ""
+}
+
+type SubscribeRequest struct {
+	LastId               int64    `protobuf:"varint,1,opt,name=last_id,json=lastId,proto3" json:"last_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SubscribeRequest) Reset()         { *m = SubscribeRequest{} }
+func (m *SubscribeRequest) String() string { return proto.CompactTextString(m) }
+func (*SubscribeRequest) ProtoMessage()    {}
+func (*SubscribeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{13}
+}
+
+func (m *SubscribeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SubscribeRequest.Unmarshal(m, b)
+}
+func (m *SubscribeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SubscribeRequest.Marshal(b, m, deterministic)
+}
+func (m *SubscribeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SubscribeRequest.Merge(m, src)
+}
+func (m *SubscribeRequest) XXX_Size() int {
+	return xxx_messageInfo_SubscribeRequest.Size(m)
+}
+func (m *SubscribeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SubscribeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SubscribeRequest proto.InternalMessageInfo
+
+func (m *SubscribeRequest) GetLastId() int64 {
+	if m != nil {
+		return m.LastId
+	}
+	return 0
+}
+
+// Event has the same types as WebSocket frames: "message", "chat_created".
+type Event struct {
+	Type                 string   `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Message              *Message `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	Chat                 *Chat    `protobuf:"bytes,3,opt,name=chat,proto3" json:"chat,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Event) Reset()         { *m = Event{} }
+func (m *Event) String() string { return proto.CompactTextString(m) }
+func (*Event) ProtoMessage()    {}
+func (*Event) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{14}
+}
+
+func (m *Event) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Event.Unmarshal(m, b)
+}
+func (m *Event) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Event.Marshal(b, m, deterministic)
+}
+func (m *Event) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Event.Merge(m, src)
+}
+func (m *Event) XXX_Size() int {
+	return xxx_messageInfo_Event.Size(m)
+}
+func (m *Event) XXX_DiscardUnknown() {
+	xxx_messageInfo_Event.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Event proto.InternalMessageInfo
+
+func (m *Event) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *Event) GetMessage() *Message {
+	if m != nil {
+		return m.Message
+	}
+	return nil
+}
+
+func (m *Event) GetChat() *Chat {
+	if m != nil {
+		return m.Chat
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*SystemEvent)(nil), "chat.SystemEvent")
+	proto.RegisterType((*Message)(nil), "chat.Message")
+	proto.RegisterType((*Chat)(nil), "chat.Chat")
+	proto.RegisterType((*AddUserRequest)(nil), "chat.AddUserRequest")
+	proto.RegisterType((*AddUserResponse)(nil), "chat.AddUserResponse")
+	proto.RegisterType((*AddChatRequest)(nil), "chat.AddChatRequest")
+	proto.RegisterType((*AddChatResponse)(nil), "chat.AddChatResponse")
+	proto.RegisterType((*GetChatsRequest)(nil), "chat.GetChatsRequest")
+	proto.RegisterType((*GetChatsResponse)(nil), "chat.GetChatsResponse")
+	proto.RegisterType((*AddMessageRequest)(nil), "chat.AddMessageRequest")
+	proto.RegisterType((*AddMessageResponse)(nil), "chat.AddMessageResponse")
+	proto.RegisterType((*GetMessagesRequest)(nil), "chat.GetMessagesRequest")
+	proto.RegisterType((*GetMessagesResponse)(nil), "chat.GetMessagesResponse")
+	proto.RegisterType((*SubscribeRequest)(nil), "chat.SubscribeRequest")
+	proto.RegisterType((*Event)(nil), "chat.Event")
+}
+
+func init() { proto.RegisterFile("chat.proto", fileDescriptor_8c585a45e2093e54) }
+
+var fileDescriptor_8c585a45e2093e54 = []byte{
+	// 816 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x54, 0xcb, 0x6e, 0xdb, 0x46,
+	0x14, 0x05, 0x49, 0x51, 0x24, 0x2f, 0x93, 0x28, 0x9a, 0x2a, 0x09, 0xc3, 0x02, 0x8d, 0x42, 0x14,
+	0xa8, 0xd2, 0x16, 0x72, 0xa0, 0x3e, 0x82, 0xb4, 0x8b, 0x42, 0x36, 0x8a, 0x40, 0x8b, 0x6c, 0x46,
+	0xed, 0xa6, 0x1b, 0x81, 0xd2, 0x5c, 0x3b, 0x44, 0x25, 0x52, 0xe5, 0x0c, 0x53, 0xfb, 0x27, 0xfa,
+	0x21, 0xfd, 0x3b, 0xff, 0x41, 0x31, 0x0f, 0x52, 0x34, 0x2d, 0xd7, 0xc8, 0x6e, 0xee, 0x7b, 0xe6,
+	0x9c, 0x73, 0x07, 0x60, 0xf3, 0x21, 0x15, 0xd3, 0x7d, 0x59, 0x88, 0x82, 0xf4, 0xe4, 0x39, 0x7e,
+	0x71, 0x51, 0x14, 0x17, 0x5b, 0x3c, 0x51, 0xbe, 0x75, 0x75, 0x7e, 0x22, 0xb2, 0x1d, 0x72, 0x91,
+	0xee, 0xf6, 0x3a, 0x2d, 0xf9, 0x01, 0xc2, 0xe5, 0x15, 0x17, 0xb8, 0xfb, 0xf5, 0x23, 0xe6, 0x82,
+	0x10, 0xe8, 0x89, 0xab, 0x3d, 0x46, 0xd6, 0xd8, 0x9a, 0x04, 0x54, 0x9d, 0xa5, 0xaf, 0xe2, 0x58,
+	0x46, 0xf6, 0xd8, 0x9a, 0x38, 0x54, 0x9d, 0x93, 0x7f, 0x6d, 0xf0, 0xde, 0x23, 0xe7, 0xe9, 0x05,
+	0x92, 0x47, 0x60, 0x67, 0x4c, 0x55, 0x38, 0xd4, 0xce, 0x98, 0xcc, 0x97, 0xb3, 0xeb, 0x7c, 0x79,
+	0x26, 0x4f, 0xa1, 0x9f, 0x56, 0xe2, 0x43, 0x51, 0x46, 0x8e, 0xf2, 0x1a, 0x4b, 0xcd, 0xc3, 0x4b,
+	0x11, 0xf5, 0xcc, 0x3c, 0xbc, 0x14, 0xe4, 0x2d, 0xc0, 0xa6, 0xc4, 0x54, 0x20, 0x5b, 0xa5, 0x22,
+	0x72, 0xc7, 0xd6, 0x24, 0x9c, 0xc5, 0x53, 0xfd, 0x90, 0x69, 0xfd, 0x90, 0xe9, 0x6f, 0xf5, 0x43,
+	0x68, 0x60, 0xb2, 0xe7, 0x82, 0xbc, 0x81, 0x00, 0x59, 0x66, 0x2a, 0xfb, 0xf7, 0x56, 0xfa, 0x3a,
+	0x79, 0x2e, 0x48, 0x04, 0x1e, 0xc3, 0x2d, 0x0a, 0x64, 0x91, 0x37, 0xb6, 0x26, 0x3e, 0xad, 0x4d,
+	0x79, 0xf3, 0x7d, 0x96, 0xe7, 0xc8, 0x22, 0x5f, 0x05, 0x8c, 0x45, 0x5e, 0x41, 0x9f, 0x2b, 0xe0,
+	0xa2, 0x40, 0xcd, 0x19, 0x4e, 0x15, 0xf8, 0x2d, 0x30, 0xa9, 0x49, 0x48, 0xae, 0x6d, 0xe8, 0x9d,
+	0x49, 0x14, 0x8e, 0x20, 0x95, 0xa7, 0x3b, 0x54, 0x48, 0x05, 0x54, 0x9d, 0x3b, 0xaf, 0x77, 0x3e,
+	0xe5, 0xf5, 0x23, 0x70, 0x25, 0x39, 0x3c, 0xea, 0x8d, 0x9d, 0x89, 0x43, 0xb5, 0x41, 0x4e, 0x60,
+	0xb4, 0x4d, 0xb9, 0x58, 0x95, 0x98, 0xb2, 0xd5, 0x4e, 0x73, 0xb6, 0xca, 0x98, 0x02, 0xd6, 0xa1,
+	0x43, 0x19, 0xa3, 0x98, 0x32, 0xc3, 0xe6, 0x82, 0x91, 0x97, 0xf0, 0xa0, 0xca, 0x55, 0xf6, 0xa6,
+	0xa8, 0x72, 0x8d, 0xa3, 0x43, 0x43, 0xed, 0x3b, 0x93, 0x2e, 0x72, 0x0a, 0x03, 0xd5, 0xb3, 0x6e,
+	0x97, 0x8a, 0xc8, 0xbb, 0xf7, 0xa6, 0x0f, 0x65, 0x89, 0x19, 0x33, 0x17, 0xe4, 0x35, 0x3c, 0x68,
+	0xf7, 0x50, 0xf0, 0x86, 0xb3, 0x87, 0x1a, 0x46, 0x93, 0x46, 0xc3, 0x56, 0x0d, 0xf9, 0x1a, 0x86,
+	0x7c, 0x5b, 0xfc, 0xbd, 0xda, 0x15, 0x0c, 0x57, 0x1c, 0x37, 0x45, 0xce, 0xb8, 0x42, 0xdf, 0xa5,
+	0x03, 0x19, 0x78, 0x5f, 0x30, 0x5c, 0x6a, 0x77, 0xf2, 0x2d, 0x3c, 0x9a, 0x33, 0xf6, 0x3b, 0xc7,
+	0x92, 0xe2, 0x5f, 0x15, 0x72, 0x41, 0x62, 0xf0, 0x25, 0x20, 0x0a, 0x70, 0x2d, 0xef, 0xc6, 0x4e,
+	0xde, 0xc0, 0xa0, 0xc9, 0xe6, 0xfb, 0x22, 0xe7, 0xb7, 0x55, 0x3d, 0x02, 0x57, 0x14, 0x7f, 0x62,
+	0x6e, 0xc8, 0xd2, 0x46, 0xf2, 0x93, 0x1a, 0x23, 0xc9, 0xad, 0xc7, 0xd4, 0x9c, 0x5a, 0x2d, 0x4e,
+	0x1b, 0x62, 0xec, 0x16, 0x31, 0xc9, 0x4b, 0x18, 0x34, 0xb5, 0xc7, 0x87, 0x26, 0x43, 0x18, 0xbc,
+	0x43, 0x21, 0x53, 0xb8, 0xe9, 0x9f, 0x7c, 0x0f, 0x8f, 0x0f, 0x2e, 0x53, 0x36, 0x06, 0x57, 0xa2,
+	0xc6, 0x23, 0x6b, 0xec, 0x4c, 0xc2, 0x19, 0x68, 0x0c, 0x55, 0x67, 0x1d, 0x48, 0x7e, 0x86, 0xe1,
+	0x9c, 0xd5, 0x1c, 0xb7, 0xae, 0xaa, 0x16, 0xd5, 0x6a, 0x2d, 0x6a, 0xbd, 0x90, 0xf6, 0x61, 0x21,
+	0x93, 0x2f, 0x81, 0xb4, 0x8b, 0xef, 0xb8, 0xeb, 0x3f, 0x16, 0x90, 0x77, 0x58, 0x93, 0xc5, 0xff,
+	0x6f, 0xc8, 0x08, 0xdc, 0x6d, 0xb6, 0xcb, 0xf4, 0x14, 0x97, 0x6a, 0x83, 0x7c, 0x0e, 0xc1, 0x1a,
+	0xcf, 0x8b, 0x52, 0xa9, 0x53, 0x7f, 0x13, 0xbe, 0x76, 0x2c, 0x18, 0x79, 0x0e, 0x7e, 0x7a, 0x2e,
+	0xb0, 0x94, 0xb1, 0x9e, 0x8a, 0x79, 0xca, 0x5e, 0xa8, 0x0d, 0xdd, 0x54, 0x25, 0x2f, 0x4a, 0x25,
+	0xe9, 0x80, 0x1a, 0x2b, 0x49, 0xe1, 0xb3, 0x1b, 0xf7, 0x31, 0xf7, 0x7e, 0x05, 0xbe, 0x91, 0x5c,
+	0x8d, 0x57, 0x47, 0x73, 0x4d, 0x98, 0xbc, 0x80, 0x30, 0xc7, 0x4b, 0xb1, 0x32, 0xed, 0x35, 0x26,
+	0x20, 0x5d, 0x67, 0x7a, 0xc4, 0x37, 0xf0, 0x78, 0x59, 0xad, 0xf9, 0xa6, 0xcc, 0xd6, 0x0d, 0xaa,
+	0xcf, 0xc0, 0x53, 0xba, 0x6e, 0xc0, 0xe9, 0x4b, 0x73, 0xc1, 0x12, 0x06, 0xee, 0xdd, 0x9f, 0xec,
+	0x57, 0xe0, 0xd5, 0x8b, 0x60, 0x1f, 0x5b, 0x84, 0x3a, 0x4a, 0xbe, 0x30, 0x78, 0xea, 0x9f, 0xa1,
+	0x4d, 0xb5, 0xf2, 0xcf, 0xae, 0x6d, 0x08, 0xa5, 0xb9, 0xc4, 0xf2, 0x63, 0xb6, 0x41, 0xf2, 0x23,
+	0x78, 0x46, 0xda, 0x64, 0xa4, 0x93, 0x6f, 0xee, 0x45, 0xfc, 0xa4, 0xe3, 0x35, 0x30, 0xe9, 0x3a,
+	0xf5, 0x6d, 0x1d, 0xea, 0x5a, 0x42, 0x8f, 0x9f, 0x74, 0xbc, 0xa6, 0xee, 0x2d, 0xf8, 0xb5, 0x3e,
+	0x89, 0x49, 0xe9, 0x48, 0x38, 0x7e, 0xda, 0x75, 0x9b, 0xd2, 0x5f, 0x00, 0x0e, 0x3a, 0x23, 0xcf,
+	0x9a, 0xfe, 0x37, 0x65, 0x1b, 0x47, 0xb7, 0x03, 0xa6, 0xc1, 0x29, 0x84, 0x2d, 0xc6, 0x49, 0xd4,
+	0xcc, 0xe9, 0x88, 0x32, 0x7e, 0x7e, 0x24, 0x62, 0x7a, 0xcc, 0x20, 0x68, 0x28, 0x25, 0xe6, 0xa6,
+	0x5d, 0x8e, 0xe3, 0x50, 0xfb, 0x15, 0x9d, 0xaf, 0xad, 0x53, 0xff, 0x8f, 0xbe, 0xb4, 0xf7, 0xeb,
+	0x75, 0x5f, 0xfd, 0x7b, 0xdf, 0xfd, 0x37, 0x00, 0x3f, 0xc2, 0x0b, 0x8f, 0x8a, 0x07, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// ChatServiceClient is the client API for ChatService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type ChatServiceClient interface {
+	// AddUser registers user and returns token that is shown only once.
+	AddUser(ctx context.Context, in *AddUserRequest, opts ...grpc.CallOption) (*AddUserResponse, error)
+	// AddChat creates chat with specified users, authenticated user becomes its owner.
+	AddChat(ctx context.Context, in *AddChatRequest, opts ...grpc.CallOption) (*AddChatResponse, error)
+	// GetChats returns chats of authenticated user, newest first.
+	GetChats(ctx context.Context, in *GetChatsRequest, opts ...grpc.CallOption) (*GetChatsResponse, error)
+	// AddMessage adds message from authenticated user to chat.
+	AddMessage(ctx context.Context, in *AddMessageRequest, opts ...grpc.CallOption) (*AddMessageResponse, error)
+	// GetMessages returns page of messages of chat, paged the same way as /messages/get.
+	GetMessages(ctx context.Context, in *GetMessagesRequest, opts ...grpc.CallOption) (*GetMessagesResponse, error)
+	// Subscribe streams new messages from all chats of authenticated user and chats created with him.
+	// If last_id is set, messages with greater id are sent first, as well as recent messages with lower id,
+	// because they may have been published after message with last_id. Client skips known ones by id.
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (ChatService_SubscribeClient, error)
+}
+
+type chatServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewChatServiceClient(cc *grpc.ClientConn) ChatServiceClient {
+	return &chatServiceClient{cc}
+}
+
+func (c *chatServiceClient) AddUser(ctx context.Context, in *AddUserRequest, opts ...grpc.CallOption) (*AddUserResponse, error) {
+	out := new(AddUserResponse)
+	err := c.cc.Invoke(ctx, "/chat.ChatService/AddUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatServiceClient) AddChat(ctx context.Context, in *AddChatRequest, opts ...grpc.CallOption) (*AddChatResponse, error) {
+	out := new(AddChatResponse)
+	err := c.cc.Invoke(ctx, "/chat.ChatService/AddChat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatServiceClient) GetChats(ctx context.Context, in *GetChatsRequest, opts ...grpc.CallOption) (*GetChatsResponse, error) {
+	out := new(GetChatsResponse)
+	err := c.cc.Invoke(ctx, "/chat.ChatService/GetChats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatServiceClient) AddMessage(ctx context.Context, in *AddMessageRequest, opts ...grpc.CallOption) (*AddMessageResponse, error) {
+	out := new(AddMessageResponse)
+	err := c.cc.Invoke(ctx, "/chat.ChatService/AddMessage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatServiceClient) GetMessages(ctx context.Context, in *GetMessagesRequest, opts ...grpc.CallOption) (*GetMessagesResponse, error) {
+	out := new(GetMessagesResponse)
+	err := c.cc.Invoke(ctx, "/chat.ChatService/GetMessages", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatServiceClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (ChatService_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_ChatService_serviceDesc.Streams[0], "/chat.ChatService/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &chatServiceSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ChatService_SubscribeClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type chatServiceSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *chatServiceSubscribeClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ChatServiceServer is the server API for ChatService service.
+type ChatServiceServer interface {
+	// AddUser registers user and returns token that is shown only once.
+	AddUser(context.Context, *AddUserRequest) (*AddUserResponse, error)
+	// AddChat creates chat with specified users, authenticated user becomes its owner.
+	AddChat(context.Context, *AddChatRequest) (*AddChatResponse, error)
+	// GetChats returns chats of authenticated user, newest first.
+	GetChats(context.Context, *GetChatsRequest) (*GetChatsResponse, error)
+	// AddMessage adds message from authenticated user to chat.
+	AddMessage(context.Context, *AddMessageRequest) (*AddMessageResponse, error)
+	// GetMessages returns page of messages of chat, paged the same way as /messages/get.
+	GetMessages(context.Context, *GetMessagesRequest) (*GetMessagesResponse, error)
+	// Subscribe streams new messages from all chats of authenticated user and chats created with him.
+	// If last_id is set, messages with greater id are sent first, as well as recent messages with lower id,
+	// because they may have been published after message with last_id. Client skips known ones by id.
+	Subscribe(*SubscribeRequest, ChatService_SubscribeServer) error
+}
+
+// UnimplementedChatServiceServer can be embedded to have forward compatible implementations.
+type UnimplementedChatServiceServer struct {
+}
+
+func (*UnimplementedChatServiceServer) AddUser(ctx context.Context, req *AddUserRequest) (*AddUserResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddUser not implemented")
+}
+func (*UnimplementedChatServiceServer) AddChat(ctx context.Context, req *AddChatRequest) (*AddChatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddChat not implemented")
+}
+func (*UnimplementedChatServiceServer) GetChats(ctx context.Context, req *GetChatsRequest) (*GetChatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetChats not implemented")
+}
+func (*UnimplementedChatServiceServer) AddMessage(ctx context.Context, req *AddMessageRequest) (*AddMessageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddMessage not implemented")
+}
+func (*UnimplementedChatServiceServer) GetMessages(ctx context.Context, req *GetMessagesRequest) (*GetMessagesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMessages not implemented")
+}
+func (*UnimplementedChatServiceServer) Subscribe(req *SubscribeRequest, srv ChatService_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+
+func RegisterChatServiceServer(s *grpc.Server, srv ChatServiceServer) {
+	s.RegisterService(&_ChatService_serviceDesc, srv)
+}
+
+func _ChatService_AddUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).AddUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chat.ChatService/AddUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).AddUser(ctx, req.(*AddUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatService_AddChat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddChatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).AddChat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chat.ChatService/AddChat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).AddChat(ctx, req.(*AddChatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatService_GetChats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetChatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).GetChats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chat.ChatService/GetChats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).GetChats(ctx, req.(*GetChatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatService_AddMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddMessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).AddMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chat.ChatService/AddMessage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).AddMessage(ctx, req.(*AddMessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatService_GetMessages_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMessagesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).GetMessages(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chat.ChatService/GetMessages",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).GetMessages(ctx, req.(*GetMessagesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatService_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ChatServiceServer).Subscribe(m, &chatServiceSubscribeServer{stream})
+}
+
+type ChatService_SubscribeServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type chatServiceSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *chatServiceSubscribeServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _ChatService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "chat.ChatService",
+	HandlerType: (*ChatServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AddUser",
+			Handler:    _ChatService_AddUser_Handler,
+		},
+		{
+			MethodName: "AddChat",
+			Handler:    _ChatService_AddChat_Handler,
+		},
+		{
+			MethodName: "GetChats",
+			Handler:    _ChatService_GetChats_Handler,
+		},
+		{
+			MethodName: "AddMessage",
+			Handler:    _ChatService_AddMessage_Handler,
+		},
+		{
+			MethodName: "GetMessages",
+			Handler:    _ChatService_GetMessages_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _ChatService_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "chat.proto",
+}
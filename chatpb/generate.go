@@ -0,0 +1,4 @@
+// Package chatpb contains gRPC service definition of chat server and code generated from it.
+package chatpb
+
+//go:generate protoc -I . --go_out=plugins=grpc:. chat.proto
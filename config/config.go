@@ -2,6 +2,7 @@ package config
 
 import (
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -10,18 +11,84 @@ type Log struct {
 	Path string
 }
 
+// Storage.Driver is "sqlite", "postgres" or "memory". Memory storage loses all data on exit.
+// Only one instance of server is supported with any driver: events of streams and long polling and rate limits
+// are kept in memory of process, so clients of other instance wouldn't get messages posted through this one.
+// Postgres only survives instances that briefly overlap, for example during restart.
+// Storage calls of request are cancelled if they take longer than Timeout together, zero means no timeout.
+// Long polling gets Timeout in addition to its waiting time, streams get it for loading missed messages.
+// Calls of background workers are limited by Timeout each.
+type Storage struct {
+	Driver  string
+	Timeout time.Duration
+}
+
 type Sqlite struct {
 	Path string
 }
 
+// Postgres.DSN is either URL or list of key=value settings of lib/pq.
+type Postgres struct {
+	DSN string
+}
+
+// Server.GRPCPort is port of gRPC API, it isn't served if port is empty.
+// Timeouts are those of http.Server, zero means no timeout. WriteTimeout is disabled by default,
+// because it would cut off event streams and long polling.
+// On shutdown in-flight requests are waited for at most ShutdownTimeout.
 type Server struct {
-	Port string
+	Port            string
+	GRPCPort        string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+}
+
+// Webhooks.Enabled turns on delivery of queued webhook events. Delivery is retried with exponential
+// backoff from MinBackoff to MaxBackoff until MaxAttempts attempts have failed.
+// At most Workers webhooks are delivered to at once.
+// Webhooks aren't delivered to DeniedNetworks (webhook.DefaultDeniedNetworks if empty) unless address
+// is in AllowedNetworks. Both are lists of networks in CIDR notation separated by commas.
+type Webhooks struct {
+	Enabled         bool
+	MaxAttempts     int
+	Timeout         time.Duration
+	MinBackoff      time.Duration
+	MaxBackoff      time.Duration
+	Workers         int
+	AllowedNetworks []string
+	DeniedNetworks  []string
+}
+
+// Idempotency.Window is how long responses to requests with Idempotency-Key header are kept.
+type Idempotency struct {
+	Window time.Duration
+}
+
+// Limit is token bucket: Rate is requests per second on average, Burst is number of requests allowed at once.
+// Zero Rate turns limit off.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimit sets limits of groups of routes. Signup is limited by client IP, other groups by user.
+type RateLimit struct {
+	Signup Limit
+	Write  Limit
+	Read   Limit
 }
 
 type Config struct {
 	Log
+	Storage
 	Sqlite
+	Postgres
 	Server
+	Webhooks
+	Idempotency
+	RateLimit
 }
 
 func MakeConfig(v *viper.Viper) *Config {
@@ -29,21 +96,98 @@ func MakeConfig(v *viper.Viper) *Config {
 		Log: Log{
 			Path: v.GetString("log.path"),
 		},
+		Storage: Storage{
+			Driver:  v.GetString("storage.driver"),
+			Timeout: v.GetDuration("storage.timeout"),
+		},
 		Sqlite: Sqlite{
 			Path: v.GetString("sqlite.path"),
 		},
+		Postgres: Postgres{
+			DSN: v.GetString("postgres.dsn"),
+		},
 		Server: Server{
-			Port: v.GetString("server.port"),
+			Port:            v.GetString("server.port"),
+			GRPCPort:        v.GetString("server.grpc_port"),
+			ReadTimeout:     v.GetDuration("server.read_timeout"),
+			WriteTimeout:    v.GetDuration("server.write_timeout"),
+			IdleTimeout:     v.GetDuration("server.idle_timeout"),
+			ShutdownTimeout: v.GetDuration("server.shutdown_timeout"),
 		},
+		Webhooks: Webhooks{
+			Enabled:         v.GetBool("webhooks.enabled"),
+			MaxAttempts:     v.GetInt("webhooks.max_attempts"),
+			Timeout:         v.GetDuration("webhooks.timeout"),
+			MinBackoff:      v.GetDuration("webhooks.min_backoff"),
+			MaxBackoff:      v.GetDuration("webhooks.max_backoff"),
+			Workers:         v.GetInt("webhooks.workers"),
+			AllowedNetworks: splitList(v.GetString("webhooks.allowed_networks")),
+			DeniedNetworks:  splitList(v.GetString("webhooks.denied_networks")),
+		},
+		Idempotency: Idempotency{
+			Window: v.GetDuration("idempotency.window"),
+		},
+		RateLimit: RateLimit{
+			Signup: makeLimit(v, "rate_limit.signup"),
+			Write:  makeLimit(v, "rate_limit.write"),
+			Read:   makeLimit(v, "rate_limit.read"),
+		},
+	}
+}
+
+func makeLimit(v *viper.Viper, key string) Limit {
+	return Limit{
+		Rate:  v.GetFloat64(key + ".rate"),
+		Burst: v.GetInt(key + ".burst"),
+	}
+}
+
+// splitList splits comma separated list, empty string is empty list.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
 }
 
 func setDefaults(v *viper.Viper) {
 	v.SetDefault("log.path", "stderr")
 
+	v.SetDefault("storage.driver", "sqlite")
+	v.SetDefault("storage.timeout", "5s")
+
 	v.SetDefault("sqlite.path", ":memory:")
 
+	v.SetDefault("postgres.dsn", "host=localhost dbname=avito_exercise sslmode=disable")
+
 	v.SetDefault("server.port", "9000")
+	v.SetDefault("server.grpc_port", "")
+	v.SetDefault("server.read_timeout", "15s")
+	v.SetDefault("server.write_timeout", "0s")
+	v.SetDefault("server.idle_timeout", "2m")
+	// docker stop waits 10 seconds before killing container
+	v.SetDefault("server.shutdown_timeout", "8s")
+
+	v.SetDefault("webhooks.enabled", true)
+	v.SetDefault("webhooks.max_attempts", 8)
+	v.SetDefault("webhooks.timeout", "10s")
+	v.SetDefault("webhooks.min_backoff", "10s")
+	v.SetDefault("webhooks.max_backoff", "1h")
+	v.SetDefault("webhooks.workers", 4)
+	v.SetDefault("webhooks.allowed_networks", "")
+	v.SetDefault("webhooks.denied_networks", "")
+
+	v.SetDefault("idempotency.window", "24h")
+
+	v.SetDefault("rate_limit.signup.rate", 0.1)
+	v.SetDefault("rate_limit.signup.burst", 10)
+	v.SetDefault("rate_limit.write.rate", 5)
+	v.SetDefault("rate_limit.write.burst", 20)
+	v.SetDefault("rate_limit.read.rate", 20)
+	v.SetDefault("rate_limit.read.burst", 50)
 }
 
 // NewViper returns new configured *viper.Viper instance
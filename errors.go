@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"gopkg.in/go-playground/validator.v9"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// apiError is error reported to client.
+// Code is stable machine-readable identifier, Message is human-readable and may change.
+// RetryAfter is set when request can be repeated after delay.
+type apiError struct {
+	Status     int
+	Code       string
+	Message    string
+	Details    []fieldError
+	RetryAfter time.Duration
+}
+
+// fieldError describes validation rule that field of request violates.
+type fieldError struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+	Param string `json:"param,omitempty"`
+}
+
+// errorResponce is body of every response with error.
+type errorResponce struct {
+	Error   string       `json:"error"`
+	Code    string       `json:"code"`
+	Details []fieldError `json:"details,omitempty"`
+}
+
+var (
+	errJsonDecoding             = &apiError{Status: http.StatusBadRequest, Code: "invalid_json", Message: "json decoding error"}
+	errInvalidInput             = &apiError{Status: http.StatusBadRequest, Code: "invalid_input", Message: "invalid input"}
+	errUnauthorized             = &apiError{Status: http.StatusUnauthorized, Code: "unauthorized", Message: "unauthorized"}
+	errNotChatMember            = &apiError{Status: http.StatusForbidden, Code: "not_chat_member", Message: "user is not in the chat"}
+	errNotMessageAuthor         = &apiError{Status: http.StatusForbidden, Code: "not_message_author", Message: "user is not author of the message"}
+	errPermissionDenied         = &apiError{Status: http.StatusForbidden, Code: "permission_denied", Message: "role of user in the chat doesn't allow this action"}
+	errNotFound                 = &apiError{Status: http.StatusNotFound, Code: "not_found", Message: "not found"}
+	errUserNotFound             = &apiError{Status: http.StatusNotFound, Code: "user_not_found", Message: "nonexistent user"}
+	errMessageNotFound          = &apiError{Status: http.StatusNotFound, Code: "message_not_found", Message: "nonexistent message"}
+	errMemberNotFound           = &apiError{Status: http.StatusNotFound, Code: "member_not_found", Message: "user is not member of the chat"}
+	errWebhookNotFound          = &apiError{Status: http.StatusNotFound, Code: "webhook_not_found", Message: "nonexistent webhook"}
+	errMethodNotFound           = &apiError{Status: http.StatusNotFound, Code: "method_not_found", Message: "unknown method"}
+	errBadHttpMethod            = &apiError{Status: http.StatusMethodNotAllowed, Code: "http_method_not_allowed", Message: "http method not allowed"}
+	errConflict                 = &apiError{Status: http.StatusConflict, Code: "conflict", Message: "already exists"}
+	errUserExists               = &apiError{Status: http.StatusConflict, Code: "user_exists", Message: "User with this username is already added"}
+	errChatExists               = &apiError{Status: http.StatusConflict, Code: "chat_exists", Message: "chat with the same name is already exists"}
+	errMemberExists             = &apiError{Status: http.StatusConflict, Code: "member_exists", Message: "user is already member of the chat"}
+	errOwnerCannotLeave         = &apiError{Status: http.StatusConflict, Code: "owner_cannot_leave", Message: "owner must transfer ownership before leaving the chat"}
+	errIdempotencyKeyInProgress = &apiError{Status: http.StatusConflict, Code: "idempotency_key_in_progress", Message: "request with the same Idempotency-Key is in progress"}
+	errAlreadyAdded             = &apiError{Status: http.StatusConflict, Code: "already_added", Message: "user was already added by request with the same Idempotency-Key, its token is shown only once"}
+	errIdempotencyKeyReused     = &apiError{Status: http.StatusUnprocessableEntity, Code: "idempotency_key_reused", Message: "Idempotency-Key was already used with different request"}
+	errRateLimited              = &apiError{Status: http.StatusTooManyRequests, Code: "rate_limited", Message: "too many requests"}
+	errSlowMode                 = &apiError{Status: http.StatusTooManyRequests, Code: "slow_mode", Message: "chat is in slow mode, wait before sending next message"}
+	errInternal                 = &apiError{Status: http.StatusInternalServerError, Code: "internal_error", Message: "internal error"}
+	errSearchUnavailable        = &apiError{Status: http.StatusNotImplemented, Code: "search_unavailable", Message: "search is unavailable"}
+	errNotReady                 = &apiError{Status: http.StatusServiceUnavailable, Code: "not_ready", Message: "server can't serve requests"}
+	errShuttingDown             = &apiError{Status: http.StatusServiceUnavailable, Code: "shutting_down", Message: "server is shutting down"}
+	errStorageTimeout           = &apiError{Status: http.StatusServiceUnavailable, Code: "storage_timeout", Message: "database didn't respond in time"}
+	errResyncRequired           = &apiError{Status: http.StatusGone, Code: "resync_required", Message: "too many missed messages, load them with /messages/get and reconnect without last id"}
+)
+
+// badRequest returns error with http.StatusBadRequest status.
+func badRequest(code string, msg string) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Code: code, Message: msg}
+}
+
+// validationError returns errInvalidInput with details about every violated rule.
+func validationError(errs validator.ValidationErrors) *apiError {
+	details := make([]fieldError, len(errs))
+	for i, err := range errs {
+		details[i] = fieldError{
+			Field: err.Field(),
+			Rule:  err.ActualTag(),
+			Param: err.Param(),
+		}
+	}
+	apiErr := *errInvalidInput
+	apiErr.Details = details
+	return &apiErr
+}
+
+// storageApiError maps sentinel errors of storage to errors for client.
+// More specific errors are checked first. It returns nil if err is unexpected.
+func storageApiError(err error) *apiError {
+	switch {
+	case errors.Is(err, storage.ErrUserNotFound):
+		return errUserNotFound
+	case errors.Is(err, storage.ErrMessageNotFound):
+		return errMessageNotFound
+	case errors.Is(err, storage.ErrMemberNotFound):
+		return errMemberNotFound
+	case errors.Is(err, storage.ErrWebhookNotFound):
+		return errWebhookNotFound
+	case errors.Is(err, storage.ErrNotFound):
+		return errNotFound
+	case errors.Is(err, storage.ErrUserExists):
+		return errUserExists
+	case errors.Is(err, storage.ErrChatExists):
+		return errChatExists
+	case errors.Is(err, storage.ErrMemberExists):
+		return errMemberExists
+	case errors.Is(err, storage.ErrAlreadyExists):
+		return errConflict
+	case errors.Is(err, storage.ErrNotChatMember):
+		return errNotChatMember
+	case errors.Is(err, storage.ErrNotMessageAuthor):
+		return errNotMessageAuthor
+	case errors.Is(err, storage.ErrPermissionDenied):
+		return errPermissionDenied
+	case errors.Is(err, storage.ErrOwnerCannotLeave):
+		return errOwnerCannotLeave
+	case errors.Is(err, storage.ErrSearchUnavailable):
+		return errSearchUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return errStorageTimeout
+	case errors.Is(err, storage.ErrSlowMode):
+		apiErr := *errSlowMode
+		var slowModeErr *storage.SlowModeError
+		if errors.As(err, &slowModeErr) {
+			apiErr.RetryAfter = slowModeErr.RetryAfter
+		}
+		return &apiErr
+	}
+	return nil
+}
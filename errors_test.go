@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+func TestStorageApiError(t *testing.T) {
+	testCases := []struct {
+		Err      error
+		Expected *apiError
+	}{
+		{storage.ErrUserNotFound, errUserNotFound},
+		{storage.ErrNotFound, errNotFound},
+		{storage.ErrUserExists, errUserExists},
+		{storage.ErrChatExists, errChatExists},
+		{storage.ErrTokenExists, errConflict},
+		{storage.ErrMessageNotFound, errMessageNotFound},
+		{storage.ErrMemberNotFound, errMemberNotFound},
+		{storage.ErrMemberExists, errMemberExists},
+		{storage.ErrNotChatMember, errNotChatMember},
+		{storage.ErrNotMessageAuthor, errNotMessageAuthor},
+		{storage.ErrPermissionDenied, errPermissionDenied},
+		{storage.ErrOwnerCannotLeave, errOwnerCannotLeave},
+		{storage.ErrWebhookNotFound, errWebhookNotFound},
+		{storage.ErrSearchUnavailable, errSearchUnavailable},
+		{fmt.Errorf("AddUser failed: %w", storage.ErrUserExists), errUserExists},
+		{fmt.Errorf("GetUserChats failed: %w", context.DeadlineExceeded), errStorageTimeout},
+		{errors.New("disk is full"), nil},
+	}
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.Expected, storageApiError(testCase.Err), testCase.Err.Error())
+	}
+}
+
+func TestValidationErrorDetails(t *testing.T) {
+	server := NewServer(nil, nil, true)
+	request, err := http.NewRequest(http.MethodPost, "/chats/add",
+		strings.NewReader(`{"name": "1chat", "users": [1, -2]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	server.handleAddChat().ServeHTTP(recorder, withUserId(request, 1))
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	var responce errorResponce
+	if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
+		assert.Equal(t, errorResponce{
+			Error: "invalid input",
+			Code:  "invalid_input",
+			Details: []fieldError{
+				{Field: "name", Rule: "identificator"},
+				{Field: "users[1]", Rule: "gte", Param: "0"},
+			},
+		}, responce)
+	}
+}
+
+func TestRouterErrors(t *testing.T) {
+	server := NewServer(nil, nil, true)
+	testCases := []struct {
+		Method string
+		Path   string
+		Error  *apiError
+	}{
+		{http.MethodPost, "/unknown", errMethodNotFound},
+		{http.MethodGet, "/users/add", errBadHttpMethod},
+	}
+	for _, testCase := range testCases {
+		request, err := http.NewRequest(testCase.Method, testCase.Path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, request)
+
+		assert.Equal(t, testCase.Error.Status, recorder.Code, testCase.Path)
+		var responce errorResponce
+		if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
+			assert.Equal(t, testCase.Error.Code, responce.Code, testCase.Path)
+		}
+	}
+}
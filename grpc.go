@@ -0,0 +1,491 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gopkg.in/go-playground/validator.v9"
+
+	"github.com/Darkclainer/avito_exercise/chatpb"
+	"github.com/Darkclainer/avito_exercise/hub"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+const (
+	// grpcErrorCodeKey is trailer with code of apiError, the same as "code" in HTTP API responses
+	grpcErrorCodeKey = "error-code"
+	// grpcAddUserMethod is the only method that doesn't require authentication
+	grpcAddUserMethod = "/chat.ChatService/AddUser"
+	// grpcStreamPingPeriod is long, because connection is kept alive by HTTP/2 itself
+	grpcStreamPingPeriod = time.Minute
+)
+
+// grpcRateLimitGroups maps methods to groups of HTTP routes with the same purpose, so they share rate limits.
+var grpcRateLimitGroups = map[string]string{
+	grpcAddUserMethod:               rateLimitSignup,
+	"/chat.ChatService/AddChat":     rateLimitWrite,
+	"/chat.ChatService/AddMessage":  rateLimitWrite,
+	"/chat.ChatService/GetChats":    rateLimitRead,
+	"/chat.ChatService/GetMessages": rateLimitRead,
+	"/chat.ChatService/Subscribe":   rateLimitRead,
+}
+
+// grpcService implements gRPC API on top of Server. Requests are converted to the same request types
+// as in HTTP handlers, so they are validated by the same rules, and events are published to the same hub.
+type grpcService struct {
+	chatpb.UnimplementedChatServiceServer
+	s *Server
+}
+
+// NewGRPCServer returns gRPC server with chat service of s.
+// Calls are authenticated with "authorization: Bearer <token>" metadata.
+func NewGRPCServer(s *Server) *grpc.Server {
+	service := &grpcService{s: s}
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(chainUnary(service.instrumentUnary, service.logUnary, service.authenticateUnary)),
+		grpc.StreamInterceptor(chainStream(service.instrumentStream, service.logStream, service.authenticateStream)),
+	)
+	chatpb.RegisterChatServiceServer(server, service)
+	return server
+}
+
+// chainUnary returns interceptor that calls interceptors in order, the first one is the outermost.
+// grpc.ChainUnaryInterceptor isn't available in used version of gRPC.
+func chainUnary(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// chainStream is chainUnary for streams.
+func chainStream(interceptors ...grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(srv interface{}, stream grpc.ServerStream) error {
+				return interceptor(srv, stream, info, next)
+			}
+		}
+		return handler(srv, stream)
+	}
+}
+
+// contextStream replaces context of stream, interceptors use it to pass values to handler.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream contextStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (g *grpcService) authenticateUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	// unary calls don't wait for events, so the whole call is limited like HTTP request, see limitDuration
+	ctx, cancel := g.s.storageContext(ctx, 0)
+	defer cancel()
+	ctx, err := g.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.rateLimit(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (g *grpcService) authenticateStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	ctx, err := g.authenticate(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	if err := g.rateLimit(ctx, info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, contextStream{stream, ctx})
+}
+
+// authenticate resolves user by token from metadata and returns context with his id.
+func (g *grpcService) authenticate(ctx context.Context, method string) (context.Context, error) {
+	logger := g.getLogger(ctx)
+	logger.Debug("New request")
+	if method == grpcAddUserMethod {
+		return ctx, nil
+	}
+	var token string
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 && strings.HasPrefix(values[0], "Bearer ") {
+		token = strings.TrimSpace(strings.TrimPrefix(values[0], "Bearer "))
+	}
+	if token == "" {
+		return nil, g.error(ctx, logger, errUnauthorized)
+	}
+	lookupCtx, cancel := g.s.storageContext(ctx, 0)
+	defer cancel()
+	userId, err := g.s.Storage.GetUserIdByToken(lookupCtx, hashToken(token))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, g.error(ctx, logger, errUnauthorized)
+	}
+	if err != nil {
+		return nil, g.storageError(ctx, logger, fmt.Errorf("GetUserIdByToken failed: %w", err))
+	}
+	return withUserIdContext(ctx, userId), nil
+}
+
+// rateLimit returns error if client exceeded rate limit of method. It's called after authenticate.
+func (g *grpcService) rateLimit(ctx context.Context, method string) error {
+	userId, _ := ctx.Value(userIdContextKey).(int64)
+	if allowed, wait := g.s.allowRequest(grpcRateLimitGroups[method], userId, grpcRemoteIP(ctx)); !allowed {
+		apiErr := *errRateLimited
+		apiErr.RetryAfter = wait
+		return g.error(ctx, g.getLogger(ctx), &apiErr)
+	}
+	return nil
+}
+
+// grpcUserId returns id of authenticated user from context of call.
+func grpcUserId(ctx context.Context) int64 {
+	return ctx.Value(userIdContextKey).(int64)
+}
+
+// getLogger returns logger of call with its id and method, see logUnary and logStream.
+func (g *grpcService) getLogger(ctx context.Context) *logrus.Entry {
+	if logger := storage.LoggerFromContext(ctx); logger != nil {
+		return logger
+	}
+	method, _ := grpc.Method(ctx)
+	return g.s.Logger.WithField("grpc_method", method)
+}
+
+// error logs apiErr and returns it as gRPC status. Code of apiErr is sent in trailer,
+// violated validation rules are attached as BadRequest details, delay before retry as RetryInfo.
+func (g *grpcService) error(ctx context.Context, logger *logrus.Entry, apiErr *apiError) error {
+	logger.WithFields(logrus.Fields{
+		"respond_code": apiErr.Code,
+		"respond_msg":  apiErr.Message,
+	}).Debug("Server responded with error")
+	grpc.SetTrailer(ctx, metadata.Pairs(grpcErrorCodeKey, apiErr.Code))
+	st := status.New(grpcCode(apiErr.Status), apiErr.Message)
+	var details []proto.Message
+	if len(apiErr.Details) != 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, detail := range apiErr.Details {
+			description := detail.Rule
+			if detail.Param != "" {
+				description += "=" + detail.Param
+			}
+			badRequest.FieldViolations = append(badRequest.FieldViolations,
+				&errdetails.BadRequest_FieldViolation{Field: detail.Field, Description: description})
+		}
+		details = append(details, badRequest)
+	}
+	if apiErr.RetryAfter > 0 {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(apiErr.RetryAfter)})
+	}
+	if len(details) == 0 {
+		return st.Err()
+	}
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+// storageError returns error that corresponds to sentinel error of storage, unexpected errors are internal.
+func (g *grpcService) storageError(ctx context.Context, logger *logrus.Entry, err error) error {
+	logger = logger.WithField("error", err)
+	apiErr := storageApiError(err)
+	if apiErr == nil {
+		return g.error(ctx, logger, errInternal)
+	}
+	return g.error(ctx, logger, apiErr)
+}
+
+// validate checks request with the same rules as HTTP handlers.
+func (g *grpcService) validate(ctx context.Context, logger *logrus.Entry, request interface{}) error {
+	err := g.s.validate.Struct(request)
+	if err == nil {
+		return nil
+	}
+	validationErrors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return g.error(ctx, logger.WithField("error", err), errInternal)
+	}
+	return g.error(ctx, logger, validationError(validationErrors))
+}
+
+// grpcCode returns gRPC status code that corresponds to HTTP status of apiError.
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusGone:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
+
+func (g *grpcService) AddUser(ctx context.Context, req *chatpb.AddUserRequest) (*chatpb.AddUserResponse, error) {
+	request := addUserRequest{Username: req.Username}
+	logger := g.getLogger(ctx).WithField("username", request.Username)
+	if err := g.validate(ctx, logger, &request); err != nil {
+		return nil, err
+	}
+	token, err := newToken()
+	if err != nil {
+		return nil, g.error(ctx, logger.WithField("error", fmt.Errorf("newToken failed: %v", err)), errInternal)
+	}
+	id, err := g.s.Storage.AddUserWithToken(ctx, request.Username, hashToken(token))
+	if err != nil {
+		return nil, g.storageError(ctx, logger, fmt.Errorf("AddUserWithToken failed: %w", err))
+	}
+	return &chatpb.AddUserResponse{Id: id, Token: token}, nil
+}
+
+func (g *grpcService) AddChat(ctx context.Context, req *chatpb.AddChatRequest) (*chatpb.AddChatResponse, error) {
+	request := addChatRequest{Name: req.Name, UserIds: req.Users}
+	userId := grpcUserId(ctx)
+	logger := g.getLogger(ctx).WithFields(logrus.Fields{
+		"chat_name": request.Name,
+		"users":     request.UserIds,
+		"user_id":   userId,
+	})
+	if err := g.validate(ctx, logger, &request); err != nil {
+		return nil, err
+	}
+	if !containsId(request.UserIds, userId) {
+		request.UserIds = append(request.UserIds, userId)
+	}
+	chatId, err := g.s.Storage.AddChat(ctx, userId, request.Name, withoutId(request.UserIds, userId))
+	if err != nil {
+		return nil, g.storageError(ctx, logger, fmt.Errorf("AddChat failed: %w", err))
+	}
+	g.s.publishChat(logger.WithField("chat_id", chatId), chatId, userId)
+	return &chatpb.AddChatResponse{Id: chatId}, nil
+}
+
+func (g *grpcService) GetChats(ctx context.Context, req *chatpb.GetChatsRequest) (*chatpb.GetChatsResponse, error) {
+	userId := grpcUserId(ctx)
+	logger := g.getLogger(ctx).WithField("user_id", userId)
+	chats, err := g.s.Storage.GetUserChats(ctx, userId)
+	if err != nil {
+		return nil, g.storageError(ctx, logger, fmt.Errorf("GetUserChats failed: %w", err))
+	}
+	responce := &chatpb.GetChatsResponse{Chats: make([]*chatpb.Chat, len(chats))}
+	for i, chat := range chats {
+		responce.Chats[i] = protoChat(chat)
+	}
+	return responce, nil
+}
+
+func (g *grpcService) AddMessage(ctx context.Context, req *chatpb.AddMessageRequest) (*chatpb.AddMessageResponse, error) {
+	request := addMessageRequest{ChatId: req.Chat, Text: req.Text}
+	authorId := grpcUserId(ctx)
+	logger := g.getLogger(ctx).WithFields(logrus.Fields{
+		"chat_id":   request.ChatId,
+		"author_id": authorId,
+		"msg_text":  request.Text,
+	})
+	if err := g.validate(ctx, logger, &request); err != nil {
+		return nil, err
+	}
+	messageId, err := g.s.Storage.AddMessage(ctx, authorId, request.ChatId, request.Text)
+	if err != nil {
+		return nil, g.storageError(ctx, logger, fmt.Errorf("AddMessage failed: %w", err))
+	}
+	g.s.publishMessage(logger, messageId)
+	return &chatpb.AddMessageResponse{Id: messageId}, nil
+}
+
+func (g *grpcService) GetMessages(ctx context.Context, req *chatpb.GetMessagesRequest) (*chatpb.GetMessagesResponse, error) {
+	request := getMessagesRequest{
+		ChatId:   req.Chat,
+		Limit:    int(req.Limit),
+		BeforeId: req.BeforeId,
+		AfterId:  req.AfterId,
+		Cursor:   req.Cursor,
+	}
+	userId := grpcUserId(ctx)
+	logger := g.getLogger(ctx).WithFields(logrus.Fields{
+		"chat_id": request.ChatId,
+		"user_id": userId,
+	})
+	if err := g.validate(ctx, logger, &request); err != nil {
+		return nil, err
+	}
+	isUserInChat, err := g.s.Storage.IsUserInChat(ctx, userId, request.ChatId)
+	if err != nil {
+		return nil, g.storageError(ctx, logger, fmt.Errorf("IsUserInChat failed: %w", err))
+	}
+	if !isUserInChat {
+		return nil, g.error(ctx, logger, errNotChatMember)
+	}
+	query, apiErr := messagesPageQuery(&request)
+	if apiErr != nil {
+		return nil, g.error(ctx, logger, apiErr)
+	}
+	messages, err := g.s.Storage.GetMessagesPage(ctx, request.ChatId, query)
+	if err != nil {
+		return nil, g.storageError(ctx, logger, fmt.Errorf("GetMessagesPage failed: %w", err))
+	}
+	messages, nextCursor := splitMessagesPage(request.ChatId, query, messages)
+	responce := &chatpb.GetMessagesResponse{
+		Messages:   make([]*chatpb.Message, len(messages)),
+		NextCursor: nextCursor,
+	}
+	for i, message := range messages {
+		responce.Messages[i] = protoMessage(message)
+	}
+	return responce, nil
+}
+
+// Subscribe streams events like /ws. Client that doesn't keep up with events gets Unavailable status,
+// as well as every client when server is shutting down. Client that missed too many messages since
+// last_id gets FailedPrecondition status with "resync_required" code.
+func (g *grpcService) Subscribe(req *chatpb.SubscribeRequest, stream chatpb.ChatService_SubscribeServer) error {
+	ctx := stream.Context()
+	userId := grpcUserId(ctx)
+	logger := g.getLogger(ctx).WithFields(logrus.Fields{
+		"user_id": userId,
+		"last_id": req.LastId,
+	})
+	if req.LastId < 0 {
+		return g.error(ctx, logger, badRequest("invalid_input", "invalid last_id"))
+	}
+	// subscribe before loading missed messages, so nothing is lost between them
+	sub := g.s.Hub.Subscribe(userId, streamSendBuffer)
+	defer g.s.Hub.Unsubscribe(sub)
+
+	var missed []*storage.Message
+	if req.LastId > 0 {
+		var err error
+		missed, err = g.s.loadMessagesAfter(ctx, userId, req.LastId)
+		if err == errTooManyMissed {
+			return g.error(ctx, logger, errResyncRequired)
+		}
+		if err != nil {
+			return g.error(ctx, logger.WithField("error", err), errInternal)
+		}
+	}
+	logger.Debug("gRPC stream subscribed")
+	writer := &grpcEventWriter{stream: stream}
+	streamEvents(writer, sub, missed, grpcStreamPingPeriod, ctx.Done(), g.s.stopping, logger)
+	logger.Debug("gRPC stream unsubscribed")
+	if writer.tooSlow {
+		return status.Error(codes.Unavailable, "client is too slow")
+	}
+	if writer.goingAway {
+		return status.Error(codes.Unavailable, "server is shutting down")
+	}
+	return nil
+}
+
+// grpcEventWriter sends events to Subscribe stream.
+type grpcEventWriter struct {
+	stream    chatpb.ChatService_SubscribeServer
+	tooSlow   bool
+	goingAway bool
+}
+
+func (writer *grpcEventWriter) WriteEvent(event *hub.Event) error {
+	protoEvent := &chatpb.Event{Type: event.Type}
+	if event.Message != nil {
+		protoEvent.Message = protoMessage(event.Message)
+	}
+	if event.Chat != nil {
+		protoEvent.Chat = protoChat(event.Chat)
+	}
+	return writer.stream.Send(protoEvent)
+}
+
+// Ping does nothing, dead connections are detected by gRPC transport.
+func (writer *grpcEventWriter) Ping() error {
+	return nil
+}
+
+func (writer *grpcEventWriter) TooSlow() {
+	writer.tooSlow = true
+}
+
+func (writer *grpcEventWriter) GoingAway() {
+	writer.goingAway = true
+}
+
+func protoTime(t *time.Time) *timestamp.Timestamp {
+	if t == nil {
+		return nil
+	}
+	protoTimestamp, _ := ptypes.TimestampProto(*t)
+	return protoTimestamp
+}
+
+func protoMessage(message *storage.Message) *chatpb.Message {
+	protoMessage := &chatpb.Message{
+		Id:        message.Id,
+		Chat:      message.ChatId,
+		Author:    message.AuthorId,
+		Text:      message.Text,
+		CreatedAt: protoTime(&message.CreatedAt),
+		EditedAt:  protoTime(message.EditedAt),
+		Deleted:   message.Deleted,
+		Pinned:    message.Pinned,
+	}
+	if message.System != nil {
+		protoMessage.System = &chatpb.SystemEvent{Type: message.System.Type, User: message.System.UserId}
+	}
+	return protoMessage
+}
+
+func protoChat(chat *storage.Chat) *chatpb.Chat {
+	protoChat := &chatpb.Chat{
+		Id:                chat.Id,
+		Name:              chat.Name,
+		CreatedAt:         protoTime(&chat.CreatedAt),
+		Users:             chat.UserIds,
+		LastReadMessageId: chat.LastReadMessageId,
+		UnreadCount:       chat.UnreadCount,
+		LastMessageAt:     protoTime(chat.LastMessageAt),
+		SlowModeSeconds:   int32(chat.SlowModeSeconds),
+	}
+	if chat.LastMessage != nil {
+		protoChat.LastMessage = protoMessage(chat.LastMessage)
+	}
+	return protoChat
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/sirupsen/logrus"
+	logrusTest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/Darkclainer/avito_exercise/chatpb"
+	"github.com/Darkclainer/avito_exercise/hub"
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/ratelimit"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// startGRPC serves gRPC API of server in memory and returns client connected to it.
+func startGRPC(t *testing.T, server *Server) (chatpb.ChatServiceClient, func()) {
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := NewGRPCServer(server)
+	go grpcServer.Serve(listener)
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(),
+		grpc.WithDialer(func(string, time.Duration) (net.Conn, error) {
+			return listener.Dial()
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chatpb.NewChatServiceClient(conn), func() {
+		conn.Close()
+		grpcServer.Stop()
+	}
+}
+
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestGRPCAddUser(t *testing.T) {
+	ctx := context.Background()
+	mockStorage := &mocks.Storage{}
+	client, stop := startGRPC(t, NewServer(mockStorage, nil, true))
+	defer stop()
+
+	mockStorage.On("AddUserWithToken", testifyMock.Anything, "user_1", testifyMock.AnythingOfType("string")).
+		Return(int64(5), nil).Once()
+	responce, err := client.AddUser(ctx, &chatpb.AddUserRequest{Username: "user_1"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(5), responce.Id)
+		assert.Len(t, responce.Token, 64)
+	}
+
+	var trailer metadata.MD
+	_, err = client.AddUser(ctx, &chatpb.AddUserRequest{Username: "1user"}, grpc.Trailer(&trailer))
+	st := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	assert.Equal(t, []string{"invalid_input"}, trailer.Get(grpcErrorCodeKey))
+	if assert.Len(t, st.Details(), 1) {
+		badRequest := st.Details()[0].(*errdetails.BadRequest)
+		assert.Equal(t, "username", badRequest.FieldViolations[0].Field)
+		assert.Equal(t, "identificator", badRequest.FieldViolations[0].Description)
+	}
+	mockStorage.AssertExpectations(t)
+}
+
+func TestGRPCAuthentication(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	client, stop := startGRPC(t, NewServer(mockStorage, nil, true))
+	defer stop()
+
+	_, err := client.GetChats(context.Background(), &chatpb.GetChatsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	mockStorage.On("GetUserIdByToken", testifyMock.Anything, hashToken("unknown")).
+		Return(int64(0), storage.ErrNotFound).Once()
+	var trailer metadata.MD
+	_, err = client.GetChats(withToken("unknown"), &chatpb.GetChatsRequest{}, grpc.Trailer(&trailer))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Equal(t, []string{"unauthorized"}, trailer.Get(grpcErrorCodeKey))
+
+	mockStorage.On("GetUserIdByToken", testifyMock.Anything, hashToken("unknown")).
+		Return(int64(0), storage.ErrNotFound).Once()
+	stream, err := client.Subscribe(withToken("unknown"), &chatpb.SubscribeRequest{})
+	if assert.NoError(t, err) {
+		_, err = stream.Recv()
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	}
+	mockStorage.AssertExpectations(t)
+}
+
+func TestGRPCMessages(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	client, stop := startGRPC(t, NewServer(mockStorage, nil, true))
+	defer stop()
+	ctx := withToken("token_1")
+	mockStorage.On("GetUserIdByToken", testifyMock.Anything, hashToken("token_1")).Return(int64(1), nil)
+
+	mockStorage.On("AddMessage", testifyMock.Anything, int64(1), int64(11), "Hello").
+		Return(int64(0), storage.ErrNotChatMember).Once()
+	var trailer metadata.MD
+	_, err := client.AddMessage(ctx, &chatpb.AddMessageRequest{Chat: 11, Text: "Hello"}, grpc.Trailer(&trailer))
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Equal(t, []string{"not_chat_member"}, trailer.Get(grpcErrorCodeKey))
+
+	message := &storage.Message{Id: 50, ChatId: 10, AuthorId: 1, Text: "Hello",
+		CreatedAt: time.Date(2019, time.January, 1, 10, 0, 0, 0, time.UTC)}
+	mockStorage.On("IsUserInChat", testifyMock.Anything, int64(1), int64(10)).Return(true, nil)
+	mockStorage.On("AddMessage", testifyMock.Anything, int64(1), int64(10), "Hello").Return(int64(50), nil).Once()
+	mockStorage.On("GetMessage", testifyMock.Anything, int64(50)).Return(message, nil).Once()
+	mockStorage.On("GetChatUserIds", testifyMock.Anything, int64(10)).Return([]int64{1, 2}, nil).Once()
+	addResponce, err := client.AddMessage(ctx, &chatpb.AddMessageRequest{Chat: 10, Text: "Hello"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(50), addResponce.Id)
+	}
+
+	mockStorage.On("GetMessagesPage", testifyMock.Anything, int64(10), storage.PageQuery{Limit: 2}).
+		Return([]*storage.Message{message, {Id: 51, ChatId: 10}}, nil).Once()
+	getResponce, err := client.GetMessages(ctx, &chatpb.GetMessagesRequest{Chat: 10, Limit: 1})
+	if assert.NoError(t, err) && assert.Len(t, getResponce.Messages, 1) {
+		assert.Equal(t, protoMessage(message), getResponce.Messages[0])
+		assert.Equal(t, encodeMessagesCursor(10, storage.PageQuery{AfterId: 50}), getResponce.NextCursor)
+	}
+
+	_, err = client.GetMessages(ctx, &chatpb.GetMessagesRequest{Chat: 10, Limit: 1001})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	mockStorage.AssertExpectations(t)
+}
+
+func TestGRPCSubscribe(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	server := NewServer(mockStorage, nil, true)
+	client, stop := startGRPC(t, server)
+	defer stop()
+	mockStorage.On("GetUserIdByToken", testifyMock.Anything, hashToken("token_20")).Return(int64(20), nil)
+
+	ctx, cancel := context.WithCancel(withToken("token_20"))
+	defer cancel()
+	stream, err := client.Subscribe(ctx, &chatpb.SubscribeRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribers(t, server, 1)
+
+	message := &storage.Message{Id: 50, ChatId: 10, AuthorId: 21,
+		System: &storage.SystemEvent{Type: storage.MemberAdded, UserId: 20}}
+	server.Hub.Publish(hub.NewMessageEvent(message), []int64{20})
+	event, err := stream.Recv()
+	if assert.NoError(t, err) {
+		assert.Equal(t, hub.EventMessage, event.Type)
+		assert.Equal(t, protoMessage(message), event.Message)
+	}
+
+	chat := &storage.Chat{Id: 11, Name: "chat_1", UserIds: []int64{20, 21}}
+	server.Hub.Publish(hub.NewChatEvent(chat), []int64{20, 21})
+	event, err = stream.Recv()
+	if assert.NoError(t, err) {
+		assert.Equal(t, hub.EventChatCreated, event.Type)
+		assert.Equal(t, "chat_1", event.Chat.Name)
+		assert.Nil(t, event.Message)
+	}
+	cancel()
+	waitSubscribers(t, server, 0)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestGRPCSubscribeResyncRequired(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	server := NewServer(mockStorage, nil, true)
+	client, stop := startGRPC(t, server)
+	defer stop()
+	mockStorage.On("GetUserIdByToken", testifyMock.Anything, hashToken("token_20")).Return(int64(20), nil)
+	mockTooManyMissed(mockStorage, 20)
+
+	stream, err := client.Subscribe(withToken("token_20"), &chatpb.SubscribeRequest{LastId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Equal(t, []string{"resync_required"}, stream.Trailer().Get(grpcErrorCodeKey))
+	mockStorage.AssertExpectations(t)
+}
+
+func TestGRPCRateLimit(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	server := NewServer(mockStorage, nil, true)
+	server.RateLimits = map[string]*ratelimit.Limiter{
+		rateLimitRead: ratelimit.New(0.5, 1),
+	}
+	client, stop := startGRPC(t, server)
+	defer stop()
+	ctx := withToken("token_1")
+	mockStorage.On("GetUserIdByToken", testifyMock.Anything, hashToken("token_1")).Return(int64(1), nil)
+	mockStorage.On("GetUserChats", testifyMock.Anything, int64(1)).Return([]*storage.Chat{}, nil).Once()
+
+	_, err := client.GetChats(ctx, &chatpb.GetChatsRequest{})
+	assert.NoError(t, err)
+	var trailer metadata.MD
+	_, err = client.GetChats(ctx, &chatpb.GetChatsRequest{}, grpc.Trailer(&trailer))
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	assert.Equal(t, []string{"rate_limited"}, trailer.Get(grpcErrorCodeKey))
+	if assert.Len(t, st.Details(), 1) {
+		retryInfo := st.Details()[0].(*errdetails.RetryInfo)
+		delay, err := ptypes.Duration(retryInfo.RetryDelay)
+		assert.NoError(t, err)
+		assert.True(t, delay > 0 && delay <= 2*time.Second, "unexpected delay %s", delay)
+	}
+	mockStorage.AssertExpectations(t)
+}
+
+func TestGRPCLogRequest(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	server := NewServer(mockStorage, nil, true)
+	server.Logger.Level = logrus.DebugLevel
+	hook := logrusTest.NewLocal(server.Logger)
+	client, stop := startGRPC(t, server)
+	defer stop()
+	mockStorage.On("GetUserIdByToken", testifyMock.Anything, hashToken("token_7")).Return(int64(7), nil)
+	mockStorage.On("GetUserChats", testifyMock.Anything, int64(7)).
+		Run(func(args testifyMock.Arguments) {
+			logger := storage.LoggerFromContext(args.Get(0).(context.Context))
+			if assert.NotNil(t, logger) {
+				assert.Equal(t, "client-id.1", logger.Data["request_id"])
+				assert.Equal(t, int64(7), logger.Data["user_id"])
+			}
+		}).
+		Return([]*storage.Chat{}, nil).Once()
+
+	var header metadata.MD
+	ctx := metadata.AppendToOutgoingContext(withToken("token_7"), grpcRequestIdKey, "client-id.1")
+	_, err := client.GetChats(ctx, &chatpb.GetChatsRequest{}, grpc.Header(&header))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"client-id.1"}, header.Get(grpcRequestIdKey))
+	access := findLogEntry(hook, "Request handled")
+	if assert.NotNil(t, access) {
+		assert.Equal(t, logrus.InfoLevel, access.Level)
+		assert.Equal(t, "client-id.1", access.Data["request_id"])
+		assert.Equal(t, "/chat.ChatService/GetChats", access.Data["grpc_method"])
+		assert.Equal(t, "OK", access.Data["grpc_code"])
+		assert.Equal(t, int64(7), access.Data["user_id"])
+		assert.Contains(t, access.Data, "duration_ms")
+	}
+
+	// streams are logged when they end, invalid id is replaced
+	hook.Reset()
+	ctx = metadata.AppendToOutgoingContext(context.Background(), grpcRequestIdKey, "invalid id")
+	stream, err := client.Subscribe(ctx, &chatpb.SubscribeRequest{})
+	if assert.NoError(t, err) {
+		_, err = stream.Recv()
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		header, err = stream.Header()
+		assert.NoError(t, err)
+	}
+	access = findLogEntry(hook, "Request handled")
+	if assert.NotNil(t, access) {
+		assert.Len(t, access.Data["request_id"], 32)
+		assert.Equal(t, header.Get(grpcRequestIdKey), []string{access.Data["request_id"].(string)})
+		assert.Equal(t, "/chat.ChatService/Subscribe", access.Data["grpc_method"])
+		assert.Equal(t, "Unauthenticated", access.Data["grpc_code"])
+		assert.NotContains(t, access.Data, "user_id")
+	}
+	mockStorage.AssertExpectations(t)
+}
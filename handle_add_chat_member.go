@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// handleAddChatMember returns handler that adds user to chat with member role.
+// Authenticated user must be member of the chat that can invite, so readonly members can't.
+// Change is recorded in chat as system message, handler responds with its id.
+func (s *Server) handleAddChatMember() http.HandlerFunc {
+	type Request struct {
+		ChatId int64 `json:"chat" validate:"required,gte=0"`
+		UserId int64 `json:"user" validate:"required,gte=0"`
+	}
+	type Responce struct {
+		Id int64 `json:"id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request Request
+		if err := s.decodeAndValidate(w, r, &request); err != nil {
+			return
+		}
+		actorId := getUserId(r)
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"chat_id":  request.ChatId,
+			"user_id":  request.UserId,
+			"actor_id": actorId,
+		})
+		messageId, err := s.Storage.AddChatMember(r.Context(), actorId, request.ChatId, request.UserId)
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("AddChatMember failed: %w", err))
+			return
+		}
+		s.publishMessage(logger, messageId)
+		s.respond(w, r, Responce{messageId}, http.StatusOK)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+func TestHandleAddChatMember(t *testing.T) {
+	type TestCase struct {
+		TestName           string
+		RequestBody        string
+		ExpectedStatusCode int
+		ExpectedErrorMsg   string
+		ExpectedErrorCode  string
+		MockReturnId       int64
+		SetupStorage       func(mock *mocks.Storage, testCase *TestCase)
+	}
+
+	testCases := []*TestCase{
+		&TestCase{
+			TestName:           "OK",
+			RequestBody:        `{"chat": 10, "user": 2}`,
+			ExpectedStatusCode: http.StatusOK,
+			MockReturnId:       30,
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("AddChatMember", testifyMock.Anything, int64(1), int64(10), int64(2)).
+					Return(testCase.MockReturnId, nil).Once()
+				mock.On("GetMessage", testifyMock.Anything, testCase.MockReturnId).
+					Return(&storage.Message{Id: testCase.MockReturnId, ChatId: 10}, nil).Once()
+				mock.On("GetChatUserIds", testifyMock.Anything, int64(10)).Return([]int64{1, 2}, nil).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "User is not in the chat",
+			RequestBody:        `{"chat": 11, "user": 2}`,
+			ExpectedStatusCode: http.StatusForbidden,
+			ExpectedErrorMsg:   "user is not in the chat",
+			ExpectedErrorCode:  "not_chat_member",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("AddChatMember", testifyMock.Anything, int64(1), int64(11), int64(2)).
+					Return(int64(0), storage.ErrNotChatMember).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Already member",
+			RequestBody:        `{"chat": 10, "user": 3}`,
+			ExpectedStatusCode: http.StatusConflict,
+			ExpectedErrorMsg:   "user is already member of the chat",
+			ExpectedErrorCode:  "member_exists",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("AddChatMember", testifyMock.Anything, int64(1), int64(10), int64(3)).
+					Return(int64(0), storage.ErrMemberExists).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Nonexistent user",
+			RequestBody:        `{"chat": 10, "user": 100}`,
+			ExpectedStatusCode: http.StatusNotFound,
+			ExpectedErrorMsg:   "nonexistent user",
+			ExpectedErrorCode:  "user_not_found",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("AddChatMember", testifyMock.Anything, int64(1), int64(10), int64(100)).
+					Return(int64(0), storage.ErrUserNotFound).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Without user",
+			RequestBody:        `{"chat": 10}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+			},
+		},
+	}
+	server := NewServer(nil, nil, true)
+
+	type Responce struct {
+		Id    int64  `json:"id"`
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.TestName, func(t *testing.T) {
+			mockStorage := &mocks.Storage{}
+			server.Storage = mockStorage
+
+			requestData := strings.NewReader(testCase.RequestBody)
+			request, err := http.NewRequest(http.MethodPost, "/chats/members/add", requestData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request = withUserId(request, 1)
+			recorder := httptest.NewRecorder()
+
+			testCase.SetupStorage(mockStorage, testCase)
+
+			handler := server.handleAddChatMember()
+			handler.ServeHTTP(recorder, request)
+
+			mockStorage.AssertExpectations(t)
+			assert.Equal(t, testCase.ExpectedStatusCode, recorder.Code)
+
+			var responce Responce
+			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
+				assert.Equal(t, testCase.MockReturnId, responce.Id)
+				assert.Equal(t, testCase.ExpectedErrorMsg, responce.Error)
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
+			}
+		})
+	}
+}
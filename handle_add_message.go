@@ -6,35 +6,35 @@ import (
 	"net/http"
 )
 
+// addMessageRequest is body of /messages/add and request of AddMessage RPC.
+type addMessageRequest struct {
+	ChatId int64  `json:"chat" validate:"required,gte=0"`
+	Text   string `json:"text"`
+}
+
+// handleAddMessage returns handler that adds message from authenticated user to chat.
+// Readonly members can't write to chat, membership is checked by storage together with insert.
 func (s *Server) handleAddMessage() http.HandlerFunc {
-	type Request struct {
-		ChatId   int64  `json:"chat" validate:"required,gte=0"`
-		AuthorId int64  `json:"author" validate:"required,gte=0"`
-		Text     string `json:"text"`
-	}
 	type Responce struct {
 		Id int64 `json:"id"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		var request Request
+		var request addMessageRequest
 		if err := s.decodeAndValidate(w, r, &request); err != nil {
 			return
 		}
+		authorId := getUserId(r)
 		logger := s.getLogger(r).WithFields(logrus.Fields{
 			"chat_id":   request.ChatId,
-			"author_id": request.AuthorId,
+			"author_id": authorId,
 			"msg_text":  request.Text,
 		})
-		if isUserInChat, _ := s.Storage.IsUserInChat(request.AuthorId, request.ChatId); !isUserInChat {
-			s.respondWithError(w, r, logger, "user is not in the chat")
-			return
-		}
-		messageId, err := s.Storage.AddMessage(request.AuthorId, request.ChatId, request.Text)
+		messageId, err := s.Storage.AddMessage(r.Context(), authorId, request.ChatId, request.Text)
 		if err != nil {
-			s.respondWithInternalError(w, r, logger.WithField("error",
-				fmt.Errorf("AddMessage failed: %s", err)))
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("AddMessage failed: %w", err))
 			return
 		}
+		s.publishMessage(logger, messageId)
 		responce := Responce{messageId}
 		s.respond(w, r, responce, http.StatusOK)
 	}
@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
 
 	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
 )
 
 func TestHandleAddMessage(t *testing.T) {
@@ -18,6 +22,8 @@ func TestHandleAddMessage(t *testing.T) {
 		RequestBody        string
 		ExpectedStatusCode int
 		ExpectedErrorMsg   string
+		ExpectedErrorCode  string
+		ExpectedRetryAfter string
 		MockReturnId       int64
 		SetupStorage       func(mock *mocks.Storage, testCase *TestCase)
 	}
@@ -25,47 +31,85 @@ func TestHandleAddMessage(t *testing.T) {
 	testCases := []*TestCase{
 		&TestCase{
 			TestName:           "Add message",
-			RequestBody:        `{"chat": 10, "author": 20, "text": "Hello, World!"}`,
+			RequestBody:        `{"chat": 10, "text": "Hello, World!"}`,
 			ExpectedStatusCode: http.StatusOK,
 			MockReturnId:       50,
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
-				mock.On("IsUserInChat", int64(20), int64(10)).Return(true, nil)
-				mock.On("AddMessage", int64(20), int64(10), "Hello, World!").Return(testCase.MockReturnId, nil)
+				mock.On("AddMessage", testifyMock.Anything, int64(20), int64(10), "Hello, World!").
+					Return(testCase.MockReturnId, nil)
+				mock.On("GetMessage", testifyMock.Anything, testCase.MockReturnId).Return(&storage.Message{
+					Id: testCase.MockReturnId, ChatId: 10, AuthorId: 20, Text: "Hello, World!",
+				}, nil)
+				mock.On("GetChatUserIds", testifyMock.Anything, int64(10)).Return([]int64{20}, nil)
 			},
 		},
 		&TestCase{
 			TestName:           "Add message with empty text",
-			RequestBody:        `{"chat": 10, "author": 20, "text": ""}`,
+			RequestBody:        `{"chat": 10, "text": ""}`,
 			ExpectedStatusCode: http.StatusOK,
 			MockReturnId:       50,
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
-				mock.On("IsUserInChat", int64(20), int64(10)).Return(true, nil)
-				mock.On("AddMessage", int64(20), int64(10), "").Return(testCase.MockReturnId, nil)
+				mock.On("AddMessage", testifyMock.Anything, int64(20), int64(10), "").Return(testCase.MockReturnId, nil)
+				mock.On("GetMessage", testifyMock.Anything, testCase.MockReturnId).Return(&storage.Message{
+					Id: testCase.MockReturnId, ChatId: 10, AuthorId: 20, Text: "",
+				}, nil)
+				mock.On("GetChatUserIds", testifyMock.Anything, int64(10)).Return([]int64{20}, nil)
 			},
 		},
 		&TestCase{
 			TestName:           "Add message to nonexistent chat",
-			RequestBody:        `{"chat": 10, "author": 20, "text": "Hello, World!"}`,
+			RequestBody:        `{"chat": 10, "text": "Hello, World!"}`,
 			ExpectedErrorMsg:   "user is not in the chat",
-			ExpectedStatusCode: http.StatusInternalServerError,
+			ExpectedErrorCode:  "not_chat_member",
+			ExpectedStatusCode: http.StatusForbidden,
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("AddMessage", testifyMock.Anything, int64(20), int64(10), "Hello, World!").
+					Return(int64(0), storage.ErrNotChatMember)
+			},
+		},
+		&TestCase{
+			TestName:           "Storage timed out",
+			RequestBody:        `{"chat": 10, "text": "Hello, World!"}`,
+			ExpectedErrorMsg:   "database didn't respond in time",
+			ExpectedErrorCode:  "storage_timeout",
+			ExpectedStatusCode: http.StatusServiceUnavailable,
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
-				mock.On("IsUserInChat", int64(20), int64(10)).Return(false, nil)
+				mock.On("AddMessage", testifyMock.Anything, int64(20), int64(10), "Hello, World!").
+					Return(int64(0), context.DeadlineExceeded)
 			},
 		},
 		&TestCase{
 			TestName:           "Add message without chat",
-			RequestBody:        `{"author": 20, "text": "Hello, World!"}`,
-			ExpectedStatusCode: http.StatusInternalServerError,
+			RequestBody:        `{"text": "Hello, World!"}`,
+			ExpectedStatusCode: http.StatusBadRequest,
 			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
 			},
 		},
 		&TestCase{
-			TestName:           "Add message without author",
-			RequestBody:        `{"chat": 10, "text": "Hello, World!"}`,
-			ExpectedStatusCode: http.StatusInternalServerError,
-			ExpectedErrorMsg:   "invalid input",
+			TestName:           "Author is always authenticated user",
+			RequestBody:        `{"chat": 10, "author": 30, "text": "Hello"}`,
+			ExpectedStatusCode: http.StatusOK,
+			MockReturnId:       51,
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("AddMessage", testifyMock.Anything, int64(20), int64(10), "Hello").Return(testCase.MockReturnId, nil)
+				mock.On("GetMessage", testifyMock.Anything, testCase.MockReturnId).Return(&storage.Message{
+					Id: testCase.MockReturnId, ChatId: 10, AuthorId: 20, Text: "Hello",
+				}, nil)
+				mock.On("GetChatUserIds", testifyMock.Anything, int64(10)).Return([]int64{20, 30}, nil)
+			},
+		},
+		&TestCase{
+			TestName:           "Slow mode",
+			RequestBody:        `{"chat": 10, "text": "Hello"}`,
+			ExpectedStatusCode: http.StatusTooManyRequests,
+			ExpectedErrorMsg:   "chat is in slow mode, wait before sending next message",
+			ExpectedErrorCode:  "slow_mode",
+			ExpectedRetryAfter: "2",
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("AddMessage", testifyMock.Anything, int64(20), int64(10), "Hello").
+					Return(int64(0), &storage.SlowModeError{RetryAfter: 1500 * time.Millisecond})
 			},
 		},
 	}
@@ -74,6 +118,7 @@ func TestHandleAddMessage(t *testing.T) {
 	type Responce struct {
 		Id    int64  `json:"id"`
 		Error string `json:"error"`
+		Code  string `json:"code"`
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.TestName, func(t *testing.T) {
@@ -81,10 +126,11 @@ func TestHandleAddMessage(t *testing.T) {
 			server.Storage = mockStorage
 
 			requestData := strings.NewReader(testCase.RequestBody)
-			request, err := http.NewRequest(http.MethodPost, "/chats/add", requestData)
+			request, err := http.NewRequest(http.MethodPost, "/messages/add", requestData)
 			if err != nil {
 				t.Fatal(err)
 			}
+			request = withUserId(request, 20)
 			recorder := httptest.NewRecorder()
 
 			testCase.SetupStorage(mockStorage, testCase)
@@ -94,11 +140,13 @@ func TestHandleAddMessage(t *testing.T) {
 
 			mockStorage.AssertExpectations(t)
 			assert.Equal(t, testCase.ExpectedStatusCode, recorder.Code)
+			assert.Equal(t, testCase.ExpectedRetryAfter, recorder.Header().Get("Retry-After"))
 
 			var responce Responce
 			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
 				assert.Equal(t, testCase.MockReturnId, responce.Id)
 				assert.Equal(t, testCase.ExpectedErrorMsg, responce.Error)
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
 			}
 		})
 	}
@@ -5,37 +5,43 @@ import (
 	"net/http"
 )
 
+// addUserRequest is body of /users/add and request of AddUser RPC.
+type addUserRequest struct {
+	Username string `json:"username" validate:"username"`
+}
+
 /* handleAddUser return handle that andd new user on POST method
 
 Request body must be json dictionary with field "username" and value string.
 Valid username must start with ASCII letter and continue with letter, number or underscore.
 Maximum length is 32 characters.
-Handler return id of new user or error msg.
+Handler return id of new user and API token or error msg.
+User and its token are created atomically, taken username is reported by storage as user_exists.
+Token must be passed in "Authorization: Bearer <token>" header to other methods and is shown only once.
 */
 func (s *Server) handleAddUser() http.HandlerFunc {
-	type Request struct {
-		Username string `json:"username" validate:"username"`
-	}
 	type Responce struct {
-		Id int64 `json:"id"`
+		Id    int64  `json:"id"`
+		Token string `json:"token"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		var request Request
+		var request addUserRequest
 		if err := s.decodeAndValidate(w, r, &request); err != nil {
 			return
 		}
 		logger := s.getLogger(r).WithField("username", request.Username)
-		if isSameUser, _ := s.Storage.IsUserExists(request.Username); isSameUser {
-			s.respondWithError(w, r, logger, "User with this username is already added")
+		token, err := newToken()
+		if err != nil {
+			s.respondWithInternalError(w, r, logger.WithField("error",
+				fmt.Errorf("newToken failed: %v", err)))
 			return
 		}
-		id, err := s.Storage.AddUser(request.Username)
+		id, err := s.Storage.AddUserWithToken(r.Context(), request.Username, hashToken(token))
 		if err != nil {
-			s.respondWithInternalError(w, r, logger.WithField("error",
-				fmt.Errorf("AddUser failed unexpectedly: %v", err)))
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("AddUserWithToken failed: %w", err))
 			return
 		}
-		responce := Responce{id}
+		responce := Responce{id, token}
 		s.respond(w, r, responce, http.StatusOK)
 	}
 }
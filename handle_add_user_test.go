@@ -2,14 +2,18 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
 
 	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
 )
 
 func TestHandleAddUser(t *testing.T) {
@@ -18,6 +22,7 @@ func TestHandleAddUser(t *testing.T) {
 		RequestBody        string
 		ExpectedStatusCode int
 		ExpectedErrorMsg   string
+		ExpectedErrorCode  string
 		MockReturnId       int64
 		SetupStorage       func(mock *mocks.Storage, testCase *TestCase)
 	}
@@ -29,40 +34,45 @@ func TestHandleAddUser(t *testing.T) {
 			ExpectedStatusCode: http.StatusOK,
 			MockReturnId:       1,
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
-				mock.On("IsUserExists", "user_1").Return(false, nil).Once()
-				mock.On("AddUser", "user_1").Return(int64(1), nil).Once()
+				mock.On("AddUserWithToken", testifyMock.Anything, "user_1", testifyMock.AnythingOfType("string")).
+					Return(int64(1), nil).Once()
 			},
 		},
 		&TestCase{
 			TestName:           "Error request format",
 			RequestBody:        `{"username": "user_1"`,
-			ExpectedStatusCode: http.StatusInternalServerError,
+			ExpectedStatusCode: http.StatusBadRequest,
 			ExpectedErrorMsg:   "json decoding error",
+			ExpectedErrorCode:  "invalid_json",
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
 			},
 		},
 		&TestCase{
 			TestName:           "Add user with duplicate username",
 			RequestBody:        `{"username": "user_1"}`,
-			ExpectedStatusCode: http.StatusInternalServerError,
+			ExpectedStatusCode: http.StatusConflict,
 			ExpectedErrorMsg:   "User with this username is already added",
+			ExpectedErrorCode:  "user_exists",
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
-				mock.On("IsUserExists", "user_1").Return(true, nil).Once()
+				mock.On("AddUserWithToken", testifyMock.Anything, "user_1", testifyMock.AnythingOfType("string")).
+					Return(int64(0), storage.ErrUserExists).Once()
 			},
 		},
 		&TestCase{
 			TestName:           "Incorrect username",
 			RequestBody:        `{"username": "1_user"}`,
-			ExpectedStatusCode: http.StatusInternalServerError,
+			ExpectedStatusCode: http.StatusBadRequest,
 			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
 			},
 		},
 		&TestCase{
 			TestName:           "Too long username",
 			RequestBody:        `{"username": "u234567890123456789012345679012345"}`,
-			ExpectedStatusCode: http.StatusInternalServerError,
+			ExpectedStatusCode: http.StatusBadRequest,
 			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
 			},
 		},
@@ -71,7 +81,9 @@ func TestHandleAddUser(t *testing.T) {
 
 	type Responce struct {
 		Id    int64  `json:"id"`
+		Token string `json:"token"`
 		Error string `json:"error"`
+		Code  string `json:"code"`
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.TestName, func(t *testing.T) {
@@ -96,8 +108,43 @@ func TestHandleAddUser(t *testing.T) {
 			var responce Responce
 			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
 				assert.Equal(t, testCase.MockReturnId, responce.Id)
+				if testCase.ExpectedStatusCode == http.StatusOK {
+					assert.Len(t, responce.Token, 64)
+				}
 				assert.Equal(t, testCase.ExpectedErrorMsg, responce.Error)
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
 			}
 		})
 	}
 }
+
+func TestHandleAddUserConcurrently(t *testing.T) {
+	server := NewServer(storage.NewMemoryStorage(), nil, true)
+	handler := server.handleAddUser()
+
+	const requests = 20
+	codes := make(chan string, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			request := httptest.NewRequest(http.MethodPost, "/users/add", strings.NewReader(`{"username": "user_1"}`))
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			var responce struct {
+				Code string `json:"code"`
+			}
+			json.Unmarshal(recorder.Body.Bytes(), &responce)
+			codes <- fmt.Sprint(recorder.Code, responce.Code)
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := make(map[string]int)
+	for code := range codes {
+		counts[code]++
+	}
+	assert.Equal(t, map[string]int{"200": 1, "409user_exists": requests - 1}, counts)
+}
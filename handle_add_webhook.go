@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+)
+
+// handleAddWebhook returns handler that registers webhook of authenticated user.
+//
+// Request must contain http or https "url", which must not point to address denied by policy of dispatcher,
+// for example to loopback or private network. Webhook with "chat" gets events only from that chat,
+// and user must be admin or owner there. Without "chat" webhook gets events from all chats of user.
+// "events" restricts events webhook is notified about, all events are sent if it's empty.
+// Responce contains "secret" that signs payloads; it's returned only once.
+func (s *Server) handleAddWebhook() http.HandlerFunc {
+	type Request struct {
+		URL    string   `json:"url" validate:"required,url,max=2048"`
+		ChatId int64    `json:"chat" validate:"gte=0"`
+		Events []string `json:"events" validate:"unique,dive,oneof=message_created message_edited message_deleted member_added member_removed member_left"`
+	}
+	type Responce struct {
+		Id     int64  `json:"id"`
+		Secret string `json:"secret"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request Request
+		if err := s.decodeAndValidate(w, r, &request); err != nil {
+			return
+		}
+		userId := getUserId(r)
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"user_id": userId,
+			"chat_id": request.ChatId,
+			"url":     request.URL,
+		})
+		if parsed, err := url.Parse(request.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			s.respondWithError(w, r, logger, badRequest("invalid_input", "url must use http or https"))
+			return
+		}
+		if s.Webhooks != nil && s.Webhooks.Addresses.CheckURL(request.URL) != nil {
+			s.respondWithError(w, r, logger, badRequest("invalid_input", "url must not point to internal address"))
+			return
+		}
+		secret, err := newToken()
+		if err != nil {
+			s.respondWithInternalError(w, r, logger.WithField("error",
+				fmt.Errorf("newToken failed: %v", err)))
+			return
+		}
+		id, err := s.Storage.AddWebhook(r.Context(), userId, request.ChatId, request.URL, secret, request.Events)
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("AddWebhook failed: %w", err))
+			return
+		}
+		s.respond(w, r, Responce{id, secret}, http.StatusOK)
+	}
+}
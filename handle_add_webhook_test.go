@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+	"github.com/Darkclainer/avito_exercise/webhook"
+)
+
+func TestHandleAddWebhook(t *testing.T) {
+	type TestCase struct {
+		TestName           string
+		RequestBody        string
+		ExpectedStatusCode int
+		ExpectedId         int64
+		ExpectedErrorMsg   string
+		ExpectedErrorCode  string
+		SetupStorage       func(mock *mocks.Storage)
+	}
+
+	testCases := []*TestCase{
+		&TestCase{
+			TestName:           "Chat webhook",
+			RequestBody:        `{"url": "https://example.com/hook", "chat": 10, "events": ["message_created", "member_added"]}`,
+			ExpectedStatusCode: http.StatusOK,
+			ExpectedId:         5,
+			SetupStorage: func(m *mocks.Storage) {
+				m.On("AddWebhook", mock.Anything, int64(1), int64(10), "https://example.com/hook", mock.AnythingOfType("string"),
+					[]string{"message_created", "member_added"}).Return(int64(5), nil).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Global webhook",
+			RequestBody:        `{"url": "http://hooks.example.com:8080/hook"}`,
+			ExpectedStatusCode: http.StatusOK,
+			ExpectedId:         6,
+			SetupStorage: func(m *mocks.Storage) {
+				m.On("AddWebhook", mock.Anything, int64(1), int64(0), "http://hooks.example.com:8080/hook", mock.AnythingOfType("string"),
+					[]string(nil)).Return(int64(6), nil).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Not admin",
+			RequestBody:        `{"url": "https://example.com/hook", "chat": 10}`,
+			ExpectedStatusCode: http.StatusForbidden,
+			ExpectedErrorMsg:   "role of user in the chat doesn't allow this action",
+			ExpectedErrorCode:  "permission_denied",
+			SetupStorage: func(m *mocks.Storage) {
+				m.On("AddWebhook", mock.Anything, int64(1), int64(10), "https://example.com/hook", mock.AnythingOfType("string"),
+					[]string(nil)).Return(int64(0), storage.ErrPermissionDenied).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Not http url",
+			RequestBody:        `{"url": "ftp://example.com/hook"}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "url must use http or https",
+			ExpectedErrorCode:  "invalid_input",
+			SetupStorage:       func(m *mocks.Storage) {},
+		},
+		&TestCase{
+			TestName:           "Loopback url",
+			RequestBody:        `{"url": "http://localhost:8080/hook"}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "url must not point to internal address",
+			ExpectedErrorCode:  "invalid_input",
+			SetupStorage:       func(m *mocks.Storage) {},
+		},
+		&TestCase{
+			TestName:           "Metadata url",
+			RequestBody:        `{"url": "http://169.254.169.254/latest/meta-data"}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "url must not point to internal address",
+			ExpectedErrorCode:  "invalid_input",
+			SetupStorage:       func(m *mocks.Storage) {},
+		},
+		&TestCase{
+			TestName:           "Unknown event",
+			RequestBody:        `{"url": "https://example.com/hook", "events": ["chat_created"]}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
+			SetupStorage:       func(m *mocks.Storage) {},
+		},
+		&TestCase{
+			TestName:           "Without url",
+			RequestBody:        `{"chat": 10}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
+			SetupStorage:       func(m *mocks.Storage) {},
+		},
+	}
+	server := NewServer(nil, nil, true)
+	server.Webhooks = webhook.New(nil, nil)
+
+	type Responce struct {
+		Id     int64  `json:"id"`
+		Secret string `json:"secret"`
+		Error  string `json:"error"`
+		Code   string `json:"code"`
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.TestName, func(t *testing.T) {
+			mockStorage := &mocks.Storage{}
+			server.Storage = mockStorage
+
+			requestData := strings.NewReader(testCase.RequestBody)
+			request, err := http.NewRequest(http.MethodPost, "/webhooks/add", requestData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request = withUserId(request, 1)
+			recorder := httptest.NewRecorder()
+
+			testCase.SetupStorage(mockStorage)
+
+			handler := server.handleAddWebhook()
+			handler.ServeHTTP(recorder, request)
+
+			mockStorage.AssertExpectations(t)
+			assert.Equal(t, testCase.ExpectedStatusCode, recorder.Code)
+
+			var responce Responce
+			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
+				assert.Equal(t, testCase.ExpectedId, responce.Id)
+				assert.Equal(t, testCase.ExpectedErrorMsg, responce.Error)
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
+				if testCase.ExpectedStatusCode == http.StatusOK {
+					assert.Len(t, responce.Secret, 64)
+				} else {
+					assert.Empty(t, responce.Secret)
+				}
+			}
+		})
+	}
+}
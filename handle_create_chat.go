@@ -7,38 +7,62 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// addChatRequest is body of /chats/add and request of AddChat RPC.
+type addChatRequest struct {
+	Name    string  `json:"name" validate:"chatname"`
+	UserIds []int64 `json:"users" validate:"gt=0,unique,dive,gte=0,required"`
+}
+
+// handleAddChat returns handler that creates chat with specified users.
+// Authenticated user is always added to the chat as its owner.
+// Members of new chat are notified about it with chat_created event.
+// Taken name and unknown users are detected by storage when chat is inserted, so concurrent requests
+// can't create two chats with the same name.
 func (s *Server) handleAddChat() http.HandlerFunc {
-	type Request struct {
-		Name    string  `json:"name" validate:"chatname"`
-		UserIds []int64 `json:"users" validate:"gt=0,unique,dive,gte=0,required"`
-	}
 	type Responce struct {
 		Id int64 `json:"id"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		var request Request
+		var request addChatRequest
 		if err := s.decodeAndValidate(w, r, &request); err != nil {
 			return
 		}
+		userId := getUserId(r)
+		if !containsId(request.UserIds, userId) {
+			request.UserIds = append(request.UserIds, userId)
+		}
 		logger := s.getLogger(r).WithFields(logrus.Fields{
 			"chat_name": request.Name,
 			"users":     request.UserIds,
+			"user_id":   userId,
 		})
-		if isChatExists, _ := s.Storage.IsChatExists(request.Name); isChatExists {
-			s.respondWithError(w, r, logger, "chat with the same name is already exists")
-			return
-		}
-		if areUsersExist, _ := s.Storage.AreUsersExistByIds(request.UserIds); !areUsersExist {
-			s.respondWithError(w, r, logger, "nonexistent user")
-			return
-		}
-		chatId, err := s.Storage.AddChat(request.Name, request.UserIds)
+		chatId, err := s.Storage.AddChat(r.Context(), userId, request.Name, withoutId(request.UserIds, userId))
 		if err != nil {
-			s.respondWithInternalError(w, r, logger.WithField("error",
-				fmt.Errorf("AddChat failed: %s", err)))
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("AddChat failed: %w", err))
 			return
 		}
+		s.publishChat(logger.WithField("chat_id", chatId), chatId, userId)
 		responce := Responce{chatId}
 		s.respond(w, r, responce, http.StatusOK)
 	}
 }
+
+// withoutId returns copy of ids without id.
+func withoutId(ids []int64, id int64) []int64 {
+	result := make([]int64, 0, len(ids))
+	for _, other := range ids {
+		if other != id {
+			result = append(result, other)
+		}
+	}
+	return result
+}
+
+func containsId(ids []int64, id int64) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
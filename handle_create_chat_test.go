@@ -6,10 +6,14 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
 
+	"github.com/Darkclainer/avito_exercise/hub"
 	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
 )
 
 func TestHandleAddChat(t *testing.T) {
@@ -18,6 +22,7 @@ func TestHandleAddChat(t *testing.T) {
 		RequestBody        string
 		ExpectedStatusCode int
 		ExpectedErrorMsg   string
+		ExpectedErrorCode  string
 		MockReturnId       int64
 		SetupStorage       func(mock *mocks.Storage, testCase *TestCase)
 	}
@@ -29,9 +34,9 @@ func TestHandleAddChat(t *testing.T) {
 			ExpectedStatusCode: http.StatusOK,
 			MockReturnId:       2,
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
-				mock.On("IsChatExists", "chat_1").Return(false, nil)
-				mock.On("AreUsersExistByIds", []int64{1}).Return(true, nil)
-				mock.On("AddChat", "chat_1", []int64{1}).Return(testCase.MockReturnId, nil)
+				mock.On("AddChat", testifyMock.Anything, int64(1), "chat_1", []int64{}).Return(testCase.MockReturnId, nil)
+				mock.On("GetUserChats", testifyMock.Anything, int64(1)).Return([]*storage.Chat{
+					{Id: testCase.MockReturnId, Name: "chat_1", UserIds: []int64{1}}}, nil)
 			},
 		},
 		&TestCase{
@@ -40,83 +45,104 @@ func TestHandleAddChat(t *testing.T) {
 			ExpectedStatusCode: http.StatusOK,
 			MockReturnId:       4,
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
-				mock.On("IsChatExists", "chat_1").Return(false, nil)
-				mock.On("AreUsersExistByIds", []int64{1, 2, 3}).Return(true, nil)
-				mock.On("AddChat", "chat_1", []int64{1, 2, 3}).Return(testCase.MockReturnId, nil)
+				mock.On("AddChat", testifyMock.Anything, int64(1), "chat_1", []int64{2, 3}).Return(testCase.MockReturnId, nil)
+				mock.On("GetUserChats", testifyMock.Anything, int64(1)).Return([]*storage.Chat{
+					{Id: testCase.MockReturnId, Name: "chat_1", UserIds: []int64{1, 2, 3}}}, nil)
+			},
+		},
+		&TestCase{
+			TestName:           "Creator is added to the chat",
+			RequestBody:        `{"name": "chat_1", "users": [2, 3]}`,
+			ExpectedStatusCode: http.StatusOK,
+			MockReturnId:       5,
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("AddChat", testifyMock.Anything, int64(1), "chat_1", []int64{2, 3}).Return(testCase.MockReturnId, nil)
+				mock.On("GetUserChats", testifyMock.Anything, int64(1)).Return([]*storage.Chat{
+					{Id: testCase.MockReturnId, Name: "chat_1", UserIds: []int64{1, 2, 3}}}, nil)
 			},
 		},
 		&TestCase{
 			TestName:           "Add chat with nonexistent user",
 			RequestBody:        `{"name": "chat_1", "users": [1, 123]}`,
 			ExpectedErrorMsg:   "nonexistent user",
-			ExpectedStatusCode: http.StatusInternalServerError,
+			ExpectedErrorCode:  "user_not_found",
+			ExpectedStatusCode: http.StatusNotFound,
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
-				mock.On("IsChatExists", "chat_1").Return(false, nil)
-				mock.On("AreUsersExistByIds", []int64{1, 123}).Return(false, nil)
+				mock.On("AddChat", testifyMock.Anything, int64(1), "chat_1", []int64{123}).
+					Return(int64(0), storage.ErrUserNotFound)
 			},
 		},
 		&TestCase{
 			TestName:           "Add chat with duplicated name",
 			RequestBody:        `{"name": "chat_1", "users": [1, 2]}`,
-			ExpectedStatusCode: http.StatusInternalServerError,
+			ExpectedStatusCode: http.StatusConflict,
 			ExpectedErrorMsg:   "chat with the same name is already exists",
+			ExpectedErrorCode:  "chat_exists",
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
-				mock.On("IsChatExists", "chat_1").Return(true, nil)
+				mock.On("AddChat", testifyMock.Anything, int64(1), "chat_1", []int64{2}).
+					Return(int64(0), storage.ErrChatExists)
 			},
 		},
 		&TestCase{
 			TestName:           "Query without users",
 			RequestBody:        `{"name": "chat"}`,
-			ExpectedStatusCode: http.StatusInternalServerError,
+			ExpectedStatusCode: http.StatusBadRequest,
 			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
 			},
 		},
 		&TestCase{
 			TestName:           "Query without chat name",
 			RequestBody:        `{"users": [1, 2]}`,
-			ExpectedStatusCode: http.StatusInternalServerError,
+			ExpectedStatusCode: http.StatusBadRequest,
 			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
 			},
 		},
 		&TestCase{
 			TestName:           "Query with empty users list",
 			RequestBody:        `{"name": "chat", "users": []}`,
-			ExpectedStatusCode: http.StatusInternalServerError,
+			ExpectedStatusCode: http.StatusBadRequest,
 			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
 			},
 		},
 		&TestCase{
 			TestName:           "Query with duplicated users id",
 			RequestBody:        `{"name": "chat", "users": [2, 1, 1, 3]}`,
-			ExpectedStatusCode: http.StatusInternalServerError,
+			ExpectedStatusCode: http.StatusBadRequest,
 			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
 			},
 		},
 		&TestCase{
 			TestName:           "Query with invalid chat name",
 			RequestBody:        `{"name": "12chat", "users": [1, 2]}`,
-			ExpectedStatusCode: http.StatusInternalServerError,
+			ExpectedStatusCode: http.StatusBadRequest,
 			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
 			},
 		},
 		&TestCase{
 			TestName:           "Query with invalid user id type",
 			RequestBody:        `{"name": "chat", "users": [1, "sdf"]}`,
-			ExpectedStatusCode: http.StatusInternalServerError,
+			ExpectedStatusCode: http.StatusBadRequest,
 			ExpectedErrorMsg:   "json decoding error",
+			ExpectedErrorCode:  "invalid_json",
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
 			},
 		},
 		&TestCase{
 			TestName:           "Query with below zero user id",
 			RequestBody:        `{"name": "chat", "users": [1, -1]}`,
-			ExpectedStatusCode: http.StatusInternalServerError,
+			ExpectedStatusCode: http.StatusBadRequest,
 			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
 			},
 		},
@@ -126,6 +152,7 @@ func TestHandleAddChat(t *testing.T) {
 	type Responce struct {
 		Id    int64  `json:"id"`
 		Error string `json:"error"`
+		Code  string `json:"code"`
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.TestName, func(t *testing.T) {
@@ -137,6 +164,7 @@ func TestHandleAddChat(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
+			request = withUserId(request, 1)
 			recorder := httptest.NewRecorder()
 
 			testCase.SetupStorage(mockStorage, testCase)
@@ -151,7 +179,35 @@ func TestHandleAddChat(t *testing.T) {
 			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
 				assert.Equal(t, testCase.MockReturnId, responce.Id)
 				assert.Equal(t, testCase.ExpectedErrorMsg, responce.Error)
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
 			}
 		})
 	}
 }
+
+func TestHandleAddChatNotifiesMembers(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	server := NewServer(mockStorage, nil, true)
+	sub := server.Hub.Subscribe(2, 1)
+	defer server.Hub.Unsubscribe(sub)
+
+	chat := &storage.Chat{Id: 10, Name: "chat_1", UserIds: []int64{1, 2}}
+	mockStorage.On("AddChat", testifyMock.Anything, int64(1), "chat_1", []int64{2}).Return(chat.Id, nil)
+	mockStorage.On("GetUserChats", testifyMock.Anything, int64(1)).Return([]*storage.Chat{{Id: 9}, chat}, nil)
+
+	request, err := http.NewRequest(http.MethodPost, "/chats/add", strings.NewReader(`{"name": "chat_1", "users": [2]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	server.handleAddChat().ServeHTTP(recorder, withUserId(request, 1))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	select {
+	case received := <-sub.Events():
+		assert.Equal(t, hub.EventChatCreated, received.Type)
+		assert.Equal(t, chat, received.Chat)
+	case <-time.After(time.Second):
+		t.Fatal("Member didn't receive new chat")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// handleDeleteMessage returns handler that deletes message. Authenticated user must be author of the message
+// or admin or owner of its chat.
+// Message stays in chat as tombstone with "deleted" set, but its text and history are erased.
+// Handler responds with the tombstone.
+func (s *Server) handleDeleteMessage() http.HandlerFunc {
+	type Request struct {
+		MessageId int64 `json:"message" validate:"required,gte=0"`
+	}
+	type Responce struct {
+		Message *storage.Message `json:"message"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request Request
+		if err := s.decodeAndValidate(w, r, &request); err != nil {
+			return
+		}
+		authorId := getUserId(r)
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"message_id": request.MessageId,
+			"author_id":  authorId,
+		})
+		if err := s.Storage.DeleteMessage(r.Context(), authorId, request.MessageId); err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("DeleteMessage failed: %w", err))
+			return
+		}
+		message, err := s.Storage.GetMessage(r.Context(), request.MessageId)
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("GetMessage failed: %w", err))
+			return
+		}
+		s.notifyWebhooks(logger, storage.WebhookMessageDeleted, message)
+		s.respond(w, r, Responce{message}, http.StatusOK)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// handleDeleteWebhook returns handler that deletes webhook of authenticated user with its queued deliveries.
+func (s *Server) handleDeleteWebhook() http.HandlerFunc {
+	type Request struct {
+		WebhookId int64 `json:"webhook" validate:"required,gte=0"`
+	}
+	type Responce struct {
+		Id int64 `json:"id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request Request
+		if err := s.decodeAndValidate(w, r, &request); err != nil {
+			return
+		}
+		userId := getUserId(r)
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"user_id":    userId,
+			"webhook_id": request.WebhookId,
+		})
+		if err := s.Storage.DeleteWebhook(r.Context(), userId, request.WebhookId); err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("DeleteWebhook failed: %w", err))
+			return
+		}
+		s.respond(w, r, Responce{request.WebhookId}, http.StatusOK)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+func TestHandleDeleteWebhook(t *testing.T) {
+	type TestCase struct {
+		TestName           string
+		RequestBody        string
+		ExpectedStatusCode int
+		ExpectedId         int64
+		ExpectedErrorMsg   string
+		ExpectedErrorCode  string
+		SetupStorage       func(mock *mocks.Storage)
+	}
+
+	testCases := []*TestCase{
+		&TestCase{
+			TestName:           "OK",
+			RequestBody:        `{"webhook": 5}`,
+			ExpectedStatusCode: http.StatusOK,
+			ExpectedId:         5,
+			SetupStorage: func(mock *mocks.Storage) {
+				mock.On("DeleteWebhook", testifyMock.Anything, int64(1), int64(5)).Return(nil).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Webhook of another user",
+			RequestBody:        `{"webhook": 6}`,
+			ExpectedStatusCode: http.StatusNotFound,
+			ExpectedErrorMsg:   "nonexistent webhook",
+			ExpectedErrorCode:  "webhook_not_found",
+			SetupStorage: func(mock *mocks.Storage) {
+				mock.On("DeleteWebhook", testifyMock.Anything, int64(1), int64(6)).Return(storage.ErrWebhookNotFound).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Without webhook",
+			RequestBody:        `{}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
+			SetupStorage:       func(mock *mocks.Storage) {},
+		},
+	}
+	server := NewServer(nil, nil, true)
+
+	type Responce struct {
+		Id    int64  `json:"id"`
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.TestName, func(t *testing.T) {
+			mockStorage := &mocks.Storage{}
+			server.Storage = mockStorage
+
+			requestData := strings.NewReader(testCase.RequestBody)
+			request, err := http.NewRequest(http.MethodPost, "/webhooks/delete", requestData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request = withUserId(request, 1)
+			recorder := httptest.NewRecorder()
+
+			testCase.SetupStorage(mockStorage)
+
+			handler := server.handleDeleteWebhook()
+			handler.ServeHTTP(recorder, request)
+
+			mockStorage.AssertExpectations(t)
+			assert.Equal(t, testCase.ExpectedStatusCode, recorder.Code)
+
+			var responce Responce
+			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
+				assert.Equal(t, testCase.ExpectedId, responce.Id)
+				assert.Equal(t, testCase.ExpectedErrorMsg, responce.Error)
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
+			}
+		})
+	}
+}
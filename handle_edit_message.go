@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// handleEditMessage returns handler that replaces text of message. Only author can edit message
+// and deleted message can't be edited. Previous text is kept in history of message.
+// Handler responds with edited message.
+func (s *Server) handleEditMessage() http.HandlerFunc {
+	type Request struct {
+		MessageId int64  `json:"message" validate:"required,gte=0"`
+		Text      string `json:"text"`
+	}
+	type Responce struct {
+		Message *storage.Message `json:"message"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request Request
+		if err := s.decodeAndValidate(w, r, &request); err != nil {
+			return
+		}
+		authorId := getUserId(r)
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"message_id": request.MessageId,
+			"author_id":  authorId,
+			"msg_text":   request.Text,
+		})
+		if err := s.Storage.EditMessage(r.Context(), authorId, request.MessageId, request.Text); err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("EditMessage failed: %w", err))
+			return
+		}
+		message, err := s.Storage.GetMessage(r.Context(), request.MessageId)
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("GetMessage failed: %w", err))
+			return
+		}
+		s.notifyWebhooks(logger, storage.WebhookMessageEdited, message)
+		s.respond(w, r, Responce{message}, http.StatusOK)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+func TestHandleEditMessage(t *testing.T) {
+	editedAt := time.Date(2019, time.January, 1, 10, 5, 0, 0, time.UTC)
+	type TestCase struct {
+		TestName           string
+		RequestBody        string
+		ExpectedStatusCode int
+		ExpectedErrorMsg   string
+		ExpectedErrorCode  string
+		Responce           *storage.Message
+		SetupStorage       func(mock *mocks.Storage, testCase *TestCase)
+	}
+
+	testCases := []*TestCase{
+		&TestCase{
+			TestName:           "OK",
+			RequestBody:        `{"message": 20, "text": "Hello, World"}`,
+			ExpectedStatusCode: http.StatusOK,
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("EditMessage", testifyMock.Anything, int64(1), int64(20), "Hello, World").Return(nil).Once()
+				mock.On("GetMessage", testifyMock.Anything, int64(20)).Return(testCase.Responce, nil).Once()
+			},
+			Responce: &storage.Message{
+				Id: 20, ChatId: 10, AuthorId: 1,
+				Text:      "Hello, World",
+				CreatedAt: time.Date(2019, time.January, 1, 10, 0, 0, 0, time.UTC),
+				EditedAt:  &editedAt,
+			},
+		},
+		&TestCase{
+			TestName:           "Message of another user",
+			RequestBody:        `{"message": 21, "text": "Hacked"}`,
+			ExpectedStatusCode: http.StatusForbidden,
+			ExpectedErrorMsg:   "user is not author of the message",
+			ExpectedErrorCode:  "not_message_author",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("EditMessage", testifyMock.Anything, int64(1), int64(21), "Hacked").
+					Return(storage.ErrNotMessageAuthor).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Nonexistent message",
+			RequestBody:        `{"message": 22, "text": "Hello"}`,
+			ExpectedStatusCode: http.StatusNotFound,
+			ExpectedErrorMsg:   "nonexistent message",
+			ExpectedErrorCode:  "message_not_found",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("EditMessage", testifyMock.Anything, int64(1), int64(22), "Hello").Return(storage.ErrMessageNotFound).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Without message id",
+			RequestBody:        `{"text": "Hello"}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+			},
+		},
+	}
+	server := NewServer(nil, nil, true)
+
+	type Responce struct {
+		Message *storage.Message `json:"message"`
+		Error   string           `json:"error"`
+		Code    string           `json:"code"`
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.TestName, func(t *testing.T) {
+			mockStorage := &mocks.Storage{}
+			server.Storage = mockStorage
+
+			requestData := strings.NewReader(testCase.RequestBody)
+			request, err := http.NewRequest(http.MethodPost, "/messages/edit", requestData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request = withUserId(request, 1)
+			recorder := httptest.NewRecorder()
+
+			testCase.SetupStorage(mockStorage, testCase)
+
+			handler := server.handleEditMessage()
+			handler.ServeHTTP(recorder, request)
+
+			mockStorage.AssertExpectations(t)
+			assert.Equal(t, testCase.ExpectedStatusCode, recorder.Code)
+
+			var responce Responce
+			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
+				assert.Equal(t, testCase.Responce, responce.Message)
+				assert.Equal(t, testCase.ExpectedErrorMsg, responce.Error)
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
+			}
+		})
+	}
+}
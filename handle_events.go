@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Darkclainer/avito_exercise/hub"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+const (
+	ssePingPeriod = 30 * time.Second
+	// sseRetry is reconnection delay in milliseconds suggested to EventSource
+	sseRetry = 3000
+)
+
+// handleEvents returns handler that streams new messages from all chats of authenticated user
+// and chats created with him as Server-Sent Events.
+//
+// Messages are sent with their id as event id, so after reconnect client (or EventSource) can set
+// "Last-Event-ID" header and missed messages are sent first, see loadMessagesAfter.
+// Some of them may be already known to client, it skips them by id.
+// Event type is "message" for messages and type of system event for system messages
+// ("member_added", "member_removed", "member_left"), data is message JSON.
+// New chats are sent as "chat_created" events without id, they aren't resent after reconnect.
+// If client missed too many messages, "error" event with errResyncRequired is sent and stream ends.
+// The event resets last event id, so EventSource reconnects without it.
+// Client that doesn't keep up with events is disconnected and should reconnect.
+func (s *Server) handleEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := getUserId(r)
+		var lastId int64
+		if rawLastId := r.Header.Get("Last-Event-ID"); rawLastId != "" {
+			var err error
+			lastId, err = strconv.ParseInt(rawLastId, 10, 64)
+			if err != nil || lastId < 0 {
+				s.respondWithError(w, r, nil, badRequest("invalid_input", "invalid Last-Event-ID"))
+				return
+			}
+		}
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"user_id": userId,
+			"last_id": lastId,
+		})
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			s.respondWithInternalError(w, r, logger.WithField("error", errors.New("streaming is unsupported")))
+			return
+		}
+
+		// subscribe before loading missed messages, so nothing is lost between them
+		sub := s.Hub.Subscribe(userId, streamSendBuffer)
+		defer s.Hub.Unsubscribe(sub)
+		var missed []*storage.Message
+		var resyncRequired bool
+		if lastId > 0 {
+			var err error
+			missed, err = s.loadMessagesAfter(r.Context(), userId, lastId)
+			resyncRequired = err == errTooManyMissed
+			if err != nil && !resyncRequired {
+				s.respondWithInternalError(w, r, logger.WithField("error", err))
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		// disable buffering of nginx, so events aren't delayed
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "retry: %d\n\n", sseRetry)
+		flusher.Flush()
+		if resyncRequired {
+			logger.Debug("Event stream client missed too many messages")
+			sseWriter{w, flusher}.ResyncRequired()
+			return
+		}
+
+		logger.Debug("Event stream subscribed")
+		streamEvents(sseWriter{w, flusher}, sub, missed, ssePingPeriod, r.Context().Done(), s.stopping, logger)
+		logger.Debug("Event stream unsubscribed")
+	}
+}
+
+// sseWriter sends events in text/event-stream format.
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (w sseWriter) WriteEvent(event *hub.Event) error {
+	var data interface{}
+	name := event.Type
+	if event.Type == hub.EventMessage {
+		data = event.Message
+		if event.Message.System != nil {
+			name = event.Message.System.Type
+		}
+		if _, err := fmt.Fprintf(w.w, "id: %d\n", event.Message.Id); err != nil {
+			return err
+		}
+	} else {
+		data = event.Chat
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w.w, "event: %s\ndata: %s\n\n", name, encoded); err != nil {
+		return err
+	}
+	w.flusher.Flush()
+	return nil
+}
+
+// Ping sends comment, that keeps connection alive through proxies.
+func (w sseWriter) Ping() error {
+	if _, err := fmt.Fprint(w.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	w.flusher.Flush()
+	return nil
+}
+
+func (w sseWriter) TooSlow() {
+	fmt.Fprint(w.w, ": too slow, reconnect with Last-Event-ID\n\n")
+	w.flusher.Flush()
+}
+
+// ResyncRequired sends errResyncRequired as "error" event with empty id.
+func (w sseWriter) ResyncRequired() {
+	encoded, _ := json.Marshal(errorResponce{Error: errResyncRequired.Message, Code: errResyncRequired.Code})
+	fmt.Fprintf(w.w, "id: \nevent: error\ndata: %s\n\n", encoded)
+	w.flusher.Flush()
+}
+
+func (w sseWriter) GoingAway() {
+	fmt.Fprint(w.w, ": server is shutting down, reconnect with Last-Event-ID\n\n")
+	w.flusher.Flush()
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/hub"
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+type serverSentEvent struct {
+	Id    string
+	Event string
+	Data  string
+}
+
+// eventStream reads server-sent events from responce body in background.
+type eventStream struct {
+	responce *http.Response
+	events   chan serverSentEvent
+}
+
+func openEventStream(t *testing.T, testServer *httptest.Server, token string, lastEventId string) *eventStream {
+	request, err := http.NewRequest(http.MethodGet, testServer.URL+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	if lastEventId != "" {
+		request.Header.Set("Last-Event-ID", lastEventId)
+	}
+	responce, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal("Get failed: ", err)
+	}
+	stream := &eventStream{responce, make(chan serverSentEvent, 10)}
+	go func() {
+		defer close(stream.events)
+		scanner := bufio.NewScanner(responce.Body)
+		var event serverSentEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if event.Event != "" {
+					stream.events <- event
+				}
+				event = serverSentEvent{}
+			case strings.HasPrefix(line, "id: "):
+				event.Id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				event.Event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.Data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return stream
+}
+
+func (stream *eventStream) Close() {
+	stream.responce.Body.Close()
+}
+
+func (stream *eventStream) read(t *testing.T) serverSentEvent {
+	select {
+	case event, ok := <-stream.events:
+		if !ok {
+			t.Fatal("Event stream is closed")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("Event wasn't received")
+	}
+	return serverSentEvent{}
+}
+
+func TestHandleEvents(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	server := NewServer(mockStorage, nil, true)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	mockStorage.On("GetUserIdByToken", testifyMock.Anything, hashToken("token_20")).Return(int64(20), nil)
+	stream := openEventStream(t, testServer, "token_20", "")
+	defer stream.Close()
+	assert.Equal(t, http.StatusOK, stream.responce.StatusCode)
+	assert.Equal(t, "text/event-stream", stream.responce.Header.Get("Content-Type"))
+	waitSubscribers(t, server, 1)
+
+	message := &storage.Message{Id: 50, ChatId: 10, AuthorId: 20, Text: "Hello",
+		CreatedAt: time.Date(2019, time.January, 1, 10, 0, 0, 0, time.UTC)}
+	server.Hub.Publish(hub.NewMessageEvent(message), []int64{20})
+	event := stream.read(t)
+	assert.Equal(t, "50", event.Id)
+	assert.Equal(t, "message", event.Event)
+	var received storage.Message
+	if assert.NoError(t, json.Unmarshal([]byte(event.Data), &received)) {
+		assert.Equal(t, *message, received)
+	}
+
+	systemMessage := &storage.Message{Id: 51, ChatId: 10, AuthorId: 21,
+		System: &storage.SystemEvent{Type: storage.MemberAdded, UserId: 22}}
+	server.Hub.Publish(hub.NewMessageEvent(systemMessage), []int64{20})
+	event = stream.read(t)
+	assert.Equal(t, "51", event.Id)
+	assert.Equal(t, storage.MemberAdded, event.Event)
+
+	chat := &storage.Chat{Id: 11, Name: "chat_1", UserIds: []int64{20, 21},
+		CreatedAt: time.Date(2019, time.January, 1, 11, 0, 0, 0, time.UTC)}
+	server.Hub.Publish(hub.NewChatEvent(chat), []int64{20, 21})
+	event = stream.read(t)
+	assert.Equal(t, "", event.Id)
+	assert.Equal(t, "chat_created", event.Event)
+	var receivedChat storage.Chat
+	if assert.NoError(t, json.Unmarshal([]byte(event.Data), &receivedChat)) {
+		assert.Equal(t, *chat, receivedChat)
+	}
+	mockStorage.AssertExpectations(t)
+}
+
+func TestHandleEventsResume(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	server := NewServer(mockStorage, nil, true)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	mockStorage.On("GetUserIdByToken", testifyMock.Anything, hashToken("token_20")).Return(int64(20), nil)
+	mockStorage.On("GetUserChats", testifyMock.Anything, int64(20)).Return([]*storage.Chat{
+		&storage.Chat{Id: 10}, &storage.Chat{Id: 11},
+	}, nil)
+	mockResumeAfter5(mockStorage)
+
+	stream := openEventStream(t, testServer, "token_20", "5")
+	defer stream.Close()
+	// messages of resume window are sent again, they may have been published after message 5
+	for _, id := range []string{"4", "5", "6", "7", "8"} {
+		assert.Equal(t, id, stream.read(t).Id)
+	}
+	// message that was already sent as missed must not be repeated
+	server.Hub.Publish(hub.NewMessageEvent(&storage.Message{Id: 8, ChatId: 10}), []int64{20})
+	server.Hub.Publish(hub.NewMessageEvent(&storage.Message{Id: 9, ChatId: 11}), []int64{20})
+	assert.Equal(t, "9", stream.read(t).Id)
+	// messages of different chats may be published out of order, none of them is lost
+	server.Hub.Publish(hub.NewMessageEvent(&storage.Message{Id: 12, ChatId: 10}), []int64{20})
+	server.Hub.Publish(hub.NewMessageEvent(&storage.Message{Id: 11, ChatId: 11}), []int64{20})
+	assert.Equal(t, "12", stream.read(t).Id)
+	assert.Equal(t, "11", stream.read(t).Id)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestHandleEventsResyncRequired(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	server := NewServer(mockStorage, nil, true)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+	mockStorage.On("GetUserIdByToken", testifyMock.Anything, hashToken("token_20")).Return(int64(20), nil)
+	mockTooManyMissed(mockStorage, 20)
+
+	stream := openEventStream(t, testServer, "token_20", "1")
+	defer stream.Close()
+	event := stream.read(t)
+	assert.Equal(t, "error", event.Event)
+	assert.Equal(t, "", event.Id, "last event id must be reset")
+	assert.JSONEq(t, `{"error": "`+errResyncRequired.Message+`", "code": "resync_required"}`, event.Data)
+	_, ok := <-stream.events
+	assert.False(t, ok, "stream should end after error")
+	mockStorage.AssertExpectations(t)
+}
+
+func TestHandleEventsInvalidLastEventId(t *testing.T) {
+	server := NewServer(&mocks.Storage{}, nil, true)
+	request, err := http.NewRequest(http.MethodGet, "/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Last-Event-ID", "abc")
+	recorder := httptest.NewRecorder()
+	server.handleEvents().ServeHTTP(recorder, withUserId(request, 20))
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, 0, server.Hub.Subscribers())
+}
+
+func TestHandleEventsStopStreams(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	server := NewServer(mockStorage, nil, true)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	mockStorage.On("GetUserIdByToken", testifyMock.Anything, hashToken("token_20")).Return(int64(20), nil)
+	stream := openEventStream(t, testServer, "token_20", "")
+	defer stream.Close()
+	waitSubscribers(t, server, 1)
+
+	server.StopStreams()
+	select {
+	case _, ok := <-stream.events:
+		assert.False(t, ok, "stream should end without events")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream wasn't closed")
+	}
+	waitSubscribers(t, server, 0)
+	mockStorage.AssertExpectations(t)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// handleGetChatMembers returns handler that responds with members of chat and their roles sorted by user id.
+// Authenticated user must be member of the chat.
+func (s *Server) handleGetChatMembers() http.HandlerFunc {
+	type Request struct {
+		ChatId int64 `json:"chat" validate:"required,gte=0"`
+	}
+	type Responce struct {
+		Members []*storage.ChatMember `json:"members"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request Request
+		if err := s.decodeAndValidate(w, r, &request); err != nil {
+			return
+		}
+		userId := getUserId(r)
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"chat_id": request.ChatId,
+			"user_id": userId,
+		})
+		isUserInChat, err := s.Storage.IsUserInChat(r.Context(), userId, request.ChatId)
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("IsUserInChat failed: %w", err))
+			return
+		}
+		if !isUserInChat {
+			s.respondWithError(w, r, logger, errNotChatMember)
+			return
+		}
+		members, err := s.Storage.GetChatMembers(r.Context(), request.ChatId)
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("GetChatMembers failed: %w", err))
+			return
+		}
+		s.respond(w, r, Responce{members}, http.StatusOK)
+	}
+}
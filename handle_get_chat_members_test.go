@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+func TestHandleGetChatMembers(t *testing.T) {
+	type TestCase struct {
+		TestName           string
+		RequestBody        string
+		ExpectedStatusCode int
+		ExpectedErrorMsg   string
+		ExpectedErrorCode  string
+		Responce           []*storage.ChatMember
+		SetupStorage       func(mock *mocks.Storage, testCase *TestCase)
+	}
+
+	testCases := []*TestCase{
+		&TestCase{
+			TestName:           "OK",
+			RequestBody:        `{"chat": 10}`,
+			ExpectedStatusCode: http.StatusOK,
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("IsUserInChat", testifyMock.Anything, int64(1), int64(10)).Return(true, nil).Once()
+				mock.On("GetChatMembers", testifyMock.Anything, int64(10)).Return(testCase.Responce, nil).Once()
+			},
+			Responce: []*storage.ChatMember{
+				&storage.ChatMember{UserId: 1, Role: storage.RoleOwner},
+				&storage.ChatMember{UserId: 2, Role: storage.RoleReadOnly},
+			},
+		},
+		&TestCase{
+			TestName:           "User is not in the chat",
+			RequestBody:        `{"chat": 11}`,
+			ExpectedStatusCode: http.StatusForbidden,
+			ExpectedErrorMsg:   "user is not in the chat",
+			ExpectedErrorCode:  "not_chat_member",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("IsUserInChat", testifyMock.Anything, int64(1), int64(11)).Return(false, nil).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Without chat",
+			RequestBody:        `{}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+			},
+		},
+	}
+	server := NewServer(nil, nil, true)
+
+	type Responce struct {
+		Members []*storage.ChatMember `json:"members"`
+		Error   string                `json:"error"`
+		Code    string                `json:"code"`
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.TestName, func(t *testing.T) {
+			mockStorage := &mocks.Storage{}
+			server.Storage = mockStorage
+
+			requestData := strings.NewReader(testCase.RequestBody)
+			request, err := http.NewRequest(http.MethodPost, "/chats/members", requestData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request = withUserId(request, 1)
+			recorder := httptest.NewRecorder()
+
+			testCase.SetupStorage(mockStorage, testCase)
+
+			handler := server.handleGetChatMembers()
+			handler.ServeHTTP(recorder, request)
+
+			mockStorage.AssertExpectations(t)
+			assert.Equal(t, testCase.ExpectedStatusCode, recorder.Code)
+
+			var responce Responce
+			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
+				assert.Equal(t, testCase.Responce, responce.Members)
+				assert.Equal(t, testCase.ExpectedErrorMsg, responce.Error)
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
+			}
+		})
+	}
+}
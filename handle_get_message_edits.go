@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// handleGetMessageEdits returns handler that responds with history of message ordered from earlier edit to later.
+// Every entry contains text of message before edit and time of edit.
+// Authenticated user must be member of the chat of message.
+func (s *Server) handleGetMessageEdits() http.HandlerFunc {
+	type Request struct {
+		MessageId int64 `json:"message" validate:"required,gte=0"`
+	}
+	type Responce struct {
+		Edits []*storage.MessageEdit `json:"edits"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request Request
+		if err := s.decodeAndValidate(w, r, &request); err != nil {
+			return
+		}
+		userId := getUserId(r)
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"message_id": request.MessageId,
+			"user_id":    userId,
+		})
+		message, err := s.Storage.GetMessage(r.Context(), request.MessageId)
+		if errors.Is(err, storage.ErrNotFound) {
+			s.respondWithError(w, r, logger, errMessageNotFound)
+			return
+		}
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("GetMessage failed: %w", err))
+			return
+		}
+		isUserInChat, err := s.Storage.IsUserInChat(r.Context(), userId, message.ChatId)
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("IsUserInChat failed: %w", err))
+			return
+		}
+		if !isUserInChat {
+			s.respondWithError(w, r, logger, errNotChatMember)
+			return
+		}
+		edits, err := s.Storage.GetMessageEdits(r.Context(), request.MessageId)
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("GetMessageEdits failed: %w", err))
+			return
+		}
+		s.respond(w, r, Responce{edits}, http.StatusOK)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// handleGetMessageReaders returns handler that responds with ids of chat members that have read message.
+// Author of the message isn't included. Authenticated user must be member of the chat of message.
+func (s *Server) handleGetMessageReaders() http.HandlerFunc {
+	type Request struct {
+		MessageId int64 `json:"message" validate:"required,gte=0"`
+	}
+	type Responce struct {
+		UserIds []int64 `json:"users"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request Request
+		if err := s.decodeAndValidate(w, r, &request); err != nil {
+			return
+		}
+		userId := getUserId(r)
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"message_id": request.MessageId,
+			"user_id":    userId,
+		})
+		message, err := s.Storage.GetMessage(r.Context(), request.MessageId)
+		if errors.Is(err, storage.ErrNotFound) {
+			s.respondWithError(w, r, logger, errMessageNotFound)
+			return
+		}
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("GetMessage failed: %w", err))
+			return
+		}
+		isUserInChat, err := s.Storage.IsUserInChat(r.Context(), userId, message.ChatId)
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("IsUserInChat failed: %w", err))
+			return
+		}
+		if !isUserInChat {
+			s.respondWithError(w, r, logger, errNotChatMember)
+			return
+		}
+		readers, err := s.Storage.GetMessageReaders(r.Context(), request.MessageId)
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("GetMessageReaders failed: %w", err))
+			return
+		}
+		s.respond(w, r, Responce{readers}, http.StatusOK)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+func TestHandleGetMessageReaders(t *testing.T) {
+	type TestCase struct {
+		TestName           string
+		RequestBody        string
+		ExpectedStatusCode int
+		ExpectedErrorMsg   string
+		ExpectedErrorCode  string
+		Responce           []int64
+		SetupStorage       func(mock *mocks.Storage, testCase *TestCase)
+	}
+
+	testCases := []*TestCase{
+		&TestCase{
+			TestName:           "OK",
+			RequestBody:        `{"message": 20}`,
+			ExpectedStatusCode: http.StatusOK,
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("GetMessage", testifyMock.Anything, int64(20)).
+					Return(&storage.Message{Id: 20, ChatId: 10, AuthorId: 2}, nil).Once()
+				mock.On("IsUserInChat", testifyMock.Anything, int64(1), int64(10)).Return(true, nil).Once()
+				mock.On("GetMessageReaders", testifyMock.Anything, int64(20)).Return(testCase.Responce, nil).Once()
+			},
+			Responce: []int64{1, 3},
+		},
+		&TestCase{
+			TestName:           "User is not in the chat",
+			RequestBody:        `{"message": 21}`,
+			ExpectedStatusCode: http.StatusForbidden,
+			ExpectedErrorMsg:   "user is not in the chat",
+			ExpectedErrorCode:  "not_chat_member",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("GetMessage", testifyMock.Anything, int64(21)).
+					Return(&storage.Message{Id: 21, ChatId: 11, AuthorId: 2}, nil).Once()
+				mock.On("IsUserInChat", testifyMock.Anything, int64(1), int64(11)).Return(false, nil).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Nonexistent message",
+			RequestBody:        `{"message": 22}`,
+			ExpectedStatusCode: http.StatusNotFound,
+			ExpectedErrorMsg:   "nonexistent message",
+			ExpectedErrorCode:  "message_not_found",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("GetMessage", testifyMock.Anything, int64(22)).Return(nil, storage.ErrNotFound).Once()
+			},
+		},
+	}
+	server := NewServer(nil, nil, true)
+
+	type Responce struct {
+		UserIds []int64 `json:"users"`
+		Error   string  `json:"error"`
+		Code    string  `json:"code"`
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.TestName, func(t *testing.T) {
+			mockStorage := &mocks.Storage{}
+			server.Storage = mockStorage
+
+			requestData := strings.NewReader(testCase.RequestBody)
+			request, err := http.NewRequest(http.MethodPost, "/messages/readers", requestData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request = withUserId(request, 1)
+			recorder := httptest.NewRecorder()
+
+			testCase.SetupStorage(mockStorage, testCase)
+
+			handler := server.handleGetMessageReaders()
+			handler.ServeHTTP(recorder, request)
+
+			mockStorage.AssertExpectations(t)
+			assert.Equal(t, testCase.ExpectedStatusCode, recorder.Code)
+
+			var responce Responce
+			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
+				assert.Equal(t, testCase.Responce, responce.UserIds)
+				assert.Equal(t, testCase.ExpectedErrorMsg, responce.Error)
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
+			}
+		})
+	}
+}
@@ -1,36 +1,216 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Darkclainer/avito_exercise/hub"
 	"github.com/Darkclainer/avito_exercise/storage"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// defaultMessagesPageSize is used when request doesn't specify limit.
+	// Maximum page size is set in validation tag of request.
+	defaultMessagesPageSize = 100
+	// longPollBuffer is number of events that long-polling request can miss before it rereads page
+	longPollBuffer = 16
+)
+
+// getMessagesRequest is body of /messages/get. GetMessages RPC uses it without waiting.
+type getMessagesRequest struct {
+	ChatId      int64  `json:"chat" validate:"required,gte=0"`
+	Limit       int    `json:"limit" validate:"gte=0,lte=1000"`
+	BeforeId    int64  `json:"before_id" validate:"gte=0"`
+	AfterId     int64  `json:"after_id" validate:"gte=0"`
+	Cursor      string `json:"cursor"`
+	WaitSeconds int    `json:"wait_seconds" validate:"gte=0,lte=60"`
+}
+
+// handleGetMessages returns handler that responds with page of messages from chat.
+// Authenticated user must be member of the chat.
+//
+// Request may contain "limit" (100 by default, 1000 at most) and one of cursors:
+// "before_id" for messages older than specified id, "after_id" for messages newer than specified id
+// or opaque "cursor" returned as "next_cursor" in previous response.
+// Without cursors page starts from the first message of the chat.
+// Messages in page are always ordered from earlier to later. "next_cursor" continues in the same direction
+// and is omitted when there are no more messages.
+//
+// If "wait_seconds" is set (60 at most) and there are no newer messages, request blocks until
+// new message is added to the chat or timeout elapses, then page is read again (it may be still empty).
+// Waiting can't be used with "before_id". Storage calls of request are limited by RequestTimeout plus "wait_seconds".
 func (s *Server) handleGetMessages() http.HandlerFunc {
-	type Request struct {
-		ChatId int64 `json:"chat" validate:"required,gte=0"`
-	}
 	type Responce struct {
-		Messages []*storage.Message `json:"messages"`
+		Messages   []*storage.Message `json:"messages"`
+		NextCursor string             `json:"next_cursor,omitempty"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		var request Request
+		var request getMessagesRequest
 		if err := s.decodeAndValidate(w, r, &request); err != nil {
 			return
 		}
+		userId := getUserId(r)
 		logger := s.getLogger(r).WithFields(logrus.Fields{
 			"chat_id": request.ChatId,
+			"user_id": userId,
 		})
+		timeout := time.Duration(request.WaitSeconds) * time.Second
+		ctx, cancel := s.storageContext(r.Context(), timeout)
+		defer cancel()
+		isUserInChat, err := s.Storage.IsUserInChat(ctx, userId, request.ChatId)
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("IsUserInChat failed: %w", err))
+			return
+		}
+		if !isUserInChat {
+			s.respondWithError(w, r, logger, errNotChatMember)
+			return
+		}
+		query, apiErr := messagesPageQuery(&request)
+		if apiErr != nil {
+			s.respondWithError(w, r, logger, apiErr)
+			return
+		}
 
-		messages, err := s.Storage.GetMessagesFromChat(request.ChatId)
+		var sub *hub.Subscription
+		if request.WaitSeconds != 0 {
+			// subscribe before reading page, so message added in between isn't missed
+			sub = s.Hub.Subscribe(userId, longPollBuffer)
+			defer s.Hub.Unsubscribe(sub)
+		}
+		messages, err := s.Storage.GetMessagesPage(ctx, request.ChatId, query)
+		if err == nil && len(messages) == 0 && sub != nil {
+			if !waitChatMessage(r.Context(), sub, request.ChatId, timeout, s.stopping) {
+				logger.Debug("Client has gone while waiting for messages")
+				return
+			}
+			messages, err = s.Storage.GetMessagesPage(ctx, request.ChatId, query)
+		}
 		if err != nil {
-			s.respondWithInternalError(w, r, logger.WithField("error",
-				fmt.Errorf("GetMessagesFromChat failed: %s", err)))
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("GetMessagesPage failed: %w", err))
 			return
 		}
-		responce := Responce{messages}
-		s.respond(w, r, responce, http.StatusOK)
+		messages, nextCursor := splitMessagesPage(request.ChatId, query, messages)
+		s.respond(w, r, Responce{messages, nextCursor}, http.StatusOK)
+	}
+}
+
+// messagesPageQuery returns query for page requested by cursor or position.
+// Its limit is one more than requested to find out whether there is next page.
+func messagesPageQuery(request *getMessagesRequest) (storage.PageQuery, *apiError) {
+	query := storage.PageQuery{
+		BeforeId: request.BeforeId,
+		AfterId:  request.AfterId,
+		Limit:    request.Limit,
+	}
+	if request.Cursor != "" {
+		if query.BeforeId != 0 || query.AfterId != 0 {
+			return query, badRequest("invalid_cursor", "cursor can not be used with before_id or after_id")
+		}
+		var err error
+		query, err = decodeMessagesCursor(request.Cursor, request.ChatId)
+		if err != nil {
+			return query, badRequest("invalid_cursor", "invalid cursor")
+		}
+		query.Limit = request.Limit
+	}
+	if query.BeforeId != 0 && query.AfterId != 0 {
+		return query, badRequest("invalid_input", "before_id and after_id are mutually exclusive")
+	}
+	if query.BeforeId != 0 && request.WaitSeconds != 0 {
+		return query, badRequest("invalid_input", "wait_seconds can not be used with before_id")
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultMessagesPageSize
+	}
+	query.Limit++
+	return query, nil
+}
+
+// splitMessagesPage drops extra message loaded by query from messagesPageQuery
+// and returns cursor of the next page, which is empty if there is no next page.
+func splitMessagesPage(chatId int64, query storage.PageQuery, messages []*storage.Message) ([]*storage.Message, string) {
+	limit := query.Limit - 1
+	if len(messages) <= limit {
+		return messages, ""
+	}
+	if query.BeforeId != 0 {
+		messages = messages[1:]
+		return messages, encodeMessagesCursor(chatId, storage.PageQuery{BeforeId: messages[0].Id})
+	}
+	messages = messages[:limit]
+	return messages, encodeMessagesCursor(chatId, storage.PageQuery{AfterId: messages[limit-1].Id})
+}
+
+// waitChatMessage blocks until subscription receives message from the chat, timeout elapses,
+// subscription is dropped or stopping is closed. It returns false if ctx is done before that.
+func waitChatMessage(ctx context.Context, sub *hub.Subscription, chatId int64, timeout time.Duration,
+	stopping <-chan struct{}) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case event := <-sub.Events():
+			if event.Type == hub.EventMessage && event.Message.ChatId == chatId {
+				return true
+			}
+		case <-sub.Done():
+			return true
+		case <-timer.C:
+			return true
+		case <-stopping:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// encodeMessagesCursor encodes chat id and query position (limit is ignored) to opaque string.
+func encodeMessagesCursor(chatId int64, query storage.PageQuery) string {
+	direction, id := "a", query.AfterId
+	if query.BeforeId != 0 {
+		direction, id = "b", query.BeforeId
+	}
+	raw := fmt.Sprintf("%d:%s:%d", chatId, direction, id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeMessagesCursor decodes cursor made by encodeMessagesCursor and checks that it belongs to the chat.
+func decodeMessagesCursor(cursor string, chatId int64) (storage.PageQuery, error) {
+	var query storage.PageQuery
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return query, err
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 3 {
+		return query, fmt.Errorf("malformed cursor %q", raw)
+	}
+	cursorChatId, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return query, err
+	}
+	if cursorChatId != chatId {
+		return query, fmt.Errorf("cursor belongs to chat %d", cursorChatId)
+	}
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || id < 0 {
+		return query, fmt.Errorf("malformed id in cursor %q", raw)
+	}
+	switch parts[1] {
+	case "a":
+		query.AfterId = id
+	case "b":
+		query.BeforeId = id
+	default:
+		return query, fmt.Errorf("unknown direction in cursor %q", raw)
 	}
+	return query, nil
 }
@@ -9,7 +9,9 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
 
+	"github.com/Darkclainer/avito_exercise/hub"
 	"github.com/Darkclainer/avito_exercise/mocks"
 	"github.com/Darkclainer/avito_exercise/storage"
 )
@@ -20,6 +22,8 @@ func TestHandleGetMessages(t *testing.T) {
 		RequestBody        string
 		ExpectedStatusCode int
 		ExpectedErrorMsg   string
+		ExpectedErrorCode  string
+		ExpectedNextCursor string
 		Responce           []*storage.Message
 		SetupStorage       func(mock *mocks.Storage, testCase *TestCase)
 	}
@@ -30,7 +34,9 @@ func TestHandleGetMessages(t *testing.T) {
 			RequestBody:        `{"chat": 10}`,
 			ExpectedStatusCode: http.StatusOK,
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
-				mock.On("GetMessagesFromChat", int64(10)).Return(testCase.Responce, nil)
+				mock.On("IsUserInChat", testifyMock.Anything, int64(1), int64(10)).Return(true, nil)
+				mock.On("GetMessagesPage", testifyMock.Anything, int64(10), storage.PageQuery{Limit: 101}).
+					Return(testCase.Responce, nil)
 			},
 			Responce: []*storage.Message{
 				&storage.Message{
@@ -50,15 +56,122 @@ func TestHandleGetMessages(t *testing.T) {
 			RequestBody:        `{"chat": 11}`,
 			ExpectedStatusCode: http.StatusOK,
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
-				mock.On("GetMessagesFromChat", int64(11)).Return(testCase.Responce, nil)
+				mock.On("IsUserInChat", testifyMock.Anything, int64(1), int64(11)).Return(true, nil)
+				mock.On("GetMessagesPage", testifyMock.Anything, int64(11), storage.PageQuery{Limit: 101}).
+					Return(testCase.Responce, nil)
 			},
 			Responce: []*storage.Message{},
 		},
+		&TestCase{
+			TestName:           "First page with next cursor",
+			RequestBody:        `{"chat": 10, "limit": 2}`,
+			ExpectedStatusCode: http.StatusOK,
+			ExpectedNextCursor: encodeMessagesCursor(10, storage.PageQuery{AfterId: 22}),
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("IsUserInChat", testifyMock.Anything, int64(1), int64(10)).Return(true, nil)
+				mock.On("GetMessagesPage", testifyMock.Anything, int64(10), storage.PageQuery{Limit: 3}).Return([]*storage.Message{
+					testCase.Responce[0], testCase.Responce[1], &storage.Message{Id: 23, ChatId: 10},
+				}, nil)
+			},
+			Responce: []*storage.Message{
+				&storage.Message{Id: 21, ChatId: 10},
+				&storage.Message{Id: 22, ChatId: 10},
+			},
+		},
+		&TestCase{
+			TestName:           "Page before id with next cursor",
+			RequestBody:        `{"chat": 10, "limit": 2, "before_id": 30}`,
+			ExpectedStatusCode: http.StatusOK,
+			ExpectedNextCursor: encodeMessagesCursor(10, storage.PageQuery{BeforeId: 22}),
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("IsUserInChat", testifyMock.Anything, int64(1), int64(10)).Return(true, nil)
+				mock.On("GetMessagesPage", testifyMock.Anything, int64(10), storage.PageQuery{BeforeId: 30, Limit: 3}).
+					Return([]*storage.Message{
+						&storage.Message{Id: 21, ChatId: 10}, testCase.Responce[0], testCase.Responce[1],
+					}, nil)
+			},
+			Responce: []*storage.Message{
+				&storage.Message{Id: 22, ChatId: 10},
+				&storage.Message{Id: 23, ChatId: 10},
+			},
+		},
+		&TestCase{
+			TestName:           "Last page by cursor",
+			RequestBody:        `{"chat": 10, "limit": 2, "cursor": "` + encodeMessagesCursor(10, storage.PageQuery{AfterId: 22}) + `"}`,
+			ExpectedStatusCode: http.StatusOK,
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("IsUserInChat", testifyMock.Anything, int64(1), int64(10)).Return(true, nil)
+				mock.On("GetMessagesPage", testifyMock.Anything, int64(10), storage.PageQuery{AfterId: 22, Limit: 3}).
+					Return(testCase.Responce, nil)
+			},
+			Responce: []*storage.Message{
+				&storage.Message{Id: 23, ChatId: 10},
+			},
+		},
+		&TestCase{
+			TestName:           "User is not in the chat",
+			RequestBody:        `{"chat": 12}`,
+			ExpectedStatusCode: http.StatusForbidden,
+			ExpectedErrorMsg:   "user is not in the chat",
+			ExpectedErrorCode:  "not_chat_member",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("IsUserInChat", testifyMock.Anything, int64(1), int64(12)).Return(false, nil)
+			},
+		},
+		&TestCase{
+			TestName:           "Cursor from another chat",
+			RequestBody:        `{"chat": 10, "cursor": "` + encodeMessagesCursor(11, storage.PageQuery{AfterId: 22}) + `"}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "invalid cursor",
+			ExpectedErrorCode:  "invalid_cursor",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("IsUserInChat", testifyMock.Anything, int64(1), int64(10)).Return(true, nil)
+			},
+		},
+		&TestCase{
+			TestName:           "Both before and after id",
+			RequestBody:        `{"chat": 10, "before_id": 5, "after_id": 2}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "before_id and after_id are mutually exclusive",
+			ExpectedErrorCode:  "invalid_input",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("IsUserInChat", testifyMock.Anything, int64(1), int64(10)).Return(true, nil)
+			},
+		},
+		&TestCase{
+			TestName:           "Wait with before id",
+			RequestBody:        `{"chat": 10, "before_id": 5, "wait_seconds": 10}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "wait_seconds can not be used with before_id",
+			ExpectedErrorCode:  "invalid_input",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("IsUserInChat", testifyMock.Anything, int64(1), int64(10)).Return(true, nil)
+			},
+		},
+		&TestCase{
+			TestName:           "Too long wait",
+			RequestBody:        `{"chat": 10, "wait_seconds": 61}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+			},
+		},
+		&TestCase{
+			TestName:           "Too big limit",
+			RequestBody:        `{"chat": 10, "limit": 1001}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+			},
+		},
 		&TestCase{
 			TestName:           "Without chat id",
 			RequestBody:        `{"user": 123}`,
-			ExpectedStatusCode: http.StatusInternalServerError,
+			ExpectedStatusCode: http.StatusBadRequest,
 			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
 			},
 		},
@@ -66,8 +179,10 @@ func TestHandleGetMessages(t *testing.T) {
 	server := NewServer(nil, nil, true)
 
 	type Responce struct {
-		Messages []*storage.Message `json:"messages"`
-		Error    string             `json:"error"`
+		Messages   []*storage.Message `json:"messages"`
+		NextCursor string             `json:"next_cursor"`
+		Error      string             `json:"error"`
+		Code       string             `json:"code"`
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.TestName, func(t *testing.T) {
@@ -79,6 +194,7 @@ func TestHandleGetMessages(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
+			request = withUserId(request, 1)
 			recorder := httptest.NewRecorder()
 
 			testCase.SetupStorage(mockStorage, testCase)
@@ -92,8 +208,72 @@ func TestHandleGetMessages(t *testing.T) {
 			var responce Responce
 			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
 				assert.Equal(t, testCase.Responce, responce.Messages)
+				assert.Equal(t, testCase.ExpectedNextCursor, responce.NextCursor)
 				assert.Equal(t, testCase.ExpectedErrorMsg, responce.Error)
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
 			}
 		})
 	}
 }
+
+func TestHandleGetMessagesWaitsForNewMessage(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	server := NewServer(mockStorage, nil, true)
+
+	message := &storage.Message{Id: 23, ChatId: 10, AuthorId: 2, Text: "Hello",
+		CreatedAt: time.Date(2019, time.January, 1, 10, 0, 0, 0, time.UTC)}
+	query := storage.PageQuery{AfterId: 22, Limit: 101}
+	mockStorage.On("IsUserInChat", testifyMock.Anything, int64(1), int64(10)).Return(true, nil)
+	mockStorage.On("GetMessagesPage", testifyMock.Anything, int64(10), query).Return([]*storage.Message{}, nil).Once()
+	mockStorage.On("GetMessagesPage", testifyMock.Anything, int64(10), query).
+		Return([]*storage.Message{message}, nil).Once()
+
+	go func() {
+		for server.Hub.Subscribers() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		// messages of other chats don't wake request
+		server.Hub.Publish(hub.NewMessageEvent(&storage.Message{Id: 22, ChatId: 11}), []int64{1})
+		server.Hub.Publish(hub.NewMessageEvent(message), []int64{1})
+	}()
+	request, err := http.NewRequest(http.MethodPost, "/messages/get",
+		strings.NewReader(`{"chat": 10, "after_id": 22, "wait_seconds": 10}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	server.handleGetMessages().ServeHTTP(recorder, withUserId(request, 1))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var responce struct {
+		Messages []*storage.Message `json:"messages"`
+	}
+	if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
+		assert.Equal(t, []*storage.Message{message}, responce.Messages)
+	}
+	assert.Equal(t, 0, server.Hub.Subscribers())
+	mockStorage.AssertExpectations(t)
+}
+
+func TestHandleGetMessagesWaitTimeout(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	server := NewServer(mockStorage, nil, true)
+
+	query := storage.PageQuery{AfterId: 22, Limit: 101}
+	mockStorage.On("IsUserInChat", testifyMock.Anything, int64(1), int64(10)).Return(true, nil)
+	mockStorage.On("GetMessagesPage", testifyMock.Anything, int64(10), query).Return([]*storage.Message{}, nil).Twice()
+
+	request, err := http.NewRequest(http.MethodPost, "/messages/get",
+		strings.NewReader(`{"chat": 10, "after_id": 22, "wait_seconds": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	started := time.Now()
+	server.handleGetMessages().ServeHTTP(recorder, withUserId(request, 1))
+
+	assert.True(t, time.Since(started) >= time.Second, "request returned before timeout")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"messages": []}`, recorder.Body.String())
+	mockStorage.AssertExpectations(t)
+}
@@ -8,26 +8,20 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// handleGetUserChats returns handler that responds with chats of authenticated user.
 func (s *Server) handleGetUserChats() http.HandlerFunc {
-	type Request struct {
-		UserId int64 `json:"user" validate:"required,gte=0"`
-	}
 	type Responce struct {
 		Chats []*storage.Chat
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		var request Request
-		if err := s.decodeAndValidate(w, r, &request); err != nil {
-			return
-		}
+		userId := getUserId(r)
 		logger := s.getLogger(r).WithFields(logrus.Fields{
-			"user_id": request.UserId,
+			"user_id": userId,
 		})
 
-		chats, err := s.Storage.GetUserChats(request.UserId)
+		chats, err := s.Storage.GetUserChats(r.Context(), userId)
 		if err != nil {
-			s.respondWithInternalError(w, r, logger.WithField("error",
-				fmt.Errorf("GetUserChats failed: %s", err)))
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("GetUserChats failed: %w", err))
 			return
 		}
 		responce := Responce{chats}
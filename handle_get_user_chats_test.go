@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
 
 	"github.com/Darkclainer/avito_exercise/mocks"
 	"github.com/Darkclainer/avito_exercise/storage"
@@ -17,9 +18,10 @@ import (
 func TestHandleGetUserChats(t *testing.T) {
 	type TestCase struct {
 		TestName           string
-		RequestBody        string
+		UserId             int64
 		ExpectedStatusCode int
 		ExpectedErrorMsg   string
+		ExpectedErrorCode  string
 		Responce           []*storage.Chat
 		SetupStorage       func(mock *mocks.Storage, testCase *TestCase)
 	}
@@ -27,17 +29,19 @@ func TestHandleGetUserChats(t *testing.T) {
 	testCases := []*TestCase{
 		&TestCase{
 			TestName:           "OK",
-			RequestBody:        `{"user": 1}`,
+			UserId:             1,
 			ExpectedStatusCode: http.StatusOK,
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
-				mock.On("GetUserChats", int64(1)).Return(testCase.Responce, nil)
+				mock.On("GetUserChats", testifyMock.Anything, int64(1)).Return(testCase.Responce, nil)
 			},
 			Responce: []*storage.Chat{
 				&storage.Chat{
-					Id:        10,
-					Name:      "chat_1",
-					CreatedAt: time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC),
-					UserIds:   []int64{1, 2, 3},
+					Id:                10,
+					Name:              "chat_1",
+					CreatedAt:         time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC),
+					UserIds:           []int64{1, 2, 3},
+					LastReadMessageId: 20,
+					UnreadCount:       4,
 				},
 				&storage.Chat{
 					Id:        11,
@@ -49,38 +53,31 @@ func TestHandleGetUserChats(t *testing.T) {
 		},
 		&TestCase{
 			TestName:           "Chat list empty",
-			RequestBody:        `{"user": 3}`,
+			UserId:             3,
 			ExpectedStatusCode: http.StatusOK,
 			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
-				mock.On("GetUserChats", int64(3)).Return(testCase.Responce, nil)
+				mock.On("GetUserChats", testifyMock.Anything, int64(3)).Return(testCase.Responce, nil)
 			},
 			Responce: []*storage.Chat{},
 		},
-		&TestCase{
-			TestName:           "Without user id",
-			RequestBody:        `{"username": "user1"}`,
-			ExpectedStatusCode: http.StatusInternalServerError,
-			ExpectedErrorMsg:   "invalid input",
-			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
-			},
-		},
 	}
 	server := NewServer(nil, nil, true)
 
 	type Responce struct {
 		Chats []*storage.Chat `json:"chats"`
 		Error string          `json:"error"`
+		Code  string          `json:"code"`
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.TestName, func(t *testing.T) {
 			mockStorage := &mocks.Storage{}
 			server.Storage = mockStorage
 
-			requestData := strings.NewReader(testCase.RequestBody)
-			request, err := http.NewRequest(http.MethodPost, "/chats/get", requestData)
+			request, err := http.NewRequest(http.MethodPost, "/chats/get", strings.NewReader("{}"))
 			if err != nil {
 				t.Fatal(err)
 			}
+			request = withUserId(request, testCase.UserId)
 			recorder := httptest.NewRecorder()
 
 			testCase.SetupStorage(mockStorage, testCase)
@@ -95,6 +92,7 @@ func TestHandleGetUserChats(t *testing.T) {
 			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
 				assert.Equal(t, testCase.Responce, responce.Chats)
 				assert.Equal(t, testCase.ExpectedErrorMsg, responce.Error)
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
 			}
 		})
 	}
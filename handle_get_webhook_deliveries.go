@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// defaultDeliveriesPageSize is used when request doesn't specify limit.
+const defaultDeliveriesPageSize = 20
+
+// handleGetWebhookDeliveries returns handler that lists deliveries of webhook of authenticated user,
+// newest first, with all their attempts.
+//
+// Request may filter deliveries by "status": "pending", "delivered" or "dead". Dead deliveries
+// have exhausted their attempts and won't be retried. "limit" is 20 by default, 100 at most.
+func (s *Server) handleGetWebhookDeliveries() http.HandlerFunc {
+	type Request struct {
+		WebhookId int64  `json:"webhook" validate:"required,gte=0"`
+		Status    string `json:"status" validate:"omitempty,oneof=pending delivered dead"`
+		Limit     int    `json:"limit" validate:"gte=0,lte=100"`
+	}
+	type Responce struct {
+		Deliveries []*storage.WebhookDelivery `json:"deliveries"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request Request
+		if err := s.decodeAndValidate(w, r, &request); err != nil {
+			return
+		}
+		userId := getUserId(r)
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"user_id":    userId,
+			"webhook_id": request.WebhookId,
+			"status":     request.Status,
+		})
+		if request.Limit == 0 {
+			request.Limit = defaultDeliveriesPageSize
+		}
+		deliveries, err := s.Storage.GetWebhookDeliveries(r.Context(), userId, request.WebhookId, request.Status,
+			request.Limit)
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("GetWebhookDeliveries failed: %w", err))
+			return
+		}
+		s.respond(w, r, Responce{deliveries}, http.StatusOK)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+func TestHandleGetWebhookDeliveries(t *testing.T) {
+	type TestCase struct {
+		TestName           string
+		RequestBody        string
+		ExpectedStatusCode int
+		ExpectedErrorMsg   string
+		ExpectedErrorCode  string
+		Deliveries         []*storage.WebhookDelivery
+		SetupStorage       func(mock *mocks.Storage, testCase *TestCase)
+	}
+
+	createdAt := time.Date(2019, time.January, 1, 10, 0, 0, 0, time.UTC)
+	testCases := []*TestCase{
+		&TestCase{
+			TestName:           "Dead letters",
+			RequestBody:        `{"webhook": 5, "status": "dead"}`,
+			ExpectedStatusCode: http.StatusOK,
+			Deliveries: []*storage.WebhookDelivery{
+				&storage.WebhookDelivery{Id: 3, WebhookId: 5, Event: storage.WebhookMessageCreated,
+					Payload: []byte(`{"event":"message_created"}`), Status: storage.DeliveryDead,
+					AttemptCount: 1, CreatedAt: createdAt, Attempts: []*storage.WebhookAttempt{
+						&storage.WebhookAttempt{AttemptedAt: createdAt, StatusCode: 500, Error: "unexpected status 500"},
+					}},
+			},
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("GetWebhookDeliveries", testifyMock.Anything, int64(1), int64(5), storage.DeliveryDead,
+					defaultDeliveriesPageSize).
+					Return(testCase.Deliveries, nil).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "With limit",
+			RequestBody:        `{"webhook": 5, "limit": 50}`,
+			ExpectedStatusCode: http.StatusOK,
+			Deliveries:         []*storage.WebhookDelivery{},
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("GetWebhookDeliveries", testifyMock.Anything, int64(1), int64(5), "", 50).
+					Return(testCase.Deliveries, nil).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Nonexistent webhook",
+			RequestBody:        `{"webhook": 6}`,
+			ExpectedStatusCode: http.StatusNotFound,
+			ExpectedErrorMsg:   "nonexistent webhook",
+			ExpectedErrorCode:  "webhook_not_found",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("GetWebhookDeliveries", testifyMock.Anything, int64(1), int64(6), "", defaultDeliveriesPageSize).
+					Return(nil, storage.ErrWebhookNotFound).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Unknown status",
+			RequestBody:        `{"webhook": 5, "status": "failed"}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
+			SetupStorage:       func(mock *mocks.Storage, testCase *TestCase) {},
+		},
+	}
+	server := NewServer(nil, nil, true)
+
+	type Responce struct {
+		Deliveries []*storage.WebhookDelivery `json:"deliveries"`
+		Error      string                     `json:"error"`
+		Code       string                     `json:"code"`
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.TestName, func(t *testing.T) {
+			mockStorage := &mocks.Storage{}
+			server.Storage = mockStorage
+
+			requestData := strings.NewReader(testCase.RequestBody)
+			request, err := http.NewRequest(http.MethodPost, "/webhooks/deliveries", requestData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request = withUserId(request, 1)
+			recorder := httptest.NewRecorder()
+
+			testCase.SetupStorage(mockStorage, testCase)
+
+			handler := server.handleGetWebhookDeliveries()
+			handler.ServeHTTP(recorder, request)
+
+			mockStorage.AssertExpectations(t)
+			assert.Equal(t, testCase.ExpectedStatusCode, recorder.Code)
+
+			var responce Responce
+			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
+				assert.Equal(t, testCase.Deliveries, responce.Deliveries)
+				assert.Equal(t, testCase.ExpectedErrorMsg, responce.Error)
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
+			}
+		})
+	}
+}
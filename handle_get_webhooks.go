@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// handleGetWebhooks returns handler that lists webhooks of authenticated user. Secrets aren't included.
+func (s *Server) handleGetWebhooks() http.HandlerFunc {
+	type Responce struct {
+		Webhooks []*storage.Webhook `json:"webhooks"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := getUserId(r)
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"user_id": userId,
+		})
+		webhooks, err := s.Storage.GetUserWebhooks(r.Context(), userId)
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("GetUserWebhooks failed: %w", err))
+			return
+		}
+		s.respond(w, r, Responce{webhooks}, http.StatusOK)
+	}
+}
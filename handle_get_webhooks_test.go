@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+func TestHandleGetWebhooks(t *testing.T) {
+	type TestCase struct {
+		TestName           string
+		ExpectedStatusCode int
+		ExpectedErrorCode  string
+		Webhooks           []*storage.Webhook
+		SetupStorage       func(mock *mocks.Storage, testCase *TestCase)
+	}
+
+	createdAt := time.Date(2019, time.January, 1, 10, 0, 0, 0, time.UTC)
+	testCases := []*TestCase{
+		&TestCase{
+			TestName:           "OK",
+			ExpectedStatusCode: http.StatusOK,
+			Webhooks: []*storage.Webhook{
+				&storage.Webhook{Id: 1, UserId: 1, URL: "https://example.com/a", Events: []string{}, CreatedAt: createdAt},
+				&storage.Webhook{Id: 2, UserId: 1, ChatId: 10, URL: "https://example.com/b",
+					Events: []string{storage.WebhookMessageCreated}, CreatedAt: createdAt},
+			},
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("GetUserWebhooks", testifyMock.Anything, int64(1)).Return(testCase.Webhooks, nil).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Storage error",
+			ExpectedStatusCode: http.StatusInternalServerError,
+			ExpectedErrorCode:  "internal_error",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("GetUserWebhooks", testifyMock.Anything, int64(1)).Return(nil, errors.New("database is locked")).Once()
+			},
+		},
+	}
+	server := NewServer(nil, nil, true)
+
+	type Responce struct {
+		Webhooks []*storage.Webhook `json:"webhooks"`
+		Code     string             `json:"code"`
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.TestName, func(t *testing.T) {
+			mockStorage := &mocks.Storage{}
+			server.Storage = mockStorage
+
+			request, err := http.NewRequest(http.MethodPost, "/webhooks/get", strings.NewReader("{}"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			request = withUserId(request, 1)
+			recorder := httptest.NewRecorder()
+
+			testCase.SetupStorage(mockStorage, testCase)
+
+			handler := server.handleGetWebhooks()
+			handler.ServeHTTP(recorder, request)
+
+			mockStorage.AssertExpectations(t)
+			assert.Equal(t, testCase.ExpectedStatusCode, recorder.Code)
+			assert.NotContains(t, recorder.Body.String(), "secret")
+
+			var responce Responce
+			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
+				assert.Equal(t, testCase.Webhooks, responce.Webhooks)
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
+			}
+		})
+	}
+}
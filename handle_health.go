@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+)
+
+// healthResponce is body of successful /healthz and /readyz responses.
+type healthResponce struct {
+	Status string `json:"status"`
+}
+
+// handleHealthz returns handler that reports that process is alive. It doesn't touch storage,
+// so slow database doesn't get server restarted.
+func (s *Server) handleHealthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.respond(w, r, healthResponce{"ok"}, http.StatusOK)
+	}
+}
+
+// handleReadyz returns handler that reports whether server can serve requests:
+// it isn't shutting down and ReadinessCheck (database ping and schema version) passes.
+func (s *Server) handleReadyz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.isStopping() {
+			s.respondWithError(w, r, nil, errShuttingDown)
+			return
+		}
+		if s.ReadinessCheck != nil {
+			if err := s.ReadinessCheck(); err != nil {
+				logger := s.getLogger(r).WithField("error", err)
+				logger.Error("Readiness check failed")
+				s.respondWithError(w, r, logger, errNotReady)
+				return
+			}
+		}
+		s.respond(w, r, healthResponce{"ok"}, http.StatusOK)
+	}
+}
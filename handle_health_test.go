@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleHealthz(t *testing.T) {
+	server := NewServer(nil, nil, true)
+	server.StopStreams()
+	recorder := httptest.NewRecorder()
+	server.handleHealthz().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"status": "ok"}`, recorder.Body.String())
+}
+
+func TestHandleReadyz(t *testing.T) {
+	type TestCase struct {
+		TestName           string
+		ExpectedStatusCode int
+		ExpectedErrorCode  string
+		ReadinessCheck     func() error
+		Stopping           bool
+	}
+	testCases := []*TestCase{
+		&TestCase{
+			TestName:           "Without check",
+			ExpectedStatusCode: http.StatusOK,
+		},
+		&TestCase{
+			TestName:           "Check passed",
+			ExpectedStatusCode: http.StatusOK,
+			ReadinessCheck:     func() error { return nil },
+		},
+		&TestCase{
+			TestName:           "Check failed",
+			ExpectedStatusCode: http.StatusServiceUnavailable,
+			ExpectedErrorCode:  "not_ready",
+			ReadinessCheck:     func() error { return errors.New("database is locked") },
+		},
+		&TestCase{
+			TestName:           "Shutting down",
+			ExpectedStatusCode: http.StatusServiceUnavailable,
+			ExpectedErrorCode:  "shutting_down",
+			ReadinessCheck:     func() error { return nil },
+			Stopping:           true,
+		},
+	}
+	type Responce struct {
+		Status string `json:"status"`
+		Code   string `json:"code"`
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.TestName, func(t *testing.T) {
+			server := NewServer(nil, nil, true)
+			server.ReadinessCheck = testCase.ReadinessCheck
+			if testCase.Stopping {
+				server.StopStreams()
+			}
+			recorder := httptest.NewRecorder()
+			server.handleReadyz().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, testCase.ExpectedStatusCode, recorder.Code)
+			var responce Responce
+			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
+				if testCase.ExpectedErrorCode == "" {
+					assert.Equal(t, "ok", responce.Status)
+				}
+			}
+		})
+	}
+}
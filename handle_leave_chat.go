@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// handleLeaveChat returns handler that removes authenticated user from chat.
+// Owner can't leave chat until he transfers ownership.
+// Change is recorded in chat as system message, handler responds with its id.
+func (s *Server) handleLeaveChat() http.HandlerFunc {
+	type Request struct {
+		ChatId int64 `json:"chat" validate:"required,gte=0"`
+	}
+	type Responce struct {
+		Id int64 `json:"id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request Request
+		if err := s.decodeAndValidate(w, r, &request); err != nil {
+			return
+		}
+		userId := getUserId(r)
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"chat_id": request.ChatId,
+			"user_id": userId,
+		})
+		messageId, err := s.Storage.RemoveChatMember(r.Context(), userId, request.ChatId, userId)
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("RemoveChatMember failed: %w", err))
+			return
+		}
+		s.publishMessage(logger, messageId, userId)
+		s.respond(w, r, Responce{messageId}, http.StatusOK)
+	}
+}
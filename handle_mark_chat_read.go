@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// handleMarkChatRead returns handler that records that authenticated user has read chat up to "message".
+// Without "message" the whole chat is read. Last read message never moves backward,
+// handler responds with its id.
+func (s *Server) handleMarkChatRead() http.HandlerFunc {
+	type Request struct {
+		ChatId    int64 `json:"chat" validate:"required,gte=0"`
+		MessageId int64 `json:"message" validate:"gte=0"`
+	}
+	type Responce struct {
+		LastReadMessageId int64 `json:"last_read_message_id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request Request
+		if err := s.decodeAndValidate(w, r, &request); err != nil {
+			return
+		}
+		userId := getUserId(r)
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"chat_id":    request.ChatId,
+			"message_id": request.MessageId,
+			"user_id":    userId,
+		})
+		lastReadId, err := s.Storage.MarkChatRead(r.Context(), userId, request.ChatId, request.MessageId)
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("MarkChatRead failed: %w", err))
+			return
+		}
+		s.respond(w, r, Responce{lastReadId}, http.StatusOK)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+func TestHandleMarkChatRead(t *testing.T) {
+	type TestCase struct {
+		TestName           string
+		RequestBody        string
+		ExpectedStatusCode int
+		ExpectedErrorMsg   string
+		ExpectedErrorCode  string
+		MockReturnId       int64
+		SetupStorage       func(mock *mocks.Storage, testCase *TestCase)
+	}
+
+	testCases := []*TestCase{
+		&TestCase{
+			TestName:           "OK",
+			RequestBody:        `{"chat": 10, "message": 20}`,
+			ExpectedStatusCode: http.StatusOK,
+			MockReturnId:       20,
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("MarkChatRead", testifyMock.Anything, int64(1), int64(10), int64(20)).
+					Return(testCase.MockReturnId, nil).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Whole chat",
+			RequestBody:        `{"chat": 10}`,
+			ExpectedStatusCode: http.StatusOK,
+			MockReturnId:       25,
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("MarkChatRead", testifyMock.Anything, int64(1), int64(10), int64(0)).
+					Return(testCase.MockReturnId, nil).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "User is not in the chat",
+			RequestBody:        `{"chat": 11, "message": 20}`,
+			ExpectedStatusCode: http.StatusForbidden,
+			ExpectedErrorMsg:   "user is not in the chat",
+			ExpectedErrorCode:  "not_chat_member",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("MarkChatRead", testifyMock.Anything, int64(1), int64(11), int64(20)).
+					Return(int64(0), storage.ErrNotChatMember).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Message is not in the chat",
+			RequestBody:        `{"chat": 10, "message": 100}`,
+			ExpectedStatusCode: http.StatusNotFound,
+			ExpectedErrorMsg:   "nonexistent message",
+			ExpectedErrorCode:  "message_not_found",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("MarkChatRead", testifyMock.Anything, int64(1), int64(10), int64(100)).
+					Return(int64(0), storage.ErrMessageNotFound).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Without chat",
+			RequestBody:        `{"message": 20}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+			},
+		},
+	}
+	server := NewServer(nil, nil, true)
+
+	type Responce struct {
+		LastReadMessageId int64  `json:"last_read_message_id"`
+		Error             string `json:"error"`
+		Code              string `json:"code"`
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.TestName, func(t *testing.T) {
+			mockStorage := &mocks.Storage{}
+			server.Storage = mockStorage
+
+			requestData := strings.NewReader(testCase.RequestBody)
+			request, err := http.NewRequest(http.MethodPost, "/chats/read", requestData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request = withUserId(request, 1)
+			recorder := httptest.NewRecorder()
+
+			testCase.SetupStorage(mockStorage, testCase)
+
+			handler := server.handleMarkChatRead()
+			handler.ServeHTTP(recorder, request)
+
+			mockStorage.AssertExpectations(t)
+			assert.Equal(t, testCase.ExpectedStatusCode, recorder.Code)
+
+			var responce Responce
+			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
+				assert.Equal(t, testCase.MockReturnId, responce.LastReadMessageId)
+				assert.Equal(t, testCase.ExpectedErrorMsg, responce.Error)
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
+			}
+		})
+	}
+}
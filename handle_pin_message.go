@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// handlePinMessage returns handler that pins or unpins message. Authenticated user must be admin
+// or owner of the chat of message. Handler responds with the message.
+func (s *Server) handlePinMessage(pinned bool) http.HandlerFunc {
+	type Request struct {
+		MessageId int64 `json:"message" validate:"required,gte=0"`
+	}
+	type Responce struct {
+		Message *storage.Message `json:"message"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request Request
+		if err := s.decodeAndValidate(w, r, &request); err != nil {
+			return
+		}
+		actorId := getUserId(r)
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"message_id": request.MessageId,
+			"actor_id":   actorId,
+			"pinned":     pinned,
+		})
+		if err := s.Storage.PinMessage(r.Context(), actorId, request.MessageId, pinned); err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("PinMessage failed: %w", err))
+			return
+		}
+		message, err := s.Storage.GetMessage(r.Context(), request.MessageId)
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("GetMessage failed: %w", err))
+			return
+		}
+		s.respond(w, r, Responce{message}, http.StatusOK)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+func TestHandlePinMessage(t *testing.T) {
+	type TestCase struct {
+		TestName           string
+		Pinned             bool
+		RequestBody        string
+		ExpectedStatusCode int
+		ExpectedErrorMsg   string
+		ExpectedErrorCode  string
+		Responce           *storage.Message
+		SetupStorage       func(mock *mocks.Storage, testCase *TestCase)
+	}
+
+	testCases := []*TestCase{
+		&TestCase{
+			TestName:           "Pin",
+			Pinned:             true,
+			RequestBody:        `{"message": 20}`,
+			ExpectedStatusCode: http.StatusOK,
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("PinMessage", testifyMock.Anything, int64(1), int64(20), true).Return(nil).Once()
+				mock.On("GetMessage", testifyMock.Anything, int64(20)).Return(testCase.Responce, nil).Once()
+			},
+			Responce: &storage.Message{
+				Id: 20, ChatId: 10, AuthorId: 2,
+				Text:      "Rules of the chat",
+				CreatedAt: time.Date(2019, time.January, 1, 10, 0, 0, 0, time.UTC),
+				Pinned:    true,
+			},
+		},
+		&TestCase{
+			TestName:           "Unpin",
+			Pinned:             false,
+			RequestBody:        `{"message": 20}`,
+			ExpectedStatusCode: http.StatusOK,
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("PinMessage", testifyMock.Anything, int64(1), int64(20), false).Return(nil).Once()
+				mock.On("GetMessage", testifyMock.Anything, int64(20)).Return(testCase.Responce, nil).Once()
+			},
+			Responce: &storage.Message{
+				Id: 20, ChatId: 10, AuthorId: 2,
+				Text:      "Rules of the chat",
+				CreatedAt: time.Date(2019, time.January, 1, 10, 0, 0, 0, time.UTC),
+			},
+		},
+		&TestCase{
+			TestName:           "Permission denied",
+			Pinned:             true,
+			RequestBody:        `{"message": 21}`,
+			ExpectedStatusCode: http.StatusForbidden,
+			ExpectedErrorMsg:   "role of user in the chat doesn't allow this action",
+			ExpectedErrorCode:  "permission_denied",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("PinMessage", testifyMock.Anything, int64(1), int64(21), true).Return(storage.ErrPermissionDenied).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Nonexistent message",
+			Pinned:             true,
+			RequestBody:        `{"message": 100}`,
+			ExpectedStatusCode: http.StatusNotFound,
+			ExpectedErrorMsg:   "nonexistent message",
+			ExpectedErrorCode:  "message_not_found",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("PinMessage", testifyMock.Anything, int64(1), int64(100), true).Return(storage.ErrMessageNotFound).Once()
+			},
+		},
+	}
+	server := NewServer(nil, nil, true)
+
+	type Responce struct {
+		Message *storage.Message `json:"message"`
+		Error   string           `json:"error"`
+		Code    string           `json:"code"`
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.TestName, func(t *testing.T) {
+			mockStorage := &mocks.Storage{}
+			server.Storage = mockStorage
+
+			requestData := strings.NewReader(testCase.RequestBody)
+			request, err := http.NewRequest(http.MethodPost, "/messages/pin", requestData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request = withUserId(request, 1)
+			recorder := httptest.NewRecorder()
+
+			testCase.SetupStorage(mockStorage, testCase)
+
+			handler := server.handlePinMessage(testCase.Pinned)
+			handler.ServeHTTP(recorder, request)
+
+			mockStorage.AssertExpectations(t)
+			assert.Equal(t, testCase.ExpectedStatusCode, recorder.Code)
+
+			var responce Responce
+			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
+				assert.Equal(t, testCase.Responce, responce.Message)
+				assert.Equal(t, testCase.ExpectedErrorMsg, responce.Error)
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
+			}
+		})
+	}
+}
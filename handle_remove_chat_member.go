@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// handleRemoveChatMember returns handler that removes user from chat. Authenticated user must be admin
+// or owner of the chat and removed user must have lower role.
+// Change is recorded in chat as system message, handler responds with its id.
+// Removed user receives the system message too.
+func (s *Server) handleRemoveChatMember() http.HandlerFunc {
+	type Request struct {
+		ChatId int64 `json:"chat" validate:"required,gte=0"`
+		UserId int64 `json:"user" validate:"required,gte=0"`
+	}
+	type Responce struct {
+		Id int64 `json:"id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request Request
+		if err := s.decodeAndValidate(w, r, &request); err != nil {
+			return
+		}
+		actorId := getUserId(r)
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"chat_id":  request.ChatId,
+			"user_id":  request.UserId,
+			"actor_id": actorId,
+		})
+		messageId, err := s.Storage.RemoveChatMember(r.Context(), actorId, request.ChatId, request.UserId)
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("RemoveChatMember failed: %w", err))
+			return
+		}
+		s.publishMessage(logger, messageId, request.UserId)
+		s.respond(w, r, Responce{messageId}, http.StatusOK)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+func TestHandleRemoveChatMember(t *testing.T) {
+	type TestCase struct {
+		TestName           string
+		RequestBody        string
+		ExpectedStatusCode int
+		ExpectedErrorMsg   string
+		ExpectedErrorCode  string
+		MockReturnId       int64
+		SetupStorage       func(mock *mocks.Storage, testCase *TestCase)
+	}
+
+	testCases := []*TestCase{
+		&TestCase{
+			TestName:           "OK",
+			RequestBody:        `{"chat": 10, "user": 2}`,
+			ExpectedStatusCode: http.StatusOK,
+			MockReturnId:       30,
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("RemoveChatMember", testifyMock.Anything, int64(1), int64(10), int64(2)).
+					Return(testCase.MockReturnId, nil).Once()
+				mock.On("GetMessage", testifyMock.Anything, testCase.MockReturnId).
+					Return(&storage.Message{Id: testCase.MockReturnId, ChatId: 10}, nil).Once()
+				mock.On("GetChatUserIds", testifyMock.Anything, int64(10)).Return([]int64{1}, nil).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "User is not in the chat",
+			RequestBody:        `{"chat": 11, "user": 2}`,
+			ExpectedStatusCode: http.StatusForbidden,
+			ExpectedErrorMsg:   "user is not in the chat",
+			ExpectedErrorCode:  "not_chat_member",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("RemoveChatMember", testifyMock.Anything, int64(1), int64(11), int64(2)).
+					Return(int64(0), storage.ErrNotChatMember).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Permission denied",
+			RequestBody:        `{"chat": 10, "user": 4}`,
+			ExpectedStatusCode: http.StatusForbidden,
+			ExpectedErrorMsg:   "role of user in the chat doesn't allow this action",
+			ExpectedErrorCode:  "permission_denied",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("RemoveChatMember", testifyMock.Anything, int64(1), int64(10), int64(4)).
+					Return(int64(0), storage.ErrPermissionDenied).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Removed user is not member",
+			RequestBody:        `{"chat": 10, "user": 3}`,
+			ExpectedStatusCode: http.StatusNotFound,
+			ExpectedErrorMsg:   "user is not member of the chat",
+			ExpectedErrorCode:  "member_not_found",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("RemoveChatMember", testifyMock.Anything, int64(1), int64(10), int64(3)).
+					Return(int64(0), storage.ErrMemberNotFound).Once()
+			},
+		},
+	}
+	server := NewServer(nil, nil, true)
+
+	type Responce struct {
+		Id    int64  `json:"id"`
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.TestName, func(t *testing.T) {
+			mockStorage := &mocks.Storage{}
+			server.Storage = mockStorage
+
+			requestData := strings.NewReader(testCase.RequestBody)
+			request, err := http.NewRequest(http.MethodPost, "/chats/members/remove", requestData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request = withUserId(request, 1)
+			recorder := httptest.NewRecorder()
+
+			testCase.SetupStorage(mockStorage, testCase)
+
+			handler := server.handleRemoveChatMember()
+			handler.ServeHTTP(recorder, request)
+
+			mockStorage.AssertExpectations(t)
+			assert.Equal(t, testCase.ExpectedStatusCode, recorder.Code)
+
+			var responce Responce
+			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
+				assert.Equal(t, testCase.MockReturnId, responce.Id)
+				assert.Equal(t, testCase.ExpectedErrorMsg, responce.Error)
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
+			}
+		})
+	}
+}
+
+func TestHandleRemoveChatMemberNotifiesRemovedUser(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	server := NewServer(mockStorage, nil, true)
+	sub := server.Hub.Subscribe(2, 1)
+	defer server.Hub.Unsubscribe(sub)
+
+	message := &storage.Message{Id: 30, ChatId: 10, AuthorId: 1,
+		System: &storage.SystemEvent{Type: storage.MemberRemoved, UserId: 2}}
+	mockStorage.On("RemoveChatMember", testifyMock.Anything, int64(1), int64(10), int64(2)).Return(message.Id, nil)
+	mockStorage.On("GetMessage", testifyMock.Anything, message.Id).Return(message, nil)
+	mockStorage.On("GetChatUserIds", testifyMock.Anything, int64(10)).Return([]int64{1}, nil)
+
+	request, err := http.NewRequest(http.MethodPost, "/chats/members/remove", strings.NewReader(`{"chat": 10, "user": 2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	server.handleRemoveChatMember().ServeHTTP(recorder, withUserId(request, 1))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	select {
+	case received := <-sub.Events():
+		assert.Equal(t, message, received.Message)
+	case <-time.After(time.Second):
+		t.Fatal("Removed user didn't receive system message")
+	}
+}
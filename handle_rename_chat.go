@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// handleRenameChat returns handler that changes name of chat. Authenticated user must be admin or owner of the chat.
+func (s *Server) handleRenameChat() http.HandlerFunc {
+	type Request struct {
+		ChatId int64  `json:"chat" validate:"required,gte=0"`
+		Name   string `json:"name" validate:"chatname"`
+	}
+	type Responce struct {
+		Id int64 `json:"id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request Request
+		if err := s.decodeAndValidate(w, r, &request); err != nil {
+			return
+		}
+		actorId := getUserId(r)
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"chat_id":   request.ChatId,
+			"chat_name": request.Name,
+			"actor_id":  actorId,
+		})
+		if err := s.Storage.RenameChat(r.Context(), actorId, request.ChatId, request.Name); err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("RenameChat failed: %w", err))
+			return
+		}
+		s.respond(w, r, Responce{request.ChatId}, http.StatusOK)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+func TestHandleRenameChat(t *testing.T) {
+	type TestCase struct {
+		TestName           string
+		RequestBody        string
+		ExpectedStatusCode int
+		ExpectedErrorMsg   string
+		ExpectedErrorCode  string
+		ExpectedId         int64
+		SetupStorage       func(mock *mocks.Storage, testCase *TestCase)
+	}
+
+	testCases := []*TestCase{
+		&TestCase{
+			TestName:           "OK",
+			RequestBody:        `{"chat": 10, "name": "renamed"}`,
+			ExpectedStatusCode: http.StatusOK,
+			ExpectedId:         10,
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("RenameChat", testifyMock.Anything, int64(1), int64(10), "renamed").Return(nil).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Permission denied",
+			RequestBody:        `{"chat": 11, "name": "renamed"}`,
+			ExpectedStatusCode: http.StatusForbidden,
+			ExpectedErrorMsg:   "role of user in the chat doesn't allow this action",
+			ExpectedErrorCode:  "permission_denied",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("RenameChat", testifyMock.Anything, int64(1), int64(11), "renamed").
+					Return(storage.ErrPermissionDenied).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Name is taken",
+			RequestBody:        `{"chat": 10, "name": "chat_2"}`,
+			ExpectedStatusCode: http.StatusConflict,
+			ExpectedErrorMsg:   "chat with the same name is already exists",
+			ExpectedErrorCode:  "chat_exists",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("RenameChat", testifyMock.Anything, int64(1), int64(10), "chat_2").Return(storage.ErrChatExists).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Invalid name",
+			RequestBody:        `{"chat": 10, "name": "12chat"}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+			},
+		},
+	}
+	server := NewServer(nil, nil, true)
+
+	type Responce struct {
+		Id    int64  `json:"id"`
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.TestName, func(t *testing.T) {
+			mockStorage := &mocks.Storage{}
+			server.Storage = mockStorage
+
+			requestData := strings.NewReader(testCase.RequestBody)
+			request, err := http.NewRequest(http.MethodPost, "/chats/rename", requestData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request = withUserId(request, 1)
+			recorder := httptest.NewRecorder()
+
+			testCase.SetupStorage(mockStorage, testCase)
+
+			handler := server.handleRenameChat()
+			handler.ServeHTTP(recorder, request)
+
+			mockStorage.AssertExpectations(t)
+			assert.Equal(t, testCase.ExpectedStatusCode, recorder.Code)
+
+			var responce Responce
+			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
+				assert.Equal(t, testCase.ExpectedId, responce.Id)
+				assert.Equal(t, testCase.ExpectedErrorMsg, responce.Error)
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
+			}
+		})
+	}
+}
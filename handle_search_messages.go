@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// defaultSearchPageSize is used when request doesn't specify limit.
+const defaultSearchPageSize = 20
+
+// handleSearchMessages returns handler that searches messages in chats of authenticated user.
+//
+// Request must contain "text" and may restrict search with "chat", "author" and RFC 3339 times
+// "from" (inclusive) and "to" (exclusive). Results are ordered by relevance and paged with "limit"
+// (20 by default, 100 at most) and "offset".
+// Every result is message with "snippet" field, where matched words are wrapped with <b></b>.
+func (s *Server) handleSearchMessages() http.HandlerFunc {
+	type Request struct {
+		Text     string    `json:"text" validate:"required,max=256"`
+		ChatId   int64     `json:"chat" validate:"gte=0"`
+		AuthorId int64     `json:"author" validate:"gte=0"`
+		From     time.Time `json:"from"`
+		To       time.Time `json:"to"`
+		Limit    int       `json:"limit" validate:"gte=0,lte=100"`
+		Offset   int       `json:"offset" validate:"gte=0"`
+	}
+	type Responce struct {
+		Results []*storage.SearchResult `json:"results"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request Request
+		if err := s.decodeAndValidate(w, r, &request); err != nil {
+			return
+		}
+		userId := getUserId(r)
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"user_id": userId,
+			"text":    request.Text,
+			"chat_id": request.ChatId,
+		})
+		if request.ChatId != 0 {
+			isUserInChat, err := s.Storage.IsUserInChat(r.Context(), userId, request.ChatId)
+			if err != nil {
+				s.respondWithStorageError(w, r, logger, fmt.Errorf("IsUserInChat failed: %w", err))
+				return
+			}
+			if !isUserInChat {
+				s.respondWithError(w, r, logger, errNotChatMember)
+				return
+			}
+		}
+		if request.Limit == 0 {
+			request.Limit = defaultSearchPageSize
+		}
+		results, err := s.Storage.SearchMessages(r.Context(), userId, storage.SearchQuery{
+			Text:     request.Text,
+			ChatId:   request.ChatId,
+			AuthorId: request.AuthorId,
+			From:     request.From,
+			To:       request.To,
+			Limit:    request.Limit,
+			Offset:   request.Offset,
+		})
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("SearchMessages failed: %w", err))
+			return
+		}
+		s.respond(w, r, Responce{results}, http.StatusOK)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+func TestHandleSearchMessages(t *testing.T) {
+	type TestCase struct {
+		TestName           string
+		RequestBody        string
+		ExpectedStatusCode int
+		ExpectedErrorMsg   string
+		ExpectedErrorCode  string
+		Responce           []*storage.SearchResult
+		SetupStorage       func(mock *mocks.Storage, testCase *TestCase)
+	}
+
+	testCases := []*TestCase{
+		&TestCase{
+			TestName:           "OK",
+			RequestBody:        `{"text": "hello"}`,
+			ExpectedStatusCode: http.StatusOK,
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("SearchMessages", testifyMock.Anything, int64(1), storage.SearchQuery{Text: "hello",
+					Limit: defaultSearchPageSize}).
+					Return(testCase.Responce, nil)
+			},
+			Responce: []*storage.SearchResult{
+				&storage.SearchResult{
+					Message: storage.Message{
+						Id: 21, ChatId: 10, AuthorId: 2,
+						Text:      "Hello, world",
+						CreatedAt: time.Date(2019, time.January, 1, 10, 0, 0, 0, time.UTC),
+					},
+					Snippet: "<b>Hello</b>, world",
+				},
+			},
+		},
+		&TestCase{
+			TestName: "With filters",
+			RequestBody: `{"text": "hello", "chat": 10, "author": 2, "limit": 5, "offset": 10,
+				"from": "2019-01-01T00:00:00Z", "to": "2019-02-01T00:00:00Z"}`,
+			ExpectedStatusCode: http.StatusOK,
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("IsUserInChat", testifyMock.Anything, int64(1), int64(10)).Return(true, nil)
+				mock.On("SearchMessages", testifyMock.Anything, int64(1), storage.SearchQuery{
+					Text:     "hello",
+					ChatId:   10,
+					AuthorId: 2,
+					From:     time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC),
+					To:       time.Date(2019, time.February, 1, 0, 0, 0, 0, time.UTC),
+					Limit:    5,
+					Offset:   10,
+				}).Return(testCase.Responce, nil)
+			},
+			Responce: []*storage.SearchResult{},
+		},
+		&TestCase{
+			TestName:           "User is not in the chat",
+			RequestBody:        `{"text": "hello", "chat": 12}`,
+			ExpectedStatusCode: http.StatusForbidden,
+			ExpectedErrorMsg:   "user is not in the chat",
+			ExpectedErrorCode:  "not_chat_member",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("IsUserInChat", testifyMock.Anything, int64(1), int64(12)).Return(false, nil)
+			},
+		},
+		&TestCase{
+			TestName:           "Search is unavailable",
+			RequestBody:        `{"text": "hello"}`,
+			ExpectedStatusCode: http.StatusNotImplemented,
+			ExpectedErrorMsg:   "search is unavailable",
+			ExpectedErrorCode:  "search_unavailable",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("SearchMessages", testifyMock.Anything, int64(1), storage.SearchQuery{Text: "hello",
+					Limit: defaultSearchPageSize}).
+					Return(nil, storage.ErrSearchUnavailable)
+			},
+		},
+		&TestCase{
+			TestName:           "Without text",
+			RequestBody:        `{"chat": 10}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+			},
+		},
+		&TestCase{
+			TestName:           "Too big limit",
+			RequestBody:        `{"text": "hello", "limit": 101}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+			},
+		},
+	}
+	server := NewServer(nil, nil, true)
+
+	type Responce struct {
+		Results []*storage.SearchResult `json:"results"`
+		Error   string                  `json:"error"`
+		Code    string                  `json:"code"`
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.TestName, func(t *testing.T) {
+			mockStorage := &mocks.Storage{}
+			server.Storage = mockStorage
+
+			requestData := strings.NewReader(testCase.RequestBody)
+			request, err := http.NewRequest(http.MethodPost, "/messages/search", requestData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request = withUserId(request, 1)
+			recorder := httptest.NewRecorder()
+
+			testCase.SetupStorage(mockStorage, testCase)
+
+			handler := server.handleSearchMessages()
+			handler.ServeHTTP(recorder, request)
+
+			mockStorage.AssertExpectations(t)
+			assert.Equal(t, testCase.ExpectedStatusCode, recorder.Code)
+
+			var responce Responce
+			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
+				assert.Equal(t, testCase.Responce, responce.Results)
+				assert.Equal(t, testCase.ExpectedErrorMsg, responce.Error)
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// handleSetChatMemberRole returns handler that gives role to member of chat. Authenticated user must be admin
+// or owner of the chat and outrank both current and new role of the member, so admins can only
+// switch members between member and readonly roles. Ownership is transferred by handleTransferChatOwnership.
+// Handler responds with members of the chat.
+func (s *Server) handleSetChatMemberRole() http.HandlerFunc {
+	type Request struct {
+		ChatId int64        `json:"chat" validate:"required,gte=0"`
+		UserId int64        `json:"user" validate:"required,gte=0"`
+		Role   storage.Role `json:"role" validate:"required,oneof=admin member readonly"`
+	}
+	type Responce struct {
+		Members []*storage.ChatMember `json:"members"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request Request
+		if err := s.decodeAndValidate(w, r, &request); err != nil {
+			return
+		}
+		actorId := getUserId(r)
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"chat_id":  request.ChatId,
+			"user_id":  request.UserId,
+			"role":     request.Role,
+			"actor_id": actorId,
+		})
+		err := s.Storage.SetChatMemberRole(r.Context(), actorId, request.ChatId, request.UserId, request.Role)
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("SetChatMemberRole failed: %w", err))
+			return
+		}
+		members, err := s.Storage.GetChatMembers(r.Context(), request.ChatId)
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("GetChatMembers failed: %w", err))
+			return
+		}
+		s.respond(w, r, Responce{members}, http.StatusOK)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// handleSetChatSlowMode returns handler that sets minimal interval between messages of one member in chat
+// (at most a day), zero turns slow mode off. Authenticated user must be admin or owner of the chat.
+// Admins and owner aren't limited by slow mode.
+func (s *Server) handleSetChatSlowMode() http.HandlerFunc {
+	type Request struct {
+		ChatId  int64 `json:"chat" validate:"required,gte=0"`
+		Seconds int   `json:"seconds" validate:"gte=0,lte=86400"`
+	}
+	type Responce struct {
+		Id int64 `json:"id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request Request
+		if err := s.decodeAndValidate(w, r, &request); err != nil {
+			return
+		}
+		actorId := getUserId(r)
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"chat_id":  request.ChatId,
+			"seconds":  request.Seconds,
+			"actor_id": actorId,
+		})
+		if err := s.Storage.SetChatSlowMode(r.Context(), actorId, request.ChatId, request.Seconds); err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("SetChatSlowMode failed: %w", err))
+			return
+		}
+		s.respond(w, r, Responce{request.ChatId}, http.StatusOK)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+func TestHandleSetChatSlowMode(t *testing.T) {
+	type TestCase struct {
+		TestName           string
+		RequestBody        string
+		ExpectedStatusCode int
+		ExpectedErrorMsg   string
+		ExpectedErrorCode  string
+		ExpectedId         int64
+		SetupStorage       func(mock *mocks.Storage, testCase *TestCase)
+	}
+
+	testCases := []*TestCase{
+		&TestCase{
+			TestName:           "OK",
+			RequestBody:        `{"chat": 10, "seconds": 30}`,
+			ExpectedStatusCode: http.StatusOK,
+			ExpectedId:         10,
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("SetChatSlowMode", testifyMock.Anything, int64(1), int64(10), 30).Return(nil).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Turn off",
+			RequestBody:        `{"chat": 10, "seconds": 0}`,
+			ExpectedStatusCode: http.StatusOK,
+			ExpectedId:         10,
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("SetChatSlowMode", testifyMock.Anything, int64(1), int64(10), 0).Return(nil).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Permission denied",
+			RequestBody:        `{"chat": 11, "seconds": 30}`,
+			ExpectedStatusCode: http.StatusForbidden,
+			ExpectedErrorMsg:   "role of user in the chat doesn't allow this action",
+			ExpectedErrorCode:  "permission_denied",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("SetChatSlowMode", testifyMock.Anything, int64(1), int64(11), 30).Return(storage.ErrPermissionDenied).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Too long",
+			RequestBody:        `{"chat": 10, "seconds": 86401}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+			},
+		},
+	}
+	server := NewServer(nil, nil, true)
+
+	type Responce struct {
+		Id    int64  `json:"id"`
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.TestName, func(t *testing.T) {
+			mockStorage := &mocks.Storage{}
+			server.Storage = mockStorage
+
+			requestData := strings.NewReader(testCase.RequestBody)
+			request, err := http.NewRequest(http.MethodPost, "/chats/slowmode", requestData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request = withUserId(request, 1)
+			recorder := httptest.NewRecorder()
+
+			testCase.SetupStorage(mockStorage, testCase)
+
+			handler := server.handleSetChatSlowMode()
+			handler.ServeHTTP(recorder, request)
+
+			mockStorage.AssertExpectations(t)
+			assert.Equal(t, testCase.ExpectedStatusCode, recorder.Code)
+
+			var responce Responce
+			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
+				assert.Equal(t, testCase.ExpectedId, responce.Id)
+				assert.Equal(t, testCase.ExpectedErrorMsg, responce.Error)
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// handleTransferChatOwnership returns handler that makes member owner of chat instead of authenticated user,
+// who becomes admin. Handler responds with members of the chat.
+func (s *Server) handleTransferChatOwnership() http.HandlerFunc {
+	type Request struct {
+		ChatId int64 `json:"chat" validate:"required,gte=0"`
+		UserId int64 `json:"user" validate:"required,gte=0"`
+	}
+	type Responce struct {
+		Members []*storage.ChatMember `json:"members"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request Request
+		if err := s.decodeAndValidate(w, r, &request); err != nil {
+			return
+		}
+		ownerId := getUserId(r)
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"chat_id":  request.ChatId,
+			"user_id":  request.UserId,
+			"owner_id": ownerId,
+		})
+		if err := s.Storage.TransferChatOwnership(r.Context(), ownerId, request.ChatId, request.UserId); err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("TransferChatOwnership failed: %w", err))
+			return
+		}
+		members, err := s.Storage.GetChatMembers(r.Context(), request.ChatId)
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("GetChatMembers failed: %w", err))
+			return
+		}
+		s.respond(w, r, Responce{members}, http.StatusOK)
+	}
+}
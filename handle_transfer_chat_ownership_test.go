@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+func TestHandleTransferChatOwnership(t *testing.T) {
+	type TestCase struct {
+		TestName           string
+		RequestBody        string
+		ExpectedStatusCode int
+		ExpectedErrorMsg   string
+		ExpectedErrorCode  string
+		Responce           []*storage.ChatMember
+		SetupStorage       func(mock *mocks.Storage, testCase *TestCase)
+	}
+
+	testCases := []*TestCase{
+		&TestCase{
+			TestName:           "OK",
+			RequestBody:        `{"chat": 10, "user": 2}`,
+			ExpectedStatusCode: http.StatusOK,
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("TransferChatOwnership", testifyMock.Anything, int64(1), int64(10), int64(2)).Return(nil).Once()
+				mock.On("GetChatMembers", testifyMock.Anything, int64(10)).Return(testCase.Responce, nil).Once()
+			},
+			Responce: []*storage.ChatMember{
+				&storage.ChatMember{UserId: 1, Role: storage.RoleAdmin},
+				&storage.ChatMember{UserId: 2, Role: storage.RoleOwner},
+			},
+		},
+		&TestCase{
+			TestName:           "User is not owner",
+			RequestBody:        `{"chat": 11, "user": 2}`,
+			ExpectedStatusCode: http.StatusForbidden,
+			ExpectedErrorMsg:   "role of user in the chat doesn't allow this action",
+			ExpectedErrorCode:  "permission_denied",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("TransferChatOwnership", testifyMock.Anything, int64(1), int64(11), int64(2)).
+					Return(storage.ErrPermissionDenied).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "User is not member",
+			RequestBody:        `{"chat": 10, "user": 3}`,
+			ExpectedStatusCode: http.StatusNotFound,
+			ExpectedErrorMsg:   "user is not member of the chat",
+			ExpectedErrorCode:  "member_not_found",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+				mock.On("TransferChatOwnership", testifyMock.Anything, int64(1), int64(10), int64(3)).
+					Return(storage.ErrMemberNotFound).Once()
+			},
+		},
+		&TestCase{
+			TestName:           "Without user",
+			RequestBody:        `{"chat": 10}`,
+			ExpectedStatusCode: http.StatusBadRequest,
+			ExpectedErrorMsg:   "invalid input",
+			ExpectedErrorCode:  "invalid_input",
+			SetupStorage: func(mock *mocks.Storage, testCase *TestCase) {
+			},
+		},
+	}
+	server := NewServer(nil, nil, true)
+
+	type Responce struct {
+		Members []*storage.ChatMember `json:"members"`
+		Error   string                `json:"error"`
+		Code    string                `json:"code"`
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.TestName, func(t *testing.T) {
+			mockStorage := &mocks.Storage{}
+			server.Storage = mockStorage
+
+			requestData := strings.NewReader(testCase.RequestBody)
+			request, err := http.NewRequest(http.MethodPost, "/chats/transfer", requestData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request = withUserId(request, 1)
+			recorder := httptest.NewRecorder()
+
+			testCase.SetupStorage(mockStorage, testCase)
+
+			handler := server.handleTransferChatOwnership()
+			handler.ServeHTTP(recorder, request)
+
+			mockStorage.AssertExpectations(t)
+			assert.Equal(t, testCase.ExpectedStatusCode, recorder.Code)
+
+			var responce Responce
+			if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
+				assert.Equal(t, testCase.Responce, responce.Members)
+				assert.Equal(t, testCase.ExpectedErrorMsg, responce.Error)
+				assert.Equal(t, testCase.ExpectedErrorCode, responce.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+
+	"github.com/Darkclainer/avito_exercise/hub"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+)
+
+// handleWebSocket returns handler that streams new messages from all chats of authenticated user
+// and chats created with him over WebSocket.
+//
+// If "last_id" query parameter is set, missed messages are sent first, so client can resume after reconnect.
+// Some of them may be already known to client, it skips them by id, see loadMessagesAfter.
+// Every message is sent as JSON frame {"type": "message", "message": {...}},
+// new chat is sent as {"type": "chat_created", "chat": {...}}.
+// Client that doesn't keep up with messages is disconnected with "try again later" close code.
+// If client missed too many messages, {"type": "error", "error": ..., "code": "resync_required"} frame
+// is sent and connection is closed.
+func (s *Server) handleWebSocket() http.HandlerFunc {
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := getUserId(r)
+		var lastId int64
+		if rawLastId := r.URL.Query().Get("last_id"); rawLastId != "" {
+			var err error
+			lastId, err = strconv.ParseInt(rawLastId, 10, 64)
+			if err != nil || lastId < 0 {
+				s.respondWithError(w, r, nil, badRequest("invalid_input", "invalid last_id"))
+				return
+			}
+		}
+		logger := s.getLogger(r).WithFields(logrus.Fields{
+			"user_id": userId,
+			"last_id": lastId,
+		})
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// upgrader already responded to client
+			logger.WithField("error", err).Debug("WebSocket upgrade failed")
+			return
+		}
+		defer conn.Close()
+		// subscribe before loading missed messages, so nothing is lost between them
+		sub := s.Hub.Subscribe(userId, streamSendBuffer)
+		defer s.Hub.Unsubscribe(sub)
+
+		var missed []*storage.Message
+		if lastId > 0 {
+			missed, err = s.loadMessagesAfter(r.Context(), userId, lastId)
+			if err == errTooManyMissed {
+				logger.Debug("WebSocket client missed too many messages")
+				webSocketWriter{conn}.ResyncRequired()
+				return
+			}
+			if err != nil {
+				logger.WithField("error", err).Error("Can not load missed messages")
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "internal error"),
+					time.Now().Add(wsWriteWait))
+				return
+			}
+		}
+		logger.Debug("WebSocket subscribed")
+		closed := make(chan struct{})
+		go readWebSocket(conn, closed)
+		streamEvents(webSocketWriter{conn}, sub, missed, wsPingPeriod, closed, s.stopping, logger)
+		logger.Debug("WebSocket unsubscribed")
+	}
+}
+
+// readWebSocket discards everything client sends, but handles pongs and control frames.
+// It closes closed when connection is broken.
+func readWebSocket(conn *websocket.Conn, closed chan<- struct{}) {
+	defer close(closed)
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// webSocketWriter sends events as JSON frames.
+type webSocketWriter struct {
+	conn *websocket.Conn
+}
+
+func (w webSocketWriter) WriteEvent(event *hub.Event) error {
+	type Frame struct {
+		Type    string           `json:"type"`
+		Message *storage.Message `json:"message,omitempty"`
+		Chat    *storage.Chat    `json:"chat,omitempty"`
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return w.conn.WriteJSON(Frame{event.Type, event.Message, event.Chat})
+}
+
+func (w webSocketWriter) Ping() error {
+	w.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return w.conn.WriteMessage(websocket.PingMessage, nil)
+}
+
+func (w webSocketWriter) TooSlow() {
+	w.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow, reconnect with last_id"),
+		time.Now().Add(wsWriteWait))
+}
+
+// ResyncRequired sends errResyncRequired as error frame and closes connection.
+func (w webSocketWriter) ResyncRequired() {
+	type Frame struct {
+		Type string `json:"type"`
+		errorResponce
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	w.conn.WriteJSON(Frame{"error", errorResponce{Error: errResyncRequired.Message, Code: errResyncRequired.Code}})
+	w.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "resync required"),
+		time.Now().Add(wsWriteWait))
+}
+
+func (w webSocketWriter) GoingAway() {
+	w.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down, reconnect with last_id"),
+		time.Now().Add(wsWriteWait))
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/hub"
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+type webSocketFrame struct {
+	Type    string           `json:"type"`
+	Message *storage.Message `json:"message"`
+}
+
+func dialWebSocket(t *testing.T, testServer *httptest.Server, token string, query string) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(testServer.URL, "http") + "/ws?" + query
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal("Dial failed: ", err)
+	}
+	return conn
+}
+
+func readFrame(t *testing.T, conn *websocket.Conn) webSocketFrame {
+	var frame webSocketFrame
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&frame); err != nil {
+		t.Fatal("Read frame failed: ", err)
+	}
+	return frame
+}
+
+func waitSubscribers(t *testing.T, server *Server, count int) {
+	for i := 0; i < 100; i++ {
+		if server.Hub.Subscribers() == count {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Hub has %d subscribers, expected %d", server.Hub.Subscribers(), count)
+}
+
+func TestHandleWebSocket(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	server := NewServer(mockStorage, nil, true)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	mockStorage.On("GetUserIdByToken", testifyMock.Anything, hashToken("token_20")).Return(int64(20), nil)
+	conn := dialWebSocket(t, testServer, "token_20", "")
+	defer conn.Close()
+	waitSubscribers(t, server, 1)
+
+	mockStorage.On("AddMessage", testifyMock.Anything, int64(20), int64(10), "Hello").Return(int64(50), nil)
+	message := &storage.Message{Id: 50, ChatId: 10, AuthorId: 20, Text: "Hello",
+		CreatedAt: time.Date(2019, time.January, 1, 10, 0, 0, 0, time.UTC)}
+	mockStorage.On("GetMessage", testifyMock.Anything, int64(50)).Return(message, nil)
+	mockStorage.On("GetChatUserIds", testifyMock.Anything, int64(10)).Return([]int64{20, 21}, nil)
+
+	request, err := http.NewRequest(http.MethodPost, testServer.URL+"/messages/add",
+		strings.NewReader(`{"chat": 10, "text": "Hello"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", "Bearer token_20")
+	responce, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal("Post failed: ", err)
+	}
+	responce.Body.Close()
+	assert.Equal(t, http.StatusOK, responce.StatusCode)
+
+	frame := readFrame(t, conn)
+	assert.Equal(t, "message", frame.Type)
+	assert.Equal(t, message, frame.Message)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestHandleWebSocketResume(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	server := NewServer(mockStorage, nil, true)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	mockStorage.On("GetUserIdByToken", testifyMock.Anything, hashToken("token_20")).Return(int64(20), nil)
+	mockStorage.On("GetUserChats", testifyMock.Anything, int64(20)).Return([]*storage.Chat{
+		&storage.Chat{Id: 10}, &storage.Chat{Id: 11},
+	}, nil)
+	mockResumeAfter5(mockStorage)
+
+	conn := dialWebSocket(t, testServer, "token_20", "last_id=5")
+	defer conn.Close()
+	for _, id := range []int64{4, 5, 6, 7, 8} {
+		frame := readFrame(t, conn)
+		assert.Equal(t, id, frame.Message.Id)
+	}
+	// message that was already sent as missed must not be repeated
+	server.Hub.Publish(hub.NewMessageEvent(&storage.Message{Id: 8, ChatId: 10}), []int64{20})
+	server.Hub.Publish(hub.NewMessageEvent(&storage.Message{Id: 9, ChatId: 11}), []int64{20})
+	assert.Equal(t, int64(9), readFrame(t, conn).Message.Id)
+	mockStorage.AssertExpectations(t)
+}
+
+// mockResumeAfter5 makes chats 10 and 11 return messages missed after message 5. Messages 4 and 5 are
+// in resume window before it, message 3 is older.
+func mockResumeAfter5(mockStorage *mocks.Storage) {
+	lastAt := time.Date(2019, time.January, 1, 10, 0, 0, 0, time.UTC)
+	mockStorage.On("GetMessage", testifyMock.Anything, int64(5)).Return(&storage.Message{Id: 5, ChatId: 11,
+		CreatedAt: lastAt}, nil)
+	mockStorage.On("GetMessagesPage", testifyMock.Anything, int64(10), storage.PageQuery{BeforeId: 6,
+		Limit: resumePageSize}).
+		Return([]*storage.Message{{Id: 3, ChatId: 10, CreatedAt: lastAt.Add(-resumeWindow - time.Second)},
+			{Id: 4, ChatId: 10, CreatedAt: lastAt.Add(time.Second)}}, nil)
+	mockStorage.On("GetMessagesPage", testifyMock.Anything, int64(11), storage.PageQuery{BeforeId: 6,
+		Limit: resumePageSize}).
+		Return([]*storage.Message{{Id: 5, ChatId: 11, CreatedAt: lastAt}}, nil)
+	mockStorage.On("GetMessagesPage", testifyMock.Anything, int64(10), storage.PageQuery{AfterId: 5,
+		Limit: resumePageSize}).
+		Return([]*storage.Message{{Id: 6, ChatId: 10}, {Id: 8, ChatId: 10}}, nil)
+	mockStorage.On("GetMessagesPage", testifyMock.Anything, int64(11), storage.PageQuery{AfterId: 5,
+		Limit: resumePageSize}).
+		Return([]*storage.Message{{Id: 7, ChatId: 11}}, nil)
+}
+
+// mockTooManyMissed makes every chat of user return full pages of missed messages, so resume is impossible.
+func mockTooManyMissed(mockStorage *mocks.Storage, userId int64) {
+	page := make([]*storage.Message, resumePageSize)
+	for i := range page {
+		page[i] = &storage.Message{Id: int64(i + 1), ChatId: 10}
+	}
+	mockStorage.On("GetUserChats", testifyMock.Anything, userId).Return([]*storage.Chat{&storage.Chat{Id: 10}}, nil)
+	mockStorage.On("GetMessage", testifyMock.Anything, testifyMock.Anything).Return(nil, storage.ErrNotFound)
+	mockStorage.On("GetMessagesPage", testifyMock.Anything, int64(10), testifyMock.Anything).Return(page, nil)
+}
+
+func TestHandleWebSocketResyncRequired(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	server := NewServer(mockStorage, nil, true)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+	mockStorage.On("GetUserIdByToken", testifyMock.Anything, hashToken("token_20")).Return(int64(20), nil)
+	mockTooManyMissed(mockStorage, 20)
+
+	conn := dialWebSocket(t, testServer, "token_20", "last_id=1")
+	defer conn.Close()
+	var frame struct {
+		Type string `json:"type"`
+		Code string `json:"code"`
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if assert.NoError(t, conn.ReadJSON(&frame)) {
+		assert.Equal(t, "error", frame.Type)
+		assert.Equal(t, "resync_required", frame.Code)
+	}
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), err)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestHandleWebSocketUnauthorized(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	server := NewServer(mockStorage, nil, true)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	mockStorage.On("GetUserIdByToken", testifyMock.Anything, hashToken("unknown")).Return(int64(0), storage.ErrNotFound)
+	url := "ws" + strings.TrimPrefix(testServer.URL, "http") + "/ws?token=unknown"
+	_, responce, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, responce) {
+		assert.Equal(t, http.StatusUnauthorized, responce.StatusCode)
+	}
+	mockStorage.AssertExpectations(t)
+}
@@ -0,0 +1,134 @@
+// Package hub implements in-process fan-out of events, such as new messages, to subscribed users.
+package hub
+
+import (
+	"sync"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// Types of Event.
+const (
+	EventMessage     = "message"
+	EventChatCreated = "chat_created"
+)
+
+// Event is published to subscribed users. Message is set for EventMessage, Chat is set for EventChatCreated.
+type Event struct {
+	Type    string
+	Message *storage.Message
+	Chat    *storage.Chat
+}
+
+func NewMessageEvent(message *storage.Message) *Event {
+	return &Event{Type: EventMessage, Message: message}
+}
+
+func NewChatEvent(chat *storage.Chat) *Event {
+	return &Event{Type: EventChatCreated, Chat: chat}
+}
+
+// Hub delivers published events to subscriptions of recipients. It is safe for concurrent use.
+type Hub struct {
+	mu   sync.RWMutex
+	subs map[int64]map[*Subscription]struct{}
+}
+
+// Subscription receives events addressed to its user.
+// Hub never blocks on slow subscription: if buffer is full, subscription is dropped and Done is closed.
+type Subscription struct {
+	UserId int64
+	events chan *Event
+	done   chan struct{}
+	once   sync.Once
+}
+
+func New() *Hub {
+	return &Hub{
+		subs: make(map[int64]map[*Subscription]struct{}),
+	}
+}
+
+// Events returns channel with events for subscription.
+func (sub *Subscription) Events() <-chan *Event {
+	return sub.events
+}
+
+// Done returns channel that is closed when subscription is removed from hub.
+func (sub *Subscription) Done() <-chan struct{} {
+	return sub.done
+}
+
+func (sub *Subscription) close() {
+	sub.once.Do(func() { close(sub.done) })
+}
+
+// Subscribe creates subscription for user with specified buffer size.
+func (h *Hub) Subscribe(userId int64, buffer int) *Subscription {
+	sub := &Subscription{
+		UserId: userId,
+		events: make(chan *Event, buffer),
+		done:   make(chan struct{}),
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	userSubs, ok := h.subs[userId]
+	if !ok {
+		userSubs = make(map[*Subscription]struct{})
+		h.subs[userId] = userSubs
+	}
+	userSubs[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe removes subscription from hub. It's safe to unsubscribe many times.
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(sub)
+}
+
+// remove must be called with locked mu.
+func (h *Hub) remove(sub *Subscription) {
+	userSubs := h.subs[sub.UserId]
+	delete(userSubs, sub)
+	if len(userSubs) == 0 {
+		delete(h.subs, sub.UserId)
+	}
+	sub.close()
+}
+
+// Publish sends event to every subscription of recipients. Subscriptions with full buffer are dropped.
+func (h *Hub) Publish(event *Event, recipients []int64) {
+	var slow []*Subscription
+	h.mu.RLock()
+	for _, userId := range recipients {
+		for sub := range h.subs[userId] {
+			select {
+			case sub.events <- event:
+			default:
+				slow = append(slow, sub)
+			}
+		}
+	}
+	h.mu.RUnlock()
+	if len(slow) == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, sub := range slow {
+		h.remove(sub)
+	}
+}
+
+// Subscribers returns number of active subscriptions.
+func (h *Hub) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	count := 0
+	for _, userSubs := range h.subs {
+		count += len(userSubs)
+	}
+	return count
+}
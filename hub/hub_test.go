@@ -0,0 +1,80 @@
+package hub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+func receive(sub *Subscription) *Event {
+	select {
+	case event := <-sub.Events():
+		return event
+	default:
+		return nil
+	}
+}
+
+func TestPublish(t *testing.T) {
+	h := New()
+	sub1 := h.Subscribe(1, 10)
+	sub1Again := h.Subscribe(1, 10)
+	sub2 := h.Subscribe(2, 10)
+	sub3 := h.Subscribe(3, 10)
+	assert.Equal(t, 4, h.Subscribers())
+
+	event := NewMessageEvent(&storage.Message{Id: 10, ChatId: 1, AuthorId: 1, Text: "Hello"})
+	h.Publish(event, []int64{1, 2, 4})
+
+	assert.Equal(t, event, receive(sub1))
+	assert.Equal(t, event, receive(sub1Again))
+	assert.Equal(t, event, receive(sub2))
+	assert.Nil(t, receive(sub3))
+
+	chatEvent := NewChatEvent(&storage.Chat{Id: 1, Name: "chat_1", UserIds: []int64{1, 3}})
+	h.Publish(chatEvent, []int64{1, 3})
+	assert.Equal(t, EventChatCreated, receive(sub1).Type)
+	assert.Equal(t, chatEvent, receive(sub3))
+	assert.Nil(t, receive(sub2))
+}
+
+func TestUnsubscribe(t *testing.T) {
+	h := New()
+	sub := h.Subscribe(1, 10)
+	h.Unsubscribe(sub)
+	h.Unsubscribe(sub)
+	assert.Equal(t, 0, h.Subscribers())
+
+	h.Publish(NewMessageEvent(&storage.Message{Id: 1}), []int64{1})
+	assert.Nil(t, receive(sub))
+	select {
+	case <-sub.Done():
+	default:
+		t.Error("Done is not closed after Unsubscribe")
+	}
+}
+
+func TestSlowSubscriptionIsDropped(t *testing.T) {
+	h := New()
+	slow := h.Subscribe(1, 1)
+	fast := h.Subscribe(1, 10)
+
+	h.Publish(NewMessageEvent(&storage.Message{Id: 1}), []int64{1})
+	h.Publish(NewMessageEvent(&storage.Message{Id: 2}), []int64{1})
+
+	select {
+	case <-slow.Done():
+	default:
+		t.Error("slow subscription is not dropped")
+	}
+	select {
+	case <-fast.Done():
+		t.Error("fast subscription is dropped")
+	default:
+	}
+	assert.Equal(t, 1, h.Subscribers())
+	assert.Equal(t, int64(1), receive(fast).Message.Id)
+	assert.Equal(t, int64(2), receive(fast).Message.Id)
+}
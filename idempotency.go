@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	defaultIdempotencyWindow = 24 * time.Hour
+	idempotencyCleanupPeriod = time.Hour
+	// idempotencyAbandonTimeout is how long key stays in progress before it can be reserved again
+	idempotencyAbandonTimeout = time.Minute
+)
+
+// idempotent is middleware for handlers that create entities. Request with Idempotency-Key header is executed
+// only once within IdempotencyWindow: its response is saved with the key, and repeated requests get it back
+// with "Idempotent-Replayed: true" header. Keys are scoped by authenticated user and endpoint.
+// Key reused with different body and key of request that is still in progress are rejected.
+// Key that stays in progress longer than idempotencyAbandonTimeout, for example because server crashed
+// or couldn't save the response, is abandoned and the next request with it is executed.
+// Responses with 5xx status aren't saved, so such requests can be retried with the same key.
+//
+// Anonymous callers (of /users/add) can't be told apart, so their keys are scoped by request body as well
+// and successful response, which contains token of new user, is neither saved nor replayed:
+// repeated request gets errAlreadyAdded instead.
+func (s *Server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		logger := s.getLogger(r).WithField("idempotency_key", key)
+		if len(key) > maxIdempotencyKeyLength {
+			s.respondWithError(w, r, logger, badRequest("invalid_idempotency_key",
+				fmt.Sprintf("Idempotency-Key must not be longer than %d characters", maxIdempotencyKeyLength)))
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			s.respondWithError(w, r, logger.WithField("error", err), errJsonDecoding)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		userId, isAuthorized := r.Context().Value(userIdContextKey).(int64)
+		reserved := &storage.IdempotencyKey{
+			UserId:      userId,
+			Endpoint:    r.URL.Path,
+			Key:         key,
+			RequestHash: requestHash(body),
+		}
+		if !isAuthorized {
+			// anonymous keys have zero user id
+			reserved.Key = key + ":" + reserved.RequestHash
+		}
+		now := time.Now()
+		saved, err := s.Storage.ReserveIdempotencyKey(r.Context(), reserved, now.Add(-s.IdempotencyWindow),
+			now.Add(-idempotencyAbandonTimeout))
+		if err != nil {
+			s.respondWithStorageError(w, r, logger, fmt.Errorf("ReserveIdempotencyKey failed: %w", err))
+			return
+		}
+		switch {
+		case saved == nil:
+		case saved.RequestHash != reserved.RequestHash:
+			s.respondWithError(w, r, logger, errIdempotencyKeyReused)
+			return
+		case saved.Status == 0:
+			s.respondWithError(w, r, logger, errIdempotencyKeyInProgress)
+			return
+		case !isAuthorized && isSuccess(saved.Status):
+			w.Header().Set(idempotentReplayedHeader, "true")
+			s.respondWithError(w, r, logger, errAlreadyAdded)
+			return
+		default:
+			logger.Debug("Replaying saved response")
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set(idempotentReplayedHeader, "true")
+			w.WriteHeader(saved.Status)
+			w.Write(saved.Body)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+		// the key is settled even if client has already gone, otherwise its retry would be rejected
+		ctx, cancel := s.storageContext(context.Background(), 0)
+		defer cancel()
+		if recorder.status >= http.StatusInternalServerError {
+			err = s.Storage.ReleaseIdempotencyKey(ctx, reserved)
+		} else {
+			reserved.Status, reserved.Body = recorder.status, recorder.body.Bytes()
+			if !isAuthorized && isSuccess(recorder.status) {
+				reserved.Body = []byte{}
+			}
+			err = s.Storage.CompleteIdempotencyKey(ctx, reserved)
+		}
+		if err != nil {
+			// response is already sent, so client will get errIdempotencyKeyInProgress until key is abandoned
+			logger.WithField("error", err).Error("Can not save response of idempotent request")
+		}
+	}
+}
+
+func isSuccess(status int) bool {
+	return status >= 200 && status < 300
+}
+
+// requestHash returns hash of request body that tells apart requests with the same Idempotency-Key.
+func requestHash(body []byte) string {
+	hash := sha256.Sum256(body)
+	return hex.EncodeToString(hash[:])
+}
+
+// responseRecorder passes response to client and keeps its status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (recorder *responseRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *responseRecorder) Write(data []byte) (int, error) {
+	recorder.body.Write(data)
+	return recorder.ResponseWriter.Write(data)
+}
+
+// RunIdempotencyCleanup deletes expired idempotency keys every hour until ctx is done.
+func (s *Server) RunIdempotencyCleanup(ctx context.Context) {
+	ticker := time.NewTicker(idempotencyCleanupPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		callCtx, cancel := s.storageContext(ctx, 0)
+		deleted, err := s.Storage.DeleteExpiredIdempotencyKeys(callCtx, time.Now().Add(-s.IdempotencyWindow))
+		cancel()
+		if err != nil {
+			s.Logger.WithField("error", err).Error("Can not delete expired idempotency keys")
+			continue
+		}
+		s.Logger.WithFields(logrus.Fields{"deleted": deleted}).Debug("Expired idempotency keys are deleted")
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// postIdempotent sends request to server as user with id 1, key is omitted if it's empty.
+func postIdempotent(server *Server, path string, key string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if key != "" {
+		request.Header.Set(idempotencyKeyHeader, key)
+	}
+	recorder := httptest.NewRecorder()
+	server.idempotent(server.handleAddMessage()).ServeHTTP(recorder, withUserId(request, 1))
+	return recorder
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	ctx := context.Background()
+	memoryStorage := storage.NewMemoryStorage()
+	userId, _ := memoryStorage.AddUser(ctx, "user_1")
+	chatId, _ := memoryStorage.AddChat(ctx, userId, "chat_1", nil)
+	server := NewServer(memoryStorage, nil, true)
+
+	first := postIdempotent(server, "/messages/add", "key_1", `{"chat": 1, "text": "Hello"}`)
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Empty(t, first.Header().Get(idempotentReplayedHeader))
+	second := postIdempotent(server, "/messages/add", "key_1", `{"chat": 1, "text": "Hello"}`)
+	assert.Equal(t, http.StatusOK, second.Code)
+	assert.Equal(t, "true", second.Header().Get(idempotentReplayedHeader))
+	assert.Equal(t, "application/json", second.Header().Get("Content-Type"))
+	assert.Equal(t, first.Body.String(), second.Body.String())
+
+	messages, err := memoryStorage.GetMessagesFromChat(ctx, chatId)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 1)
+
+	// errors are replayed as well
+	first = postIdempotent(server, "/messages/add", "key_2", `{"chat": 2, "text": "Hello"}`)
+	assert.Equal(t, http.StatusForbidden, first.Code)
+	second = postIdempotent(server, "/messages/add", "key_2", `{"chat": 2, "text": "Hello"}`)
+	assert.Equal(t, http.StatusForbidden, second.Code)
+	assert.Equal(t, "true", second.Header().Get(idempotentReplayedHeader))
+
+	// requests without key are executed every time
+	postIdempotent(server, "/messages/add", "", `{"chat": 1, "text": "Hello"}`)
+	postIdempotent(server, "/messages/add", "", `{"chat": 1, "text": "Hello"}`)
+	messages, err = memoryStorage.GetMessagesFromChat(ctx, chatId)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 3)
+}
+
+func TestIdempotentRejections(t *testing.T) {
+	ctx := context.Background()
+	memoryStorage := storage.NewMemoryStorage()
+	userId, _ := memoryStorage.AddUser(ctx, "user_1")
+	memoryStorage.AddChat(ctx, userId, "chat_1", nil)
+	server := NewServer(memoryStorage, nil, true)
+
+	type Responce struct {
+		Code string `json:"code"`
+	}
+	assertError := func(t *testing.T, recorder *httptest.ResponseRecorder, status int, code string) {
+		assert.Equal(t, status, recorder.Code)
+		var responce Responce
+		if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &responce)) {
+			assert.Equal(t, code, responce.Code)
+		}
+	}
+
+	postIdempotent(server, "/messages/add", "key_1", `{"chat": 1, "text": "Hello"}`)
+	assertError(t, postIdempotent(server, "/messages/add", "key_1", `{"chat": 1, "text": "Bye"}`),
+		http.StatusUnprocessableEntity, "idempotency_key_reused")
+
+	// key reserved by request that hasn't finished yet
+	body := `{"chat": 1, "text": "Hello"}`
+	_, err := memoryStorage.ReserveIdempotencyKey(ctx, &storage.IdempotencyKey{
+		UserId: 1, Endpoint: "/messages/add", Key: "key_2", RequestHash: requestHash([]byte(body))}, time.Now(),
+		time.Now())
+	assert.NoError(t, err)
+	assertError(t, postIdempotent(server, "/messages/add", "key_2", body),
+		http.StatusConflict, "idempotency_key_in_progress")
+
+	assertError(t, postIdempotent(server, "/messages/add", strings.Repeat("k", 256), body),
+		http.StatusBadRequest, "invalid_idempotency_key")
+}
+
+func TestIdempotentReleasesKeyOnInternalError(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	server := NewServer(mockStorage, nil, true)
+	body := `{"chat": 10, "text": "Hello"}`
+	key := &storage.IdempotencyKey{UserId: 1, Endpoint: "/messages/add", Key: "key_1",
+		RequestHash: requestHash([]byte(body))}
+	mockStorage.On("ReserveIdempotencyKey", testifyMock.Anything, key, testifyMock.AnythingOfType("time.Time"),
+		testifyMock.AnythingOfType("time.Time")).
+		Return(nil, nil).Once()
+	mockStorage.On("AddMessage", testifyMock.Anything, int64(1), int64(10), "Hello").
+		Return(int64(0), errors.New("disk is full")).Once()
+	mockStorage.On("ReleaseIdempotencyKey", testifyMock.Anything, key).Return(nil).Once()
+
+	recorder := postIdempotent(server, "/messages/add", "key_1", body)
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestIdempotentScopes(t *testing.T) {
+	ctx := context.Background()
+	memoryStorage := storage.NewMemoryStorage()
+	server := NewServer(memoryStorage, nil, true)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	type Responce struct {
+		Id    int64  `json:"id"`
+		Token string `json:"token"`
+		Code  string `json:"code"`
+	}
+	addUser := func(username string) (*http.Response, Responce) {
+		request, err := http.NewRequest(http.MethodPost, testServer.URL+"/users/add",
+			strings.NewReader(`{"username": "`+username+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set(idempotencyKeyHeader, "key_1")
+		responce, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer responce.Body.Close()
+		var body Responce
+		if err := json.NewDecoder(responce.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return responce, body
+	}
+	first, created := addUser("user_1")
+	assert.Equal(t, http.StatusOK, first.StatusCode)
+	assert.NotEmpty(t, created.Token)
+	// anonymous callers can't be told apart, so repeated signup must not get token of the first one
+	second, repeated := addUser("user_1")
+	assert.Equal(t, http.StatusConflict, second.StatusCode)
+	assert.Equal(t, "true", second.Header.Get(idempotentReplayedHeader))
+	assert.Empty(t, repeated.Token)
+	assert.Equal(t, "already_added", repeated.Code)
+	body := `{"username": "user_1"}`
+	hash := requestHash([]byte(body))
+	saved, err := memoryStorage.ReserveIdempotencyKey(ctx, &storage.IdempotencyKey{
+		UserId: 0, Endpoint: "/users/add", Key: "key_1:" + hash}, time.Now().Add(-time.Hour),
+		time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	if assert.NotNil(t, saved) {
+		assert.Equal(t, http.StatusOK, saved.Status)
+		assert.Empty(t, saved.Body, "response with token must not be saved")
+	}
+	// other anonymous caller with the same key isn't affected
+	third, other := addUser("user_2")
+	assert.Equal(t, http.StatusOK, third.StatusCode)
+	assert.NotEmpty(t, other.Token)
+	assert.NotEqual(t, created.Id, other.Id)
+
+	// the same key of other endpoint is independent
+	postIdempotent(server, "/messages/add", "key_1", `{"chat": 1, "text": "Hello"}`)
+	saved, err = memoryStorage.ReserveIdempotencyKey(ctx, &storage.IdempotencyKey{
+		UserId: 1, Endpoint: "/chats/add", Key: "key_1"}, time.Now().Add(-time.Hour),
+		time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Nil(t, saved)
+}
+
+func TestIdempotentReplaysAnonymousErrors(t *testing.T) {
+	server := NewServer(storage.NewMemoryStorage(), nil, true)
+	executed := 0
+	handler := server.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		executed++
+		server.respondWithError(w, r, nil, errUserExists)
+	})
+	for i := 0; i < 2; i++ {
+		request := httptest.NewRequest(http.MethodPost, "/users/add", strings.NewReader(`{"username": "user_1"}`))
+		request.Header.Set(idempotencyKeyHeader, "key_1")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "user_exists")
+	}
+	assert.Equal(t, 1, executed)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// unmatchedRoute labels requests that didn't match any route, so arbitrary paths don't create new series.
+const unmatchedRoute = "unmatched"
+
+// instrument records count and latency of requests by route template and status.
+// Streaming routes (/ws, /events) are recorded when the stream ends.
+func (s *Server) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		writer := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(writer, r)
+		route := unmatchedRoute
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		s.Metrics.ObserveRequest(route, r.Method, writer.status, time.Since(start))
+	})
+}
+
+// instrumentUnary is gRPC interceptor that records count and latency of calls by method and status code.
+func (g *grpcService) instrumentUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	response, err := handler(ctx, req)
+	g.s.Metrics.ObserveGRPCCall(info.FullMethod, status.Code(err).String(), time.Since(start))
+	return response, err
+}
+
+// instrumentStream is instrumentUnary for streams, they are recorded when they end.
+func (g *grpcService) instrumentStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, stream)
+	g.s.Metrics.ObserveGRPCCall(info.FullMethod, status.Code(err).String(), time.Since(start))
+	return err
+}
+
+// statusWriter remembers status and size of response. It keeps Flusher and Hijacker of underlying writer
+// available, because server-sent events and WebSocket depend on them.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(data []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(data)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer doesn't support hijacking")
+	}
+	// connection is taken over by WebSocket, that's reported as switching protocols
+	w.status = http.StatusSwitchingProtocols
+	w.wroteHeader = true
+	return hijacker.Hijack()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Darkclainer/avito_exercise/chatpb"
+	"github.com/Darkclainer/avito_exercise/mocks"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	server := NewServer(&mocks.Storage{}, nil, true)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	post := func(path string) {
+		responce, err := http.Post(testServer.URL+path, "application/json", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		responce.Body.Close()
+	}
+	post("/chats/get")
+	post("/chats/get")
+	post("/unknown/path")
+
+	responce, err := http.Get(testServer.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer responce.Body.Close()
+	assert.Equal(t, http.StatusOK, responce.StatusCode)
+	body, err := ioutil.ReadAll(responce.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := string(body)
+	assert.Contains(t, metrics, `chat_http_requests_total{method="POST",route="/chats/get",status="401"} 2`)
+	assert.Contains(t, metrics, `chat_http_requests_total{method="POST",route="unmatched",status="404"} 1`)
+	assert.Contains(t, metrics, `chat_http_request_duration_seconds_count{method="POST",route="/chats/get",status="401"} 2`)
+	assert.Contains(t, metrics, "chat_event_subscribers 0")
+	assert.NotContains(t, metrics, "/unknown/path")
+}
+
+func TestGRPCMetrics(t *testing.T) {
+	server := NewServer(&mocks.Storage{}, nil, true)
+	client, stop := startGRPC(t, server)
+	defer stop()
+
+	_, err := client.GetChats(context.Background(), &chatpb.GetChatsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	stream, err := client.Subscribe(context.Background(), &chatpb.SubscribeRequest{})
+	if assert.NoError(t, err) {
+		_, err = stream.Recv()
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	}
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	metrics := recorder.Body.String()
+	assert.Contains(t, metrics, `chat_grpc_calls_total{code="Unauthenticated",method="/chat.ChatService/GetChats"} 1`)
+	assert.Contains(t, metrics, `chat_grpc_calls_total{code="Unauthenticated",method="/chat.ChatService/Subscribe"} 1`)
+	assert.Contains(t, metrics,
+		`chat_grpc_call_duration_seconds_count{code="Unauthenticated",method="/chat.ChatService/GetChats"} 1`)
+}
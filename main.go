@@ -1,16 +1,26 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 
 	"github.com/Darkclainer/avito_exercise/config"
+	"github.com/Darkclainer/avito_exercise/metrics"
+	"github.com/Darkclainer/avito_exercise/ratelimit"
 	"github.com/Darkclainer/avito_exercise/storage"
+	"github.com/Darkclainer/avito_exercise/webhook"
 )
 
 func NewLogger(cfg *config.Log) (*logrus.Logger, func(), error) {
@@ -28,6 +38,170 @@ func NewLogger(cfg *config.Log) (*logrus.Logger, func(), error) {
 	return logger, func() { logFile.Close() }, nil
 
 }
+
+// NewWebhookDispatcher creates dispatcher with settings from config, nil if webhooks are disabled.
+func NewWebhookDispatcher(cfg *config.Webhooks, s storage.Storage, logger *logrus.Logger) (*webhook.Dispatcher,
+	error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	dispatcher := webhook.New(s, logger)
+	dispatcher.MaxAttempts = cfg.MaxAttempts
+	dispatcher.Client.Timeout = cfg.Timeout
+	dispatcher.MinBackoff = cfg.MinBackoff
+	dispatcher.MaxBackoff = cfg.MaxBackoff
+	dispatcher.Workers = cfg.Workers
+	denied := cfg.DeniedNetworks
+	if len(denied) == 0 {
+		denied = webhook.DefaultDeniedNetworks
+	}
+	addresses, err := webhook.NewAddressPolicy(cfg.AllowedNetworks, denied)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid webhook networks: %w", err)
+	}
+	dispatcher.Addresses = addresses
+	return dispatcher, nil
+}
+
+// NewRateLimits creates limiters of groups of routes with settings from config.
+// Groups with zero rate aren't limited. Burst less than 1 would reject every request, so it's raised to 1.
+func NewRateLimits(cfg *config.RateLimit) map[string]*ratelimit.Limiter {
+	limits := map[string]config.Limit{
+		rateLimitSignup: cfg.Signup,
+		rateLimitWrite:  cfg.Write,
+		rateLimitRead:   cfg.Read,
+	}
+	limiters := make(map[string]*ratelimit.Limiter)
+	for group, limit := range limits {
+		if limit.Rate <= 0 {
+			continue
+		}
+		if limit.Burst < 1 {
+			limit.Burst = 1
+		}
+		limiters[group] = ratelimit.New(limit.Rate, limit.Burst)
+	}
+	return limiters
+}
+
+// NewStorage creates storage with driver specified in config. Returned function releases storage resources.
+func NewStorage(cfg *config.Config) (storage.Storage, func(), error) {
+	nothing := func() {}
+	switch cfg.Storage.Driver {
+	case "memory":
+		return storage.NewMemoryStorage(), nothing, nil
+	case "sqlite":
+		dbStorage, err := openSqlite(&cfg.Sqlite)
+		if err != nil {
+			return nil, nothing, err
+		}
+		if err := dbStorage.Migrate(); err != nil {
+			dbStorage.Close()
+			return nil, nothing, fmt.Errorf("Can not migrate database: %w", err)
+		}
+		if err := dbStorage.CheckSearch(); err != nil {
+			dbStorage.Close()
+			return nil, nothing, fmt.Errorf("Can not use search, binary must be built with -tags sqlite_fts5: %w", err)
+		}
+		return dbStorage, func() { dbStorage.Close() }, nil
+	case "postgres":
+		dbStorage, err := openPostgres(&cfg.Postgres)
+		if err != nil {
+			return nil, nothing, err
+		}
+		if err := dbStorage.Migrate(); err != nil {
+			dbStorage.Close()
+			return nil, nothing, fmt.Errorf("Can not migrate database: %w", err)
+		}
+		return dbStorage, func() { dbStorage.Close() }, nil
+	}
+	return nil, nothing, fmt.Errorf("Unknown storage driver %q", cfg.Storage.Driver)
+}
+
+// NewHTTPServer creates http.Server for handler with port and timeouts from config.
+func NewHTTPServer(cfg *config.Server, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         ":" + cfg.Port,
+		Handler:      handler,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+}
+
+// shutdown stops accepting new requests and waits for in-flight ones at most timeout,
+// then closes connections that are still open. grpcServer may be nil.
+func shutdown(timeout time.Duration, server *Server, httpServer *http.Server, grpcServer *grpc.Server,
+	logger *logrus.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// streams never finish by themselves, both servers would wait for them until timeout
+	server.StopStreams()
+	grpcStopped := make(chan struct{})
+	if grpcServer != nil {
+		go func() {
+			grpcServer.GracefulStop()
+			close(grpcStopped)
+		}()
+	}
+	if err := httpServer.Shutdown(ctx); err != nil {
+		logger.WithField("error", err).Error("HTTP server didn't finish requests in time")
+		httpServer.Close()
+	}
+	if grpcServer != nil {
+		select {
+		case <-grpcStopped:
+		case <-ctx.Done():
+			logger.Error("gRPC server didn't finish requests in time")
+			grpcServer.Stop()
+		}
+	}
+}
+
+func openSqlite(cfg *config.Sqlite) (storage.SqlStorage, error) {
+	dbStorage, err := storage.OpenSqlite(cfg.Path)
+	if err != nil {
+		return dbStorage, fmt.Errorf("Can not create database: %s", err)
+	}
+	if err := dbStorage.Ping(); err != nil {
+		dbStorage.Close()
+		return dbStorage, fmt.Errorf("Can not to connect to database: %s", err)
+	}
+	return dbStorage, nil
+}
+
+func openPostgres(cfg *config.Postgres) (storage.PostgresStorage, error) {
+	dbStorage, err := storage.OpenPostgres(cfg.DSN)
+	if err != nil {
+		return dbStorage, fmt.Errorf("Can not create database: %s", err)
+	}
+	if err := dbStorage.Ping(); err != nil {
+		dbStorage.Close()
+		return dbStorage, fmt.Errorf("Can not to connect to database: %s", err)
+	}
+	return dbStorage, nil
+}
+
+// openMigrator opens database of storage driver from config and returns its migrator.
+// Returned function closes database.
+func openMigrator(cfg *config.Config) (storage.Migrator, func(), error) {
+	switch cfg.Storage.Driver {
+	case "sqlite":
+		dbStorage, err := openSqlite(&cfg.Sqlite)
+		if err != nil {
+			return storage.Migrator{}, nil, err
+		}
+		return dbStorage.Migrator(), func() { dbStorage.Close() }, nil
+	case "postgres":
+		dbStorage, err := openPostgres(&cfg.Postgres)
+		if err != nil {
+			return storage.Migrator{}, nil, err
+		}
+		return dbStorage.Migrator(), func() { dbStorage.Close() }, nil
+	}
+	return storage.Migrator{}, nil, fmt.Errorf("Migrations are not supported by %q storage driver", cfg.Storage.Driver)
+}
+
 func main() {
 	viper, err := config.NewViper()
 	if err != nil {
@@ -41,22 +215,87 @@ func main() {
 	}
 	defer closeLog()
 
-	db, err := sql.Open("sqlite3", cfg.Sqlite.Path)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrator, closeDB, err := openMigrator(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer closeDB()
+		if err := runMigrate(migrator, os.Args[2:], os.Stdout); err != nil {
+			log.Fatal("Migrate failed: ", err)
+		}
+		return
+	}
+
+	storageHandler, closeStorage, err := NewStorage(cfg)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer closeStorage()
+	server := NewServer(storageHandler, logger, false)
+	switch dbStorage := storageHandler.(type) {
+	case storage.SqlStorage:
+		server.Metrics.RegisterDB(dbStorage.DB)
+		server.ReadinessCheck = dbStorage.CheckReady
+	case storage.PostgresStorage:
+		server.Metrics.RegisterDB(dbStorage.DB)
+		server.ReadinessCheck = dbStorage.CheckReady
+	}
+	server.Storage = metrics.InstrumentStorage(storageHandler, server.Metrics)
+	server.RequestTimeout = cfg.Storage.Timeout
+	// dispatcher has no requests, so each of its calls is limited instead
+	webhookStorage := storage.WithTimeout(server.Storage, cfg.Storage.Timeout)
+	server.Webhooks, err = NewWebhookDispatcher(&cfg.Webhooks, webhookStorage, logger)
 	if err != nil {
-		logger.Fatal("Can not create database: ", err)
+		logger.Fatal(err)
+	}
+	server.IdempotencyWindow = cfg.Idempotency.Window
+	server.RateLimits = NewRateLimits(&cfg.RateLimit)
+
+	// workers are stopped after servers, so requests finishing during shutdown can still use them
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	runWorker := func(run func(context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(workersCtx)
+		}()
 	}
-	defer db.Close()
-	if err := db.Ping(); err != nil {
-		logger.Fatal("Can not to connect to database: ", err)
+	if server.Webhooks != nil {
+		runWorker(server.Webhooks.Run)
 	}
+	runWorker(server.RunIdempotencyCleanup)
 
-	dbStorage := storage.SqlStorage{DB: db}
-	dbStorage.Setup()
-	server := NewServer(dbStorage, logger, false)
+	serveErrors := make(chan error, 2)
+	var grpcServer *grpc.Server
+	if cfg.Server.GRPCPort != "" {
+		listener, err := net.Listen("tcp", ":"+cfg.Server.GRPCPort)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		grpcServer = NewGRPCServer(server)
+		go func() {
+			serveErrors <- grpcServer.Serve(listener)
+		}()
+	}
+	httpServer := NewHTTPServer(&cfg.Server, server)
+	go func() {
+		serveErrors <- httpServer.ListenAndServe()
+	}()
 	logger.Debug("Server started")
 
-	err = http.ListenAndServe(":"+cfg.Server.Port, server)
-	if err != nil {
-		logger.Fatal(err)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case sig := <-signals:
+		logger.WithField("signal", sig).Info("Shutting down")
+	case err := <-serveErrors:
+		logger.WithField("error", err).Error("Server failed, shutting down")
 	}
+	shutdown(cfg.Server.ShutdownTimeout, server, httpServer, grpcServer, logger)
+	stopWorkers()
+	workers.Wait()
+	// storage is closed by deferred closeStorage when nothing uses it anymore
+	logger.Info("Server stopped")
 }
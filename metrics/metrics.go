@@ -0,0 +1,218 @@
+// Package metrics collects Prometheus metrics of server: HTTP requests, gRPC calls, storage calls,
+// database connection pool, event subscribers and created entities.
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+const namespace = "chat"
+
+// Metrics owns its registry, so every server (and every test) has independent metrics.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	grpcCalls       *prometheus.CounterVec
+	grpcDuration    *prometheus.HistogramVec
+	storageDuration *prometheus.HistogramVec
+	storageErrors   *prometheus.CounterVec
+	usersCreated    prometheus.Counter
+	chatsCreated    prometheus.Counter
+	messagesCreated prometheus.Counter
+}
+
+// New creates metrics and registers them together with Go runtime and process collectors.
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by route, method and status.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests by route, method and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		grpcCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "grpc_calls_total",
+			Help:      "Number of gRPC calls by method and status code.",
+		}, []string{"method", "code"}),
+		grpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "grpc_call_duration_seconds",
+			Help:      "Latency of gRPC calls by method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "code"}),
+		storageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "storage_call_duration_seconds",
+			Help:      "Latency of storage calls by method.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 9),
+		}, []string{"method"}),
+		storageErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "storage_call_errors_total",
+			Help:      "Number of storage calls that returned error, by method.",
+		}, []string{"method"}),
+		usersCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "users_created_total",
+			Help:      "Number of created users.",
+		}),
+		chatsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "chats_created_total",
+			Help:      "Number of created chats.",
+		}),
+		messagesCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_created_total",
+			Help:      "Number of messages sent by users.",
+		}),
+	}
+	m.Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.grpcCalls,
+		m.grpcDuration,
+		m.storageDuration,
+		m.storageErrors,
+		m.usersCreated,
+		m.chatsCreated,
+		m.messagesCreated,
+	)
+	return m
+}
+
+// Handler serves metrics in Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records finished HTTP request. Route is path template, not actual path,
+// so that number of label values stays bounded.
+func (m *Metrics) ObserveRequest(route string, method string, status int, duration time.Duration) {
+	labels := prometheus.Labels{"route": route, "method": method, "status": strconv.Itoa(status)}
+	m.requests.With(labels).Inc()
+	m.requestDuration.With(labels).Observe(duration.Seconds())
+}
+
+// ObserveGRPCCall records finished gRPC call. Method is full name of method, code is name of status code.
+func (m *Metrics) ObserveGRPCCall(method string, code string, duration time.Duration) {
+	labels := prometheus.Labels{"method": method, "code": code}
+	m.grpcCalls.With(labels).Inc()
+	m.grpcDuration.With(labels).Observe(duration.Seconds())
+}
+
+// slowStorageCall is duration of storage call after which it is logged as slow.
+const slowStorageCall = 500 * time.Millisecond
+
+// observeStorageCall records finished storage call. Failed and slow calls are also logged with logger
+// of request from ctx, so they can be matched to request id. Calls made outside of requests aren't logged.
+func (m *Metrics) observeStorageCall(ctx context.Context, method string, start time.Time, err error) {
+	duration := time.Since(start)
+	m.storageDuration.WithLabelValues(method).Observe(duration.Seconds())
+	if err != nil {
+		m.storageErrors.WithLabelValues(method).Inc()
+	}
+	logger := storage.LoggerFromContext(ctx)
+	if logger == nil {
+		return
+	}
+	logger = logger.WithFields(logrus.Fields{
+		"storage_method": method,
+		"duration_ms":    float64(duration.Microseconds()) / 1000,
+	})
+	switch {
+	case err != nil:
+		logger.WithField("error", err).Warn("Storage call failed")
+	case duration >= slowStorageCall:
+		logger.Warn("Storage call is slow")
+	}
+}
+
+// RegisterSubscribers exports number of active event subscriptions returned by count.
+func (m *Metrics) RegisterSubscribers(count func() int) {
+	m.Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "event_subscribers",
+		Help:      "Number of active WebSocket, SSE and gRPC event subscriptions.",
+	}, func() float64 { return float64(count()) }))
+}
+
+// RegisterDB exports statistics of connection pool of db.
+func (m *Metrics) RegisterDB(db *sql.DB) {
+	m.Registry.MustRegister(newDBStatsCollector(db))
+}
+
+// dbStatsCollector reads sql.DBStats on every scrape.
+type dbStatsCollector struct {
+	db *sql.DB
+
+	maxOpen           *prometheus.Desc
+	open              *prometheus.Desc
+	inUse             *prometheus.Desc
+	idle              *prometheus.Desc
+	waitCount         *prometheus.Desc
+	waitDuration      *prometheus.Desc
+	maxIdleClosed     *prometheus.Desc
+	maxLifetimeClosed *prometheus.Desc
+}
+
+func newDBStatsCollector(db *sql.DB) *dbStatsCollector {
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db", name), help, nil, nil)
+	}
+	return &dbStatsCollector{
+		db:                db,
+		maxOpen:           desc("max_open_connections", "Maximum number of open connections, 0 is unlimited."),
+		open:              desc("open_connections", "Number of established connections, both in use and idle."),
+		inUse:             desc("in_use_connections", "Number of connections currently in use."),
+		idle:              desc("idle_connections", "Number of idle connections."),
+		waitCount:         desc("wait_count_total", "Number of times connection was waited for."),
+		waitDuration:      desc("wait_duration_seconds_total", "Total time blocked waiting for connection."),
+		maxIdleClosed:     desc("max_idle_closed_total", "Number of connections closed due to SetMaxIdleConns."),
+		maxLifetimeClosed: desc("max_lifetime_closed_total", "Number of connections closed due to SetConnMaxLifetime."),
+	}
+}
+
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxOpen
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.maxIdleClosed
+	ch <- c.maxLifetimeClosed
+}
+
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.db.Stats()
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.maxIdleClosed, prometheus.CounterValue, float64(stats.MaxIdleClosed))
+	ch <- prometheus.MustNewConstMetric(c.maxLifetimeClosed, prometheus.CounterValue, float64(stats.MaxLifetimeClosed))
+}
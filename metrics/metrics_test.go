@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	logrusTest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// family returns gathered metric family by its name, nil if there is no such family.
+func family(t *testing.T, m *Metrics, name string) *dto.MetricFamily {
+	families, err := m.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			return family
+		}
+	}
+	return nil
+}
+
+// storageCallCount returns number of storage calls of method observed by latency histogram.
+func storageCallCount(t *testing.T, m *Metrics, method string) uint64 {
+	durations := family(t, m, "chat_storage_call_duration_seconds")
+	if durations == nil {
+		return 0
+	}
+	for _, metric := range durations.Metric {
+		if metric.Label[0].GetValue() == method {
+			return metric.Histogram.GetSampleCount()
+		}
+	}
+	return 0
+}
+
+func TestInstrumentStorage(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+	mockStorage := &mocks.Storage{}
+	instrumented := InstrumentStorage(mockStorage, m)
+
+	mockStorage.On("AddUser", testifyMock.Anything, "user_1").Return(int64(1), nil).Once()
+	mockStorage.On("AddUser", testifyMock.Anything, "user_2").Return(int64(0), errors.New("disk is full")).Once()
+	mockStorage.On("IsUserInChat", testifyMock.Anything, int64(1), int64(10)).Return(true, nil).Once()
+	mockStorage.On("AddMessage", testifyMock.Anything, int64(1), int64(10), "Hello").Return(int64(5), nil).Once()
+
+	id, err := instrumented.AddUser(ctx, "user_1")
+	assert.Equal(t, int64(1), id)
+	assert.NoError(t, err)
+	_, err = instrumented.AddUser(ctx, "user_2")
+	assert.Error(t, err)
+	isInChat, err := instrumented.IsUserInChat(ctx, 1, 10)
+	assert.True(t, isInChat)
+	assert.NoError(t, err)
+	instrumented.AddMessage(ctx, 1, 10, "Hello")
+
+	assert.Equal(t, uint64(2), storageCallCount(t, m, "AddUser"))
+	assert.Equal(t, uint64(1), storageCallCount(t, m, "IsUserInChat"))
+	assert.Equal(t, uint64(0), storageCallCount(t, m, "AddChat"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.storageErrors.WithLabelValues("AddUser")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(m.storageErrors.WithLabelValues("IsUserInChat")))
+	// failed creation isn't counted
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.usersCreated))
+	assert.Equal(t, float64(0), testutil.ToFloat64(m.chatsCreated))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.messagesCreated))
+	mockStorage.AssertExpectations(t)
+}
+
+func TestInstrumentStorageCountsUsersWithToken(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+	mockStorage := &mocks.Storage{}
+	instrumented := InstrumentStorage(mockStorage, m)
+
+	mockStorage.On("AddUserWithToken", testifyMock.Anything, "user_1", "hash_1").Return(int64(1), nil).Once()
+	mockStorage.On("AddUserWithToken", testifyMock.Anything, "user_1", "hash_2").
+		Return(int64(0), storage.ErrUserExists).Once()
+
+	id, err := instrumented.AddUserWithToken(ctx, "user_1", "hash_1")
+	assert.Equal(t, int64(1), id)
+	assert.NoError(t, err)
+	_, err = instrumented.AddUserWithToken(ctx, "user_1", "hash_2")
+	assert.Equal(t, storage.ErrUserExists, err)
+
+	assert.Equal(t, uint64(2), storageCallCount(t, m, "AddUserWithToken"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.usersCreated))
+	mockStorage.AssertExpectations(t)
+}
+
+func TestInstrumentStorageLogsFailedCalls(t *testing.T) {
+	logger, hook := logrusTest.NewNullLogger()
+	ctx := storage.WithLogger(context.Background(), logger.WithField("request_id", "request_1"))
+	m := New()
+	mockStorage := &mocks.Storage{}
+	instrumented := InstrumentStorage(mockStorage, m)
+
+	mockStorage.On("IsUserInChat", testifyMock.Anything, int64(1), int64(10)).Return(true, nil).Once()
+	mockStorage.On("GetUserChats", testifyMock.Anything, int64(1)).
+		Return(nil, context.DeadlineExceeded).Once()
+	instrumented.IsUserInChat(ctx, 1, 10)
+	assert.Empty(t, hook.AllEntries())
+	instrumented.GetUserChats(ctx, 1)
+	if entry := hook.LastEntry(); assert.NotNil(t, entry) {
+		assert.Equal(t, "Storage call failed", entry.Message)
+		assert.Equal(t, "request_1", entry.Data["request_id"])
+		assert.Equal(t, "GetUserChats", entry.Data["storage_method"])
+		assert.Equal(t, context.DeadlineExceeded, entry.Data["error"])
+	}
+
+	// calls outside of requests have no logger
+	mockStorage.On("GetUserChats", testifyMock.Anything, int64(2)).
+		Return(nil, context.DeadlineExceeded).Once()
+	instrumented.GetUserChats(context.Background(), 2)
+	assert.Len(t, hook.AllEntries(), 1)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestRegisterDB(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(3)
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New()
+	m.RegisterDB(db)
+	maxOpen := family(t, m, "chat_db_max_open_connections")
+	if assert.NotNil(t, maxOpen) {
+		assert.Equal(t, float64(3), maxOpen.Metric[0].Gauge.GetValue())
+	}
+	open := family(t, m, "chat_db_open_connections")
+	if assert.NotNil(t, open) {
+		assert.Equal(t, float64(1), open.Metric[0].Gauge.GetValue())
+	}
+	assert.NotNil(t, family(t, m, "chat_db_wait_duration_seconds_total"))
+}
+
+func TestRegisterSubscribers(t *testing.T) {
+	m := New()
+	count := 0
+	m.RegisterSubscribers(func() int { return count })
+	count = 3
+	subscribers := family(t, m, "chat_event_subscribers")
+	if assert.NotNil(t, subscribers) {
+		assert.Equal(t, float64(3), subscribers.Metric[0].Gauge.GetValue())
+	}
+}
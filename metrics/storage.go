@@ -0,0 +1,305 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// instrumentedStorage records latency and errors of every call to wrapped storage.
+// Successful creation of users, chats and messages is counted as well. Failed and slow calls are logged.
+type instrumentedStorage struct {
+	storage storage.Storage
+	metrics *Metrics
+}
+
+// InstrumentStorage returns storage that records calls to s in m.
+func InstrumentStorage(s storage.Storage, m *Metrics) storage.Storage {
+	return &instrumentedStorage{storage: s, metrics: m}
+}
+
+func (s *instrumentedStorage) AreUsersExistByIds(ctx context.Context, userIds []int64) (bool, error) {
+	start := time.Now()
+	result, err := s.storage.AreUsersExistByIds(ctx, userIds)
+	s.metrics.observeStorageCall(ctx, "AreUsersExistByIds", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) AddUser(ctx context.Context, username string) (int64, error) {
+	start := time.Now()
+	result, err := s.storage.AddUser(ctx, username)
+	s.metrics.observeStorageCall(ctx, "AddUser", start, err)
+	if err == nil {
+		s.metrics.usersCreated.Inc()
+	}
+	return result, err
+}
+
+func (s *instrumentedStorage) AddUserToken(ctx context.Context, userId int64, tokenHash string) error {
+	start := time.Now()
+	err := s.storage.AddUserToken(ctx, userId, tokenHash)
+	s.metrics.observeStorageCall(ctx, "AddUserToken", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) AddUserWithToken(ctx context.Context, username string, tokenHash string) (int64, error) {
+	start := time.Now()
+	result, err := s.storage.AddUserWithToken(ctx, username, tokenHash)
+	s.metrics.observeStorageCall(ctx, "AddUserWithToken", start, err)
+	if err == nil {
+		s.metrics.usersCreated.Inc()
+	}
+	return result, err
+}
+
+func (s *instrumentedStorage) GetUserIdByToken(ctx context.Context, tokenHash string) (int64, error) {
+	start := time.Now()
+	result, err := s.storage.GetUserIdByToken(ctx, tokenHash)
+	s.metrics.observeStorageCall(ctx, "GetUserIdByToken", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) GetUserChats(ctx context.Context, userId int64) ([]*storage.Chat, error) {
+	start := time.Now()
+	result, err := s.storage.GetUserChats(ctx, userId)
+	s.metrics.observeStorageCall(ctx, "GetUserChats", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) AddChat(ctx context.Context, ownerId int64, chatname string, memberIds []int64) (int64, error) {
+	start := time.Now()
+	result, err := s.storage.AddChat(ctx, ownerId, chatname, memberIds)
+	s.metrics.observeStorageCall(ctx, "AddChat", start, err)
+	if err == nil {
+		s.metrics.chatsCreated.Inc()
+	}
+	return result, err
+}
+
+func (s *instrumentedStorage) RenameChat(ctx context.Context, actorId int64, chatId int64, chatname string) error {
+	start := time.Now()
+	err := s.storage.RenameChat(ctx, actorId, chatId, chatname)
+	s.metrics.observeStorageCall(ctx, "RenameChat", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) SetChatSlowMode(ctx context.Context, actorId int64, chatId int64, seconds int) error {
+	start := time.Now()
+	err := s.storage.SetChatSlowMode(ctx, actorId, chatId, seconds)
+	s.metrics.observeStorageCall(ctx, "SetChatSlowMode", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) IsUserInChat(ctx context.Context, userId int64, chatId int64) (bool, error) {
+	start := time.Now()
+	result, err := s.storage.IsUserInChat(ctx, userId, chatId)
+	s.metrics.observeStorageCall(ctx, "IsUserInChat", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) GetChatUserIds(ctx context.Context, chatId int64) ([]int64, error) {
+	start := time.Now()
+	result, err := s.storage.GetChatUserIds(ctx, chatId)
+	s.metrics.observeStorageCall(ctx, "GetChatUserIds", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) GetChatMembers(ctx context.Context, chatId int64) ([]*storage.ChatMember, error) {
+	start := time.Now()
+	result, err := s.storage.GetChatMembers(ctx, chatId)
+	s.metrics.observeStorageCall(ctx, "GetChatMembers", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) MarkChatRead(ctx context.Context, userId int64, chatId int64, messageId int64) (int64, error) {
+	start := time.Now()
+	result, err := s.storage.MarkChatRead(ctx, userId, chatId, messageId)
+	s.metrics.observeStorageCall(ctx, "MarkChatRead", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) AddChatMember(ctx context.Context, actorId int64, chatId int64, userId int64) (int64, error) {
+	start := time.Now()
+	result, err := s.storage.AddChatMember(ctx, actorId, chatId, userId)
+	s.metrics.observeStorageCall(ctx, "AddChatMember", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) RemoveChatMember(ctx context.Context, actorId int64, chatId int64, userId int64) (int64, error) {
+	start := time.Now()
+	result, err := s.storage.RemoveChatMember(ctx, actorId, chatId, userId)
+	s.metrics.observeStorageCall(ctx, "RemoveChatMember", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) SetChatMemberRole(ctx context.Context, actorId int64, chatId int64, userId int64, role storage.Role) error {
+	start := time.Now()
+	err := s.storage.SetChatMemberRole(ctx, actorId, chatId, userId, role)
+	s.metrics.observeStorageCall(ctx, "SetChatMemberRole", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) TransferChatOwnership(ctx context.Context, ownerId int64, chatId int64, userId int64) error {
+	start := time.Now()
+	err := s.storage.TransferChatOwnership(ctx, ownerId, chatId, userId)
+	s.metrics.observeStorageCall(ctx, "TransferChatOwnership", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) AddMessage(ctx context.Context, authorId int64, chatId int64, text string) (int64, error) {
+	start := time.Now()
+	result, err := s.storage.AddMessage(ctx, authorId, chatId, text)
+	s.metrics.observeStorageCall(ctx, "AddMessage", start, err)
+	if err == nil {
+		s.metrics.messagesCreated.Inc()
+	}
+	return result, err
+}
+
+func (s *instrumentedStorage) GetMessage(ctx context.Context, messageId int64) (*storage.Message, error) {
+	start := time.Now()
+	result, err := s.storage.GetMessage(ctx, messageId)
+	s.metrics.observeStorageCall(ctx, "GetMessage", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) GetMessagesFromChat(ctx context.Context, chatId int64) ([]*storage.Message, error) {
+	start := time.Now()
+	result, err := s.storage.GetMessagesFromChat(ctx, chatId)
+	s.metrics.observeStorageCall(ctx, "GetMessagesFromChat", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) GetMessagesPage(ctx context.Context, chatId int64, query storage.PageQuery) ([]*storage.Message, error) {
+	start := time.Now()
+	result, err := s.storage.GetMessagesPage(ctx, chatId, query)
+	s.metrics.observeStorageCall(ctx, "GetMessagesPage", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) EditMessage(ctx context.Context, authorId int64, messageId int64, text string) error {
+	start := time.Now()
+	err := s.storage.EditMessage(ctx, authorId, messageId, text)
+	s.metrics.observeStorageCall(ctx, "EditMessage", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) DeleteMessage(ctx context.Context, actorId int64, messageId int64) error {
+	start := time.Now()
+	err := s.storage.DeleteMessage(ctx, actorId, messageId)
+	s.metrics.observeStorageCall(ctx, "DeleteMessage", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) PinMessage(ctx context.Context, actorId int64, messageId int64, pinned bool) error {
+	start := time.Now()
+	err := s.storage.PinMessage(ctx, actorId, messageId, pinned)
+	s.metrics.observeStorageCall(ctx, "PinMessage", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) GetMessageEdits(ctx context.Context, messageId int64) ([]*storage.MessageEdit, error) {
+	start := time.Now()
+	result, err := s.storage.GetMessageEdits(ctx, messageId)
+	s.metrics.observeStorageCall(ctx, "GetMessageEdits", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) GetMessageReaders(ctx context.Context, messageId int64) ([]int64, error) {
+	start := time.Now()
+	result, err := s.storage.GetMessageReaders(ctx, messageId)
+	s.metrics.observeStorageCall(ctx, "GetMessageReaders", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) SearchMessages(ctx context.Context, userId int64, query storage.SearchQuery) ([]*storage.SearchResult, error) {
+	start := time.Now()
+	result, err := s.storage.SearchMessages(ctx, userId, query)
+	s.metrics.observeStorageCall(ctx, "SearchMessages", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) AddWebhook(ctx context.Context, userId int64, chatId int64, url string, secret string, events []string) (int64, error) {
+	start := time.Now()
+	result, err := s.storage.AddWebhook(ctx, userId, chatId, url, secret, events)
+	s.metrics.observeStorageCall(ctx, "AddWebhook", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) GetUserWebhooks(ctx context.Context, userId int64) ([]*storage.Webhook, error) {
+	start := time.Now()
+	result, err := s.storage.GetUserWebhooks(ctx, userId)
+	s.metrics.observeStorageCall(ctx, "GetUserWebhooks", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) DeleteWebhook(ctx context.Context, userId int64, webhookId int64) error {
+	start := time.Now()
+	err := s.storage.DeleteWebhook(ctx, userId, webhookId)
+	s.metrics.observeStorageCall(ctx, "DeleteWebhook", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) GetChatWebhooks(ctx context.Context, chatId int64, event string) ([]*storage.Webhook, error) {
+	start := time.Now()
+	result, err := s.storage.GetChatWebhooks(ctx, chatId, event)
+	s.metrics.observeStorageCall(ctx, "GetChatWebhooks", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) AddWebhookDelivery(ctx context.Context, webhookId int64, event string, payload []byte) (int64, error) {
+	start := time.Now()
+	result, err := s.storage.AddWebhookDelivery(ctx, webhookId, event, payload)
+	s.metrics.observeStorageCall(ctx, "AddWebhookDelivery", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) ClaimDueWebhookDeliveries(ctx context.Context, now time.Time, claimedUntil time.Time, limit int) ([]*storage.WebhookDelivery, error) {
+	start := time.Now()
+	result, err := s.storage.ClaimDueWebhookDeliveries(ctx, now, claimedUntil, limit)
+	s.metrics.observeStorageCall(ctx, "ClaimDueWebhookDeliveries", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) AddWebhookAttempt(ctx context.Context, attempt *storage.WebhookAttempt, status string, nextAttemptAt time.Time) error {
+	start := time.Now()
+	err := s.storage.AddWebhookAttempt(ctx, attempt, status, nextAttemptAt)
+	s.metrics.observeStorageCall(ctx, "AddWebhookAttempt", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) GetWebhookDeliveries(ctx context.Context, userId int64, webhookId int64, status string, limit int) ([]*storage.WebhookDelivery, error) {
+	start := time.Now()
+	result, err := s.storage.GetWebhookDeliveries(ctx, userId, webhookId, status, limit)
+	s.metrics.observeStorageCall(ctx, "GetWebhookDeliveries", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) ReserveIdempotencyKey(ctx context.Context, key *storage.IdempotencyKey, expiredBefore time.Time, abandonedBefore time.Time) (*storage.IdempotencyKey, error) {
+	start := time.Now()
+	result, err := s.storage.ReserveIdempotencyKey(ctx, key, expiredBefore, abandonedBefore)
+	s.metrics.observeStorageCall(ctx, "ReserveIdempotencyKey", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) CompleteIdempotencyKey(ctx context.Context, key *storage.IdempotencyKey) error {
+	start := time.Now()
+	err := s.storage.CompleteIdempotencyKey(ctx, key)
+	s.metrics.observeStorageCall(ctx, "CompleteIdempotencyKey", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) ReleaseIdempotencyKey(ctx context.Context, key *storage.IdempotencyKey) error {
+	start := time.Now()
+	err := s.storage.ReleaseIdempotencyKey(ctx, key)
+	s.metrics.observeStorageCall(ctx, "ReleaseIdempotencyKey", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	start := time.Now()
+	result, err := s.storage.DeleteExpiredIdempotencyKeys(ctx, expiredBefore)
+	s.metrics.observeStorageCall(ctx, "DeleteExpiredIdempotencyKeys", start, err)
+	return result, err
+}
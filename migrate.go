@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+const migrateUsage = `usage: main migrate <command> [steps]
+commands:
+    status       print applied and pending migrations
+    up [steps]   apply pending migrations (all by default)
+    down [steps] revert applied migrations (one by default)`
+
+// runMigrate executes migrate subcommand with specified args (without "migrate" itself).
+func runMigrate(migrator storage.Migrator, args []string, out io.Writer) error {
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("%s", migrateUsage)
+	}
+	steps := 0
+	if len(args) == 2 {
+		var err error
+		steps, err = strconv.Atoi(args[1])
+		if err != nil || steps <= 0 {
+			return fmt.Errorf("steps must be positive number, got %q", args[1])
+		}
+	}
+	switch args[0] {
+	case "status":
+		return printMigrationStatus(migrator, out)
+	case "up":
+		applied, err := migrator.Up(steps)
+		fmt.Fprintf(out, "applied %d migration(s)\n", applied)
+		return err
+	case "down":
+		if steps == 0 {
+			steps = 1
+		}
+		reverted, err := migrator.Down(steps)
+		fmt.Fprintf(out, "reverted %d migration(s)\n", reverted)
+		return err
+	}
+	return fmt.Errorf("unknown migrate command %q\n%s", args[0], migrateUsage)
+}
+
+func printMigrationStatus(migrator storage.Migrator, out io.Writer) error {
+	version, err := migrator.Version()
+	if err != nil {
+		return err
+	}
+	states, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "database version: %d, latest version: %d\n", version, migrator.Latest())
+	for _, state := range states {
+		status := "pending"
+		if state.Applied {
+			status = "applied at " + state.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(out, "%4d %-30s %s\n", state.Version, state.Name, status)
+	}
+	if version > migrator.Latest() {
+		return storage.ErrSchemaTooNew
+	}
+	return nil
+}
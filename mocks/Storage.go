@@ -3,8 +3,10 @@
 package mocks
 
 import (
+	context "context"
 	storage "github.com/Darkclainer/avito_exercise/storage"
 	mock "github.com/stretchr/testify/mock"
+	time "time"
 )
 
 // Storage is an autogenerated mock type for the Storage type
@@ -12,20 +14,20 @@ type Storage struct {
 	mock.Mock
 }
 
-// AddChat provides a mock function with given fields: chatname, userIds
-func (_m *Storage) AddChat(chatname string, userIds []int64) (int64, error) {
-	ret := _m.Called(chatname, userIds)
+// AddChat provides a mock function with given fields: ctx, ownerId, chatname, memberIds
+func (_m *Storage) AddChat(ctx context.Context, ownerId int64, chatname string, memberIds []int64) (int64, error) {
+	ret := _m.Called(ctx, ownerId, chatname, memberIds)
 
 	var r0 int64
-	if rf, ok := ret.Get(0).(func(string, []int64) int64); ok {
-		r0 = rf(chatname, userIds)
+	if rf, ok := ret.Get(0).(func(context.Context, int64, string, []int64) int64); ok {
+		r0 = rf(ctx, ownerId, chatname, memberIds)
 	} else {
 		r0 = ret.Get(0).(int64)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, []int64) error); ok {
-		r1 = rf(chatname, userIds)
+	if rf, ok := ret.Get(1).(func(context.Context, int64, string, []int64) error); ok {
+		r1 = rf(ctx, ownerId, chatname, memberIds)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -33,20 +35,20 @@ func (_m *Storage) AddChat(chatname string, userIds []int64) (int64, error) {
 	return r0, r1
 }
 
-// AddMessage provides a mock function with given fields: chatId, authorId, text
-func (_m *Storage) AddMessage(chatId int64, authorId int64, text string) (int64, error) {
-	ret := _m.Called(chatId, authorId, text)
+// AddChatMember provides a mock function with given fields: ctx, actorId, chatId, userId
+func (_m *Storage) AddChatMember(ctx context.Context, actorId int64, chatId int64, userId int64) (int64, error) {
+	ret := _m.Called(ctx, actorId, chatId, userId)
 
 	var r0 int64
-	if rf, ok := ret.Get(0).(func(int64, int64, string) int64); ok {
-		r0 = rf(chatId, authorId, text)
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64, int64) int64); ok {
+		r0 = rf(ctx, actorId, chatId, userId)
 	} else {
 		r0 = ret.Get(0).(int64)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(int64, int64, string) error); ok {
-		r1 = rf(chatId, authorId, text)
+	if rf, ok := ret.Get(1).(func(context.Context, int64, int64, int64) error); ok {
+		r1 = rf(ctx, actorId, chatId, userId)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -54,20 +56,20 @@ func (_m *Storage) AddMessage(chatId int64, authorId int64, text string) (int64,
 	return r0, r1
 }
 
-// AddUser provides a mock function with given fields: username
-func (_m *Storage) AddUser(username string) (int64, error) {
-	ret := _m.Called(username)
+// AddMessage provides a mock function with given fields: ctx, authorId, chatId, text
+func (_m *Storage) AddMessage(ctx context.Context, authorId int64, chatId int64, text string) (int64, error) {
+	ret := _m.Called(ctx, authorId, chatId, text)
 
 	var r0 int64
-	if rf, ok := ret.Get(0).(func(string) int64); ok {
-		r0 = rf(username)
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64, string) int64); ok {
+		r0 = rf(ctx, authorId, chatId, text)
 	} else {
 		r0 = ret.Get(0).(int64)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(username)
+	if rf, ok := ret.Get(1).(func(context.Context, int64, int64, string) error); ok {
+		r1 = rf(ctx, authorId, chatId, text)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -75,20 +77,393 @@ func (_m *Storage) AddUser(username string) (int64, error) {
 	return r0, r1
 }
 
-// AreUsersExistByIds provides a mock function with given fields: userIds
-func (_m *Storage) AreUsersExistByIds(userIds []int64) (bool, error) {
-	ret := _m.Called(userIds)
+// AddUser provides a mock function with given fields: ctx, username
+func (_m *Storage) AddUser(ctx context.Context, username string) (int64, error) {
+	ret := _m.Called(ctx, username)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, string) int64); ok {
+		r0 = rf(ctx, username)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, username)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AddUserToken provides a mock function with given fields: ctx, userId, tokenHash
+func (_m *Storage) AddUserToken(ctx context.Context, userId int64, tokenHash string) error {
+	ret := _m.Called(ctx, userId, tokenHash)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, string) error); ok {
+		r0 = rf(ctx, userId, tokenHash)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// AddUserWithToken provides a mock function with given fields: ctx, username, tokenHash
+func (_m *Storage) AddUserWithToken(ctx context.Context, username string, tokenHash string) (int64, error) {
+	ret := _m.Called(ctx, username, tokenHash)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) int64); ok {
+		r0 = rf(ctx, username, tokenHash)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, username, tokenHash)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AddWebhook provides a mock function with given fields: ctx, userId, chatId, url, secret, events
+func (_m *Storage) AddWebhook(ctx context.Context, userId int64, chatId int64, url string, secret string, events []string) (int64, error) {
+	ret := _m.Called(ctx, userId, chatId, url, secret, events)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64, string, string, []string) int64); ok {
+		r0 = rf(ctx, userId, chatId, url, secret, events)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int64, int64, string, string, []string) error); ok {
+		r1 = rf(ctx, userId, chatId, url, secret, events)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AddWebhookAttempt provides a mock function with given fields: ctx, attempt, status, nextAttemptAt
+func (_m *Storage) AddWebhookAttempt(ctx context.Context, attempt *storage.WebhookAttempt, status string, nextAttemptAt time.Time) error {
+	ret := _m.Called(ctx, attempt, status, nextAttemptAt)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *storage.WebhookAttempt, string, time.Time) error); ok {
+		r0 = rf(ctx, attempt, status, nextAttemptAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// AddWebhookDelivery provides a mock function with given fields: ctx, webhookId, event, payload
+func (_m *Storage) AddWebhookDelivery(ctx context.Context, webhookId int64, event string, payload []byte) (int64, error) {
+	ret := _m.Called(ctx, webhookId, event, payload)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, int64, string, []byte) int64); ok {
+		r0 = rf(ctx, webhookId, event, payload)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int64, string, []byte) error); ok {
+		r1 = rf(ctx, webhookId, event, payload)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AreUsersExistByIds provides a mock function with given fields: ctx, userIds
+func (_m *Storage) AreUsersExistByIds(ctx context.Context, userIds []int64) (bool, error) {
+	ret := _m.Called(ctx, userIds)
 
 	var r0 bool
-	if rf, ok := ret.Get(0).(func([]int64) bool); ok {
-		r0 = rf(userIds)
+	if rf, ok := ret.Get(0).(func(context.Context, []int64) bool); ok {
+		r0 = rf(ctx, userIds)
 	} else {
 		r0 = ret.Get(0).(bool)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func([]int64) error); ok {
-		r1 = rf(userIds)
+	if rf, ok := ret.Get(1).(func(context.Context, []int64) error); ok {
+		r1 = rf(ctx, userIds)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ClaimDueWebhookDeliveries provides a mock function with given fields: ctx, now, claimedUntil, limit
+func (_m *Storage) ClaimDueWebhookDeliveries(ctx context.Context, now time.Time, claimedUntil time.Time, limit int) ([]*storage.WebhookDelivery, error) {
+	ret := _m.Called(ctx, now, claimedUntil, limit)
+
+	var r0 []*storage.WebhookDelivery
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, time.Time, int) []*storage.WebhookDelivery); ok {
+		r0 = rf(ctx, now, claimedUntil, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*storage.WebhookDelivery)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time, time.Time, int) error); ok {
+		r1 = rf(ctx, now, claimedUntil, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CompleteIdempotencyKey provides a mock function with given fields: ctx, key
+func (_m *Storage) CompleteIdempotencyKey(ctx context.Context, key *storage.IdempotencyKey) error {
+	ret := _m.Called(ctx, key)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *storage.IdempotencyKey) error); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteExpiredIdempotencyKeys provides a mock function with given fields: ctx, expiredBefore
+func (_m *Storage) DeleteExpiredIdempotencyKeys(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	ret := _m.Called(ctx, expiredBefore)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) int64); ok {
+		r0 = rf(ctx, expiredBefore)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(ctx, expiredBefore)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteMessage provides a mock function with given fields: ctx, actorId, messageId
+func (_m *Storage) DeleteMessage(ctx context.Context, actorId int64, messageId int64) error {
+	ret := _m.Called(ctx, actorId, messageId)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64) error); ok {
+		r0 = rf(ctx, actorId, messageId)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteWebhook provides a mock function with given fields: ctx, userId, webhookId
+func (_m *Storage) DeleteWebhook(ctx context.Context, userId int64, webhookId int64) error {
+	ret := _m.Called(ctx, userId, webhookId)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64) error); ok {
+		r0 = rf(ctx, userId, webhookId)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// EditMessage provides a mock function with given fields: ctx, authorId, messageId, text
+func (_m *Storage) EditMessage(ctx context.Context, authorId int64, messageId int64, text string) error {
+	ret := _m.Called(ctx, authorId, messageId, text)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64, string) error); ok {
+		r0 = rf(ctx, authorId, messageId, text)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetChatMembers provides a mock function with given fields: ctx, chatId
+func (_m *Storage) GetChatMembers(ctx context.Context, chatId int64) ([]*storage.ChatMember, error) {
+	ret := _m.Called(ctx, chatId)
+
+	var r0 []*storage.ChatMember
+	if rf, ok := ret.Get(0).(func(context.Context, int64) []*storage.ChatMember); ok {
+		r0 = rf(ctx, chatId)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*storage.ChatMember)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, chatId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetChatUserIds provides a mock function with given fields: ctx, chatId
+func (_m *Storage) GetChatUserIds(ctx context.Context, chatId int64) ([]int64, error) {
+	ret := _m.Called(ctx, chatId)
+
+	var r0 []int64
+	if rf, ok := ret.Get(0).(func(context.Context, int64) []int64); ok {
+		r0 = rf(ctx, chatId)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]int64)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, chatId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetChatWebhooks provides a mock function with given fields: ctx, chatId, event
+func (_m *Storage) GetChatWebhooks(ctx context.Context, chatId int64, event string) ([]*storage.Webhook, error) {
+	ret := _m.Called(ctx, chatId, event)
+
+	var r0 []*storage.Webhook
+	if rf, ok := ret.Get(0).(func(context.Context, int64, string) []*storage.Webhook); ok {
+		r0 = rf(ctx, chatId, event)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*storage.Webhook)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int64, string) error); ok {
+		r1 = rf(ctx, chatId, event)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetMessage provides a mock function with given fields: ctx, messageId
+func (_m *Storage) GetMessage(ctx context.Context, messageId int64) (*storage.Message, error) {
+	ret := _m.Called(ctx, messageId)
+
+	var r0 *storage.Message
+	if rf, ok := ret.Get(0).(func(context.Context, int64) *storage.Message); ok {
+		r0 = rf(ctx, messageId)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*storage.Message)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, messageId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetMessageEdits provides a mock function with given fields: ctx, messageId
+func (_m *Storage) GetMessageEdits(ctx context.Context, messageId int64) ([]*storage.MessageEdit, error) {
+	ret := _m.Called(ctx, messageId)
+
+	var r0 []*storage.MessageEdit
+	if rf, ok := ret.Get(0).(func(context.Context, int64) []*storage.MessageEdit); ok {
+		r0 = rf(ctx, messageId)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*storage.MessageEdit)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, messageId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetMessageReaders provides a mock function with given fields: ctx, messageId
+func (_m *Storage) GetMessageReaders(ctx context.Context, messageId int64) ([]int64, error) {
+	ret := _m.Called(ctx, messageId)
+
+	var r0 []int64
+	if rf, ok := ret.Get(0).(func(context.Context, int64) []int64); ok {
+		r0 = rf(ctx, messageId)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]int64)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, messageId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetMessagesFromChat provides a mock function with given fields: ctx, chatId
+func (_m *Storage) GetMessagesFromChat(ctx context.Context, chatId int64) ([]*storage.Message, error) {
+	ret := _m.Called(ctx, chatId)
+
+	var r0 []*storage.Message
+	if rf, ok := ret.Get(0).(func(context.Context, int64) []*storage.Message); ok {
+		r0 = rf(ctx, chatId)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*storage.Message)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, chatId)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -96,13 +471,13 @@ func (_m *Storage) AreUsersExistByIds(userIds []int64) (bool, error) {
 	return r0, r1
 }
 
-// GetMessagesFromChat provides a mock function with given fields: chatId
-func (_m *Storage) GetMessagesFromChat(chatId int64) ([]*storage.Message, error) {
-	ret := _m.Called(chatId)
+// GetMessagesPage provides a mock function with given fields: ctx, chatId, query
+func (_m *Storage) GetMessagesPage(ctx context.Context, chatId int64, query storage.PageQuery) ([]*storage.Message, error) {
+	ret := _m.Called(ctx, chatId, query)
 
 	var r0 []*storage.Message
-	if rf, ok := ret.Get(0).(func(int64) []*storage.Message); ok {
-		r0 = rf(chatId)
+	if rf, ok := ret.Get(0).(func(context.Context, int64, storage.PageQuery) []*storage.Message); ok {
+		r0 = rf(ctx, chatId, query)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]*storage.Message)
@@ -110,8 +485,8 @@ func (_m *Storage) GetMessagesFromChat(chatId int64) ([]*storage.Message, error)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(int64) error); ok {
-		r1 = rf(chatId)
+	if rf, ok := ret.Get(1).(func(context.Context, int64, storage.PageQuery) error); ok {
+		r1 = rf(ctx, chatId, query)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -119,13 +494,13 @@ func (_m *Storage) GetMessagesFromChat(chatId int64) ([]*storage.Message, error)
 	return r0, r1
 }
 
-// GetUserChats provides a mock function with given fields: userId
-func (_m *Storage) GetUserChats(userId int64) ([]*storage.Chat, error) {
-	ret := _m.Called(userId)
+// GetUserChats provides a mock function with given fields: ctx, userId
+func (_m *Storage) GetUserChats(ctx context.Context, userId int64) ([]*storage.Chat, error) {
+	ret := _m.Called(ctx, userId)
 
 	var r0 []*storage.Chat
-	if rf, ok := ret.Get(0).(func(int64) []*storage.Chat); ok {
-		r0 = rf(userId)
+	if rf, ok := ret.Get(0).(func(context.Context, int64) []*storage.Chat); ok {
+		r0 = rf(ctx, userId)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]*storage.Chat)
@@ -133,8 +508,8 @@ func (_m *Storage) GetUserChats(userId int64) ([]*storage.Chat, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(int64) error); ok {
-		r1 = rf(userId)
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, userId)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -142,20 +517,20 @@ func (_m *Storage) GetUserChats(userId int64) ([]*storage.Chat, error) {
 	return r0, r1
 }
 
-// IsChatExists provides a mock function with given fields: chatname
-func (_m *Storage) IsChatExists(chatname string) (bool, error) {
-	ret := _m.Called(chatname)
+// GetUserIdByToken provides a mock function with given fields: ctx, tokenHash
+func (_m *Storage) GetUserIdByToken(ctx context.Context, tokenHash string) (int64, error) {
+	ret := _m.Called(ctx, tokenHash)
 
-	var r0 bool
-	if rf, ok := ret.Get(0).(func(string) bool); ok {
-		r0 = rf(chatname)
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, string) int64); ok {
+		r0 = rf(ctx, tokenHash)
 	} else {
-		r0 = ret.Get(0).(bool)
+		r0 = ret.Get(0).(int64)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(chatname)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, tokenHash)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -163,20 +538,45 @@ func (_m *Storage) IsChatExists(chatname string) (bool, error) {
 	return r0, r1
 }
 
-// IsUserExists provides a mock function with given fields: username
-func (_m *Storage) IsUserExists(username string) (bool, error) {
-	ret := _m.Called(username)
+// GetUserWebhooks provides a mock function with given fields: ctx, userId
+func (_m *Storage) GetUserWebhooks(ctx context.Context, userId int64) ([]*storage.Webhook, error) {
+	ret := _m.Called(ctx, userId)
 
-	var r0 bool
-	if rf, ok := ret.Get(0).(func(string) bool); ok {
-		r0 = rf(username)
+	var r0 []*storage.Webhook
+	if rf, ok := ret.Get(0).(func(context.Context, int64) []*storage.Webhook); ok {
+		r0 = rf(ctx, userId)
 	} else {
-		r0 = ret.Get(0).(bool)
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*storage.Webhook)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, userId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetWebhookDeliveries provides a mock function with given fields: ctx, userId, webhookId, status, limit
+func (_m *Storage) GetWebhookDeliveries(ctx context.Context, userId int64, webhookId int64, status string, limit int) ([]*storage.WebhookDelivery, error) {
+	ret := _m.Called(ctx, userId, webhookId, status, limit)
+
+	var r0 []*storage.WebhookDelivery
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64, string, int) []*storage.WebhookDelivery); ok {
+		r0 = rf(ctx, userId, webhookId, status, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*storage.WebhookDelivery)
+		}
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(username)
+	if rf, ok := ret.Get(1).(func(context.Context, int64, int64, string, int) error); ok {
+		r1 = rf(ctx, userId, webhookId, status, limit)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -184,23 +584,195 @@ func (_m *Storage) IsUserExists(username string) (bool, error) {
 	return r0, r1
 }
 
-// IsUserInChat provides a mock function with given fields: userId, chatId
-func (_m *Storage) IsUserInChat(userId int64, chatId int64) (bool, error) {
-	ret := _m.Called(userId, chatId)
+// IsUserInChat provides a mock function with given fields: ctx, userId, chatId
+func (_m *Storage) IsUserInChat(ctx context.Context, userId int64, chatId int64) (bool, error) {
+	ret := _m.Called(ctx, userId, chatId)
 
 	var r0 bool
-	if rf, ok := ret.Get(0).(func(int64, int64) bool); ok {
-		r0 = rf(userId, chatId)
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64) bool); ok {
+		r0 = rf(ctx, userId, chatId)
 	} else {
 		r0 = ret.Get(0).(bool)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(int64, int64) error); ok {
-		r1 = rf(userId, chatId)
+	if rf, ok := ret.Get(1).(func(context.Context, int64, int64) error); ok {
+		r1 = rf(ctx, userId, chatId)
 	} else {
 		r1 = ret.Error(1)
 	}
 
 	return r0, r1
 }
+
+// MarkChatRead provides a mock function with given fields: ctx, userId, chatId, messageId
+func (_m *Storage) MarkChatRead(ctx context.Context, userId int64, chatId int64, messageId int64) (int64, error) {
+	ret := _m.Called(ctx, userId, chatId, messageId)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64, int64) int64); ok {
+		r0 = rf(ctx, userId, chatId, messageId)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int64, int64, int64) error); ok {
+		r1 = rf(ctx, userId, chatId, messageId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PinMessage provides a mock function with given fields: ctx, actorId, messageId, pinned
+func (_m *Storage) PinMessage(ctx context.Context, actorId int64, messageId int64, pinned bool) error {
+	ret := _m.Called(ctx, actorId, messageId, pinned)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64, bool) error); ok {
+		r0 = rf(ctx, actorId, messageId, pinned)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ReleaseIdempotencyKey provides a mock function with given fields: ctx, key
+func (_m *Storage) ReleaseIdempotencyKey(ctx context.Context, key *storage.IdempotencyKey) error {
+	ret := _m.Called(ctx, key)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *storage.IdempotencyKey) error); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RemoveChatMember provides a mock function with given fields: ctx, actorId, chatId, userId
+func (_m *Storage) RemoveChatMember(ctx context.Context, actorId int64, chatId int64, userId int64) (int64, error) {
+	ret := _m.Called(ctx, actorId, chatId, userId)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64, int64) int64); ok {
+		r0 = rf(ctx, actorId, chatId, userId)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int64, int64, int64) error); ok {
+		r1 = rf(ctx, actorId, chatId, userId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RenameChat provides a mock function with given fields: ctx, actorId, chatId, chatname
+func (_m *Storage) RenameChat(ctx context.Context, actorId int64, chatId int64, chatname string) error {
+	ret := _m.Called(ctx, actorId, chatId, chatname)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64, string) error); ok {
+		r0 = rf(ctx, actorId, chatId, chatname)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ReserveIdempotencyKey provides a mock function with given fields: ctx, key, expiredBefore, abandonedBefore
+func (_m *Storage) ReserveIdempotencyKey(ctx context.Context, key *storage.IdempotencyKey, expiredBefore time.Time, abandonedBefore time.Time) (*storage.IdempotencyKey, error) {
+	ret := _m.Called(ctx, key, expiredBefore, abandonedBefore)
+
+	var r0 *storage.IdempotencyKey
+	if rf, ok := ret.Get(0).(func(context.Context, *storage.IdempotencyKey, time.Time, time.Time) *storage.IdempotencyKey); ok {
+		r0 = rf(ctx, key, expiredBefore, abandonedBefore)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*storage.IdempotencyKey)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *storage.IdempotencyKey, time.Time, time.Time) error); ok {
+		r1 = rf(ctx, key, expiredBefore, abandonedBefore)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SearchMessages provides a mock function with given fields: ctx, userId, query
+func (_m *Storage) SearchMessages(ctx context.Context, userId int64, query storage.SearchQuery) ([]*storage.SearchResult, error) {
+	ret := _m.Called(ctx, userId, query)
+
+	var r0 []*storage.SearchResult
+	if rf, ok := ret.Get(0).(func(context.Context, int64, storage.SearchQuery) []*storage.SearchResult); ok {
+		r0 = rf(ctx, userId, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*storage.SearchResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int64, storage.SearchQuery) error); ok {
+		r1 = rf(ctx, userId, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SetChatMemberRole provides a mock function with given fields: ctx, actorId, chatId, userId, role
+func (_m *Storage) SetChatMemberRole(ctx context.Context, actorId int64, chatId int64, userId int64, role storage.Role) error {
+	ret := _m.Called(ctx, actorId, chatId, userId, role)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64, int64, storage.Role) error); ok {
+		r0 = rf(ctx, actorId, chatId, userId, role)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetChatSlowMode provides a mock function with given fields: ctx, actorId, chatId, seconds
+func (_m *Storage) SetChatSlowMode(ctx context.Context, actorId int64, chatId int64, seconds int) error {
+	ret := _m.Called(ctx, actorId, chatId, seconds)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64, int) error); ok {
+		r0 = rf(ctx, actorId, chatId, seconds)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// TransferChatOwnership provides a mock function with given fields: ctx, ownerId, chatId, userId
+func (_m *Storage) TransferChatOwnership(ctx context.Context, ownerId int64, chatId int64, userId int64) error {
+	ret := _m.Called(ctx, ownerId, chatId, userId)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64, int64) error); ok {
+		r0 = rf(ctx, ownerId, chatId, userId)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
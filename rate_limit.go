@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/peer"
+)
+
+// Groups of routes with separate rate limits. Signup is limited by client IP, other groups by user.
+const (
+	rateLimitSignup = "signup"
+	rateLimitWrite  = "write"
+	rateLimitRead   = "read"
+)
+
+// rateLimited rejects requests to next with errRateLimited when client exceeds rate limit of group.
+// Authenticated clients are limited by user id, anonymous ones by IP address.
+func (s *Server) rateLimited(group string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, _ := r.Context().Value(userIdContextKey).(int64)
+		if allowed, wait := s.allowRequest(group, userId, remoteIP(r.RemoteAddr)); !allowed {
+			apiErr := *errRateLimited
+			apiErr.RetryAfter = wait
+			s.respondWithError(w, r, nil, &apiErr)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// allowRequest takes token of client from limiter of group. If client is limited, it returns false and how long to wait.
+func (s *Server) allowRequest(group string, userId int64, ip string) (bool, time.Duration) {
+	limiter := s.RateLimits[group]
+	if limiter == nil {
+		return true, 0
+	}
+	key := "ip:" + ip
+	if userId != 0 {
+		key = fmt.Sprintf("user:%d", userId)
+	}
+	return limiter.Allow(key)
+}
+
+// remoteIP returns IP address of client without port. Proxy headers aren't trusted, so server must face clients.
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// grpcRemoteIP returns IP address of gRPC client.
+func grpcRemoteIP(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return remoteIP(p.Addr.String())
+	}
+	return ""
+}
+
+// retryAfterSeconds rounds delay up to whole seconds for Retry-After header.
+func retryAfterSeconds(delay time.Duration) int {
+	return int(math.Ceil(delay.Seconds()))
+}
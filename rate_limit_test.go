@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Darkclainer/avito_exercise/config"
+	"github.com/Darkclainer/avito_exercise/ratelimit"
+)
+
+func TestRateLimited(t *testing.T) {
+	server := NewServer(nil, nil, true)
+	server.RateLimits = map[string]*ratelimit.Limiter{
+		rateLimitWrite: ratelimit.New(0.5, 2),
+	}
+	okHandler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	send := func(group string, userId int64, remoteAddr string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/messages/add", strings.NewReader("{}"))
+		request.RemoteAddr = remoteAddr
+		if userId != 0 {
+			request = withUserId(request, userId)
+		}
+		recorder := httptest.NewRecorder()
+		server.rateLimited(group, okHandler).ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	assert.Equal(t, http.StatusOK, send(rateLimitWrite, 1, "10.0.0.1:1000").Code)
+	assert.Equal(t, http.StatusOK, send(rateLimitWrite, 1, "10.0.0.2:1000").Code)
+	limited := send(rateLimitWrite, 1, "10.0.0.3:1000")
+	assert.Equal(t, http.StatusTooManyRequests, limited.Code)
+	assert.Equal(t, "2", limited.Header().Get("Retry-After"))
+	var responce struct {
+		Code string `json:"code"`
+	}
+	if assert.NoError(t, json.Unmarshal(limited.Body.Bytes(), &responce)) {
+		assert.Equal(t, "rate_limited", responce.Code)
+	}
+
+	// other users and anonymous clients have their own buckets
+	assert.Equal(t, http.StatusOK, send(rateLimitWrite, 2, "10.0.0.1:1000").Code)
+	assert.Equal(t, http.StatusOK, send(rateLimitWrite, 0, "10.0.0.1:1000").Code)
+	assert.Equal(t, http.StatusOK, send(rateLimitWrite, 0, "10.0.0.1:2000").Code)
+	assert.Equal(t, http.StatusTooManyRequests, send(rateLimitWrite, 0, "10.0.0.1:3000").Code)
+	assert.Equal(t, http.StatusOK, send(rateLimitWrite, 0, "10.0.0.2:1000").Code)
+
+	// group without limiter isn't limited
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, send(rateLimitRead, 1, "10.0.0.1:1000").Code)
+	}
+}
+
+func TestNewRateLimits(t *testing.T) {
+	limiters := NewRateLimits(&config.RateLimit{
+		Signup: config.Limit{Rate: 0, Burst: 10},
+		Write:  config.Limit{Rate: 1, Burst: 0},
+		Read:   config.Limit{Rate: 1, Burst: 2},
+	})
+	assert.NotContains(t, limiters, rateLimitSignup)
+	// zero burst is raised to 1, so the first request is allowed
+	if assert.Contains(t, limiters, rateLimitWrite) {
+		allowed, _ := limiters[rateLimitWrite].Allow("user")
+		assert.True(t, allowed)
+		allowed, _ = limiters[rateLimitWrite].Allow("user")
+		assert.False(t, allowed)
+	}
+	assert.Contains(t, limiters, rateLimitRead)
+}
@@ -0,0 +1,81 @@
+// Package ratelimit limits rate of events by key, for example requests by user, with token buckets.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// cleanupPeriod is how often buckets of keys that were idle long enough to refill are forgotten
+const cleanupPeriod = time.Minute
+
+// Limiter gives every key bucket of Burst tokens that is refilled with Rate tokens per second.
+// Event takes one token. Limiter is safe for concurrent use.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu          sync.Mutex
+	buckets     map[string]*bucket
+	lastCleanup time.Time
+	now         func() time.Time
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// New returns limiter that allows burst events at once and rate events per second on average.
+// Rate and burst must be positive.
+func New(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes token from bucket of key. If there is no token, it returns false and how long to wait for one.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.lastCleanup) >= cleanupPeriod {
+		l.cleanup(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, updatedAt: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refilled(b, now)
+	b.updatedAt = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration(math.Ceil((1 - b.tokens) / l.rate * float64(time.Second)))
+	return false, wait
+}
+
+// refilled returns number of tokens in bucket at now.
+func (l *Limiter) refilled(b *bucket, now time.Time) float64 {
+	elapsed := now.Sub(b.updatedAt).Seconds()
+	if elapsed <= 0 {
+		return b.tokens
+	}
+	return math.Min(l.burst, b.tokens+elapsed*l.rate)
+}
+
+// cleanup forgets full buckets: new bucket would be the same. It must be called with locked mu.
+func (l *Limiter) cleanup(now time.Time) {
+	for key, b := range l.buckets {
+		if l.refilled(b, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastCleanup = now
+}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is moved forward manually.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestLimiter(rate float64, burst int) (*Limiter, *fakeClock) {
+	clock := &fakeClock{time.Date(2019, time.January, 1, 10, 0, 0, 0, time.UTC)}
+	limiter := New(rate, burst)
+	limiter.now = clock.Now
+	return limiter, clock
+}
+
+func TestLimiterBurstAndRefill(t *testing.T) {
+	limiter, clock := newTestLimiter(2, 3)
+	for i := 0; i < 3; i++ {
+		allowed, _ := limiter.Allow("user:1")
+		assert.True(t, allowed, "event %d of burst", i)
+	}
+	allowed, wait := limiter.Allow("user:1")
+	assert.False(t, allowed)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// keys are independent
+	allowed, _ = limiter.Allow("user:2")
+	assert.True(t, allowed)
+
+	clock.now = clock.now.Add(250 * time.Millisecond)
+	allowed, wait = limiter.Allow("user:1")
+	assert.False(t, allowed)
+	assert.Equal(t, 250*time.Millisecond, wait)
+	clock.now = clock.now.Add(250 * time.Millisecond)
+	allowed, _ = limiter.Allow("user:1")
+	assert.True(t, allowed)
+
+	// bucket isn't refilled beyond burst
+	clock.now = clock.now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		allowed, _ := limiter.Allow("user:1")
+		assert.True(t, allowed, "event %d of burst", i)
+	}
+	allowed, _ = limiter.Allow("user:1")
+	assert.False(t, allowed)
+}
+
+func TestLimiterCleanup(t *testing.T) {
+	limiter, clock := newTestLimiter(1, 2)
+	limiter.Allow("user:1")
+	limiter.Allow("user:2")
+	limiter.Allow("user:2")
+	clock.now = clock.now.Add(cleanupPeriod)
+	limiter.Allow("user:3")
+	// user:1 and user:2 have refilled buckets, only user:3 is kept
+	assert.Len(t, limiter.buckets, 1)
+	_, ok := limiter.buckets["user:3"]
+	assert.True(t, ok)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+const (
+	requestIdHeader = "X-Request-ID"
+	// grpcRequestIdKey is metadata key with request id of gRPC call, it's the same header in HTTP/2
+	grpcRequestIdKey = "x-request-id"
+	// maxRequestIdLength limits id sent by client, longer ids are replaced
+	maxRequestIdLength = 128
+)
+
+// accessLog collects data of request that is known only to handlers deeper in chain.
+type accessLog struct {
+	userId int64
+}
+
+// logRequest is middleware that gives every request id and logger and writes access log line when it's done.
+// Id is taken from X-Request-ID header if client sent valid one, otherwise it's generated.
+// It's echoed in response, so client can refer to it.
+func (s *Server) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestId := r.Header.Get(requestIdHeader)
+		if !isValidRequestId(requestId) {
+			requestId = newRequestId()
+		}
+		w.Header().Set(requestIdHeader, requestId)
+		logger := s.Logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"url":        loggedUrl(r.URL),
+			"method":     r.Method,
+		})
+		access := &accessLog{}
+		ctx := storage.WithLogger(r.Context(), logger)
+		ctx = context.WithValue(ctx, accessLogContextKey, access)
+		writer := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(writer, r.WithContext(ctx))
+
+		fields := logrus.Fields{
+			"status":      writer.status,
+			"bytes":       writer.bytes,
+			"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"remote_addr": r.RemoteAddr,
+		}
+		if access.userId != 0 {
+			fields["user_id"] = access.userId
+		}
+		logger.WithFields(fields).Info("Request handled")
+	})
+}
+
+// logUnary is gRPC interceptor that does the same as logRequest for unary calls.
+// Id is taken from "x-request-id" metadata and sent back in header of response.
+func (g *grpcService) logUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, done := g.logCall(ctx, info.FullMethod, grpc.SetHeader)
+	response, err := handler(ctx, req)
+	done(err)
+	return response, err
+}
+
+// logStream is logUnary for streams, access log line is written when stream ends.
+func (g *grpcService) logStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	setHeader := func(_ context.Context, md metadata.MD) error {
+		return stream.SetHeader(md)
+	}
+	ctx, done := g.logCall(stream.Context(), info.FullMethod, setHeader)
+	err := handler(srv, contextStream{stream, ctx})
+	done(err)
+	return err
+}
+
+// logCall returns context of call with request id and logger, done must be called with result of call
+// to write access log line.
+func (g *grpcService) logCall(ctx context.Context, method string,
+	setHeader func(context.Context, metadata.MD) error) (context.Context, func(error)) {
+	start := time.Now()
+	var requestId string
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(grpcRequestIdKey); len(values) > 0 {
+		requestId = values[0]
+	}
+	if !isValidRequestId(requestId) {
+		requestId = newRequestId()
+	}
+	// error means that header is already sent, it can't happen before handler is called
+	_ = setHeader(ctx, metadata.Pairs(grpcRequestIdKey, requestId))
+	logger := g.s.Logger.WithFields(logrus.Fields{
+		"request_id":  requestId,
+		"grpc_method": method,
+	})
+	access := &accessLog{}
+	ctx = storage.WithLogger(ctx, logger)
+	ctx = context.WithValue(ctx, accessLogContextKey, access)
+	return ctx, func(err error) {
+		fields := logrus.Fields{
+			"grpc_code":   status.Code(err).String(),
+			"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"remote_addr": grpcRemoteAddr(ctx),
+		}
+		if access.userId != 0 {
+			fields["user_id"] = access.userId
+		}
+		logger.WithFields(fields).Info("Request handled")
+	}
+}
+
+// grpcRemoteAddr returns address of client of call.
+func grpcRemoteAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// loggedUrl returns url without "token" query parameter, so tokens of WebSocket and EventSource clients
+// don't get to logs.
+func loggedUrl(u *url.URL) string {
+	query := u.Query()
+	if _, ok := query["token"]; !ok {
+		return u.String()
+	}
+	query.Del("token")
+	redacted := *u
+	redacted.RawQuery = query.Encode()
+	return redacted.String()
+}
+
+// isValidRequestId accepts non-empty ids of printable ASCII characters without spaces,
+// so they can't break log lines or headers.
+func isValidRequestId(requestId string) bool {
+	if requestId == "" || len(requestId) > maxRequestIdLength {
+		return false
+	}
+	for i := 0; i < len(requestId); i++ {
+		if requestId[i] <= ' ' || requestId[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestId generates random request id.
+func newRequestId() string {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		// id is only for correlating logs, time is unique enough if random source fails
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(raw)
+}
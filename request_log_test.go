@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logrusTest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// findLogEntry returns the last log entry with message, nil if there is no one.
+func findLogEntry(hook *logrusTest.Hook, message string) *logrus.Entry {
+	entries := hook.AllEntries()
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Message == message {
+			return entries[i]
+		}
+	}
+	return nil
+}
+
+func TestLogRequest(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	server := NewServer(mockStorage, nil, true)
+	server.Logger.Level = logrus.DebugLevel
+	hook := logrusTest.NewLocal(server.Logger)
+	mockStorage.On("GetUserIdByToken", testifyMock.Anything, hashToken("token_7")).Return(int64(7), nil)
+
+	send := func(requestId string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/chats/get", strings.NewReader("{}"))
+		request.Header.Set("Authorization", "Bearer token_7")
+		if requestId != "" {
+			request.Header.Set(requestIdHeader, requestId)
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	mockStorage.On("GetUserChats", testifyMock.Anything, int64(7)).Return([]*storage.Chat{}, nil).Once()
+	recorder := send("client-id.1")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "client-id.1", recorder.Header().Get(requestIdHeader))
+	access := findLogEntry(hook, "Request handled")
+	if assert.NotNil(t, access) {
+		assert.Equal(t, logrus.InfoLevel, access.Level)
+		assert.Equal(t, "client-id.1", access.Data["request_id"])
+		assert.Equal(t, http.StatusOK, access.Data["status"])
+		assert.Equal(t, int64(recorder.Body.Len()), access.Data["bytes"])
+		assert.Equal(t, int64(7), access.Data["user_id"])
+		assert.Contains(t, access.Data, "duration_ms")
+	}
+
+	// errors logged by handler can be correlated with access log
+	hook.Reset()
+	mockStorage.On("GetUserChats", testifyMock.Anything, int64(7)).Return(nil, errors.New("database is locked")).Once()
+	recorder = send("bad id")
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	requestId := recorder.Header().Get(requestIdHeader)
+	assert.Len(t, requestId, 32)
+	failure := findLogEntry(hook, "Server responded with error")
+	if assert.NotNil(t, failure) {
+		assert.Equal(t, requestId, failure.Data["request_id"])
+		assert.Equal(t, int64(7), failure.Data["user_id"])
+		assert.Contains(t, failure.Data, "error")
+	}
+	access = findLogEntry(hook, "Request handled")
+	if assert.NotNil(t, access) {
+		assert.Equal(t, requestId, access.Data["request_id"])
+		assert.Equal(t, http.StatusInternalServerError, access.Data["status"])
+	}
+	mockStorage.AssertExpectations(t)
+}
+
+func TestLogRequestHidesToken(t *testing.T) {
+	server := NewServer(&mocks.Storage{}, nil, true)
+	server.Logger.Level = logrus.DebugLevel
+	hook := logrusTest.NewLocal(server.Logger)
+
+	request := httptest.NewRequest(http.MethodGet, "/unknown?last_id=5&token=secret", nil)
+	server.ServeHTTP(httptest.NewRecorder(), request)
+	access := findLogEntry(hook, "Request handled")
+	if assert.NotNil(t, access) {
+		assert.Equal(t, "/unknown?last_id=5", access.Data["url"])
+	}
+}
+
+func TestIsValidRequestId(t *testing.T) {
+	assert.True(t, isValidRequestId("5f0c7a1e-3b2d-4c7a-9e8f-1a2b3c4d5e6f"))
+	assert.False(t, isValidRequestId(""))
+	assert.False(t, isValidRequestId("with space"))
+	assert.False(t, isValidRequestId("line\nbreak"))
+	assert.False(t, isValidRequestId("юникод"))
+	assert.False(t, isValidRequestId(strings.Repeat("a", maxRequestIdLength+1)))
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// limitDuration is middleware that sets deadline of RequestTimeout on context of request,
+// so all storage calls made by request share it. Long polling and streams wait for events longer than that,
+// they aren't wrapped and limit their storage calls with storageContext themselves.
+func (s *Server) limitDuration(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := s.storageContext(r.Context(), 0)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// storageContext returns ctx with deadline after RequestTimeout and extra time, for example time of waiting
+// for events. Earlier deadline of ctx still applies. Zero RequestTimeout means no deadline.
+func (s *Server) storageContext(ctx context.Context, extra time.Duration) (context.Context, context.CancelFunc) {
+	if s.RequestTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.RequestTimeout+extra)
+}
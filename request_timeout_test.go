@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+
+	"github.com/Darkclainer/avito_exercise/mocks"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	server := NewServer(mockStorage, nil, true)
+	server.RequestTimeout = time.Minute
+	var deadlines []time.Time
+	recordDeadline := func(args testifyMock.Arguments) {
+		deadline, ok := args.Get(0).(context.Context).Deadline()
+		assert.True(t, ok)
+		deadlines = append(deadlines, deadline)
+	}
+	mockStorage.On("GetUserIdByToken", testifyMock.Anything, hashToken("token_7")).Run(recordDeadline).
+		Return(int64(7), nil)
+	mockStorage.On("GetUserChats", testifyMock.Anything, int64(7)).Run(recordDeadline).
+		Return([]*storage.Chat{}, nil)
+	mockStorage.On("IsUserInChat", testifyMock.Anything, int64(7), int64(10)).Run(recordDeadline).
+		Return(false, nil)
+
+	send := func(url string, body string) {
+		request := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		request.Header.Set("Authorization", "Bearer token_7")
+		server.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	// all calls of request share one deadline
+	start := time.Now()
+	send("/chats/get", "{}")
+	if assert.Len(t, deadlines, 2) {
+		assert.Equal(t, deadlines[0], deadlines[1])
+		assert.WithinDuration(t, start.Add(time.Minute), deadlines[0], time.Second)
+	}
+
+	// long polling gets waiting time in addition
+	deadlines = nil
+	start = time.Now()
+	send("/messages/get", `{"chat": 10, "wait_seconds": 30}`)
+	if assert.Len(t, deadlines, 2) {
+		assert.WithinDuration(t, start.Add(time.Minute), deadlines[0], time.Second)
+		assert.WithinDuration(t, start.Add(time.Minute+30*time.Second), deadlines[1], time.Second)
+	}
+	mockStorage.AssertExpectations(t)
+}
+
+func TestRequestTimeoutDisabled(t *testing.T) {
+	mockStorage := &mocks.Storage{}
+	server := NewServer(mockStorage, nil, true)
+	mockStorage.On("GetUserIdByToken", testifyMock.Anything, hashToken("token_7")).
+		Run(func(args testifyMock.Arguments) {
+			_, ok := args.Get(0).(context.Context).Deadline()
+			assert.False(t, ok)
+		}).
+		Return(int64(0), storage.ErrNotFound)
+
+	request := httptest.NewRequest(http.MethodPost, "/chats/get", strings.NewReader("{}"))
+	request.Header.Set("Authorization", "Bearer token_7")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	mockStorage.AssertExpectations(t)
+}
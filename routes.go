@@ -1,9 +1,55 @@
 package main
 
+import "net/http"
+
 func (s *Server) routes() {
-	s.router.HandleFunc("/users/add", s.handleAddUser()).Methods("POST")
-	s.router.HandleFunc("/chats/add", s.handleAddChat()).Methods("POST")
-	s.router.HandleFunc("/chats/get", s.handleGetUserChats()).Methods("POST")
-	s.router.HandleFunc("/messages/add", s.handleAddMessage()).Methods("POST")
-	s.router.HandleFunc("/messages/get", s.handleGetMessages()).Methods("POST")
+	s.router.NotFoundHandler = s.instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.respondWithError(w, r, nil, errMethodNotFound)
+	}))
+	s.router.MethodNotAllowedHandler = s.instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.respondWithError(w, r, nil, errBadHttpMethod)
+	}))
+	s.router.Use(s.instrument)
+
+	s.router.Handle("/metrics", s.Metrics.Handler()).Methods("GET")
+	s.router.HandleFunc("/healthz", s.handleHealthz()).Methods("GET")
+	s.router.HandleFunc("/readyz", s.handleReadyz()).Methods("GET")
+
+	s.router.Handle("/users/add", s.limitDuration(s.rateLimited(rateLimitSignup, s.idempotent(s.handleAddUser())))).Methods("POST")
+
+	authorized := s.router.NewRoute().Subrouter()
+	authorized.Use(s.limitDuration, s.authenticate)
+	authorized.HandleFunc("/chats/add", s.rateLimited(rateLimitWrite, s.idempotent(s.handleAddChat()))).Methods("POST")
+	authorized.HandleFunc("/chats/get", s.rateLimited(rateLimitRead, s.handleGetUserChats())).Methods("POST")
+	authorized.HandleFunc("/chats/read", s.rateLimited(rateLimitWrite, s.handleMarkChatRead())).Methods("POST")
+	authorized.HandleFunc("/chats/rename", s.rateLimited(rateLimitWrite, s.handleRenameChat())).Methods("POST")
+	authorized.HandleFunc("/chats/slowmode", s.rateLimited(rateLimitWrite, s.handleSetChatSlowMode())).Methods("POST")
+	authorized.HandleFunc("/chats/members", s.rateLimited(rateLimitRead, s.handleGetChatMembers())).Methods("POST")
+	authorized.HandleFunc("/chats/members/add", s.rateLimited(rateLimitWrite, s.handleAddChatMember())).Methods("POST")
+	authorized.HandleFunc("/chats/members/remove", s.rateLimited(rateLimitWrite, s.handleRemoveChatMember())).Methods("POST")
+	authorized.HandleFunc("/chats/members/role", s.rateLimited(rateLimitWrite, s.handleSetChatMemberRole())).Methods("POST")
+	authorized.HandleFunc("/chats/transfer", s.rateLimited(rateLimitWrite, s.handleTransferChatOwnership())).Methods("POST")
+	authorized.HandleFunc("/chats/leave", s.rateLimited(rateLimitWrite, s.handleLeaveChat())).Methods("POST")
+	authorized.HandleFunc("/messages/add", s.rateLimited(rateLimitWrite, s.idempotent(s.handleAddMessage()))).Methods("POST")
+	authorized.HandleFunc("/messages/search", s.rateLimited(rateLimitRead, s.handleSearchMessages())).Methods("POST")
+	authorized.HandleFunc("/messages/edit", s.rateLimited(rateLimitWrite, s.handleEditMessage())).Methods("POST")
+	authorized.HandleFunc("/messages/delete", s.rateLimited(rateLimitWrite, s.handleDeleteMessage())).Methods("POST")
+	authorized.HandleFunc("/messages/pin", s.rateLimited(rateLimitWrite, s.handlePinMessage(true))).Methods("POST")
+	authorized.HandleFunc("/messages/unpin", s.rateLimited(rateLimitWrite, s.handlePinMessage(false))).Methods("POST")
+	authorized.HandleFunc("/messages/history", s.rateLimited(rateLimitRead, s.handleGetMessageEdits())).Methods("POST")
+	authorized.HandleFunc("/messages/readers", s.rateLimited(rateLimitRead, s.handleGetMessageReaders())).Methods("POST")
+	authorized.HandleFunc("/webhooks/add", s.rateLimited(rateLimitWrite, s.handleAddWebhook())).Methods("POST")
+	authorized.HandleFunc("/webhooks/get", s.rateLimited(rateLimitRead, s.handleGetWebhooks())).Methods("POST")
+	authorized.HandleFunc("/webhooks/delete", s.rateLimited(rateLimitWrite, s.handleDeleteWebhook())).Methods("POST")
+	authorized.HandleFunc("/webhooks/deliveries", s.rateLimited(rateLimitRead, s.handleGetWebhookDeliveries())).Methods("POST")
+
+	// long polling sets its own deadline, which includes time of waiting
+	polling := s.router.NewRoute().Subrouter()
+	polling.Use(s.authenticate)
+	polling.HandleFunc("/messages/get", s.rateLimited(rateLimitRead, s.handleGetMessages())).Methods("POST")
+
+	streams := s.router.NewRoute().Subrouter()
+	streams.Use(s.authenticateStream)
+	streams.HandleFunc("/ws", s.rateLimited(rateLimitRead, s.handleWebSocket())).Methods("GET")
+	streams.HandleFunc("/events", s.rateLimited(rateLimitRead, s.handleEvents())).Methods("GET")
 }
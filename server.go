@@ -1,30 +1,52 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/go-playground/validator.v9"
 
+	"github.com/Darkclainer/avito_exercise/hub"
+	"github.com/Darkclainer/avito_exercise/metrics"
+	"github.com/Darkclainer/avito_exercise/ratelimit"
 	"github.com/Darkclainer/avito_exercise/storage"
+	"github.com/Darkclainer/avito_exercise/webhook"
 )
 
 type Server struct {
-	router    *mux.Router
-	Logger    *logrus.Logger
-	Storage   storage.Storage
-	validate  *validator.Validate
-	isTesting bool
+	router            *mux.Router
+	Logger            *logrus.Logger
+	Storage           storage.Storage
+	Hub               *hub.Hub
+	Metrics           *metrics.Metrics
+	Webhooks          *webhook.Dispatcher           // nil if webhooks aren't delivered
+	IdempotencyWindow time.Duration                 // how long responses to requests with Idempotency-Key are kept
+	ReadinessCheck    func() error                  // checked by /readyz, nil if storage is always ready
+	RateLimits        map[string]*ratelimit.Limiter // by group of routes, group without limiter isn't limited
+	RequestTimeout    time.Duration                 // limits storage calls of request, zero means no limit
+	validate          *validator.Validate
+	isTesting         bool
+	stopping          chan struct{} // closed by StopStreams
+	stopOnce          sync.Once
 }
 
 func NewServer(storageHandler storage.Storage, logger *logrus.Logger, isTesting bool) *Server {
 	s := &Server{
-		router:    mux.NewRouter(),
-		Logger:    logger,
-		Storage:   storageHandler,
-		isTesting: isTesting,
+		router:            mux.NewRouter(),
+		Logger:            logger,
+		Storage:           storageHandler,
+		Hub:               hub.New(),
+		Metrics:           metrics.New(),
+		isTesting:         isTesting,
+		IdempotencyWindow: defaultIdempotencyWindow,
+		stopping:          make(chan struct{}),
 	}
 	if logger == nil {
 		s.Logger = logrus.New()
@@ -34,29 +56,108 @@ func NewServer(storageHandler storage.Storage, logger *logrus.Logger, isTesting
 	} else {
 		s.Logger.Level = logrus.DebugLevel
 	}
+	s.Metrics.RegisterSubscribers(s.Hub.Subscribers)
 	s.routes()
 	s.validate = NewValidate()
 	return s
 }
 
+// StopStreams ends event streams and long polling and makes server unready.
+// It is called before shutdown of HTTP and gRPC servers, because they wait for running requests to finish.
+func (s *Server) StopStreams() {
+	s.stopOnce.Do(func() { close(s.stopping) })
+}
+
+func (s *Server) isStopping() bool {
+	select {
+	case <-s.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// getLogger returns logger of request with its id, url and method, see logRequest.
+// Requests that haven't passed logRequest, like ones in handler tests, get logger without id.
 func (s *Server) getLogger(r *http.Request) *logrus.Entry {
+	if logger := storage.LoggerFromContext(r.Context()); logger != nil {
+		return logger
+	}
 	return s.Logger.WithFields(logrus.Fields{
-		"url":    r.URL,
+		"url":    loggedUrl(r.URL),
 		"method": r.Method,
 	})
 }
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.Logger.WithFields(logrus.Fields{
-		"url":    r.URL,
-		"method": r.Method,
-	}).Debug("New request")
-	s.router.ServeHTTP(w, r)
+	s.logRequest(s.router).ServeHTTP(w, r)
+}
+
+// publishMessage delivers message to subscribers of its chat members and to extraRecipients,
+// for example to users that were just removed from chat.
+// Errors are only logged, because message is already stored. Storage is queried without context of request,
+// so message is published even if its author has disconnected.
+func (s *Server) publishMessage(logger *logrus.Entry, messageId int64, extraRecipients ...int64) {
+	ctx, cancel := s.storageContext(context.Background(), 0)
+	defer cancel()
+	message, err := s.Storage.GetMessage(ctx, messageId)
+	if err != nil {
+		logger.WithField("error", fmt.Errorf("GetMessage failed: %s", err)).Error("Can not publish message")
+		return
+	}
+	userIds, err := s.Storage.GetChatUserIds(ctx, message.ChatId)
+	if err != nil {
+		logger.WithField("error", fmt.Errorf("GetChatUserIds failed: %s", err)).Error("Can not publish message")
+		return
+	}
+	for _, userId := range extraRecipients {
+		if !containsId(userIds, userId) {
+			userIds = append(userIds, userId)
+		}
+	}
+	s.Hub.Publish(hub.NewMessageEvent(message), userIds)
+	event := storage.WebhookMessageCreated
+	if message.System != nil {
+		event = message.System.Type
+	}
+	s.notifyWebhooks(logger, event, message)
+}
+
+// notifyWebhooks queues event about message for webhooks subscribed to it.
+// Errors are only logged, because message is already stored.
+func (s *Server) notifyWebhooks(logger *logrus.Entry, event string, message *storage.Message) {
+	if s.Webhooks == nil {
+		return
+	}
+	if err := s.Webhooks.Notify(context.Background(), event, message); err != nil {
+		logger.WithField("error", err).Error("Can not notify webhooks")
+	}
+}
+
+// publishChat delivers just created chat to subscribers of its members.
+// Chat is loaded as seen by its creator, that's the same for everyone in new chat.
+// Errors are only logged, because chat is already stored.
+func (s *Server) publishChat(logger *logrus.Entry, chatId int64, creatorId int64) {
+	ctx, cancel := s.storageContext(context.Background(), 0)
+	defer cancel()
+	chats, err := s.Storage.GetUserChats(ctx, creatorId)
+	if err != nil {
+		logger.WithField("error", fmt.Errorf("GetUserChats failed: %s", err)).Error("Can not publish chat")
+		return
+	}
+	for _, chat := range chats {
+		if chat.Id == chatId {
+			s.Hub.Publish(hub.NewChatEvent(chat), chat.UserIds)
+			return
+		}
+	}
+	logger.Error("Can not publish chat: it isn't found in chats of its creator")
 }
 
 // respond sends respond with json data and log if there is any error whyle encoding.
 func (s *Server) respond(w http.ResponseWriter, r *http.Request, data interface{}, status int) {
-	w.WriteHeader(status)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	if data != nil {
 		err := json.NewEncoder(w).Encode(data)
 		if err != nil {
@@ -72,7 +173,7 @@ func (s *Server) respond(w http.ResponseWriter, r *http.Request, data interface{
 func (s *Server) decode(w http.ResponseWriter, r *http.Request, value interface{}) error {
 	err := json.NewDecoder(r.Body).Decode(value)
 	if err != nil {
-		s.respondWithError(w, r, s.getLogger(r).WithField("error", err), "json decoding error")
+		s.respondWithError(w, r, s.getLogger(r).WithField("error", err), errJsonDecoding)
 	}
 	return err
 }
@@ -89,35 +190,52 @@ func (s *Server) decodeAndValidate(w http.ResponseWriter, r *http.Request, value
 			return err
 		}
 		logger := s.getLogger(r)
-		for _, err := range err.(validator.ValidationErrors) {
+		validationErrors := err.(validator.ValidationErrors)
+		for _, err := range validationErrors {
 			logger.WithFields(logrus.Fields{
 				"tag":   err.ActualTag(),
 				"field": err.StructNamespace(),
 				"value": err.Value(),
 			}).Debug("Error while validating")
 		}
-		s.respondWithError(w, r, logger, "invalid input")
+		s.respondWithError(w, r, logger, validationError(validationErrors))
 		return err
 	}
 	return nil
 }
 
-// respondWithError responds with "error" field and specified msg and log it
-// Also it sets status code to http.StatusInternalServerError
-func (s *Server) respondWithError(w http.ResponseWriter, r *http.Request, logger *logrus.Entry, msg string) {
+// respondWithError responds with apiErr status and its code, message and details, and log it.
+func (s *Server) respondWithError(w http.ResponseWriter, r *http.Request, logger *logrus.Entry, apiErr *apiError) {
 	if logger == nil {
 		logger = s.getLogger(r)
 	}
-	logger.WithField("respond_msg", msg).Debug("Server responded with error")
+	logger.WithFields(logrus.Fields{
+		"respond_code": apiErr.Code,
+		"respond_msg":  apiErr.Message,
+	}).Debug("Server responded with error")
+
+	if apiErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(apiErr.RetryAfter)))
+	}
+	s.respond(w, r, errorResponce{apiErr.Message, apiErr.Code, apiErr.Details}, apiErr.Status)
+}
 
-	type Responce struct {
-		Error string `json:error`
+// respondWithStorageError responds with error that corresponds to sentinel error of storage.
+// Unexpected errors are hidden from client as internal ones.
+func (s *Server) respondWithStorageError(w http.ResponseWriter, r *http.Request, logger *logrus.Entry, err error) {
+	if logger == nil {
+		logger = s.getLogger(r)
+	}
+	apiErr := storageApiError(err)
+	if apiErr == nil {
+		s.respondWithInternalError(w, r, logger.WithField("error", err))
+		return
 	}
-	s.respond(w, r, Responce{msg}, http.StatusInternalServerError)
+	s.respondWithError(w, r, logger.WithField("error", err), apiErr)
 }
 
-// respondWithInternalError works as respondWithError, but it has predifined msg.
+// respondWithInternalError responds with errInternal.
 // Its function used for hiding from client what kind of error happened.
 func (s *Server) respondWithInternalError(w http.ResponseWriter, r *http.Request, logger *logrus.Entry) {
-	s.respondWithError(w, r, logger, "internal error")
+	s.respondWithError(w, r, logger, errInternal)
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// apiClient calls API of test server and decodes responces.
+type apiClient struct {
+	t      *testing.T
+	server *httptest.Server
+}
+
+func (c apiClient) post(path string, token string, request interface{}, responce interface{}) int {
+	body, err := json.Marshal(request)
+	if err != nil {
+		c.t.Fatal("Marshal failed: ", err)
+	}
+	httpRequest, err := http.NewRequest(http.MethodPost, c.server.URL+path, bytes.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if token != "" {
+		httpRequest.Header.Set("Authorization", "Bearer "+token)
+	}
+	httpResponce, err := http.DefaultClient.Do(httpRequest)
+	if err != nil {
+		c.t.Fatal("Request failed: ", err)
+	}
+	defer httpResponce.Body.Close()
+	if err := json.NewDecoder(httpResponce.Body).Decode(responce); err != nil {
+		c.t.Fatal("Decode failed: ", err)
+	}
+	return httpResponce.StatusCode
+}
+
+func TestServerWithMemoryStorage(t *testing.T) {
+	server := NewServer(storage.NewMemoryStorage(), nil, true)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+	client := apiClient{t, testServer}
+
+	type User struct {
+		Id    int64  `json:"id"`
+		Token string `json:"token"`
+	}
+	var alice, bob User
+	assert.Equal(t, http.StatusOK, client.post("/users/add", "", map[string]string{"username": "alice"}, &alice))
+	assert.Equal(t, http.StatusOK, client.post("/users/add", "", map[string]string{"username": "bob"}, &bob))
+
+	var chat struct {
+		Id int64 `json:"id"`
+	}
+	status := client.post("/chats/add", alice.Token, map[string]interface{}{
+		"name":  "chat_1",
+		"users": []int64{bob.Id},
+	}, &chat)
+	assert.Equal(t, http.StatusOK, status)
+
+	for _, text := range []string{"Hello, Bob", "How are you?"} {
+		var message struct {
+			Id int64 `json:"id"`
+		}
+		status := client.post("/messages/add", alice.Token, map[string]interface{}{
+			"chat": chat.Id,
+			"text": text,
+		}, &message)
+		assert.Equal(t, http.StatusOK, status)
+	}
+
+	var messages struct {
+		Messages []*storage.Message `json:"messages"`
+	}
+	status = client.post("/messages/get", bob.Token, map[string]interface{}{"chat": chat.Id}, &messages)
+	assert.Equal(t, http.StatusOK, status)
+	if assert.Len(t, messages.Messages, 2) {
+		assert.Equal(t, "Hello, Bob", messages.Messages[0].Text)
+		assert.Equal(t, alice.Id, messages.Messages[0].AuthorId)
+		assert.Equal(t, "How are you?", messages.Messages[1].Text)
+	}
+
+	var found struct {
+		Results []*storage.SearchResult `json:"results"`
+	}
+	status = client.post("/messages/search", bob.Token, map[string]interface{}{"text": "bob"}, &found)
+	assert.Equal(t, http.StatusOK, status)
+	if assert.Len(t, found.Results, 1) {
+		assert.Equal(t, "Hello, <b>Bob</b>", found.Results[0].Snippet)
+	}
+
+	var chats struct {
+		Chats []*storage.Chat `json:"chats"`
+	}
+	status = client.post("/chats/get", bob.Token, map[string]interface{}{}, &chats)
+	assert.Equal(t, http.StatusOK, status)
+	if assert.Len(t, chats.Chats, 1) {
+		assert.Equal(t, "chat_1", chats.Chats[0].Name)
+		assert.Equal(t, []int64{alice.Id, bob.Id}, chats.Chats[0].UserIds)
+	}
+}
@@ -0,0 +1,59 @@
+package storage_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+	"github.com/Darkclainer/avito_exercise/storage/postgrestest"
+	"github.com/Darkclainer/avito_exercise/storage/storagetest"
+)
+
+func TestSqlStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) (storage.Storage, func()) {
+		tempFile, err := ioutil.TempFile("", "sql-db-conformance-")
+		if err != nil {
+			t.Fatal("Creation temp file for db failed: ", err)
+		}
+		tempFile.Close()
+		sqlStorage, err := storage.OpenSqlite(tempFile.Name())
+		if err != nil {
+			t.Fatal("Open db failed: ", err)
+		}
+		if err := sqlStorage.Migrate(); err != nil {
+			t.Fatal("Migrate failed: ", err)
+		}
+		return sqlStorage, func() {
+			sqlStorage.Close()
+			os.Remove(tempFile.Name())
+		}
+	})
+}
+
+func TestPostgresStorageConformance(t *testing.T) {
+	server := postgrestest.Start(t)
+	defer server.Stop()
+	storagetest.Run(t, func(t *testing.T) (storage.Storage, func()) {
+		dsn, dropDatabase := server.NewDatabase(t)
+		postgresStorage, err := storage.OpenPostgres(dsn)
+		if err != nil {
+			t.Fatal("Open db failed: ", err)
+		}
+		if err := postgresStorage.Migrate(); err != nil {
+			t.Fatal("Migrate failed: ", err)
+		}
+		return postgresStorage, func() {
+			postgresStorage.Close()
+			dropDatabase()
+		}
+	})
+}
+
+func TestMemoryStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) (storage.Storage, func()) {
+		return storage.NewMemoryStorage(), func() {}
+	})
+}
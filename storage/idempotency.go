@@ -0,0 +1,17 @@
+package storage
+
+import "time"
+
+// IdempotencyKey is response saved for request with Idempotency-Key header, so that repeated request
+// gets the same response instead of being executed again. Keys are scoped by user (zero for requests
+// without authentication) and endpoint. Status is zero while the first request is in progress.
+// RequestHash tells apart different requests sent with the same key.
+type IdempotencyKey struct {
+	UserId      int64
+	Endpoint    string
+	Key         string
+	RequestHash string
+	Status      int
+	Body        []byte
+	CreatedAt   time.Time
+}
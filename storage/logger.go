@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// loggerContextKey keeps *logrus.Entry of request that made storage call.
+type loggerContextKey struct{}
+
+// WithLogger returns ctx that carries logger of request, so storage calls made with ctx are logged
+// with request id and other fields of logger.
+func WithLogger(ctx context.Context, logger *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, logger)
+}
+
+// LoggerFromContext returns logger of request that ctx carries, nil if there is none.
+func LoggerFromContext(ctx context.Context) *logrus.Entry {
+	logger, _ := ctx.Value(loggerContextKey{}).(*logrus.Entry)
+	return logger
+}
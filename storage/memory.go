@@ -0,0 +1,916 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps all data in memory and loses it on exit.
+// It has the same semantics as SqlStorage and is safe for concurrent use. Use NewMemoryStorage to create it.
+// Its methods ignore context, because they never wait for anything but its mutex.
+type MemoryStorage struct {
+	mu sync.RWMutex
+
+	lastUserId     int64
+	lastChatId     int64
+	lastMessageId  int64
+	lastWebhookId  int64
+	lastDeliveryId int64
+
+	users     map[int64]*memoryUser
+	usernames map[string]int64
+	tokens    map[string]int64
+	chats     map[int64]*Chat
+	chatNames map[string]int64
+	// members maps chat id to roles of its members
+	members map[int64]map[int64]Role
+	// lastRead maps chat id to last read message of its members
+	lastRead map[int64]map[int64]int64
+	// chatMessages maps chat id to messages ordered by id
+	chatMessages map[int64][]*Message
+	messages     map[int64]*Message
+	// edits maps message id to its history
+	edits    map[int64][]*MessageEdit
+	webhooks map[int64]*Webhook
+	// deliveries maps delivery id to delivery with its attempts
+	deliveries      map[int64]*WebhookDelivery
+	idempotencyKeys map[idempotencyScope]*IdempotencyKey
+}
+
+// idempotencyScope identifies IdempotencyKey.
+type idempotencyScope struct {
+	UserId   int64
+	Endpoint string
+	Key      string
+}
+
+type memoryUser struct {
+	Id        int64
+	Username  string
+	CreatedAt time.Time
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		users:           make(map[int64]*memoryUser),
+		usernames:       make(map[string]int64),
+		tokens:          make(map[string]int64),
+		chats:           make(map[int64]*Chat),
+		chatNames:       make(map[string]int64),
+		members:         make(map[int64]map[int64]Role),
+		lastRead:        make(map[int64]map[int64]int64),
+		chatMessages:    make(map[int64][]*Message),
+		messages:        make(map[int64]*Message),
+		edits:           make(map[int64][]*MessageEdit),
+		webhooks:        make(map[int64]*Webhook),
+		deliveries:      make(map[int64]*WebhookDelivery),
+		idempotencyKeys: make(map[idempotencyScope]*IdempotencyKey),
+	}
+}
+
+func (m *MemoryStorage) AreUsersExistByIds(ctx context.Context, userIds []int64) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	// repeated ids are counted once, as in SqlStorage
+	existing := make(map[int64]struct{}, len(userIds))
+	for _, userId := range userIds {
+		if _, ok := m.users[userId]; ok {
+			existing[userId] = struct{}{}
+		}
+	}
+	return len(existing) == len(userIds), nil
+}
+
+func (m *MemoryStorage) AddUser(ctx context.Context, username string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.usernames[username]; ok {
+		return 0, ErrUserExists
+	}
+	m.lastUserId++
+	user := &memoryUser{
+		Id:        m.lastUserId,
+		Username:  username,
+		CreatedAt: time.Now(),
+	}
+	m.users[user.Id] = user
+	m.usernames[username] = user.Id
+	return user.Id, nil
+}
+
+func (m *MemoryStorage) AddUserToken(ctx context.Context, userId int64, tokenHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[userId]; !ok {
+		return ErrUserNotFound
+	}
+	if _, ok := m.tokens[tokenHash]; ok {
+		return ErrTokenExists
+	}
+	m.tokens[tokenHash] = userId
+	return nil
+}
+
+func (m *MemoryStorage) AddUserWithToken(ctx context.Context, username string, tokenHash string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.usernames[username]; ok {
+		return 0, ErrUserExists
+	}
+	if _, ok := m.tokens[tokenHash]; ok {
+		return 0, ErrTokenExists
+	}
+	m.lastUserId++
+	user := &memoryUser{
+		Id:        m.lastUserId,
+		Username:  username,
+		CreatedAt: time.Now(),
+	}
+	m.users[user.Id] = user
+	m.usernames[username] = user.Id
+	m.tokens[tokenHash] = user.Id
+	return user.Id, nil
+}
+
+// GetUserIdByToken returns id of token owner or ErrNotFound.
+func (m *MemoryStorage) GetUserIdByToken(ctx context.Context, tokenHash string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	userId, ok := m.tokens[tokenHash]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return userId, nil
+}
+
+// GetUserChats returns chats of the user from the latest to the earliest by time of the last message
+// (or creation time of the chat if it is empty) in the same order as SqlStorage.
+func (m *MemoryStorage) GetUserChats(ctx context.Context, userId int64) ([]*Chat, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	chats := make([]*Chat, 0)
+	lastTime := make(map[int64]time.Time)
+	for chatId, members := range m.members {
+		if _, ok := members[userId]; !ok {
+			continue
+		}
+		chat := *m.chats[chatId]
+		chat.UserIds = m.chatUserIds(chatId)
+		chat.LastReadMessageId = m.lastRead[chatId][userId]
+		chats = append(chats, &chat)
+		lastTime[chatId] = chat.CreatedAt
+		messages := m.chatMessages[chatId]
+		if len(messages) > 0 {
+			lastMessage := *messages[len(messages)-1]
+			chat.LastMessage = &lastMessage
+			chat.LastMessageAt = &lastMessage.CreatedAt
+			lastTime[chatId] = lastMessage.CreatedAt
+		}
+		for i := len(messages) - 1; i >= 0 && messages[i].Id > chat.LastReadMessageId; i-- {
+			if messages[i].AuthorId != userId && !messages[i].Deleted {
+				chat.UnreadCount++
+			}
+		}
+	}
+	sort.Slice(chats, func(i, j int) bool {
+		if lastTime[chats[i].Id].Equal(lastTime[chats[j].Id]) {
+			return chats[i].Id > chats[j].Id
+		}
+		return lastTime[chats[i].Id].After(lastTime[chats[j].Id])
+	})
+	return chats, nil
+}
+
+func (m *MemoryStorage) AddChat(ctx context.Context, ownerId int64, chatname string, memberIds []int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.chatNames[chatname]; ok {
+		return 0, ErrChatExists
+	}
+	members := make(map[int64]Role, len(memberIds)+1)
+	for _, userId := range append([]int64{ownerId}, memberIds...) {
+		if _, ok := m.users[userId]; !ok {
+			return 0, ErrUserNotFound
+		}
+		if _, ok := members[userId]; ok {
+			return 0, fmt.Errorf("user %d is repeated", userId)
+		}
+		members[userId] = RoleMember
+	}
+	members[ownerId] = RoleOwner
+	m.lastChatId++
+	chat := &Chat{
+		Id:        m.lastChatId,
+		Name:      chatname,
+		CreatedAt: time.Now(),
+	}
+	m.chats[chat.Id] = chat
+	m.chatNames[chatname] = chat.Id
+	m.members[chat.Id] = members
+	m.lastRead[chat.Id] = make(map[int64]int64)
+	return chat.Id, nil
+}
+
+func (m *MemoryStorage) RenameChat(ctx context.Context, actorId int64, chatId int64, chatname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	role, ok := m.members[chatId][actorId]
+	if !ok {
+		return ErrNotChatMember
+	}
+	if err := checkPermission(role, PermissionRenameChat); err != nil {
+		return err
+	}
+	chat := m.chats[chatId]
+	if chat.Name == chatname {
+		return nil
+	}
+	if _, ok := m.chatNames[chatname]; ok {
+		return ErrChatExists
+	}
+	delete(m.chatNames, chat.Name)
+	chat.Name = chatname
+	m.chatNames[chatname] = chatId
+	return nil
+}
+
+func (m *MemoryStorage) SetChatSlowMode(ctx context.Context, actorId int64, chatId int64, seconds int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	role, ok := m.members[chatId][actorId]
+	if !ok {
+		return ErrNotChatMember
+	}
+	if err := checkPermission(role, PermissionManageSlowMode); err != nil {
+		return err
+	}
+	m.chats[chatId].SlowModeSeconds = seconds
+	return nil
+}
+
+func (m *MemoryStorage) IsUserInChat(ctx context.Context, userId int64, chatId int64) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.members[chatId][userId]
+	return ok, nil
+}
+
+// GetChatUserIds returns members of the chat sorted by id.
+func (m *MemoryStorage) GetChatUserIds(ctx context.Context, chatId int64) ([]int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.chatUserIds(chatId), nil
+}
+
+// chatUserIds must be called with locked mu.
+func (m *MemoryStorage) chatUserIds(chatId int64) []int64 {
+	userIds := make([]int64, 0, len(m.members[chatId]))
+	for userId := range m.members[chatId] {
+		userIds = append(userIds, userId)
+	}
+	sort.Slice(userIds, func(i, j int) bool {
+		return userIds[i] < userIds[j]
+	})
+	return userIds
+}
+
+// GetChatMembers returns members of the chat with their roles sorted by user id.
+func (m *MemoryStorage) GetChatMembers(ctx context.Context, chatId int64) ([]*ChatMember, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	userIds := m.chatUserIds(chatId)
+	members := make([]*ChatMember, len(userIds))
+	for i, userId := range userIds {
+		members[i] = &ChatMember{UserId: userId, Role: m.members[chatId][userId]}
+	}
+	return members, nil
+}
+
+func (m *MemoryStorage) AddChatMember(ctx context.Context, actorId int64, chatId int64, userId int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	role, ok := m.members[chatId][actorId]
+	if !ok {
+		return 0, ErrNotChatMember
+	}
+	if err := checkPermission(role, PermissionInvite); err != nil {
+		return 0, err
+	}
+	if _, ok := m.members[chatId][userId]; ok {
+		return 0, ErrMemberExists
+	}
+	if _, ok := m.users[userId]; !ok {
+		return 0, ErrUserNotFound
+	}
+	m.members[chatId][userId] = RoleMember
+	message := m.addMessage(actorId, chatId, "")
+	message.System = &SystemEvent{MemberAdded, userId}
+	m.lastRead[chatId][userId] = message.Id
+	return message.Id, nil
+}
+
+func (m *MemoryStorage) RemoveChatMember(ctx context.Context, actorId int64, chatId int64,
+	userId int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	actorRole, ok := m.members[chatId][actorId]
+	if !ok {
+		return 0, ErrNotChatMember
+	}
+	userRole, ok := m.members[chatId][userId]
+	if !ok {
+		return 0, ErrMemberNotFound
+	}
+	if err := checkRemove(actorRole, userRole, actorId == userId); err != nil {
+		return 0, err
+	}
+	delete(m.members[chatId], userId)
+	delete(m.lastRead[chatId], userId)
+	message := m.addMessage(actorId, chatId, "")
+	message.System = &SystemEvent{MemberRemoved, userId}
+	if actorId == userId {
+		message.System.Type = MemberLeft
+	}
+	return message.Id, nil
+}
+
+func (m *MemoryStorage) MarkChatRead(ctx context.Context, userId int64, chatId int64, messageId int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.members[chatId][userId]; !ok {
+		return 0, ErrNotChatMember
+	}
+	if messageId == 0 {
+		if messages := m.chatMessages[chatId]; len(messages) > 0 {
+			messageId = messages[len(messages)-1].Id
+		}
+	} else if message, ok := m.messages[messageId]; !ok || message.ChatId != chatId {
+		return 0, ErrMessageNotFound
+	}
+	if messageId > m.lastRead[chatId][userId] {
+		m.lastRead[chatId][userId] = messageId
+	}
+	return m.lastRead[chatId][userId], nil
+}
+
+func (m *MemoryStorage) SetChatMemberRole(ctx context.Context, actorId int64, chatId int64, userId int64,
+	role Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	actorRole, ok := m.members[chatId][actorId]
+	if !ok {
+		return ErrNotChatMember
+	}
+	userRole, ok := m.members[chatId][userId]
+	if !ok {
+		return ErrMemberNotFound
+	}
+	if err := checkRoleChange(actorRole, userRole, role); err != nil {
+		return err
+	}
+	m.members[chatId][userId] = role
+	return nil
+}
+
+func (m *MemoryStorage) TransferChatOwnership(ctx context.Context, ownerId int64, chatId int64, userId int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ownerRole, ok := m.members[chatId][ownerId]
+	if !ok {
+		return ErrNotChatMember
+	}
+	if err := checkPermission(ownerRole, PermissionTransferOwnership); err != nil {
+		return err
+	}
+	if _, ok := m.members[chatId][userId]; !ok {
+		return ErrMemberNotFound
+	}
+	if ownerId != userId {
+		m.members[chatId][ownerId] = RoleAdmin
+		m.members[chatId][userId] = RoleOwner
+	}
+	return nil
+}
+
+func (m *MemoryStorage) AddMessage(ctx context.Context, authorId int64, chatId int64, text string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	role, ok := m.members[chatId][authorId]
+	if !ok {
+		return 0, ErrNotChatMember
+	}
+	if err := checkPermission(role, PermissionPost); err != nil {
+		return 0, err
+	}
+	if !role.Can(PermissionManageSlowMode) {
+		if err := m.checkSlowMode(authorId, chatId); err != nil {
+			return 0, err
+		}
+	}
+	return m.addMessage(authorId, chatId, text).Id, nil
+}
+
+// checkSlowMode returns *SlowModeError if author has posted in chat with slow mode too recently.
+// It must be called with locked mu.
+func (m *MemoryStorage) checkSlowMode(authorId int64, chatId int64) error {
+	seconds := m.chats[chatId].SlowModeSeconds
+	if seconds == 0 {
+		return nil
+	}
+	messages := m.chatMessages[chatId]
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].AuthorId != authorId || messages[i].System != nil {
+			continue
+		}
+		if wait := time.Until(messages[i].CreatedAt.Add(time.Duration(seconds) * time.Second)); wait > 0 {
+			return &SlowModeError{RetryAfter: wait}
+		}
+		return nil
+	}
+	return nil
+}
+
+// addMessage must be called with locked mu.
+func (m *MemoryStorage) addMessage(authorId int64, chatId int64, text string) *Message {
+	m.lastMessageId++
+	message := &Message{
+		Id:        m.lastMessageId,
+		ChatId:    chatId,
+		AuthorId:  authorId,
+		Text:      text,
+		CreatedAt: time.Now(),
+	}
+	m.messages[message.Id] = message
+	m.chatMessages[chatId] = append(m.chatMessages[chatId], message)
+	return message
+}
+
+// GetMessage returns message by id or ErrNotFound.
+func (m *MemoryStorage) GetMessage(ctx context.Context, messageId int64) (*Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	message, ok := m.messages[messageId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *message
+	return &copied, nil
+}
+
+func (m *MemoryStorage) GetMessagesFromChat(ctx context.Context, chatId int64) ([]*Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return copyMessages(m.chatMessages[chatId]), nil
+}
+
+func (m *MemoryStorage) GetMessagesPage(ctx context.Context, chatId int64, query PageQuery) ([]*Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	messages := m.chatMessages[chatId]
+	if query.BeforeId > 0 {
+		end := sort.Search(len(messages), func(i int) bool {
+			return messages[i].Id >= query.BeforeId
+		})
+		start := end - query.Limit
+		if start < 0 {
+			start = 0
+		}
+		return copyMessages(messages[start:end]), nil
+	}
+	start := sort.Search(len(messages), func(i int) bool {
+		return messages[i].Id > query.AfterId
+	})
+	end := start + query.Limit
+	if end > len(messages) {
+		end = len(messages)
+	}
+	return copyMessages(messages[start:end]), nil
+}
+
+func (m *MemoryStorage) EditMessage(ctx context.Context, authorId int64, messageId int64, text string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	message, err := m.changeableMessage(messageId)
+	if err != nil {
+		return err
+	}
+	if message.AuthorId != authorId {
+		return ErrNotMessageAuthor
+	}
+	role, ok := m.members[message.ChatId][authorId]
+	if !ok {
+		return ErrNotChatMember
+	}
+	if err := checkPermission(role, PermissionPost); err != nil {
+		return err
+	}
+	editedAt := time.Now()
+	m.edits[messageId] = append(m.edits[messageId], &MessageEdit{
+		MessageId: messageId,
+		Text:      message.Text,
+		EditedAt:  editedAt,
+	})
+	message.Text = text
+	message.EditedAt = &editedAt
+	return nil
+}
+
+func (m *MemoryStorage) DeleteMessage(ctx context.Context, actorId int64, messageId int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	message, err := m.changeableMessage(messageId)
+	if err != nil {
+		return err
+	}
+	if message.AuthorId != actorId {
+		role, ok := m.members[message.ChatId][actorId]
+		if !ok || !role.Can(PermissionDeleteMessages) {
+			return ErrNotMessageAuthor
+		}
+	}
+	delete(m.edits, messageId)
+	message.Text = ""
+	message.Deleted = true
+	message.Pinned = false
+	return nil
+}
+
+func (m *MemoryStorage) PinMessage(ctx context.Context, actorId int64, messageId int64, pinned bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	message, ok := m.messages[messageId]
+	if !ok || message.Deleted {
+		return ErrMessageNotFound
+	}
+	role, ok := m.members[message.ChatId][actorId]
+	if !ok {
+		return ErrNotChatMember
+	}
+	if err := checkPermission(role, PermissionPinMessages); err != nil {
+		return err
+	}
+	message.Pinned = pinned
+	return nil
+}
+
+// changeableMessage returns message if it exists and isn't deleted.
+// System messages can't be changed by anyone.
+// It must be called with locked mu.
+func (m *MemoryStorage) changeableMessage(messageId int64) (*Message, error) {
+	message, ok := m.messages[messageId]
+	if !ok || message.Deleted {
+		return nil, ErrMessageNotFound
+	}
+	if message.System != nil {
+		return nil, ErrNotMessageAuthor
+	}
+	return message, nil
+}
+
+func (m *MemoryStorage) GetMessageEdits(ctx context.Context, messageId int64) ([]*MessageEdit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.messages[messageId]; !ok {
+		return nil, ErrMessageNotFound
+	}
+	edits := make([]*MessageEdit, len(m.edits[messageId]))
+	for i, edit := range m.edits[messageId] {
+		edit := *edit
+		edits[i] = &edit
+	}
+	return edits, nil
+}
+
+// GetMessageReaders returns ids of chat members, except author, that have read the message, sorted by id.
+func (m *MemoryStorage) GetMessageReaders(ctx context.Context, messageId int64) ([]int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	message, ok := m.messages[messageId]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	userIds := make([]int64, 0)
+	for _, userId := range m.chatUserIds(message.ChatId) {
+		if userId != message.AuthorId && m.lastRead[message.ChatId][userId] >= messageId {
+			userIds = append(userIds, userId)
+		}
+	}
+	return userIds, nil
+}
+
+// SearchMessages matches whole words, its ranking is number of matched words.
+// Snippet is the whole text of message.
+func (m *MemoryStorage) SearchMessages(ctx context.Context, userId int64, query SearchQuery) ([]*SearchResult, error) {
+	results := make([]*SearchResult, 0)
+	terms := searchTerms(query.Text)
+	if len(terms) == 0 {
+		return results, nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	matches := make(map[*SearchResult]int)
+	for chatId, members := range m.members {
+		if _, ok := members[userId]; !ok || query.ChatId != 0 && query.ChatId != chatId {
+			continue
+		}
+		for _, message := range m.chatMessages[chatId] {
+			if query.AuthorId != 0 && query.AuthorId != message.AuthorId ||
+				!query.From.IsZero() && message.CreatedAt.Before(query.From) ||
+				!query.To.IsZero() && !message.CreatedAt.Before(query.To) {
+				continue
+			}
+			snippet, count := highlightTerms(message.Text, terms)
+			if count == 0 {
+				continue
+			}
+			result := &SearchResult{Message: *message, Snippet: snippet}
+			results = append(results, result)
+			matches[result] = count
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if matches[results[i]] == matches[results[j]] {
+			return results[i].Id > results[j].Id
+		}
+		return matches[results[i]] > matches[results[j]]
+	})
+	if query.Offset >= len(results) {
+		return results[:0], nil
+	}
+	results = results[query.Offset:]
+	if query.Limit >= 0 && len(results) > query.Limit {
+		results = results[:query.Limit]
+	}
+	return results, nil
+}
+
+// copyMessages copies messages, so callers can't modify stored ones.
+func copyMessages(messages []*Message) []*Message {
+	copied := make([]*Message, len(messages))
+	for i, message := range messages {
+		message := *message
+		copied[i] = &message
+	}
+	return copied
+}
+
+func (m *MemoryStorage) AddWebhook(ctx context.Context, userId int64, chatId int64, url string, secret string,
+	events []string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if chatId != 0 {
+		role, ok := m.members[chatId][userId]
+		if !ok {
+			return 0, ErrNotChatMember
+		}
+		if err := checkPermission(role, PermissionManageWebhooks); err != nil {
+			return 0, err
+		}
+	}
+	if _, ok := m.users[userId]; !ok {
+		return 0, ErrUserNotFound
+	}
+	m.lastWebhookId++
+	m.webhooks[m.lastWebhookId] = &Webhook{
+		Id:        m.lastWebhookId,
+		UserId:    userId,
+		ChatId:    chatId,
+		URL:       url,
+		Secret:    secret,
+		Events:    append([]string{}, events...),
+		CreatedAt: time.Now(),
+	}
+	return m.lastWebhookId, nil
+}
+
+// GetUserWebhooks returns webhooks registered by the user ordered by id.
+func (m *MemoryStorage) GetUserWebhooks(ctx context.Context, userId int64) ([]*Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.filterWebhooks(func(webhook *Webhook) bool {
+		return webhook.UserId == userId
+	}), nil
+}
+
+func (m *MemoryStorage) DeleteWebhook(ctx context.Context, userId int64, webhookId int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	webhook, ok := m.webhooks[webhookId]
+	if !ok || webhook.UserId != userId {
+		return ErrWebhookNotFound
+	}
+	delete(m.webhooks, webhookId)
+	for id, delivery := range m.deliveries {
+		if delivery.WebhookId == webhookId {
+			delete(m.deliveries, id)
+		}
+	}
+	return nil
+}
+
+// GetChatWebhooks returns webhooks of the chat and global webhooks of its current members,
+// that are subscribed to event, ordered by id.
+func (m *MemoryStorage) GetChatWebhooks(ctx context.Context, chatId int64, event string) ([]*Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.filterWebhooks(func(webhook *Webhook) bool {
+		_, isMember := m.members[chatId][webhook.UserId]
+		return (webhook.ChatId == chatId || webhook.ChatId == 0 && isMember) && webhook.Wants(event)
+	}), nil
+}
+
+// filterWebhooks returns copies of webhooks that match ordered by id. It must be called with locked mu.
+func (m *MemoryStorage) filterWebhooks(match func(webhook *Webhook) bool) []*Webhook {
+	webhooks := make([]*Webhook, 0)
+	for _, webhook := range m.webhooks {
+		if match(webhook) {
+			webhook := *webhook
+			webhook.Events = append([]string{}, webhook.Events...)
+			webhooks = append(webhooks, &webhook)
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].Id < webhooks[j].Id
+	})
+	return webhooks
+}
+
+func (m *MemoryStorage) AddWebhookDelivery(ctx context.Context, webhookId int64, event string,
+	payload []byte) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.webhooks[webhookId]; !ok {
+		return 0, ErrWebhookNotFound
+	}
+	now := time.Now()
+	m.lastDeliveryId++
+	m.deliveries[m.lastDeliveryId] = &WebhookDelivery{
+		Id:            m.lastDeliveryId,
+		WebhookId:     webhookId,
+		Event:         event,
+		Payload:       append([]byte{}, payload...),
+		Status:        DeliveryPending,
+		NextAttemptAt: &now,
+		CreatedAt:     now,
+		Attempts:      make([]*WebhookAttempt, 0),
+	}
+	return m.lastDeliveryId, nil
+}
+
+// ClaimDueWebhookDeliveries returns at most limit pending deliveries which next attempt isn't later than now,
+// from the most overdue one, and postpones their next attempt to claimedUntil. Dispatchers of other instances
+// sharing database don't get claimed deliveries, and if claiming one dies before its attempt is recorded,
+// deliveries are due again after claimedUntil. Deliveries have URL and Secret of their webhooks.
+func (m *MemoryStorage) ClaimDueWebhookDeliveries(ctx context.Context, now time.Time, claimedUntil time.Time,
+	limit int) ([]*WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deliveries := make([]*WebhookDelivery, 0)
+	for _, delivery := range m.deliveries {
+		if delivery.Status == DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			due := copyDelivery(delivery)
+			due.Attempts = nil
+			due.URL = m.webhooks[delivery.WebhookId].URL
+			due.Secret = m.webhooks[delivery.WebhookId].Secret
+			deliveries = append(deliveries, due)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		if deliveries[i].NextAttemptAt.Equal(*deliveries[j].NextAttemptAt) {
+			return deliveries[i].Id < deliveries[j].Id
+		}
+		return deliveries[i].NextAttemptAt.Before(*deliveries[j].NextAttemptAt)
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	for _, delivery := range deliveries {
+		claimed := claimedUntil
+		m.deliveries[delivery.Id].NextAttemptAt = &claimed
+		delivery.NextAttemptAt = &claimedUntil
+	}
+	return deliveries, nil
+}
+
+func (m *MemoryStorage) AddWebhookAttempt(ctx context.Context, attempt *WebhookAttempt, status string,
+	nextAttemptAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delivery, ok := m.deliveries[attempt.DeliveryId]
+	if !ok {
+		return ErrNotFound
+	}
+	recorded := *attempt
+	delivery.Attempts = append(delivery.Attempts, &recorded)
+	delivery.AttemptCount++
+	delivery.Status = status
+	delivery.NextAttemptAt = nil
+	if status == DeliveryPending {
+		delivery.NextAttemptAt = &nextAttemptAt
+	}
+	return nil
+}
+
+// GetWebhookDeliveries returns at most limit latest deliveries of webhook with their attempts, from the latest one.
+// Empty status matches any status.
+func (m *MemoryStorage) GetWebhookDeliveries(ctx context.Context, userId int64, webhookId int64, status string,
+	limit int) ([]*WebhookDelivery, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	webhook, ok := m.webhooks[webhookId]
+	if !ok || webhook.UserId != userId {
+		return nil, ErrWebhookNotFound
+	}
+	deliveries := make([]*WebhookDelivery, 0)
+	for _, delivery := range m.deliveries {
+		if delivery.WebhookId == webhookId && (status == "" || delivery.Status == status) {
+			deliveries = append(deliveries, copyDelivery(delivery))
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].Id > deliveries[j].Id
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+// copyDelivery copies delivery with its attempts, so callers can't modify stored one.
+func copyDelivery(delivery *WebhookDelivery) *WebhookDelivery {
+	copied := *delivery
+	copied.Payload = append([]byte{}, delivery.Payload...)
+	if delivery.NextAttemptAt != nil {
+		next := *delivery.NextAttemptAt
+		copied.NextAttemptAt = &next
+	}
+	copied.Attempts = make([]*WebhookAttempt, len(delivery.Attempts))
+	for i, attempt := range delivery.Attempts {
+		attempt := *attempt
+		copied.Attempts[i] = &attempt
+	}
+	return &copied
+}
+
+// ReserveIdempotencyKey returns saved key if it was created after expiredBefore and, if it's still in progress,
+// after abandonedBefore. Otherwise it saves key as in progress and returns nil.
+func (m *MemoryStorage) ReserveIdempotencyKey(ctx context.Context, key *IdempotencyKey, expiredBefore time.Time,
+	abandonedBefore time.Time) (*IdempotencyKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	scope := idempotencyScope{key.UserId, key.Endpoint, key.Key}
+	stored, ok := m.idempotencyKeys[scope]
+	abandoned := ok && stored.Status == 0 && stored.CreatedAt.Before(abandonedBefore)
+	if ok && !stored.CreatedAt.Before(expiredBefore) && !abandoned {
+		copied := *stored
+		copied.Body = append([]byte{}, stored.Body...)
+		return &copied, nil
+	}
+	m.idempotencyKeys[scope] = &IdempotencyKey{
+		UserId:      key.UserId,
+		Endpoint:    key.Endpoint,
+		Key:         key.Key,
+		RequestHash: key.RequestHash,
+		Body:        []byte{},
+		CreatedAt:   time.Now(),
+	}
+	return nil, nil
+}
+
+func (m *MemoryStorage) CompleteIdempotencyKey(ctx context.Context, key *IdempotencyKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.idempotencyKeys[idempotencyScope{key.UserId, key.Endpoint, key.Key}]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Status = key.Status
+	stored.Body = append([]byte{}, key.Body...)
+	return nil
+}
+
+func (m *MemoryStorage) ReleaseIdempotencyKey(ctx context.Context, key *IdempotencyKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	scope := idempotencyScope{key.UserId, key.Endpoint, key.Key}
+	if stored, ok := m.idempotencyKeys[scope]; ok && stored.Status == 0 {
+		delete(m.idempotencyKeys, scope)
+	}
+	return nil
+}
+
+func (m *MemoryStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	for scope, stored := range m.idempotencyKeys {
+		if stored.CreatedAt.Before(expiredBefore) {
+			delete(m.idempotencyKeys, scope)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrSchemaTooNew is returned when database was migrated by newer version of binary
+// and current binary doesn't know how to work with it.
+var ErrSchemaTooNew = errors.New("database schema is newer than binary supports")
+
+// migrationLockKey is key of postgres advisory lock held by Up and Down. It is arbitrary, but must be the same
+// for all versions of binary.
+const migrationLockKey int64 = 4170113954
+
+// Migration is a single numbered step of schema evolution.
+// Up and Down are executed in transaction together with update of schema_migrations table.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState describes migration and whether it was applied to database.
+type MigrationState struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies Migrations to DB. Migrations must be sorted by version and versions must start with 1
+// and have no gaps. Bookkeeping statements are written for sqlite unless Postgres is set.
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+	Postgres   bool
+}
+
+// Latest returns version of the last known migration.
+func (m Migrator) Latest() int {
+	if len(m.Migrations) == 0 {
+		return 0
+	}
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+func (m Migrator) setup() error {
+	timeType := "DATETIME"
+	if m.Postgres {
+		timeType = "TIMESTAMP WITH TIME ZONE"
+	}
+	stmt := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+		    version INTEGER NOT NULL PRIMARY KEY,
+		    name TEXT NOT NULL,
+		    applied_at ` + timeType + ` NOT NULL
+		);
+	`
+	if _, err := m.DB.Exec(stmt); err != nil {
+		return fmt.Errorf("creating schema_migrations failed: %s", err)
+	}
+	return nil
+}
+
+// isSetUp reports whether schema_migrations table exists. It's created only by Up and Down,
+// so reading version, for example by readiness check, doesn't change database.
+func (m Migrator) isSetUp() (bool, error) {
+	stmt := `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`
+	if m.Postgres {
+		stmt = `SELECT COUNT(*) FROM pg_tables WHERE schemaname = current_schema() AND tablename = 'schema_migrations'`
+	}
+	var count int
+	if err := m.DB.QueryRow(stmt).Scan(&count); err != nil {
+		return false, fmt.Errorf("looking for schema_migrations failed: %s", err)
+	}
+	return count > 0, nil
+}
+
+// Version returns current version of database schema. Zero means that no migration was applied.
+func (m Migrator) Version() (int, error) {
+	isSetUp, err := m.isSetUp()
+	if err != nil || !isSetUp {
+		return 0, err
+	}
+	var version int
+	err = m.DB.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// Check returns ErrSchemaTooNew if database was migrated beyond known migrations.
+func (m Migrator) Check() error {
+	version, err := m.Version()
+	if err != nil {
+		return err
+	}
+	if version > m.Latest() {
+		return fmt.Errorf("%w: database version is %d, latest known is %d", ErrSchemaTooNew, version, m.Latest())
+	}
+	return nil
+}
+
+// Status returns state of every known migration.
+func (m Migrator) Status() ([]MigrationState, error) {
+	applied, err := m.appliedAt()
+	if err != nil {
+		return nil, err
+	}
+	states := make([]MigrationState, len(m.Migrations))
+	for i, migration := range m.Migrations {
+		appliedAt, ok := applied[migration.Version]
+		states[i] = MigrationState{
+			Migration: migration,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		}
+	}
+	return states, nil
+}
+
+// appliedAt returns time of application of every applied migration by its version.
+func (m Migrator) appliedAt() (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+	isSetUp, err := m.isSetUp()
+	if err != nil || !isSetUp {
+		return applied, err
+	}
+	rows, err := m.DB.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// Up applies at most steps pending migrations. If steps is not positive all pending migrations are applied.
+// It returns number of applied migrations.
+func (m Migrator) Up(steps int) (int, error) {
+	unlock, err := m.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	if err := m.setup(); err != nil {
+		return 0, err
+	}
+	if err := m.Check(); err != nil {
+		return 0, err
+	}
+	version, err := m.Version()
+	if err != nil {
+		return 0, err
+	}
+	applied := 0
+	for _, migration := range m.Migrations {
+		if migration.Version <= version {
+			continue
+		}
+		if steps > 0 && applied >= steps {
+			break
+		}
+		err := m.apply(migration.Up,
+			`INSERT INTO schema_migrations(version, name, applied_at) VALUES(?, ?, ?)`,
+			migration.Version, migration.Name, time.Now())
+		if err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed: %s", migration.Version, migration.Name, err)
+		}
+		applied++
+	}
+	return applied, nil
+}
+
+// Down reverts last steps applied migrations. It returns number of reverted migrations.
+func (m Migrator) Down(steps int) (int, error) {
+	unlock, err := m.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	if err := m.setup(); err != nil {
+		return 0, err
+	}
+	if err := m.Check(); err != nil {
+		return 0, err
+	}
+	version, err := m.Version()
+	if err != nil {
+		return 0, err
+	}
+	reverted := 0
+	for i := len(m.Migrations) - 1; i >= 0 && reverted < steps; i-- {
+		migration := m.Migrations[i]
+		if migration.Version > version {
+			continue
+		}
+		err := m.apply(migration.Down,
+			`DELETE FROM schema_migrations WHERE version = ?`,
+			migration.Version)
+		if err != nil {
+			return reverted, fmt.Errorf("revert of migration %d (%s) failed: %s", migration.Version, migration.Name, err)
+		}
+		reverted++
+	}
+	return reverted, nil
+}
+
+// lock makes concurrent migrators of postgres wait for each other, so servers started at once don't try
+// to create schema_migrations and apply the same migration together. Advisory lock belongs to session,
+// so it is held on dedicated connection until returned function is called. Sqlite isn't locked, writes
+// to its file are serialized anyway.
+func (m Migrator) lock() (func(), error) {
+	if !m.Postgres {
+		return func() {}, nil
+	}
+	ctx := context.Background()
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("taking migration lock failed: %s", err)
+	}
+	return func() {
+		conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+		conn.Close()
+	}, nil
+}
+
+// apply executes migration script and bookkeeping statement in single transaction.
+func (m Migrator) apply(script string, bookkeeping string, args ...interface{}) (err error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+	if _, err = tx.Exec(script); err != nil {
+		return
+	}
+	_, err = tx.Exec(m.rebind(bookkeeping), args...)
+	return
+}
+
+// rebind replaces ? placeholders of stmt with numbered ones if database is postgres.
+func (m Migrator) rebind(stmt string) string {
+	if !m.Postgres {
+		return stmt
+	}
+	parts := strings.Split(stmt, "?")
+	var builder strings.Builder
+	for i, part := range parts {
+		if i > 0 {
+			fmt.Fprintf(&builder, "$%d", i)
+		}
+		builder.WriteString(part)
+	}
+	return builder.String()
+}
@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openEmptyDb(t *testing.T) (*sql.DB, func()) {
+	tempFile, err := ioutil.TempFile("", "sql-db-test-migrate-")
+	if err != nil {
+		t.Fatal("Creation temp file for db failed: ", err)
+	}
+	tempFile.Close()
+	db, err := sql.Open("sqlite3", tempFile.Name())
+	if err != nil {
+		t.Fatal("Open db failed: ", err)
+	}
+	return db, func() {
+		db.Close()
+		os.Remove(tempFile.Name())
+	}
+}
+
+func isTableExists(t *testing.T, db *sql.DB, tableName string) bool {
+	var name string
+	err := db.QueryRow(`SELECT name FROM sqlite_master WHERE type = "table" AND name = ?`, tableName).Scan(&name)
+	isExist, err := isExistByError(err)
+	if err != nil {
+		t.Fatal("Query sqlite_master failed: ", err)
+	}
+	return isExist
+}
+
+var testMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "first",
+		Up:      `CREATE TABLE first (id INTEGER NOT NULL PRIMARY KEY);`,
+		Down:    `DROP TABLE first;`,
+	},
+	{
+		Version: 2,
+		Name:    "second",
+		Up:      `CREATE TABLE second (id INTEGER NOT NULL PRIMARY KEY);`,
+		Down:    `DROP TABLE second;`,
+	},
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	db, teardown := openEmptyDb(t)
+	defer teardown()
+	migrator := Migrator{DB: db, Migrations: testMigrations}
+
+	version, err := migrator.Version()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, version)
+	assert.NoError(t, migrator.Check())
+	states, err := migrator.Status()
+	assert.NoError(t, err)
+	if assert.Len(t, states, 2) {
+		assert.False(t, states[0].Applied)
+	}
+	assert.False(t, isTableExists(t, db, "schema_migrations"), "reading version must not change database")
+
+	applied, err := migrator.Up(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, applied)
+	assert.True(t, isTableExists(t, db, "first"))
+	assert.False(t, isTableExists(t, db, "second"))
+
+	applied, err = migrator.Up(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, applied)
+	assert.True(t, isTableExists(t, db, "second"))
+
+	states, err = migrator.Status()
+	assert.NoError(t, err)
+	if assert.Len(t, states, 2) {
+		assert.True(t, states[0].Applied)
+		assert.True(t, states[1].Applied)
+	}
+
+	reverted, err := migrator.Down(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reverted)
+	assert.False(t, isTableExists(t, db, "second"))
+	version, err = migrator.Version()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, version)
+
+	reverted, err = migrator.Down(5)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reverted)
+	assert.False(t, isTableExists(t, db, "first"))
+}
+
+func TestMigratorFailedMigrationRollsBack(t *testing.T) {
+	db, teardown := openEmptyDb(t)
+	defer teardown()
+	migrations := append([]Migration{}, testMigrations[0], Migration{
+		Version: 2,
+		Name:    "broken",
+		Up:      `CREATE TABLE broken (id INTEGER); INSERT INTO nonexistent VALUES (1);`,
+	})
+	migrator := Migrator{DB: db, Migrations: migrations}
+
+	applied, err := migrator.Up(0)
+	assert.Error(t, err)
+	assert.Equal(t, 1, applied)
+	assert.False(t, isTableExists(t, db, "broken"))
+	version, err := migrator.Version()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, version)
+}
+
+func TestMigratorRefusesNewerSchema(t *testing.T) {
+	db, teardown := openEmptyDb(t)
+	defer teardown()
+	migrator := Migrator{DB: db, Migrations: testMigrations}
+	if _, err := migrator.Up(0); err != nil {
+		t.Fatal("Up failed: ", err)
+	}
+	older := Migrator{DB: db, Migrations: testMigrations[:1]}
+
+	_, err := older.Up(0)
+	assert.True(t, errors.Is(err, ErrSchemaTooNew))
+	_, err = older.Down(1)
+	assert.True(t, errors.Is(err, ErrSchemaTooNew))
+}
+
+func TestMigrateAdoptsDatabaseWithoutVersionTable(t *testing.T) {
+	db, teardown := openEmptyDb(t)
+	defer teardown()
+	// database created by old binary that had schema, but no version table
+	if _, err := db.Exec(sqliteMigrations[0].Up); err != nil {
+		t.Fatal("Create legacy schema failed: ", err)
+	}
+	if _, err := db.Exec(`INSERT INTO users(username, created_at) VALUES ("old_user", "2019-01-01 10:00:00")`); err != nil {
+		t.Fatal("Insert failed: ", err)
+	}
+	sqlStorage := SqlStorage{db}
+	assert.NoError(t, sqlStorage.Migrate())
+
+	_, err := sqlStorage.AddUser(context.Background(), "old_user")
+	assert.Equal(t, ErrUserExists, err, "user must survive migration")
+	version, err := sqlStorage.Migrator().Version()
+	assert.NoError(t, err)
+	assert.Equal(t, sqlStorage.Migrator().Latest(), version)
+}
+
+func TestSqliteMigrationsRevertible(t *testing.T) {
+	ctx := context.Background()
+	db, teardown := openEmptyDb(t)
+	defer teardown()
+	sqlStorage := SqlStorage{db}
+	assert.NoError(t, sqlStorage.Migrate())
+	userId, err := sqlStorage.AddUser(ctx, "user_1")
+	assert.NoError(t, err)
+	chatId, err := sqlStorage.AddChat(ctx, userId, "chat_1", nil)
+	assert.NoError(t, err)
+	messageId, err := sqlStorage.AddMessage(ctx, userId, chatId, "Hello")
+	assert.NoError(t, err)
+	assert.NoError(t, sqlStorage.EditMessage(ctx, userId, messageId, "Hello, World"))
+	invitedId, err := sqlStorage.AddUser(ctx, "user_2")
+	assert.NoError(t, err)
+	_, err = sqlStorage.AddChatMember(ctx, userId, chatId, invitedId)
+	assert.NoError(t, err)
+	assert.NoError(t, sqlStorage.PinMessage(ctx, userId, messageId, true))
+	webhookId, err := sqlStorage.AddWebhook(ctx, userId, chatId, "http://localhost/hook", "secret", nil)
+	assert.NoError(t, err)
+	_, err = sqlStorage.AddWebhookDelivery(ctx, webhookId, WebhookMessageCreated, []byte(`{}`))
+	assert.NoError(t, err)
+	_, err = sqlStorage.ReserveIdempotencyKey(ctx, &IdempotencyKey{UserId: userId, Endpoint: "/messages/add", Key: "key"},
+		time.Now(), time.Now())
+	assert.NoError(t, err)
+
+	// system messages are removed with system_messages migration,
+	// other messages and members must survive reverting of later migrations
+	systemMessagesVersion := 5
+	migrator := sqlStorage.Migrator()
+	_, err = migrator.Down(migrator.Latest() - systemMessagesVersion + 1)
+	assert.NoError(t, err)
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM messages").Scan(&count))
+	assert.Equal(t, 1, count)
+	var text string
+	assert.NoError(t, db.QueryRow("SELECT text FROM messages WHERE id = ?", messageId).Scan(&text))
+	assert.Equal(t, "Hello, World", text)
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM users_chats WHERE chat_id = ?", chatId).Scan(&count))
+	assert.Equal(t, 2, count)
+
+	_, err = migrator.Down(0)
+	assert.NoError(t, err)
+	_, err = migrator.Up(0)
+	assert.NoError(t, err)
+}
+
+func TestReadReceiptsMigration(t *testing.T) {
+	ctx := context.Background()
+	db, teardown := openEmptyDb(t)
+	defer teardown()
+	sqlStorage := SqlStorage{db}
+	assert.NoError(t, sqlStorage.Migrate())
+	userIds := make([]int64, 2)
+	for i, username := range []string{"user_1", "user_2"} {
+		userId, err := sqlStorage.AddUser(ctx, username)
+		assert.NoError(t, err)
+		userIds[i] = userId
+	}
+	chatId, err := sqlStorage.AddChat(ctx, userIds[0], "chat_1", userIds[1:])
+	assert.NoError(t, err)
+	_, err = sqlStorage.AddMessage(ctx, userIds[0], chatId, "Hello")
+	assert.NoError(t, err)
+	messageId, err := sqlStorage.AddMessage(ctx, userIds[0], chatId, "Old news")
+	assert.NoError(t, err)
+
+	// history that existed before read receipts is read by everyone
+	migrator := sqlStorage.Migrator()
+	readReceiptsVersion := 7
+	_, err = migrator.Down(migrator.Latest() - readReceiptsVersion + 1)
+	assert.NoError(t, err)
+	_, err = migrator.Up(0)
+	assert.NoError(t, err)
+	chats, err := sqlStorage.GetUserChats(ctx, userIds[1])
+	if assert.NoError(t, err) && assert.Len(t, chats, 1) {
+		assert.Equal(t, messageId, chats[0].LastReadMessageId)
+		assert.Equal(t, int64(0), chats[0].UnreadCount)
+	}
+}
+
+func TestMigratorRebind(t *testing.T) {
+	stmt := `INSERT INTO schema_migrations(version, name, applied_at) VALUES(?, ?, ?)`
+	assert.Equal(t, stmt, Migrator{}.rebind(stmt))
+	assert.Equal(t, `INSERT INTO schema_migrations(version, name, applied_at) VALUES($1, $2, $3)`,
+		Migrator{Postgres: true}.rebind(stmt))
+}
@@ -0,0 +1,323 @@
+package storage
+
+// sqliteMigrations are applied to SqlStorage in order. Never change migration that was already released,
+// add new one instead.
+var sqliteMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial_schema",
+		// IF NOT EXISTS is kept, so databases created before migrations were introduced are adopted
+		Up: `
+			CREATE TABLE IF NOT EXISTS users (
+			    id INTEGER NOT NULL PRIMARY KEY,
+			    username TEXT NOT NULL UNIQUE,
+			    created_at DATETIME NOT NULL
+			);
+			CREATE TABLE IF NOT EXISTS chats (
+			    id INTEGER NOT NULL PRIMARY KEY,
+			    name TEXT NOT NULL UNIQUE,
+			    created_at DATETIME NOT NULL
+			);
+			CREATE TABLE IF NOT EXISTS users_chats (
+			    user_id INTEGER NOT NULL,
+			    chat_id INTEGER NOT NULL,
+			    FOREIGN KEY (user_id) REFERENCES users (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    FOREIGN KEY (chat_id) REFERENCES chats (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    PRIMARY KEY (user_id, chat_id)
+			);
+			CREATE TABLE IF NOT EXISTS messages (
+			    id INTEGER NOT NULL PRIMARY KEY,
+			    chat_id INTEGER NOT NULL,
+			    author_id INTEGER NOT NULL,
+			    text TEXT,
+			    created_at DATETIME NOT NULL,
+			    FOREIGN KEY (chat_id) REFERENCES chats (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    FOREIGN KEY (author_id) REFERENCES users (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE
+			);
+		`,
+		Down: `
+			DROP TABLE messages;
+			DROP TABLE users_chats;
+			DROP TABLE chats;
+			DROP TABLE users;
+		`,
+	},
+	{
+		Version: 2,
+		Name:    "messages_chat_id_index",
+		Up:      `CREATE INDEX messages_chat_id_id ON messages (chat_id, id);`,
+		Down:    `DROP INDEX messages_chat_id_id;`,
+	},
+	{
+		Version: 3,
+		Name:    "user_tokens",
+		Up: `
+			CREATE TABLE user_tokens (
+			    token_hash TEXT NOT NULL PRIMARY KEY,
+			    user_id INTEGER NOT NULL,
+			    created_at DATETIME NOT NULL,
+			    FOREIGN KEY (user_id) REFERENCES users (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE
+			);
+		`,
+		Down: `DROP TABLE user_tokens;`,
+	},
+	{
+		Version: 4,
+		Name:    "message_edits",
+		Up: `
+			ALTER TABLE messages ADD COLUMN edited_at DATETIME;
+			ALTER TABLE messages ADD COLUMN deleted BOOLEAN NOT NULL DEFAULT 0;
+			CREATE TABLE message_edits (
+			    id INTEGER NOT NULL PRIMARY KEY,
+			    message_id INTEGER NOT NULL,
+			    text TEXT NOT NULL,
+			    edited_at DATETIME NOT NULL,
+			    FOREIGN KEY (message_id) REFERENCES messages (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE
+			);
+			CREATE INDEX message_edits_message_id ON message_edits (message_id, id);
+		`,
+		// sqlite can't drop columns, so messages table is recreated
+		Down: `
+			DROP TABLE message_edits;
+			CREATE TABLE messages_without_edits (
+			    id INTEGER NOT NULL PRIMARY KEY,
+			    chat_id INTEGER NOT NULL,
+			    author_id INTEGER NOT NULL,
+			    text TEXT,
+			    created_at DATETIME NOT NULL,
+			    FOREIGN KEY (chat_id) REFERENCES chats (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    FOREIGN KEY (author_id) REFERENCES users (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE
+			);
+			INSERT INTO messages_without_edits SELECT id, chat_id, author_id, text, created_at FROM messages;
+			DROP TABLE messages;
+			ALTER TABLE messages_without_edits RENAME TO messages;
+			CREATE INDEX messages_chat_id_id ON messages (chat_id, id);
+		`,
+	},
+	{
+		Version: 5,
+		Name:    "system_messages",
+		Up: `
+			CREATE TABLE system_messages (
+			    message_id INTEGER NOT NULL PRIMARY KEY,
+			    type TEXT NOT NULL,
+			    user_id INTEGER NOT NULL,
+			    FOREIGN KEY (message_id) REFERENCES messages (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    FOREIGN KEY (user_id) REFERENCES users (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE
+			);
+		`,
+		Down: `
+			DELETE FROM messages WHERE id IN (SELECT message_id FROM system_messages);
+			DROP TABLE system_messages;
+		`,
+	},
+	{
+		Version: 6,
+		Name:    "chat_roles",
+		// creators of existing chats weren't recorded, so the earliest added member becomes owner
+		Up: `
+			ALTER TABLE users_chats ADD COLUMN role TEXT NOT NULL DEFAULT 'member';
+			UPDATE users_chats SET role = 'owner'
+			    WHERE rowid IN (SELECT MIN(rowid) FROM users_chats GROUP BY chat_id);
+			CREATE TABLE pinned_messages (
+			    message_id INTEGER NOT NULL PRIMARY KEY,
+			    pinned_by INTEGER NOT NULL,
+			    pinned_at DATETIME NOT NULL,
+			    FOREIGN KEY (message_id) REFERENCES messages (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    FOREIGN KEY (pinned_by) REFERENCES users (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE
+			);
+		`,
+		// sqlite can't drop columns, so users_chats table is recreated
+		Down: `
+			DROP TABLE pinned_messages;
+			CREATE TABLE users_chats_without_roles (
+			    user_id INTEGER NOT NULL,
+			    chat_id INTEGER NOT NULL,
+			    FOREIGN KEY (user_id) REFERENCES users (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    FOREIGN KEY (chat_id) REFERENCES chats (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    PRIMARY KEY (user_id, chat_id)
+			);
+			INSERT INTO users_chats_without_roles SELECT user_id, chat_id FROM users_chats;
+			DROP TABLE users_chats;
+			ALTER TABLE users_chats_without_roles RENAME TO users_chats;
+		`,
+	},
+	{
+		Version: 7,
+		Name:    "read_receipts",
+		// existing history is considered read, so users don't get all of it as unread after upgrade
+		Up: `
+			ALTER TABLE users_chats ADD COLUMN last_read_message_id INTEGER NOT NULL DEFAULT 0;
+			UPDATE users_chats SET last_read_message_id =
+			    (SELECT COALESCE(MAX(id), 0) FROM messages WHERE messages.chat_id = users_chats.chat_id);
+		`,
+		// sqlite can't drop columns, so users_chats table is recreated
+		Down: `
+			CREATE TABLE users_chats_without_reads (
+			    user_id INTEGER NOT NULL,
+			    chat_id INTEGER NOT NULL,
+			    role TEXT NOT NULL DEFAULT 'member',
+			    FOREIGN KEY (user_id) REFERENCES users (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    FOREIGN KEY (chat_id) REFERENCES chats (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    PRIMARY KEY (user_id, chat_id)
+			);
+			INSERT INTO users_chats_without_reads SELECT user_id, chat_id, role FROM users_chats;
+			DROP TABLE users_chats;
+			ALTER TABLE users_chats_without_reads RENAME TO users_chats;
+		`,
+	},
+	{
+		Version: 8,
+		Name:    "chat_last_messages",
+		// summary isn't kept in columns of chats, because Down couldn't remove them: bundled sqlite 3.29 has no
+		// DROP COLUMN, and recreating chats deletes all members and messages through ON DELETE CASCADE, since
+		// foreign keys can't be turned off inside transaction of migration. Other tables recreated by Down
+		// aren't referenced with cascading deletes, chats is. Summary is read with one join by primary key.
+		Up: `
+			CREATE TABLE chat_last_messages (
+			    chat_id INTEGER NOT NULL PRIMARY KEY,
+			    message_id INTEGER NOT NULL,
+			    created_at DATETIME NOT NULL,
+			    FOREIGN KEY (chat_id) REFERENCES chats (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    FOREIGN KEY (message_id) REFERENCES messages (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE
+			);
+			INSERT INTO chat_last_messages(chat_id, message_id, created_at)
+			    SELECT messages.chat_id, messages.id, messages.created_at FROM messages
+			    WHERE messages.id IN (SELECT MAX(id) FROM messages GROUP BY chat_id);
+			CREATE INDEX users_chats_chat_id ON users_chats (chat_id, user_id);
+		`,
+		Down: `
+			DROP INDEX users_chats_chat_id;
+			DROP TABLE chat_last_messages;
+		`,
+	},
+	{
+		Version: 9,
+		Name:    "webhooks",
+		// chat_id is NULL for global webhooks, events is comma separated list (empty for all events)
+		Up: `
+			CREATE TABLE webhooks (
+			    id INTEGER NOT NULL PRIMARY KEY,
+			    user_id INTEGER NOT NULL,
+			    chat_id INTEGER,
+			    url TEXT NOT NULL,
+			    secret TEXT NOT NULL,
+			    events TEXT NOT NULL,
+			    created_at DATETIME NOT NULL,
+			    FOREIGN KEY (user_id) REFERENCES users (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    FOREIGN KEY (chat_id) REFERENCES chats (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE
+			);
+			CREATE INDEX webhooks_chat_id ON webhooks (chat_id);
+			CREATE INDEX webhooks_user_id ON webhooks (user_id);
+			CREATE TABLE webhook_deliveries (
+			    id INTEGER NOT NULL PRIMARY KEY,
+			    webhook_id INTEGER NOT NULL,
+			    event TEXT NOT NULL,
+			    payload TEXT NOT NULL,
+			    status TEXT NOT NULL,
+			    attempt_count INTEGER NOT NULL DEFAULT 0,
+			    next_attempt_at DATETIME,
+			    created_at DATETIME NOT NULL,
+			    FOREIGN KEY (webhook_id) REFERENCES webhooks (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE
+			);
+			CREATE INDEX webhook_deliveries_status ON webhook_deliveries (status, next_attempt_at);
+			CREATE INDEX webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id, id);
+			CREATE TABLE webhook_attempts (
+			    id INTEGER NOT NULL PRIMARY KEY,
+			    delivery_id INTEGER NOT NULL,
+			    attempted_at DATETIME NOT NULL,
+			    status_code INTEGER NOT NULL,
+			    error TEXT NOT NULL,
+			    FOREIGN KEY (delivery_id) REFERENCES webhook_deliveries (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE
+			);
+			CREATE INDEX webhook_attempts_delivery_id ON webhook_attempts (delivery_id, id);
+		`,
+		Down: `
+			DROP TABLE webhook_attempts;
+			DROP TABLE webhook_deliveries;
+			DROP TABLE webhooks;
+		`,
+	},
+	{
+		Version: 10,
+		Name:    "idempotency_keys",
+		// user_id is 0 for requests without authentication, status is 0 while request is in progress
+		Up: `
+			CREATE TABLE idempotency_keys (
+			    user_id INTEGER NOT NULL,
+			    endpoint TEXT NOT NULL,
+			    idempotency_key TEXT NOT NULL,
+			    request_hash TEXT NOT NULL,
+			    status INTEGER NOT NULL,
+			    body BLOB NOT NULL,
+			    created_at DATETIME NOT NULL,
+			    PRIMARY KEY (user_id, endpoint, idempotency_key)
+			);
+			CREATE INDEX idempotency_keys_created_at ON idempotency_keys (created_at);
+		`,
+		Down: `
+			DROP TABLE idempotency_keys;
+		`,
+	},
+	{
+		Version: 11,
+		Name:    "chat_slow_modes",
+		// chats without slow mode have no row
+		Up: `
+			CREATE TABLE chat_slow_modes (
+			    chat_id INTEGER NOT NULL PRIMARY KEY,
+			    seconds INTEGER NOT NULL,
+			    FOREIGN KEY (chat_id) REFERENCES chats (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE
+			);
+		`,
+		Down: `
+			DROP TABLE chat_slow_modes;
+		`,
+	},
+}
@@ -0,0 +1,1178 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgresStorage keeps data in PostgreSQL. Its transactions don't rely on single process, so instances that
+// briefly overlap during restart don't break data, but server itself supports only one running instance.
+// It behaves exactly like SqlStorage, but has its own schema and migrations.
+type PostgresStorage struct {
+	*sql.DB
+}
+
+// OpenPostgres opens connection pool to postgres database, dsn is either URL or list of key=value settings,
+// for example "host=localhost dbname=avito_exercise sslmode=disable".
+func OpenPostgres(dsn string) (PostgresStorage, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return PostgresStorage{}, err
+	}
+	return PostgresStorage{db}, nil
+}
+
+// Migrator returns Migrator with all schema migrations of PostgresStorage.
+func (db PostgresStorage) Migrator() Migrator {
+	return Migrator{DB: db.DB, Migrations: postgresMigrations, Postgres: true}
+}
+
+// Migrate applies all pending migrations. It refuses to work with database that is newer than binary.
+func (db PostgresStorage) Migrate() error {
+	if _, err := db.Migrator().Up(0); err != nil {
+		return fmt.Errorf("Migrate failed: %w", err)
+	}
+	return nil
+}
+
+// CheckReady returns error if database doesn't respond or its schema isn't at the latest known version.
+func (db PostgresStorage) CheckReady() error {
+	return checkReady(db.DB, db.Migrator())
+}
+
+// Codes of postgres errors, see appendix "PostgreSQL Error Codes" of its documentation.
+const (
+	pgForeignKeyViolation = "23503"
+	pgUniqueViolation     = "23505"
+	pgQueryCanceled       = "57014"
+)
+
+// isPostgresError reports whether err is postgres error with code.
+func isPostgresError(err error, code pq.ErrorCode) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == code
+}
+
+// contextError replaces *err with error of ctx if query was cancelled because ctx is done.
+// Such queries fail with query_canceled error of postgres, which can't be told from other failures otherwise.
+func contextError(ctx context.Context, err *error) {
+	if isPostgresError(*err, pgQueryCanceled) && ctx.Err() != nil {
+		*err = ctx.Err()
+	}
+}
+
+// inTransaction is unit of work of PostgresStorage, it works as SqlStorage.inTransaction.
+func (db PostgresStorage) inTransaction(ctx context.Context, work func(tx *sql.Tx) error) error {
+	return SqlStorage{db.DB}.inTransaction(ctx, work)
+}
+
+// rowQueryer is implemented by both *sql.DB and *sql.Tx.
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (db PostgresStorage) AreUsersExistByIds(ctx context.Context, userIds []int64) (_ bool, err error) {
+	defer contextError(ctx, &err)
+	var numberOfRows int
+	err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE id = ANY($1)",
+		pq.Array(userIds)).Scan(&numberOfRows)
+	if err != nil {
+		return false, err
+	}
+	return numberOfRows == len(userIds), nil
+}
+
+func (db PostgresStorage) AddUser(ctx context.Context, username string) (_ int64, err error) {
+	defer contextError(ctx, &err)
+	return pgInsertUser(ctx, db, username)
+}
+
+func (db PostgresStorage) AddUserToken(ctx context.Context, userId int64, tokenHash string) (err error) {
+	defer contextError(ctx, &err)
+	return pgInsertUserToken(ctx, db, userId, tokenHash)
+}
+
+// AddUserWithToken creates user together with its first token, so user can't be left without token.
+func (db PostgresStorage) AddUserWithToken(ctx context.Context, username string, tokenHash string) (userId int64,
+	err error) {
+	defer contextError(ctx, &err)
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		if userId, err = pgInsertUser(ctx, tx, username); err != nil {
+			return
+		}
+		return pgInsertUserToken(ctx, tx, userId, tokenHash)
+	})
+	return
+}
+
+// pgInsertUser returns ErrUserExists if username is taken.
+func pgInsertUser(ctx context.Context, db rowQueryer, username string) (int64, error) {
+	var userId int64
+	err := db.QueryRowContext(ctx, "INSERT INTO users(username, created_at) VALUES($1, $2) RETURNING id",
+		username, time.Now()).Scan(&userId)
+	if isPostgresError(err, pgUniqueViolation) {
+		return 0, ErrUserExists
+	}
+	return userId, err
+}
+
+func pgInsertUserToken(ctx context.Context, db execer, userId int64, tokenHash string) error {
+	_, err := db.ExecContext(ctx, "INSERT INTO user_tokens(token_hash, user_id, created_at) VALUES($1, $2, $3)",
+		tokenHash, userId, time.Now())
+	switch {
+	case isPostgresError(err, pgUniqueViolation):
+		return ErrTokenExists
+	case isPostgresError(err, pgForeignKeyViolation):
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// GetUserIdByToken returns id of token owner or ErrNotFound.
+func (db PostgresStorage) GetUserIdByToken(ctx context.Context, tokenHash string) (_ int64, err error) {
+	defer contextError(ctx, &err)
+	var userId int64
+	err = db.QueryRowContext(ctx, "SELECT user_id FROM user_tokens WHERE token_hash = $1", tokenHash).Scan(&userId)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return userId, err
+}
+
+// GetUserChats returns chats of the user from the latest to the earliest by time of the last message
+// (or creation time of the chat if it is empty). It makes two queries regardless of number of chats.
+func (db PostgresStorage) GetUserChats(ctx context.Context, userId int64) (_ []*Chat, err error) {
+	defer contextError(ctx, &err)
+	// chats without messages have no id of the last message, they go after chats created at the same time
+	stmt := `SELECT chats.id, chats.name, chats.created_at, users_chats.last_read_message_id,
+			(SELECT COUNT(*) FROM messages WHERE messages.chat_id = chats.id
+			    AND messages.id > users_chats.last_read_message_id
+			    AND messages.author_id != users_chats.user_id AND NOT messages.deleted),
+			(SELECT COALESCE(STRING_AGG(members.user_id::TEXT, ','), '') FROM users_chats AS members
+			    WHERE members.chat_id = chats.id),
+			chat_last_messages.created_at, COALESCE(chat_slow_modes.seconds, 0)
+		FROM users_chats
+		INNER JOIN chats ON users_chats.chat_id = chats.id
+		LEFT JOIN chat_last_messages ON chat_last_messages.chat_id = chats.id
+		LEFT JOIN chat_slow_modes ON chat_slow_modes.chat_id = chats.id
+		WHERE users_chats.user_id = $1
+		ORDER BY COALESCE(chat_last_messages.created_at, chats.created_at) DESC,
+			chat_last_messages.message_id DESC NULLS LAST, chats.id DESC`
+	rows, err := db.QueryContext(ctx, stmt, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	chats := make([]*Chat, 0)
+	chatById := make(map[int64]*Chat)
+	for rows.Next() {
+		chat := &Chat{}
+		var userIds string
+		err := rows.Scan(&chat.Id, &chat.Name, &chat.CreatedAt, &chat.LastReadMessageId, &chat.UnreadCount,
+			&userIds, &chat.LastMessageAt, &chat.SlowModeSeconds)
+		if err != nil {
+			return nil, err
+		}
+		if chat.UserIds, err = parseIds(userIds); err != nil {
+			return nil, err
+		}
+		chats = append(chats, chat)
+		chatById[chat.Id] = chat
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.QueryContext(ctx, messageSelect+` WHERE messages.id IN (SELECT chat_last_messages.message_id
+		FROM users_chats INNER JOIN chat_last_messages ON chat_last_messages.chat_id = users_chats.chat_id
+		WHERE users_chats.user_id = $1)`, userId)
+	if err != nil {
+		return nil, err
+	}
+	lastMessages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, message := range lastMessages {
+		if chat, ok := chatById[message.ChatId]; ok {
+			chat.LastMessage = message
+		}
+	}
+	return chats, nil
+}
+
+func (db PostgresStorage) GetChatUserIds(ctx context.Context, chatId int64) (_ []int64, err error) {
+	defer contextError(ctx, &err)
+	rows, err := db.QueryContext(ctx, "SELECT user_id FROM users_chats WHERE chat_id = $1 ORDER BY user_id", chatId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	userIds := make([]int64, 0)
+	for rows.Next() {
+		var userId int64
+		if err := rows.Scan(&userId); err != nil {
+			return nil, err
+		}
+		userIds = append(userIds, userId)
+	}
+	return userIds, rows.Err()
+}
+
+// AddChat creates chat where owner has RoleOwner and other members have RoleMember.
+// MemberIds must not contain owner.
+func (db PostgresStorage) AddChat(ctx context.Context, ownerId int64, chatName string,
+	memberIds []int64) (chatId int64, err error) {
+	defer contextError(ctx, &err)
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		err = tx.QueryRowContext(ctx, "INSERT INTO chats(name, created_at) VALUES($1, $2) RETURNING id",
+			chatName, time.Now()).Scan(&chatId)
+		if isPostgresError(err, pgUniqueViolation) {
+			err = ErrChatExists
+		}
+		if err != nil {
+			return
+		}
+		for _, userId := range append([]int64{ownerId}, memberIds...) {
+			role := RoleMember
+			if userId == ownerId {
+				role = RoleOwner
+			}
+			_, err = tx.ExecContext(ctx, "INSERT INTO users_chats(user_id, chat_id, role) VALUES($1, $2, $3)",
+				userId, chatId, role)
+			if isPostgresError(err, pgForeignKeyViolation) {
+				err = ErrUserNotFound
+			}
+			if err != nil {
+				return
+			}
+		}
+		return
+	})
+	return
+}
+
+// RenameChat changes name of chat. It returns ErrChatExists if name is taken by another chat.
+func (db PostgresStorage) RenameChat(ctx context.Context, actorId int64, chatId int64, chatName string) (err error) {
+	defer contextError(ctx, &err)
+	return db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		role, err := pgMemberRole(ctx, tx, actorId, chatId)
+		if err != nil {
+			return
+		}
+		if err = checkPermission(role, PermissionRenameChat); err != nil {
+			return
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE chats SET name = $1 WHERE id = $2", chatName, chatId)
+		if isPostgresError(err, pgUniqueViolation) {
+			err = ErrChatExists
+		}
+		return
+	})
+}
+
+// SetChatSlowMode sets minimal interval between messages of one member in chat, zero turns slow mode off.
+func (db PostgresStorage) SetChatSlowMode(ctx context.Context, actorId int64, chatId int64, seconds int) (err error) {
+	defer contextError(ctx, &err)
+	return db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		role, err := pgMemberRole(ctx, tx, actorId, chatId)
+		if err != nil {
+			return
+		}
+		if err = checkPermission(role, PermissionManageSlowMode); err != nil {
+			return
+		}
+		if seconds == 0 {
+			_, err = tx.ExecContext(ctx, "DELETE FROM chat_slow_modes WHERE chat_id = $1", chatId)
+			return
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO chat_slow_modes(chat_id, seconds) VALUES($1, $2)
+			ON CONFLICT (chat_id) DO UPDATE SET seconds = EXCLUDED.seconds`, chatId, seconds)
+		return
+	})
+}
+
+func (db PostgresStorage) IsUserInChat(ctx context.Context, userId int64, chatId int64) (_ bool, err error) {
+	defer contextError(ctx, &err)
+	err = db.QueryRowContext(ctx, "SELECT user_id FROM users_chats WHERE user_id = $1 AND chat_id = $2",
+		userId, chatId).Scan(&userId)
+	return isExistByError(err)
+}
+
+// GetChatMembers returns members of the chat with their roles sorted by user id.
+func (db PostgresStorage) GetChatMembers(ctx context.Context, chatId int64) (_ []*ChatMember, err error) {
+	defer contextError(ctx, &err)
+	rows, err := db.QueryContext(ctx, "SELECT user_id, role FROM users_chats WHERE chat_id = $1 ORDER BY user_id",
+		chatId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := make([]*ChatMember, 0)
+	for rows.Next() {
+		member := &ChatMember{}
+		if err := rows.Scan(&member.UserId, &member.Role); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// AddChatMember adds user to chat and records it with system message, which id is returned.
+// Actor must have PermissionInvite, new member gets RoleMember. History before the system message is read for him.
+func (db PostgresStorage) AddChatMember(ctx context.Context, actorId int64, chatId int64,
+	userId int64) (messageId int64, err error) {
+	defer contextError(ctx, &err)
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		role, err := pgMemberRole(ctx, tx, actorId, chatId)
+		if err != nil {
+			return
+		}
+		if err = checkPermission(role, PermissionInvite); err != nil {
+			return
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO users_chats(user_id, chat_id, role) VALUES($1, $2, $3)",
+			userId, chatId, RoleMember)
+		switch {
+		case isPostgresError(err, pgUniqueViolation):
+			err = ErrMemberExists
+			return
+		case isPostgresError(err, pgForeignKeyViolation):
+			err = ErrUserNotFound
+			return
+		case err != nil:
+			return
+		}
+		if messageId, err = pgAddSystemMessage(ctx, tx, actorId, chatId, SystemEvent{MemberAdded, userId}); err != nil {
+			return
+		}
+		_, err = tx.ExecContext(ctx,
+			"UPDATE users_chats SET last_read_message_id = $1 WHERE user_id = $2 AND chat_id = $3",
+			messageId, userId, chatId)
+		return
+	})
+	return
+}
+
+// RemoveChatMember removes user from chat and records it with system message, which id is returned.
+// If actor removes himself, he leaves the chat, otherwise he must have PermissionRemoveMembers
+// and outrank removed member.
+func (db PostgresStorage) RemoveChatMember(ctx context.Context, actorId int64, chatId int64,
+	userId int64) (messageId int64, err error) {
+	defer contextError(ctx, &err)
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		if err = pgLockMembers(ctx, tx, chatId, actorId, userId); err != nil {
+			return
+		}
+		actorRole, err := pgMemberRole(ctx, tx, actorId, chatId)
+		if err != nil {
+			return
+		}
+		userRole, err := pgMemberRole(ctx, tx, userId, chatId)
+		if err == ErrNotChatMember {
+			err = ErrMemberNotFound
+		}
+		if err != nil {
+			return
+		}
+		if err = checkRemove(actorRole, userRole, actorId == userId); err != nil {
+			return
+		}
+		if _, err = tx.ExecContext(ctx, "DELETE FROM users_chats WHERE user_id = $1 AND chat_id = $2",
+			userId, chatId); err != nil {
+			return
+		}
+		event := SystemEvent{MemberRemoved, userId}
+		if actorId == userId {
+			event.Type = MemberLeft
+		}
+		messageId, err = pgAddSystemMessage(ctx, tx, actorId, chatId, event)
+		return
+	})
+	return
+}
+
+// MarkChatRead moves last read message of user in the chat forward to message and returns
+// resulting last read message id. It never moves backward. Zero messageId marks the whole chat read.
+// It returns ErrMessageNotFound if message isn't in the chat.
+func (db PostgresStorage) MarkChatRead(ctx context.Context, userId int64, chatId int64,
+	messageId int64) (lastReadId int64, err error) {
+	defer contextError(ctx, &err)
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		if _, err = pgMemberRole(ctx, tx, userId, chatId); err != nil {
+			return
+		}
+		if messageId == 0 {
+			err = tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM messages WHERE chat_id = $1",
+				chatId).Scan(&messageId)
+		} else {
+			err = tx.QueryRowContext(ctx, "SELECT id FROM messages WHERE id = $1 AND chat_id = $2",
+				messageId, chatId).Scan(&messageId)
+			if err == sql.ErrNoRows {
+				err = ErrMessageNotFound
+			}
+		}
+		if err != nil {
+			return
+		}
+		err = tx.QueryRowContext(ctx, `UPDATE users_chats
+			SET last_read_message_id = GREATEST(last_read_message_id, $1)
+			WHERE user_id = $2 AND chat_id = $3 RETURNING last_read_message_id`,
+			messageId, userId, chatId).Scan(&lastReadId)
+		return
+	})
+	return
+}
+
+// SetChatMemberRole gives role to member of the chat. Actor must have PermissionManageRoles
+// and outrank both current and new role of member. Owner can't be appointed, see TransferChatOwnership.
+func (db PostgresStorage) SetChatMemberRole(ctx context.Context, actorId int64, chatId int64, userId int64,
+	role Role) (err error) {
+	defer contextError(ctx, &err)
+	return db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		if err = pgLockMembers(ctx, tx, chatId, actorId, userId); err != nil {
+			return
+		}
+		actorRole, err := pgMemberRole(ctx, tx, actorId, chatId)
+		if err != nil {
+			return
+		}
+		userRole, err := pgMemberRole(ctx, tx, userId, chatId)
+		if err == ErrNotChatMember {
+			err = ErrMemberNotFound
+		}
+		if err != nil {
+			return
+		}
+		if err = checkRoleChange(actorRole, userRole, role); err != nil {
+			return
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE users_chats SET role = $1 WHERE user_id = $2 AND chat_id = $3",
+			role, userId, chatId)
+		return
+	})
+}
+
+// TransferChatOwnership makes member owner of the chat, previous owner becomes admin.
+func (db PostgresStorage) TransferChatOwnership(ctx context.Context, ownerId int64, chatId int64,
+	userId int64) (err error) {
+	defer contextError(ctx, &err)
+	return db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		if err = pgLockMembers(ctx, tx, chatId, ownerId, userId); err != nil {
+			return
+		}
+		ownerRole, err := pgMemberRole(ctx, tx, ownerId, chatId)
+		if err != nil {
+			return
+		}
+		if err = checkPermission(ownerRole, PermissionTransferOwnership); err != nil {
+			return
+		}
+		_, err = pgMemberRole(ctx, tx, userId, chatId)
+		if err == ErrNotChatMember {
+			err = ErrMemberNotFound
+		}
+		if err != nil || ownerId == userId {
+			return
+		}
+		stmt := "UPDATE users_chats SET role = $1 WHERE user_id = $2 AND chat_id = $3"
+		if _, err = tx.ExecContext(ctx, stmt, RoleAdmin, ownerId, chatId); err != nil {
+			return
+		}
+		_, err = tx.ExecContext(ctx, stmt, RoleOwner, userId, chatId)
+		return
+	})
+}
+
+// pgMemberRole returns role of user in the chat or ErrNotChatMember. Membership is locked until the end
+// of transaction, so role can't be changed concurrently after it has been checked.
+func pgMemberRole(ctx context.Context, tx *sql.Tx, userId int64, chatId int64) (Role, error) {
+	var role Role
+	err := tx.QueryRowContext(ctx, "SELECT role FROM users_chats WHERE user_id = $1 AND chat_id = $2 FOR UPDATE",
+		userId, chatId).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrNotChatMember
+	}
+	return role, err
+}
+
+// pgLockMembers locks memberships of both users in the chat in order of their ids. Transactions that
+// check roles of two members must lock them first, otherwise they can deadlock locking them in opposite order.
+func pgLockMembers(ctx context.Context, tx *sql.Tx, chatId int64, firstId int64, secondId int64) error {
+	_, err := tx.ExecContext(ctx, `SELECT 1 FROM users_chats WHERE chat_id = $1 AND user_id IN ($2, $3)
+		ORDER BY user_id FOR UPDATE`, chatId, firstId, secondId)
+	return err
+}
+
+func pgAddSystemMessage(ctx context.Context, tx *sql.Tx, authorId int64, chatId int64,
+	event SystemEvent) (int64, error) {
+	messageId, err := pgInsertMessage(ctx, tx, authorId, chatId, "")
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO system_messages(message_id, type, user_id) VALUES($1, $2, $3)",
+		messageId, event.Type, event.UserId)
+	return messageId, err
+}
+
+// AddMessage adds message to chat and makes it the last message of the chat.
+func (db PostgresStorage) AddMessage(ctx context.Context, authorId int64, chatId int64,
+	text string) (messageId int64, err error) {
+	defer contextError(ctx, &err)
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		// membership of author is locked, so his concurrent messages can't pass slow mode check together
+		role, err := pgMemberRole(ctx, tx, authorId, chatId)
+		if err != nil {
+			return
+		}
+		if err = checkPermission(role, PermissionPost); err != nil {
+			return
+		}
+		if !role.Can(PermissionManageSlowMode) {
+			if err = pgCheckSlowMode(ctx, tx, authorId, chatId); err != nil {
+				return
+			}
+		}
+		messageId, err = pgInsertMessage(ctx, tx, authorId, chatId, text)
+		return
+	})
+	return
+}
+
+// pgCheckSlowMode returns *SlowModeError if author has posted in chat with slow mode too recently.
+// System messages aren't posted by author, so they are ignored.
+func pgCheckSlowMode(ctx context.Context, tx *sql.Tx, authorId int64, chatId int64) error {
+	var seconds int
+	err := tx.QueryRowContext(ctx, "SELECT seconds FROM chat_slow_modes WHERE chat_id = $1", chatId).Scan(&seconds)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var postedAt time.Time
+	err = tx.QueryRowContext(ctx, `SELECT messages.created_at FROM messages
+		LEFT JOIN system_messages ON system_messages.message_id = messages.id
+		WHERE messages.chat_id = $1 AND messages.author_id = $2 AND system_messages.message_id IS NULL
+		ORDER BY messages.id DESC LIMIT 1`, chatId, authorId).Scan(&postedAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if wait := time.Until(postedAt.Add(time.Duration(seconds) * time.Second)); wait > 0 {
+		return &SlowModeError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// pgInsertMessage inserts message and updates summary of the last message in chat.
+// Summary is never moved back to earlier message by transaction that commits later.
+func pgInsertMessage(ctx context.Context, tx *sql.Tx, authorId int64, chatId int64, text string) (int64, error) {
+	createdAt := time.Now()
+	var messageId int64
+	err := tx.QueryRowContext(ctx, `INSERT INTO messages(chat_id, author_id, text, created_at)
+		VALUES($1, $2, $3, $4) RETURNING id`, chatId, authorId, text, createdAt).Scan(&messageId)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO chat_last_messages(chat_id, message_id, created_at) VALUES($1, $2, $3)
+		ON CONFLICT (chat_id) DO UPDATE SET message_id = EXCLUDED.message_id, created_at = EXCLUDED.created_at
+		WHERE chat_last_messages.message_id < EXCLUDED.message_id`, chatId, messageId, createdAt)
+	return messageId, err
+}
+
+// GetMessage returns message by id or ErrNotFound.
+func (db PostgresStorage) GetMessage(ctx context.Context, messageId int64) (_ *Message, err error) {
+	defer contextError(ctx, &err)
+	message, err := scanMessage(db.QueryRowContext(ctx, messageSelect+" WHERE messages.id = $1", messageId))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+func (db PostgresStorage) GetMessagesFromChat(ctx context.Context, chatId int64) (_ []*Message, err error) {
+	defer contextError(ctx, &err)
+	rows, err := db.QueryContext(ctx, messageSelect+` WHERE messages.chat_id = $1
+		ORDER BY messages.created_at ASC, messages.id ASC`, chatId)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+func (db PostgresStorage) GetMessagesPage(ctx context.Context, chatId int64, query PageQuery) (_ []*Message,
+	err error) {
+	defer contextError(ctx, &err)
+	if query.BeforeId > 0 {
+		rows, err := db.QueryContext(ctx, messageSelect+` WHERE messages.chat_id = $1 AND messages.id < $2
+			ORDER BY messages.id DESC LIMIT $3`, chatId, query.BeforeId, query.Limit)
+		if err != nil {
+			return nil, err
+		}
+		messages, err := scanMessages(rows)
+		if err != nil {
+			return nil, err
+		}
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+		return messages, nil
+	}
+	rows, err := db.QueryContext(ctx, messageSelect+` WHERE messages.chat_id = $1 AND messages.id > $2
+		ORDER BY messages.id ASC LIMIT $3`, chatId, query.AfterId, query.Limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+// EditMessage replaces text of message and saves previous text to its history.
+// Only author can edit message and only while he has PermissionPost in the chat.
+// It returns ErrMessageNotFound if message doesn't exist or is deleted.
+func (db PostgresStorage) EditMessage(ctx context.Context, authorId int64, messageId int64, text string) (err error) {
+	defer contextError(ctx, &err)
+	return db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		message, err := pgChangeableMessage(ctx, tx, messageId)
+		if err != nil {
+			return
+		}
+		if message.AuthorId != authorId {
+			err = ErrNotMessageAuthor
+			return
+		}
+		role, err := pgMemberRole(ctx, tx, authorId, message.ChatId)
+		if err != nil {
+			return
+		}
+		if err = checkPermission(role, PermissionPost); err != nil {
+			return
+		}
+		editedAt := time.Now()
+		_, err = tx.ExecContext(ctx, "INSERT INTO message_edits(message_id, text, edited_at) VALUES($1, $2, $3)",
+			messageId, message.Text, editedAt)
+		if err != nil {
+			return
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE messages SET text = $1, edited_at = $2 WHERE id = $3",
+			text, editedAt, messageId)
+		return
+	})
+}
+
+// DeleteMessage turns message into tombstone. Its text and history are erased and it is unpinned.
+// Author can always delete his message, other members need PermissionDeleteMessages.
+// It returns ErrMessageNotFound if message doesn't exist or is already deleted.
+func (db PostgresStorage) DeleteMessage(ctx context.Context, actorId int64, messageId int64) (err error) {
+	defer contextError(ctx, &err)
+	return db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		message, err := pgChangeableMessage(ctx, tx, messageId)
+		if err != nil {
+			return
+		}
+		if message.AuthorId != actorId {
+			var role Role
+			role, err = pgMemberRole(ctx, tx, actorId, message.ChatId)
+			if err != nil && err != ErrNotChatMember {
+				return
+			}
+			if err != nil || !role.Can(PermissionDeleteMessages) {
+				err = ErrNotMessageAuthor
+				return
+			}
+		}
+		if _, err = tx.ExecContext(ctx, "DELETE FROM message_edits WHERE message_id = $1", messageId); err != nil {
+			return
+		}
+		if _, err = tx.ExecContext(ctx, "DELETE FROM pinned_messages WHERE message_id = $1", messageId); err != nil {
+			return
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE messages SET text = '', deleted = TRUE WHERE id = $1", messageId)
+		return
+	})
+}
+
+// PinMessage pins or unpins message. Actor must have PermissionPinMessages in chat of the message.
+// It returns ErrMessageNotFound if message doesn't exist or is deleted.
+func (db PostgresStorage) PinMessage(ctx context.Context, actorId int64, messageId int64, pinned bool) (err error) {
+	defer contextError(ctx, &err)
+	return db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		message, err := pgExistingMessage(ctx, tx, messageId)
+		if err != nil {
+			return
+		}
+		role, err := pgMemberRole(ctx, tx, actorId, message.ChatId)
+		if err != nil {
+			return
+		}
+		if err = checkPermission(role, PermissionPinMessages); err != nil {
+			return
+		}
+		if pinned {
+			_, err = tx.ExecContext(ctx, `INSERT INTO pinned_messages(message_id, pinned_by, pinned_at)
+				VALUES($1, $2, $3) ON CONFLICT (message_id) DO NOTHING`, messageId, actorId, time.Now())
+			return
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM pinned_messages WHERE message_id = $1", messageId)
+		return
+	})
+}
+
+// pgExistingMessage returns message if it exists and isn't deleted, otherwise ErrMessageNotFound.
+// Message is locked until the end of transaction, so concurrent changes don't lose each other.
+// It must be locked before membership of actor, the same order is used by every transaction.
+func pgExistingMessage(ctx context.Context, tx *sql.Tx, messageId int64) (*Message, error) {
+	message, err := scanMessage(tx.QueryRowContext(ctx, messageSelect+" WHERE messages.id = $1 FOR UPDATE OF messages",
+		messageId))
+	if err == sql.ErrNoRows || err == nil && message.Deleted {
+		return nil, ErrMessageNotFound
+	}
+	return message, err
+}
+
+// pgChangeableMessage is pgExistingMessage that can be edited or deleted.
+// System messages can't be changed by anyone, ErrNotMessageAuthor is returned for them.
+func pgChangeableMessage(ctx context.Context, tx *sql.Tx, messageId int64) (*Message, error) {
+	message, err := pgExistingMessage(ctx, tx, messageId)
+	if err != nil {
+		return nil, err
+	}
+	if message.System != nil {
+		return nil, ErrNotMessageAuthor
+	}
+	return message, nil
+}
+
+// GetMessageEdits returns history of message ordered from earlier edit to later.
+// It returns ErrMessageNotFound if message doesn't exist.
+func (db PostgresStorage) GetMessageEdits(ctx context.Context, messageId int64) (_ []*MessageEdit, err error) {
+	defer contextError(ctx, &err)
+	var id int64
+	err = db.QueryRowContext(ctx, "SELECT id FROM messages WHERE id = $1", messageId).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, "SELECT text, edited_at FROM message_edits WHERE message_id = $1 ORDER BY id",
+		messageId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	edits := make([]*MessageEdit, 0)
+	for rows.Next() {
+		edit := &MessageEdit{MessageId: messageId}
+		if err := rows.Scan(&edit.Text, &edit.EditedAt); err != nil {
+			return nil, err
+		}
+		edits = append(edits, edit)
+	}
+	return edits, rows.Err()
+}
+
+// GetMessageReaders returns ids of chat members, except author, that have read the message, sorted by id.
+// It returns ErrMessageNotFound if message doesn't exist.
+func (db PostgresStorage) GetMessageReaders(ctx context.Context, messageId int64) (_ []int64, err error) {
+	defer contextError(ctx, &err)
+	var chatId, authorId int64
+	err = db.QueryRowContext(ctx, "SELECT chat_id, author_id FROM messages WHERE id = $1",
+		messageId).Scan(&chatId, &authorId)
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, `SELECT user_id FROM users_chats
+		WHERE chat_id = $1 AND last_read_message_id >= $2 AND user_id != $3 ORDER BY user_id`,
+		chatId, messageId, authorId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	userIds := make([]int64, 0)
+	for rows.Next() {
+		var userId int64
+		if err := rows.Scan(&userId); err != nil {
+			return nil, err
+		}
+		userIds = append(userIds, userId)
+	}
+	return userIds, rows.Err()
+}
+
+// searchVector is indexed by messages_search index, queries must use exactly the same expression.
+const searchVector = "to_tsvector('simple', COALESCE(messages.text, ''))"
+
+// SearchMessages ranks messages by frequency of terms. Snippet is the whole text of message with highlighted terms.
+func (db PostgresStorage) SearchMessages(ctx context.Context, userId int64, query SearchQuery) (_ []*SearchResult,
+	err error) {
+	defer contextError(ctx, &err)
+	results := make([]*SearchResult, 0)
+	terms := searchTerms(query.Text)
+	if len(terms) == 0 {
+		return results, nil
+	}
+	// terms consist of letters and digits only, so they can't contain operators of tsquery
+	stmt := `SELECT messages.id, messages.chat_id, messages.author_id, messages.text, messages.created_at,
+			messages.edited_at, EXISTS(SELECT 1 FROM pinned_messages WHERE message_id = messages.id)
+		FROM messages
+		INNER JOIN users_chats ON users_chats.chat_id = messages.chat_id AND users_chats.user_id = $1
+		WHERE ` + searchVector + ` @@ to_tsquery('simple', $2) AND NOT messages.deleted`
+	args := []interface{}{userId, strings.Join(terms, " & ")}
+	if query.ChatId != 0 {
+		args = append(args, query.ChatId)
+		stmt += fmt.Sprintf(" AND messages.chat_id = $%d", len(args))
+	}
+	if query.AuthorId != 0 {
+		args = append(args, query.AuthorId)
+		stmt += fmt.Sprintf(" AND messages.author_id = $%d", len(args))
+	}
+	if !query.From.IsZero() {
+		args = append(args, query.From)
+		stmt += fmt.Sprintf(" AND messages.created_at >= $%d", len(args))
+	}
+	if !query.To.IsZero() {
+		args = append(args, query.To)
+		stmt += fmt.Sprintf(" AND messages.created_at < $%d", len(args))
+	}
+	args = append(args, query.Limit, query.Offset)
+	stmt += fmt.Sprintf(" ORDER BY ts_rank(%s, to_tsquery('simple', $2)) DESC, messages.id DESC LIMIT $%d OFFSET $%d",
+		searchVector, len(args)-1, len(args))
+
+	rows, err := db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		result := &SearchResult{}
+		err := rows.Scan(&result.Id, &result.ChatId, &result.AuthorId, &result.Text, &result.CreatedAt,
+			&result.EditedAt, &result.Pinned)
+		if err != nil {
+			return nil, err
+		}
+		// postgres splits words a bit differently, text is shown as is if they disagree
+		snippet, count := highlightTerms(result.Text, terms)
+		if count == 0 {
+			snippet = result.Text
+		}
+		result.Snippet = snippet
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// AddWebhook registers webhook of the user. Webhook of chat (non-zero chatId) can be added only by member
+// with PermissionManageWebhooks, global one by anyone.
+func (db PostgresStorage) AddWebhook(ctx context.Context, userId int64, chatId int64, url string, secret string,
+	events []string) (webhookId int64, err error) {
+	defer contextError(ctx, &err)
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		var chat interface{}
+		if chatId != 0 {
+			var role Role
+			role, err = pgMemberRole(ctx, tx, userId, chatId)
+			if err != nil {
+				return
+			}
+			if err = checkPermission(role, PermissionManageWebhooks); err != nil {
+				return
+			}
+			chat = chatId
+		}
+		err = tx.QueryRowContext(ctx, `INSERT INTO webhooks(user_id, chat_id, url, secret, events, created_at)
+			VALUES($1, $2, $3, $4, $5, $6) RETURNING id`, userId, chat, url, secret, joinEvents(events),
+			time.Now()).Scan(&webhookId)
+		if isPostgresError(err, pgForeignKeyViolation) {
+			err = ErrUserNotFound
+		}
+		return
+	})
+	return
+}
+
+// GetUserWebhooks returns webhooks registered by the user ordered by id.
+func (db PostgresStorage) GetUserWebhooks(ctx context.Context, userId int64) (_ []*Webhook, err error) {
+	defer contextError(ctx, &err)
+	rows, err := db.QueryContext(ctx, webhookSelect+" WHERE user_id = $1 ORDER BY id", userId)
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhooks(rows)
+}
+
+// DeleteWebhook deletes webhook with its deliveries. Only user that registered webhook can delete it,
+// for others it is ErrWebhookNotFound.
+func (db PostgresStorage) DeleteWebhook(ctx context.Context, userId int64, webhookId int64) (err error) {
+	defer contextError(ctx, &err)
+	result, err := db.ExecContext(ctx, "DELETE FROM webhooks WHERE id = $1 AND user_id = $2", webhookId, userId)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// GetChatWebhooks returns webhooks subscribed to event in the chat: webhooks of the chat and global webhooks
+// of its current members. They are ordered by id.
+func (db PostgresStorage) GetChatWebhooks(ctx context.Context, chatId int64, event string) (_ []*Webhook,
+	err error) {
+	defer contextError(ctx, &err)
+	rows, err := db.QueryContext(ctx, webhookSelect+` WHERE chat_id = $1
+		OR (chat_id IS NULL AND user_id IN (SELECT user_id FROM users_chats WHERE chat_id = $1))
+		ORDER BY id`, chatId)
+	if err != nil {
+		return nil, err
+	}
+	webhooks, err := scanWebhooks(rows)
+	if err != nil {
+		return nil, err
+	}
+	subscribed := make([]*Webhook, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		if webhook.Wants(event) {
+			subscribed = append(subscribed, webhook)
+		}
+	}
+	return subscribed, nil
+}
+
+// AddWebhookDelivery queues payload of event for webhook. Delivery is due immediately.
+func (db PostgresStorage) AddWebhookDelivery(ctx context.Context, webhookId int64, event string,
+	payload []byte) (deliveryId int64, err error) {
+	defer contextError(ctx, &err)
+	now := time.Now()
+	err = db.QueryRowContext(ctx,
+		`INSERT INTO webhook_deliveries(webhook_id, event, payload, status, next_attempt_at, created_at)
+		VALUES($1, $2, $3, $4, $5, $6) RETURNING id`,
+		webhookId, event, string(payload), DeliveryPending, now, now).Scan(&deliveryId)
+	if isPostgresError(err, pgForeignKeyViolation) {
+		return 0, ErrWebhookNotFound
+	}
+	return deliveryId, err
+}
+
+// ClaimDueWebhookDeliveries returns at most limit pending deliveries which next attempt isn't later than now,
+// from the most overdue one, and postpones their next attempt to claimedUntil. Dispatchers of other instances
+// sharing database don't get claimed deliveries, and if claiming one dies before its attempt is recorded,
+// deliveries are due again after claimedUntil. Deliveries have URL and Secret of their webhooks.
+func (db PostgresStorage) ClaimDueWebhookDeliveries(ctx context.Context, now time.Time, claimedUntil time.Time,
+	limit int) (_ []*WebhookDelivery, err error) {
+	defer contextError(ctx, &err)
+	// rows locked by concurrent claim are skipped, so instances don't wait for each other and claim different ones
+	rows, err := db.QueryContext(ctx, `WITH due AS (
+			SELECT id, next_attempt_at FROM webhook_deliveries WHERE status = $1 AND next_attempt_at <= $2
+			ORDER BY next_attempt_at, id LIMIT $3 FOR UPDATE SKIP LOCKED
+		), claimed AS (
+			UPDATE webhook_deliveries SET next_attempt_at = $4 FROM due WHERE webhook_deliveries.id = due.id
+			RETURNING webhook_deliveries.*
+		)
+		SELECT claimed.id, claimed.webhook_id, claimed.event, claimed.payload, claimed.status, claimed.attempt_count,
+			claimed.next_attempt_at, claimed.created_at, webhooks.url, webhooks.secret
+		FROM claimed
+		INNER JOIN webhooks ON webhooks.id = claimed.webhook_id
+		INNER JOIN due ON due.id = claimed.id
+		ORDER BY due.next_attempt_at, claimed.id`,
+		DeliveryPending, now, limit, claimedUntil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := make([]*WebhookDelivery, 0)
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// AddWebhookAttempt records attempt of delivery and sets its new status.
+// Delivery that stays pending will be due at nextAttemptAt. It returns ErrNotFound if delivery doesn't exist.
+func (db PostgresStorage) AddWebhookAttempt(ctx context.Context, attempt *WebhookAttempt, status string,
+	nextAttemptAt time.Time) (err error) {
+	defer contextError(ctx, &err)
+	return db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		var next interface{}
+		if status == DeliveryPending {
+			next = nextAttemptAt
+		}
+		result, err := tx.ExecContext(ctx, `UPDATE webhook_deliveries SET status = $1,
+			attempt_count = attempt_count + 1, next_attempt_at = $2 WHERE id = $3`,
+			status, next, attempt.DeliveryId)
+		if err != nil {
+			return
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return
+		}
+		if updated == 0 {
+			err = ErrNotFound
+			return
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO webhook_attempts(delivery_id, attempted_at, status_code, error) VALUES($1, $2, $3, $4)`,
+			attempt.DeliveryId, attempt.AttemptedAt, attempt.StatusCode, attempt.Error)
+		return
+	})
+}
+
+// GetWebhookDeliveries returns at most limit latest deliveries of webhook with their attempts, from the latest one.
+// Empty status matches any status. Only user that registered webhook can inspect it,
+// for others it is ErrWebhookNotFound.
+func (db PostgresStorage) GetWebhookDeliveries(ctx context.Context, userId int64, webhookId int64, status string,
+	limit int) (_ []*WebhookDelivery, err error) {
+	defer contextError(ctx, &err)
+	var ownerId int64
+	err = db.QueryRowContext(ctx, "SELECT user_id FROM webhooks WHERE id = $1", webhookId).Scan(&ownerId)
+	if err == sql.ErrNoRows || (err == nil && ownerId != userId) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, deliverySelect+` WHERE webhook_deliveries.webhook_id = $1
+		AND ($2 = '' OR webhook_deliveries.status = $2)
+		ORDER BY webhook_deliveries.id DESC LIMIT $3`, webhookId, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := make([]*WebhookDelivery, 0)
+	byId := make(map[int64]*WebhookDelivery)
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		delivery.URL, delivery.Secret = "", ""
+		delivery.Attempts = make([]*WebhookAttempt, 0)
+		deliveries = append(deliveries, delivery)
+		byId[delivery.Id] = delivery
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return deliveries, nil
+	}
+	// attempts of all listed deliveries are loaded at once
+	attemptRows, err := db.QueryContext(ctx, `SELECT delivery_id, attempted_at, status_code, error
+		FROM webhook_attempts
+		WHERE delivery_id IN (SELECT id FROM webhook_deliveries WHERE webhook_id = $1 AND id >= $2)
+		ORDER BY id`, webhookId, deliveries[len(deliveries)-1].Id)
+	if err != nil {
+		return nil, err
+	}
+	defer attemptRows.Close()
+	for attemptRows.Next() {
+		attempt := &WebhookAttempt{}
+		err := attemptRows.Scan(&attempt.DeliveryId, &attempt.AttemptedAt, &attempt.StatusCode, &attempt.Error)
+		if err != nil {
+			return nil, err
+		}
+		if delivery, ok := byId[attempt.DeliveryId]; ok {
+			delivery.Attempts = append(delivery.Attempts, attempt)
+		}
+	}
+	return deliveries, attemptRows.Err()
+}
+
+// ReserveIdempotencyKey returns saved key with the same user, endpoint and key if it was created after
+// expiredBefore and, if it's still in progress, after abandonedBefore. Otherwise it saves key as in progress
+// (expired or abandoned one is replaced) and returns nil.
+func (db PostgresStorage) ReserveIdempotencyKey(ctx context.Context, key *IdempotencyKey, expiredBefore time.Time,
+	abandonedBefore time.Time) (saved *IdempotencyKey, err error) {
+	defer contextError(ctx, &err)
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		// conflicting row is locked even if it isn't replaced, so it can be read afterwards
+		result, err := tx.ExecContext(ctx, `INSERT INTO idempotency_keys(user_id, endpoint, idempotency_key,
+			request_hash, status, body, created_at) VALUES($1, $2, $3, $4, 0, $5, $6)
+			ON CONFLICT (user_id, endpoint, idempotency_key) DO UPDATE SET request_hash = EXCLUDED.request_hash,
+			    status = 0, body = EXCLUDED.body, created_at = EXCLUDED.created_at
+			WHERE idempotency_keys.created_at < $7
+			    OR (idempotency_keys.status = 0 AND idempotency_keys.created_at < $8)`,
+			key.UserId, key.Endpoint, key.Key, key.RequestHash, []byte{}, time.Now(), expiredBefore, abandonedBefore)
+		if err != nil {
+			return
+		}
+		reserved, err := result.RowsAffected()
+		if err != nil || reserved > 0 {
+			return
+		}
+		stored := &IdempotencyKey{UserId: key.UserId, Endpoint: key.Endpoint, Key: key.Key}
+		err = tx.QueryRowContext(ctx, `SELECT request_hash, status, body, created_at FROM idempotency_keys
+			WHERE user_id = $1 AND endpoint = $2 AND idempotency_key = $3`, key.UserId, key.Endpoint, key.Key).
+			Scan(&stored.RequestHash, &stored.Status, &stored.Body, &stored.CreatedAt)
+		if err == nil {
+			saved = stored
+		}
+		return
+	})
+	return
+}
+
+// CompleteIdempotencyKey saves Status and Body of reserved key. It returns ErrNotFound if key isn't reserved.
+func (db PostgresStorage) CompleteIdempotencyKey(ctx context.Context, key *IdempotencyKey) (err error) {
+	defer contextError(ctx, &err)
+	// nil slice would be stored as NULL
+	body := append([]byte{}, key.Body...)
+	result, err := db.ExecContext(ctx, `UPDATE idempotency_keys SET status = $1, body = $2
+		WHERE user_id = $3 AND endpoint = $4 AND idempotency_key = $5`,
+		key.Status, body, key.UserId, key.Endpoint, key.Key)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey deletes key that is still in progress, so request with it can be retried.
+func (db PostgresStorage) ReleaseIdempotencyKey(ctx context.Context, key *IdempotencyKey) (err error) {
+	defer contextError(ctx, &err)
+	_, err = db.ExecContext(ctx, `DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND endpoint = $2 AND idempotency_key = $3 AND status = 0`,
+		key.UserId, key.Endpoint, key.Key)
+	return err
+}
+
+// DeleteExpiredIdempotencyKeys deletes keys created before expiredBefore and returns their number.
+func (db PostgresStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, expiredBefore time.Time) (_ int64,
+	err error) {
+	defer contextError(ctx, &err)
+	result, err := db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE created_at < $1", expiredBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,165 @@
+package storage
+
+// postgresMigrations are applied to PostgresStorage in order. Never change migration that was already released,
+// add new one instead.
+var postgresMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial_schema",
+		// schema matches version 11 of sqlite schema, search index is expression index over text of messages
+		Up: `
+			CREATE TABLE users (
+			    id BIGSERIAL NOT NULL PRIMARY KEY,
+			    username TEXT NOT NULL UNIQUE,
+			    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+			);
+			CREATE TABLE user_tokens (
+			    token_hash TEXT NOT NULL PRIMARY KEY,
+			    user_id BIGINT NOT NULL REFERENCES users (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+			);
+			CREATE TABLE chats (
+			    id BIGSERIAL NOT NULL PRIMARY KEY,
+			    name TEXT NOT NULL UNIQUE,
+			    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+			);
+			CREATE TABLE users_chats (
+			    user_id BIGINT NOT NULL REFERENCES users (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    chat_id BIGINT NOT NULL REFERENCES chats (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    role TEXT NOT NULL DEFAULT 'member',
+			    last_read_message_id BIGINT NOT NULL DEFAULT 0,
+			    PRIMARY KEY (user_id, chat_id)
+			);
+			CREATE INDEX users_chats_chat_id ON users_chats (chat_id, user_id);
+			CREATE TABLE chat_slow_modes (
+			    chat_id BIGINT NOT NULL PRIMARY KEY REFERENCES chats (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    seconds INTEGER NOT NULL
+			);
+			CREATE TABLE messages (
+			    id BIGSERIAL NOT NULL PRIMARY KEY,
+			    chat_id BIGINT NOT NULL REFERENCES chats (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    author_id BIGINT NOT NULL REFERENCES users (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    text TEXT,
+			    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			    edited_at TIMESTAMP WITH TIME ZONE,
+			    deleted BOOLEAN NOT NULL DEFAULT FALSE
+			);
+			CREATE INDEX messages_chat_id_id ON messages (chat_id, id);
+			CREATE INDEX messages_search ON messages USING GIN (to_tsvector('simple', COALESCE(text, '')));
+			CREATE TABLE message_edits (
+			    id BIGSERIAL NOT NULL PRIMARY KEY,
+			    message_id BIGINT NOT NULL REFERENCES messages (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    text TEXT NOT NULL,
+			    edited_at TIMESTAMP WITH TIME ZONE NOT NULL
+			);
+			CREATE INDEX message_edits_message_id ON message_edits (message_id, id);
+			CREATE TABLE system_messages (
+			    message_id BIGINT NOT NULL PRIMARY KEY REFERENCES messages (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    type TEXT NOT NULL,
+			    user_id BIGINT NOT NULL REFERENCES users (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE
+			);
+			CREATE TABLE pinned_messages (
+			    message_id BIGINT NOT NULL PRIMARY KEY REFERENCES messages (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    pinned_by BIGINT NOT NULL REFERENCES users (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    pinned_at TIMESTAMP WITH TIME ZONE NOT NULL
+			);
+			CREATE TABLE chat_last_messages (
+			    chat_id BIGINT NOT NULL PRIMARY KEY REFERENCES chats (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    message_id BIGINT NOT NULL REFERENCES messages (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+			);
+			CREATE TABLE webhooks (
+			    id BIGSERIAL NOT NULL PRIMARY KEY,
+			    user_id BIGINT NOT NULL REFERENCES users (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    chat_id BIGINT REFERENCES chats (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    url TEXT NOT NULL,
+			    secret TEXT NOT NULL,
+			    events TEXT NOT NULL,
+			    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+			);
+			CREATE INDEX webhooks_chat_id ON webhooks (chat_id);
+			CREATE INDEX webhooks_user_id ON webhooks (user_id);
+			CREATE TABLE webhook_deliveries (
+			    id BIGSERIAL NOT NULL PRIMARY KEY,
+			    webhook_id BIGINT NOT NULL REFERENCES webhooks (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    event TEXT NOT NULL,
+			    payload TEXT NOT NULL,
+			    status TEXT NOT NULL,
+			    attempt_count INTEGER NOT NULL DEFAULT 0,
+			    next_attempt_at TIMESTAMP WITH TIME ZONE,
+			    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+			);
+			CREATE INDEX webhook_deliveries_status ON webhook_deliveries (status, next_attempt_at);
+			CREATE INDEX webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id, id);
+			CREATE TABLE webhook_attempts (
+			    id BIGSERIAL NOT NULL PRIMARY KEY,
+			    delivery_id BIGINT NOT NULL REFERENCES webhook_deliveries (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			    attempted_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			    status_code INTEGER NOT NULL,
+			    error TEXT NOT NULL
+			);
+			CREATE INDEX webhook_attempts_delivery_id ON webhook_attempts (delivery_id, id);
+			CREATE TABLE idempotency_keys (
+			    user_id BIGINT NOT NULL,
+			    endpoint TEXT NOT NULL,
+			    idempotency_key TEXT NOT NULL,
+			    request_hash TEXT NOT NULL,
+			    status INTEGER NOT NULL,
+			    body BYTEA NOT NULL,
+			    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			    PRIMARY KEY (user_id, endpoint, idempotency_key)
+			);
+			CREATE INDEX idempotency_keys_created_at ON idempotency_keys (created_at);
+		`,
+		Down: `
+			DROP TABLE idempotency_keys;
+			DROP TABLE webhook_attempts;
+			DROP TABLE webhook_deliveries;
+			DROP TABLE webhooks;
+			DROP TABLE chat_last_messages;
+			DROP TABLE pinned_messages;
+			DROP TABLE system_messages;
+			DROP TABLE message_edits;
+			DROP TABLE messages;
+			DROP TABLE chat_slow_modes;
+			DROP TABLE users_chats;
+			DROP TABLE chats;
+			DROP TABLE user_tokens;
+			DROP TABLE users;
+		`,
+	},
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Darkclainer/avito_exercise/storage/postgrestest"
+)
+
+func postgresTables(t *testing.T, db *sql.DB) []string {
+	rows, err := db.Query("SELECT tablename FROM pg_tables WHERE schemaname = 'public'")
+	if err != nil {
+		t.Fatal("Failed to query table names: ", err)
+	}
+	defer rows.Close()
+	tables := make([]string, 0)
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			t.Fatal("Scan table name failed: ", err)
+		}
+		tables = append(tables, tableName)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+func TestPostgresMigrate(t *testing.T) {
+	server := postgrestest.Start(t)
+	defer server.Stop()
+	dsn, dropDatabase := server.NewDatabase(t)
+	defer dropDatabase()
+	postgresStorage, err := OpenPostgres(dsn)
+	if err != nil {
+		t.Fatal("Open db failed: ", err)
+	}
+	defer postgresStorage.Close()
+
+	assert.Error(t, postgresStorage.CheckReady())
+	if !assert.NoError(t, postgresStorage.Migrate()) {
+		return
+	}
+	assert.NoError(t, postgresStorage.CheckReady())
+	tablesShouldExist := []string{
+		"chat_last_messages",
+		"chat_slow_modes",
+		"chats",
+		"idempotency_keys",
+		"message_edits",
+		"messages",
+		"pinned_messages",
+		"schema_migrations",
+		"system_messages",
+		"user_tokens",
+		"users",
+		"users_chats",
+		"webhook_attempts",
+		"webhook_deliveries",
+		"webhooks",
+	}
+	assert.Equal(t, tablesShouldExist, postgresTables(t, postgresStorage.DB))
+
+	migrator := postgresStorage.Migrator()
+	reverted, err := migrator.Down(len(postgresMigrations))
+	assert.NoError(t, err)
+	assert.Equal(t, len(postgresMigrations), reverted)
+	assert.Equal(t, []string{"schema_migrations"}, postgresTables(t, postgresStorage.DB))
+	applied, err := migrator.Up(0)
+	assert.NoError(t, err)
+	assert.Equal(t, len(postgresMigrations), applied)
+}
+
+// TestPostgresMigrateConcurrently starts migrations of empty database at once, as servers started together do.
+func TestPostgresMigrateConcurrently(t *testing.T) {
+	server := postgrestest.Start(t)
+	defer server.Stop()
+	dsn, dropDatabase := server.NewDatabase(t)
+	defer dropDatabase()
+	postgresStorage, err := OpenPostgres(dsn)
+	if err != nil {
+		t.Fatal("Open db failed: ", err)
+	}
+	defer postgresStorage.Close()
+
+	const migrators = 5
+	var wg sync.WaitGroup
+	applied := make([]int, migrators)
+	errs := make([]error, migrators)
+	for i := 0; i < migrators; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			applied[i], errs[i] = postgresStorage.Migrator().Up(0)
+		}(i)
+	}
+	wg.Wait()
+	total := 0
+	for i := 0; i < migrators; i++ {
+		assert.NoError(t, errs[i])
+		total += applied[i]
+	}
+	assert.Equal(t, len(postgresMigrations), total)
+	assert.NoError(t, postgresStorage.CheckReady())
+}
+
+func TestContextError(t *testing.T) {
+	canceled := &pq.Error{Code: pgQueryCanceled}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	err := error(canceled)
+	contextError(ctx, &err)
+	assert.Equal(t, canceled, err, "query canceled by server itself must be kept")
+
+	cancel()
+	contextError(ctx, &err)
+	assert.Equal(t, context.Canceled, err)
+
+	other := errors.New("connection refused")
+	err = other
+	contextError(ctx, &err)
+	assert.Equal(t, other, err)
+}
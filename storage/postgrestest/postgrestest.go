@@ -0,0 +1,166 @@
+// Package postgrestest runs ephemeral PostgreSQL server for tests of storage.PostgresStorage.
+//
+// Server is started from local binaries (initdb and postgres) in temporary directory and listens only
+// on unix socket there, so tests need no network and no preinstalled database. Binaries are looked for
+// in AE_TEST_POSTGRES_BIN, PATH and /usr/lib/postgresql/*/bin. If AE_TEST_POSTGRES_DSN is set, existing
+// server with that DSN is used instead. Tests are skipped if neither is available.
+package postgrestest
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// startTimeout limits time that server may take to accept connections.
+const startTimeout = 30 * time.Second
+
+// Server is postgres server where every test gets its own database.
+type Server struct {
+	dsn       string
+	dir       string
+	cmd       *exec.Cmd
+	databases int64
+}
+
+// Start starts ephemeral server or connects to one from AE_TEST_POSTGRES_DSN. It skips t if postgres
+// isn't available. Server must be stopped by Stop.
+func Start(t *testing.T) *Server {
+	if dsn := os.Getenv("AE_TEST_POSTGRES_DSN"); dsn != "" {
+		if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+			var err error
+			if dsn, err = pq.ParseURL(dsn); err != nil {
+				t.Fatal("Parse AE_TEST_POSTGRES_DSN failed: ", err)
+			}
+		}
+		return &Server{dsn: dsn}
+	}
+	binDir := findBinaries()
+	if binDir == "" {
+		t.Skip("postgres binaries not found, set AE_TEST_POSTGRES_BIN or AE_TEST_POSTGRES_DSN")
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("postgres can't be run by root, set AE_TEST_POSTGRES_DSN")
+	}
+	dir, err := ioutil.TempDir("", "postgrestest-")
+	if err != nil {
+		t.Fatal("Creation temp dir failed: ", err)
+	}
+	dataDir := filepath.Join(dir, "data")
+	initdb := exec.Command(filepath.Join(binDir, "initdb"), "-D", dataDir, "-U", "postgres", "-A", "trust",
+		"-E", "UTF8", "--locale=C", "--no-sync")
+	if output, err := initdb.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("initdb failed: %s\n%s", err, output)
+	}
+	// server listens only on unix socket in dir, durability isn't needed for tests
+	cmd := exec.Command(filepath.Join(binDir, "postgres"), "-D", dataDir, "-k", dir, "-c", "listen_addresses=",
+		"-c", "fsync=off", "-c", "synchronous_commit=off", "-c", "full_page_writes=off")
+	logFile, err := os.Create(filepath.Join(dir, "postgres.log"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal("Creation log file failed: ", err)
+	}
+	defer logFile.Close()
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal("Start postgres failed: ", err)
+	}
+	server := &Server{
+		dsn: fmt.Sprintf("host=%s user=postgres dbname=postgres sslmode=disable", dir),
+		dir: dir,
+		cmd: cmd,
+	}
+	if err := server.waitReady(); err != nil {
+		log, _ := ioutil.ReadFile(logFile.Name())
+		server.Stop()
+		t.Fatalf("postgres isn't ready: %s\n%s", err, log)
+	}
+	return server
+}
+
+// findBinaries returns directory with initdb and postgres or empty string.
+func findBinaries() string {
+	dirs := []string{os.Getenv("AE_TEST_POSTGRES_BIN")}
+	if path, err := exec.LookPath("initdb"); err == nil {
+		dirs = append(dirs, filepath.Dir(path))
+	}
+	// debian keeps binaries out of PATH, the newest version is preferred
+	installed, _ := filepath.Glob("/usr/lib/postgresql/*/bin")
+	sort.Sort(sort.Reverse(sort.StringSlice(installed)))
+	dirs = append(dirs, installed...)
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		if isExecutable(filepath.Join(dir, "initdb")) && isExecutable(filepath.Join(dir, "postgres")) {
+			return dir
+		}
+	}
+	return ""
+}
+
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir() && info.Mode()&0111 != 0
+}
+
+func (s *Server) waitReady() error {
+	db, err := sql.Open("postgres", s.dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	deadline := time.Now().Add(startTimeout)
+	for {
+		err := db.Ping()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// NewDatabase creates empty database and returns its DSN and function that drops it.
+// Connections to database must be closed before it is dropped.
+func (s *Server) NewDatabase(t *testing.T) (string, func()) {
+	name := fmt.Sprintf("test_%d_%d", os.Getpid(), atomic.AddInt64(&s.databases, 1))
+	db, err := sql.Open("postgres", s.dsn)
+	if err != nil {
+		t.Fatal("Open postgres failed: ", err)
+	}
+	if _, err := db.Exec("CREATE DATABASE " + name); err != nil {
+		db.Close()
+		t.Fatal("Create database failed: ", err)
+	}
+	// the last setting takes precedence, so dbname of server DSN is overridden
+	return s.dsn + " dbname=" + name, func() {
+		defer db.Close()
+		if _, err := db.Exec("DROP DATABASE " + name); err != nil {
+			t.Error("Drop database failed: ", err)
+		}
+	}
+}
+
+// Stop stops ephemeral server and removes its files. Server from AE_TEST_POSTGRES_DSN is left running.
+func (s *Server) Stop() {
+	if s.cmd == nil {
+		return
+	}
+	// SIGINT is fast shutdown of postgres
+	s.cmd.Process.Signal(syscall.SIGINT)
+	s.cmd.Wait()
+	os.RemoveAll(s.dir)
+}
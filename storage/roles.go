@@ -0,0 +1,119 @@
+package storage
+
+import "fmt"
+
+// Role of member in chat. Creator of chat is its owner, invited users are members.
+type Role string
+
+// Roles from the most powerful to the least.
+const (
+	RoleOwner    Role = "owner"
+	RoleAdmin    Role = "admin"
+	RoleMember   Role = "member"
+	RoleReadOnly Role = "readonly"
+)
+
+// Permission is action in chat that is allowed only to some roles.
+// Reading chat and leaving it are allowed to everyone.
+type Permission int
+
+const (
+	// PermissionPost allows to write and edit messages
+	PermissionPost Permission = iota
+	PermissionInvite
+	// PermissionRemoveMembers allows to remove members with lower role
+	PermissionRemoveMembers
+	PermissionRenameChat
+	PermissionPinMessages
+	// PermissionDeleteMessages allows to delete messages of other members
+	PermissionDeleteMessages
+	// PermissionManageWebhooks allows to register webhooks of chat
+	PermissionManageWebhooks
+	// PermissionManageRoles allows to give roles lower than own to members with lower role
+	PermissionManageRoles
+	// PermissionTransferOwnership allows to make another member owner of chat
+	PermissionTransferOwnership
+	// PermissionManageSlowMode allows to set slow mode of chat, it doesn't apply to members with this permission
+	PermissionManageSlowMode
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleOwner: []Permission{PermissionPost, PermissionInvite, PermissionRemoveMembers, PermissionRenameChat,
+		PermissionPinMessages, PermissionDeleteMessages, PermissionManageWebhooks, PermissionManageRoles,
+		PermissionTransferOwnership, PermissionManageSlowMode},
+	RoleAdmin: []Permission{PermissionPost, PermissionInvite, PermissionRemoveMembers, PermissionRenameChat,
+		PermissionPinMessages, PermissionDeleteMessages, PermissionManageWebhooks, PermissionManageRoles,
+		PermissionManageSlowMode},
+	RoleMember:   []Permission{PermissionPost, PermissionInvite},
+	RoleReadOnly: []Permission{},
+}
+
+// roleRanks is used to compare roles, bigger rank is more powerful.
+var roleRanks = map[Role]int{
+	RoleOwner:    3,
+	RoleAdmin:    2,
+	RoleMember:   1,
+	RoleReadOnly: 0,
+}
+
+// IsValid reports whether role is one of predefined roles.
+func (r Role) IsValid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Can reports whether role has permission.
+func (r Role) Can(permission Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Outranks reports whether role is more powerful than other.
+func (r Role) Outranks(other Role) bool {
+	return r.IsValid() && roleRanks[r] > roleRanks[other]
+}
+
+// ChatMember is user in chat with his role.
+type ChatMember struct {
+	UserId int64 `json:"user"`
+	Role   Role  `json:"role"`
+}
+
+// checkPermission returns ErrPermissionDenied if role doesn't have permission.
+func checkPermission(role Role, permission Permission) error {
+	if !role.Can(permission) {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// checkRemove decides whether actor may remove target from chat.
+// Anyone may leave chat except owner, who must transfer ownership first.
+func checkRemove(actorRole Role, targetRole Role, isLeaving bool) error {
+	if isLeaving {
+		if actorRole == RoleOwner {
+			return ErrOwnerCannotLeave
+		}
+		return nil
+	}
+	if !actorRole.Can(PermissionRemoveMembers) || !actorRole.Outranks(targetRole) {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// checkRoleChange decides whether actor may give role to target.
+// Owner can't be appointed, ownership is transferred instead.
+func checkRoleChange(actorRole Role, targetRole Role, role Role) error {
+	if !role.IsValid() {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	if !actorRole.Can(PermissionManageRoles) || !actorRole.Outranks(targetRole) || !actorRole.Outranks(role) {
+		return ErrPermissionDenied
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Matched terms in SearchResult.Snippet are wrapped with these markers.
+const (
+	SnippetMatchStart = "<b>"
+	SnippetMatchEnd   = "</b>"
+)
+
+// SearchQuery describes full-text search of messages in chats of the user. Results are ordered by relevance.
+// Text is split into words and message must contain every one of them, case insensitive.
+// Zero ChatId, AuthorId, From or To don't restrict search.
+type SearchQuery struct {
+	Text     string
+	ChatId   int64
+	AuthorId int64
+	// From is inclusive, To is exclusive
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+// SearchResult is found message with snippet of its text where matched words are highlighted.
+type SearchResult struct {
+	Message
+	Snippet string `json:"snippet"`
+}
+
+// searchTerms splits text into lowercased words. Everything except letters and digits is separator,
+// so terms are safe to be quoted in FTS5 query.
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), isNotWordRune)
+}
+
+func isNotWordRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// highlightTerms returns text with highlighted words that are among terms and number of such words.
+// It returns zero if text doesn't contain some of terms.
+func highlightTerms(text string, terms []string) (string, int) {
+	found := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		found[term] = false
+	}
+	var builder strings.Builder
+	matches := 0
+	wordStart := -1
+	flushWord := func(end int) {
+		word := text[wordStart:end]
+		term := strings.ToLower(word)
+		if _, ok := found[term]; ok {
+			found[term] = true
+			matches++
+			word = SnippetMatchStart + word + SnippetMatchEnd
+		}
+		builder.WriteString(word)
+		wordStart = -1
+	}
+	for i, r := range text {
+		if isNotWordRune(r) {
+			if wordStart >= 0 {
+				flushWord(i)
+			}
+			builder.WriteRune(r)
+		} else if wordStart < 0 {
+			wordStart = i
+		}
+	}
+	if wordStart >= 0 {
+		flushWord(len(text))
+	}
+	for _, isFound := range found {
+		if !isFound {
+			return "", 0
+		}
+	}
+	return builder.String(), matches
+}
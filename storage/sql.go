@@ -1,66 +1,98 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/mattn/go-sqlite3"
 )
 
 type SqlStorage struct {
 	*sql.DB
 }
 
-func (db SqlStorage) Setup() error {
-	schema := `
-		CREATE TABLE IF NOT EXISTS users (
-		    id INTEGER NOT NULL PRIMARY KEY,
-		    username TEXT NOT NULL UNIQUE,
-		    created_at DATETIME NOT NULL
-		);
-		CREATE TABLE IF NOT EXISTS chats (
-		    id INTEGER NOT NULL PRIMARY KEY,
-		    name TEXT NOT NULL UNIQUE,
-		    created_at DATETIME NOT NULL
-		);
-		CREATE TABLE IF NOT EXISTS users_chats (
-		    user_id INTEGER NOT NULL,
-		    chat_id INTEGER NOT NULL,
-		    FOREIGN KEY (user_id) REFERENCES users (id)
-			ON UPDATE CASCADE
-			ON DELETE CASCADE,
-		    FOREIGN KEY (chat_id) REFERENCES chats (id)
-			ON UPDATE CASCADE
-			ON DELETE CASCADE,
-		    PRIMARY KEY (user_id, chat_id)
-		);
-		CREATE TABLE IF NOT EXISTS messages (
-		    id INTEGER NOT NULL PRIMARY KEY,
-		    chat_id INTEGER NOT NULL,
-		    author_id INTEGER NOT NULL,
-		    text TEXT,
-		    created_at DATETIME NOT NULL,
-		    FOREIGN KEY (chat_id) REFERENCES chats (id)
-			ON UPDATE CASCADE
-			ON DELETE CASCADE,
-		    FOREIGN KEY (author_id) REFERENCES users (id)
-			ON UPDATE CASCADE
-			ON DELETE CASCADE
-		);
-	`
-	if _, err := db.Exec(schema); err != nil {
-		return fmt.Errorf("Setup failed: %s", err)
+// OpenSqlite opens sqlite database by path with enforced foreign keys. Path may have its own options,
+// like "file:chat.db?cache=shared".
+// Transactions take write lock immediately, so concurrent ones wait for each other instead of failing
+// to upgrade read lock.
+func OpenSqlite(path string) (SqlStorage, error) {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	db, err := sql.Open("sqlite3", path+separator+"_foreign_keys=1&_txlock=immediate")
+	if err != nil {
+		return SqlStorage{}, err
+	}
+	return SqlStorage{db}, nil
+}
+
+// Migrator returns Migrator with all schema migrations of SqlStorage.
+func (db SqlStorage) Migrator() Migrator {
+	return Migrator{DB: db.DB, Migrations: sqliteMigrations}
+}
+
+// Migrate applies all pending migrations. It refuses to work with database that is newer than binary.
+// Also it creates full-text search index if it is available.
+func (db SqlStorage) Migrate() error {
+	if _, err := db.Migrator().Up(0); err != nil {
+		return fmt.Errorf("Migrate failed: %w", err)
+	}
+	if err := db.ensureSearchIndex(); err != nil {
+		return fmt.Errorf("ensureSearchIndex failed: %w", err)
 	}
 	return nil
 }
 
-func (db SqlStorage) IsUserExists(username string) (bool, error) {
-	sqlStmt := `SELECT username FROM users WHERE username = ?`
-	err := db.QueryRow(sqlStmt, username).Scan(&username)
-	return isExistByError(err)
+// CheckReady returns error if database doesn't respond or its schema isn't at the latest known version.
+func (db SqlStorage) CheckReady() error {
+	return checkReady(db.DB, db.Migrator())
+}
+
+// checkReady pings db and compares version of its schema with the latest migration of migrator.
+func checkReady(db *sql.DB, migrator Migrator) error {
+	if err := db.Ping(); err != nil {
+		return fmt.Errorf("Ping failed: %w", err)
+	}
+	version, err := migrator.Version()
+	if err != nil {
+		return fmt.Errorf("Version failed: %w", err)
+	}
+	if version != migrator.Latest() {
+		return fmt.Errorf("database version is %d, expected %d", version, migrator.Latest())
+	}
+	return nil
+}
+
+// inTransaction is unit of work of SqlStorage: work gets transaction and every step of multi-step operation
+// must go through it. The transaction is committed if work succeeds and rolled back if it returns error
+// or panics, so operation is never applied partially. Errors of constraints must be converted by work itself,
+// for example to ErrChatExists, because conflicts are detected by constraints, not by checks before inserts.
+func (db SqlStorage) inTransaction(ctx context.Context, work func(tx *sql.Tx) error) (err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+	if err = work(tx); err != nil {
+		return err
+	}
+	committed = true
+	return tx.Commit()
 }
-func (db SqlStorage) AreUsersExistByIds(userIds []int64) (bool, error) {
+
+func (db SqlStorage) AreUsersExistByIds(ctx context.Context, userIds []int64) (bool, error) {
 	inStmtPart := strings.Repeat("?, ", len(userIds))
 	sqlStmt := fmt.Sprintf("SELECT COUNT(*) FROM users WHERE id IN (%s)", inStmtPart[:len(inStmtPart)-2])
 	args := make([]interface{}, len(userIds))
@@ -68,51 +100,158 @@ func (db SqlStorage) AreUsersExistByIds(userIds []int64) (bool, error) {
 		args[i] = id
 	}
 	var numberOfRows int
-	err := db.QueryRow(sqlStmt, args...).Scan(&numberOfRows)
+	err := db.QueryRowContext(ctx, sqlStmt, args...).Scan(&numberOfRows)
 	if err != nil {
 		return false, err
 	}
 	return numberOfRows == len(userIds), nil
 }
 
-func (db SqlStorage) AddUser(username string) (int64, error) {
-	result, err := db.Exec("INSERT INTO users(username, created_at) VALUES(?, ?)",
+func (db SqlStorage) AddUser(ctx context.Context, username string) (int64, error) {
+	return insertUser(ctx, db, username)
+}
+
+func (db SqlStorage) AddUserToken(ctx context.Context, userId int64, tokenHash string) error {
+	return insertUserToken(ctx, db, userId, tokenHash)
+}
+
+// AddUserWithToken creates user together with its first token, so user can't be left without token.
+func (db SqlStorage) AddUserWithToken(ctx context.Context, username string, tokenHash string) (userId int64,
+	err error) {
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		if userId, err = insertUser(ctx, tx, username); err != nil {
+			return
+		}
+		return insertUserToken(ctx, tx, userId, tokenHash)
+	})
+	return
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx, so single statement can run inside unit of work or alone.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertUser returns ErrUserExists if username is taken. Uniqueness is checked by constraint of users table,
+// so concurrent inserts of the same username can't both succeed.
+func insertUser(ctx context.Context, db execer, username string) (int64, error) {
+	result, err := db.ExecContext(ctx, "INSERT INTO users(username, created_at) VALUES(?, ?)",
 		username,
 		time.Now())
+	if isConstraintError(err, sqlite3.ErrConstraintUnique) {
+		return 0, ErrUserExists
+	}
 	if err != nil {
 		return 0, err
 	}
 	return result.LastInsertId()
 }
 
-func (db SqlStorage) GetUserChats(userId int64) ([]*Chat, error) {
-	stmt := `SELECT chats.id, chats.name, chats.created_at FROM users_chats 
-		INNER JOIN chats ON users_chats.chat_id = chats.id 
-		WHERE users_chats.user_id = ?`
-	rows, err := db.Query(stmt, userId)
+func insertUserToken(ctx context.Context, db execer, userId int64, tokenHash string) error {
+	_, err := db.ExecContext(ctx, "INSERT INTO user_tokens(token_hash, user_id, created_at) VALUES(?, ?, ?)",
+		tokenHash,
+		userId,
+		time.Now())
+	switch {
+	case isConstraintError(err, sqlite3.ErrConstraintPrimaryKey):
+		return ErrTokenExists
+	case isConstraintError(err, sqlite3.ErrConstraintForeignKey):
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// GetUserIdByToken returns id of token owner or ErrNotFound.
+func (db SqlStorage) GetUserIdByToken(ctx context.Context, tokenHash string) (int64, error) {
+	var userId int64
+	err := db.QueryRowContext(ctx, "SELECT user_id FROM user_tokens WHERE token_hash = ?", tokenHash).Scan(&userId)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return userId, err
+}
+
+// GetUserChats returns chats of the user from the latest to the earliest by time of the last message
+// (or creation time of the chat if it is empty). It makes two queries regardless of number of chats.
+func (db SqlStorage) GetUserChats(ctx context.Context, userId int64) ([]*Chat, error) {
+	// times may be stored with different time zones, so they are compared as julian days,
+	// which have millisecond precision, so ties are broken by ids
+	stmt := `SELECT chats.id, chats.name, chats.created_at, users_chats.last_read_message_id,
+			(SELECT COUNT(*) FROM messages WHERE messages.chat_id = chats.id
+			    AND messages.id > users_chats.last_read_message_id
+			    AND messages.author_id != users_chats.user_id AND NOT messages.deleted),
+			(SELECT GROUP_CONCAT(members.user_id) FROM users_chats AS members WHERE members.chat_id = chats.id),
+			chat_last_messages.created_at, COALESCE(chat_slow_modes.seconds, 0)
+		FROM users_chats
+		INNER JOIN chats ON users_chats.chat_id = chats.id
+		LEFT JOIN chat_last_messages ON chat_last_messages.chat_id = chats.id
+		LEFT JOIN chat_slow_modes ON chat_slow_modes.chat_id = chats.id
+		WHERE users_chats.user_id = ?
+		ORDER BY julianday(COALESCE(chat_last_messages.created_at, chats.created_at)) DESC,
+			chat_last_messages.message_id DESC, chats.id DESC`
+	rows, err := db.QueryContext(ctx, stmt, userId)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	chats := make([]*Chat, 0)
+	chatById := make(map[int64]*Chat)
 	for rows.Next() {
 		chat := &Chat{}
-		err := rows.Scan(&chat.Id, &chat.Name, &chat.CreatedAt)
+		var userIds string
+		err := rows.Scan(&chat.Id, &chat.Name, &chat.CreatedAt, &chat.LastReadMessageId, &chat.UnreadCount,
+			&userIds, &chat.LastMessageAt, &chat.SlowModeSeconds)
 		if err != nil {
 			return nil, err
 		}
-		userIds, err := db.getUserIdsFromChat(chat.Id)
-		if err != nil {
+		if chat.UserIds, err = parseIds(userIds); err != nil {
 			return nil, err
 		}
-		chat.UserIds = userIds
 		chats = append(chats, chat)
+		chatById[chat.Id] = chat
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.QueryContext(ctx, messageSelect+` WHERE messages.id IN (SELECT chat_last_messages.message_id
+		FROM users_chats INNER JOIN chat_last_messages ON chat_last_messages.chat_id = users_chats.chat_id
+		WHERE users_chats.user_id = ?)`, userId)
+	if err != nil {
+		return nil, err
+	}
+	lastMessages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, message := range lastMessages {
+		if chat, ok := chatById[message.ChatId]; ok {
+			chat.LastMessage = message
+		}
 	}
-	db.sortChatsByLastMessage(chats)
 	return chats, nil
 }
-func (db SqlStorage) getUserIdsFromChat(chatId int64) ([]int64, error) {
-	rows, err := db.Query("SELECT user_id FROM users_chats WHERE chat_id = ?", chatId)
+
+// parseIds parses ids concatenated with comma and sorts them.
+func parseIds(concatenated string) ([]int64, error) {
+	ids := make([]int64, 0)
+	for _, field := range strings.Split(concatenated, ",") {
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids, nil
+}
+func (db SqlStorage) GetChatUserIds(ctx context.Context, chatId int64) ([]int64, error) {
+	rows, err := db.QueryContext(ctx, "SELECT user_id FROM users_chats WHERE chat_id = ? ORDER BY user_id", chatId)
 	if err != nil {
 		return nil, err
 	}
@@ -129,111 +268,1066 @@ func (db SqlStorage) getUserIdsFromChat(chatId int64) ([]int64, error) {
 	}
 	return userIds, nil
 }
-func (db SqlStorage) sortChatsByLastMessage(chats []*Chat) {
-	chatDate := make(map[int64]time.Time, len(chats))
-	type Result struct {
-		ChatId   int64
-		LastTime time.Time
-	}
-	ch := make(chan Result)
-	for _, chat := range chats {
-		go func(chat *Chat) {
-			var lastMessageTime time.Time
-			err := db.QueryRow("SELECT created_at FROM messages WHERE chat_id = ? ORDER BY id DESC", chat.Id).Scan(&lastMessageTime)
+
+// AddChat creates chat where owner has RoleOwner and other members have RoleMember.
+// MemberIds must not contain owner.
+func (db SqlStorage) AddChat(ctx context.Context, ownerId int64, chatName string,
+	memberIds []int64) (chatId int64, err error) {
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		result, err := tx.ExecContext(ctx, "INSERT INTO chats(name, created_at) VALUES(?, ?)", chatName, time.Now())
+		if isConstraintError(err, sqlite3.ErrConstraintUnique) {
+			err = ErrChatExists
+			return
+		}
+		if err != nil {
+			return
+		}
+		if chatId, err = result.LastInsertId(); err != nil {
+			return
+		}
+		for _, userId := range append([]int64{ownerId}, memberIds...) {
+			role := RoleMember
+			if userId == ownerId {
+				role = RoleOwner
+			}
+			_, err = tx.ExecContext(ctx, "INSERT INTO users_chats(user_id, chat_id, role) VALUES(?, ?, ?)",
+				userId, chatId, role)
+			if isConstraintError(err, sqlite3.ErrConstraintForeignKey) {
+				err = ErrUserNotFound
+				return
+			}
 			if err != nil {
-				// Actually if any error ocur - we send chat.created_at. This may be not the best tactic
-				ch <- Result{chat.Id, chat.CreatedAt}
 				return
 			}
-			ch <- Result{chat.Id, lastMessageTime}
-		}(chat)
-	}
-	for i := 0; i < len(chats); i++ {
-		result := <-ch
-		chatDate[result.ChatId] = result.LastTime
-	}
-	sort.Slice(chats, func(i, j int) bool {
-		return chatDate[chats[i].Id].Before(chatDate[chats[j].Id])
+		}
+		return
+	})
+	return
+}
+
+// RenameChat changes name of chat. It returns ErrChatExists if name is taken by another chat.
+func (db SqlStorage) RenameChat(ctx context.Context, actorId int64, chatId int64, chatName string) (err error) {
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		role, err := memberRole(ctx, tx, actorId, chatId)
+		if err != nil {
+			return
+		}
+		if err = checkPermission(role, PermissionRenameChat); err != nil {
+			return
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE chats SET name = ? WHERE id = ?", chatName, chatId)
+		if isConstraintError(err, sqlite3.ErrConstraintUnique) {
+			err = ErrChatExists
+		}
+		return
 	})
+	return
+}
 
+// SetChatSlowMode sets minimal interval between messages of one member in chat, zero turns slow mode off.
+func (db SqlStorage) SetChatSlowMode(ctx context.Context, actorId int64, chatId int64, seconds int) (err error) {
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		role, err := memberRole(ctx, tx, actorId, chatId)
+		if err != nil {
+			return
+		}
+		if err = checkPermission(role, PermissionManageSlowMode); err != nil {
+			return
+		}
+		if seconds == 0 {
+			_, err = tx.ExecContext(ctx, "DELETE FROM chat_slow_modes WHERE chat_id = ?", chatId)
+			return
+		}
+		_, err = tx.ExecContext(ctx, "INSERT OR REPLACE INTO chat_slow_modes(chat_id, seconds) VALUES(?, ?)",
+			chatId, seconds)
+		return
+	})
+	return
 }
 
-func (db SqlStorage) IsChatExists(chatname string) (bool, error) {
-	sqlStmt := `SELECT name FROM chats WHERE name = ?`
-	err := db.QueryRow(sqlStmt, chatname).Scan(&chatname)
+func (db SqlStorage) IsUserInChat(ctx context.Context, userId int64, chatId int64) (bool, error) {
+	stmt := `SELECT user_id FROM users_chats WHERE user_id = ? AND chat_id = ?`
+	err := db.QueryRowContext(ctx, stmt, userId, chatId).Scan(&userId)
 	return isExistByError(err)
 }
 
-func (db SqlStorage) AddChat(chatName string, userIds []int64) (chatId int64, err error) {
-	tx, err := db.Begin()
+// GetChatMembers returns members of the chat with their roles sorted by user id.
+func (db SqlStorage) GetChatMembers(ctx context.Context, chatId int64) ([]*ChatMember, error) {
+	rows, err := db.QueryContext(ctx, "SELECT user_id, role FROM users_chats WHERE chat_id = ? ORDER BY user_id",
+		chatId)
 	if err != nil {
-		return
+		return nil, err
 	}
-	defer func() {
+	defer rows.Close()
+
+	members := make([]*ChatMember, 0)
+	for rows.Next() {
+		member := &ChatMember{}
+		if err := rows.Scan(&member.UserId, &member.Role); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// AddChatMember adds user to chat and records it with system message, which id is returned.
+// Actor must have PermissionInvite, new member gets RoleMember. History before the system message is read for him.
+func (db SqlStorage) AddChatMember(ctx context.Context, actorId int64, chatId int64,
+	userId int64) (messageId int64, err error) {
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		role, err := memberRole(ctx, tx, actorId, chatId)
 		if err != nil {
-			tx.Rollback()
 			return
 		}
-		tx.Commit()
-	}()
-	result, err := db.Exec("INSERT INTO chats(name, created_at) VALUES(?, ?)", chatName, time.Now())
-	if err != nil {
+		if err = checkPermission(role, PermissionInvite); err != nil {
+			return
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO users_chats(user_id, chat_id, role) VALUES(?, ?, ?)",
+			userId, chatId, RoleMember)
+		switch {
+		case isConstraintError(err, sqlite3.ErrConstraintPrimaryKey):
+			err = ErrMemberExists
+			return
+		case isConstraintError(err, sqlite3.ErrConstraintForeignKey):
+			err = ErrUserNotFound
+			return
+		case err != nil:
+			return
+		}
+		if messageId, err = addSystemMessage(ctx, tx, actorId, chatId, SystemEvent{MemberAdded, userId}); err != nil {
+			return
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE users_chats SET last_read_message_id = ? WHERE user_id = ? AND chat_id = ?",
+			messageId, userId, chatId)
 		return
-	}
-	if chatId, err = result.LastInsertId(); err != nil {
+	})
+	return
+}
+
+// RemoveChatMember removes user from chat and records it with system message, which id is returned.
+// If actor removes himself, he leaves the chat, otherwise he must have PermissionRemoveMembers
+// and outrank removed member.
+func (db SqlStorage) RemoveChatMember(ctx context.Context, actorId int64, chatId int64,
+	userId int64) (messageId int64, err error) {
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		actorRole, err := memberRole(ctx, tx, actorId, chatId)
+		if err != nil {
+			return
+		}
+		userRole, err := memberRole(ctx, tx, userId, chatId)
+		if err == ErrNotChatMember {
+			err = ErrMemberNotFound
+		}
+		if err != nil {
+			return
+		}
+		if err = checkRemove(actorRole, userRole, actorId == userId); err != nil {
+			return
+		}
+		if _, err = tx.ExecContext(ctx, "DELETE FROM users_chats WHERE user_id = ? AND chat_id = ?",
+			userId, chatId); err != nil {
+			return
+		}
+		event := SystemEvent{MemberRemoved, userId}
+		if actorId == userId {
+			event.Type = MemberLeft
+		}
+		messageId, err = addSystemMessage(ctx, tx, actorId, chatId, event)
 		return
-	}
-	for _, userId := range userIds {
-		if _, err = db.Exec("INSERT INTO users_chats(user_id, chat_id) VALUES(?, ?)", userId, chatId); err != nil {
+	})
+	return
+}
+
+// MarkChatRead moves last read message of user in the chat forward to message and returns
+// resulting last read message id. It never moves backward. Zero messageId marks the whole chat read.
+// It returns ErrMessageNotFound if message isn't in the chat.
+func (db SqlStorage) MarkChatRead(ctx context.Context, userId int64, chatId int64,
+	messageId int64) (lastReadId int64, err error) {
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		if _, err = memberRole(ctx, tx, userId, chatId); err != nil {
 			return
 		}
-	}
+		if messageId == 0 {
+			err = tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM messages WHERE chat_id = ?",
+				chatId).Scan(&messageId)
+		} else {
+			err = tx.QueryRowContext(ctx, "SELECT id FROM messages WHERE id = ? AND chat_id = ?",
+				messageId, chatId).Scan(&messageId)
+			if err == sql.ErrNoRows {
+				err = ErrMessageNotFound
+			}
+		}
+		if err != nil {
+			return
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE users_chats SET last_read_message_id = MAX(last_read_message_id, ?)
+			WHERE user_id = ? AND chat_id = ?`, messageId, userId, chatId)
+		if err != nil {
+			return
+		}
+		err = tx.QueryRowContext(ctx, "SELECT last_read_message_id FROM users_chats WHERE user_id = ? AND chat_id = ?",
+			userId, chatId).Scan(&lastReadId)
+		return
+	})
 	return
 }
-func (db SqlStorage) IsUserInChat(userId int64, chatId int64) (bool, error) {
-	stmt := `SELECT user_id FROM users_chats WHERE user_id = ? AND chat_id = ?`
-	err := db.QueryRow(stmt, userId, chatId).Scan(&userId)
-	return isExistByError(err)
+
+// SetChatMemberRole gives role to member of the chat. Actor must have PermissionManageRoles
+// and outrank both current and new role of member. Owner can't be appointed, see TransferChatOwnership.
+func (db SqlStorage) SetChatMemberRole(ctx context.Context, actorId int64, chatId int64, userId int64,
+	role Role) (err error) {
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		actorRole, err := memberRole(ctx, tx, actorId, chatId)
+		if err != nil {
+			return
+		}
+		userRole, err := memberRole(ctx, tx, userId, chatId)
+		if err == ErrNotChatMember {
+			err = ErrMemberNotFound
+		}
+		if err != nil {
+			return
+		}
+		if err = checkRoleChange(actorRole, userRole, role); err != nil {
+			return
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE users_chats SET role = ? WHERE user_id = ? AND chat_id = ?",
+			role, userId, chatId)
+		return
+	})
+	return
+}
+
+// TransferChatOwnership makes member owner of the chat, previous owner becomes admin.
+func (db SqlStorage) TransferChatOwnership(ctx context.Context, ownerId int64, chatId int64, userId int64) (err error) {
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		ownerRole, err := memberRole(ctx, tx, ownerId, chatId)
+		if err != nil {
+			return
+		}
+		if err = checkPermission(ownerRole, PermissionTransferOwnership); err != nil {
+			return
+		}
+		_, err = memberRole(ctx, tx, userId, chatId)
+		if err == ErrNotChatMember {
+			err = ErrMemberNotFound
+		}
+		if err != nil || ownerId == userId {
+			return
+		}
+		stmt := "UPDATE users_chats SET role = ? WHERE user_id = ? AND chat_id = ?"
+		if _, err = tx.ExecContext(ctx, stmt, RoleAdmin, ownerId, chatId); err != nil {
+			return
+		}
+		_, err = tx.ExecContext(ctx, stmt, RoleOwner, userId, chatId)
+		return
+	})
+	return
+}
+
+// memberRole returns role of user in the chat or ErrNotChatMember.
+func memberRole(ctx context.Context, tx *sql.Tx, userId int64, chatId int64) (Role, error) {
+	var role Role
+	err := tx.QueryRowContext(ctx, "SELECT role FROM users_chats WHERE user_id = ? AND chat_id = ?",
+		userId, chatId).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrNotChatMember
+	}
+	return role, err
 }
-func (db SqlStorage) AddMessage(authorId int64, chatId int64, text string) (int64, error) {
-	isUserInChat, err := db.IsUserInChat(authorId, chatId)
+
+func addSystemMessage(ctx context.Context, tx *sql.Tx, authorId int64, chatId int64, event SystemEvent) (int64, error) {
+	messageId, err := insertMessage(ctx, tx, authorId, chatId, "")
 	if err != nil {
 		return 0, err
 	}
-	if !isUserInChat {
-		return 0, fmt.Errorf("user is not in chat, or either of them doesn't exist")
+	_, err = tx.ExecContext(ctx, "INSERT INTO system_messages(message_id, type, user_id) VALUES(?, ?, ?)",
+		messageId, event.Type, event.UserId)
+	return messageId, err
+}
+
+// AddMessage adds message to chat and makes it the last message of the chat.
+func (db SqlStorage) AddMessage(ctx context.Context, authorId int64, chatId int64,
+	text string) (messageId int64, err error) {
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		role, err := memberRole(ctx, tx, authorId, chatId)
+		if err != nil {
+			return
+		}
+		if err = checkPermission(role, PermissionPost); err != nil {
+			return
+		}
+		if !role.Can(PermissionManageSlowMode) {
+			if err = checkSlowMode(ctx, tx, authorId, chatId); err != nil {
+				return
+			}
+		}
+		messageId, err = insertMessage(ctx, tx, authorId, chatId, text)
+		return
+	})
+	return
+}
+
+// checkSlowMode returns *SlowModeError if author has posted in chat with slow mode too recently.
+// System messages aren't posted by author, so they are ignored.
+func checkSlowMode(ctx context.Context, tx *sql.Tx, authorId int64, chatId int64) error {
+	var seconds int
+	err := tx.QueryRowContext(ctx, "SELECT seconds FROM chat_slow_modes WHERE chat_id = ?", chatId).Scan(&seconds)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var postedAt time.Time
+	err = tx.QueryRowContext(ctx, `SELECT messages.created_at FROM messages
+		LEFT JOIN system_messages ON system_messages.message_id = messages.id
+		WHERE messages.chat_id = ? AND messages.author_id = ? AND system_messages.message_id IS NULL
+		ORDER BY messages.id DESC LIMIT 1`, chatId, authorId).Scan(&postedAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if wait := time.Until(postedAt.Add(time.Duration(seconds) * time.Second)); wait > 0 {
+		return &SlowModeError{RetryAfter: wait}
 	}
+	return nil
+}
+
+// insertMessage inserts message and updates summary of the last message in chat.
+func insertMessage(ctx context.Context, tx *sql.Tx, authorId int64, chatId int64, text string) (int64, error) {
+	createdAt := time.Now()
 	insertStatement := `INSERT INTO messages(chat_id, author_id, text, created_at) VALUES(?, ?, ?, ?)`
-	result, err := db.Exec(insertStatement, chatId, authorId, text, time.Now())
+	result, err := tx.ExecContext(ctx, insertStatement, chatId, authorId, text, createdAt)
 	if err != nil {
 		return 0, err
 	}
-	return result.LastInsertId()
+	messageId, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT OR REPLACE INTO chat_last_messages(chat_id, message_id, created_at) VALUES(?, ?, ?)",
+		chatId, messageId, createdAt)
+	return messageId, err
+}
+
+// messageSelect selects columns read by scanMessage. Statement can be continued with WHERE clause.
+const messageSelect = `SELECT messages.id, messages.chat_id, messages.author_id, messages.text, messages.created_at,
+		messages.edited_at, messages.deleted, pinned_messages.message_id IS NOT NULL,
+		system_messages.type, system_messages.user_id
+	FROM messages
+	LEFT JOIN pinned_messages ON pinned_messages.message_id = messages.id
+	LEFT JOIN system_messages ON system_messages.message_id = messages.id`
+
+// GetMessage returns message by id or ErrNotFound.
+func (db SqlStorage) GetMessage(ctx context.Context, messageId int64) (*Message, error) {
+	message, err := scanMessage(db.QueryRowContext(ctx, messageSelect+" WHERE messages.id = ?", messageId))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
 }
-func (db SqlStorage) GetMessagesFromChat(chatId int64) ([]*Message, error) {
-	rows, err := db.Query(`SELECT id, author_id, text, created_at FROM messages WHERE chat_id = ? ORDER BY created_at ASC`, chatId)
+func (db SqlStorage) GetMessagesFromChat(ctx context.Context, chatId int64) ([]*Message, error) {
+	rows, err := db.QueryContext(ctx, messageSelect+" WHERE messages.chat_id = ? ORDER BY messages.created_at ASC",
+		chatId)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	messages := make([]*Message, 0)
-	for rows.Next() {
-		message := &Message{ChatId: chatId}
-		err := rows.Scan(&message.Id, &message.AuthorId, &message.Text, &message.CreatedAt)
+	return scanMessages(rows)
+}
+func (db SqlStorage) GetMessagesPage(ctx context.Context, chatId int64, query PageQuery) ([]*Message, error) {
+	if query.BeforeId > 0 {
+		rows, err := db.QueryContext(ctx, messageSelect+` WHERE messages.chat_id = ? AND messages.id < ?
+			ORDER BY messages.id DESC LIMIT ?`, chatId, query.BeforeId, query.Limit)
 		if err != nil {
 			return nil, err
 		}
-		messages = append(messages, message)
+		messages, err := scanMessages(rows)
+		if err != nil {
+			return nil, err
+		}
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+		return messages, nil
+	}
+	rows, err := db.QueryContext(ctx, messageSelect+` WHERE messages.chat_id = ? AND messages.id > ?
+		ORDER BY messages.id ASC LIMIT ?`, chatId, query.AfterId, query.Limit)
+	if err != nil {
+		return nil, err
 	}
-	return messages, nil
+	return scanMessages(rows)
 }
 
-func isExistByError(err error) (bool, error) {
-	if err != nil {
-		if err != sql.ErrNoRows {
-			return false, err
+// EditMessage replaces text of message and saves previous text to its history.
+// Only author can edit message and only while he has PermissionPost in the chat.
+// It returns ErrMessageNotFound if message doesn't exist or is deleted.
+func (db SqlStorage) EditMessage(ctx context.Context, authorId int64, messageId int64, text string) (err error) {
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		message, err := changeableMessage(ctx, tx, messageId)
+		if err != nil {
+			return
 		}
-		return false, nil
-	}
-	return true, nil
+		if message.AuthorId != authorId {
+			err = ErrNotMessageAuthor
+			return
+		}
+		role, err := memberRole(ctx, tx, authorId, message.ChatId)
+		if err != nil {
+			return
+		}
+		if err = checkPermission(role, PermissionPost); err != nil {
+			return
+		}
+		editedAt := time.Now()
+		_, err = tx.ExecContext(ctx, "INSERT INTO message_edits(message_id, text, edited_at) VALUES(?, ?, ?)",
+			messageId, message.Text, editedAt)
+		if err != nil {
+			return
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE messages SET text = ?, edited_at = ? WHERE id = ?", text, editedAt, messageId)
+		return
+	})
+	return
+}
+
+// DeleteMessage turns message into tombstone. Its text and history are erased and it is unpinned.
+// Author can always delete his message, other members need PermissionDeleteMessages.
+// It returns ErrMessageNotFound if message doesn't exist or is already deleted.
+func (db SqlStorage) DeleteMessage(ctx context.Context, actorId int64, messageId int64) (err error) {
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		message, err := changeableMessage(ctx, tx, messageId)
+		if err != nil {
+			return
+		}
+		if message.AuthorId != actorId {
+			var role Role
+			role, err = memberRole(ctx, tx, actorId, message.ChatId)
+			if err != nil && err != ErrNotChatMember {
+				return
+			}
+			if err != nil || !role.Can(PermissionDeleteMessages) {
+				err = ErrNotMessageAuthor
+				return
+			}
+		}
+		if _, err = tx.ExecContext(ctx, "DELETE FROM message_edits WHERE message_id = ?", messageId); err != nil {
+			return
+		}
+		if _, err = tx.ExecContext(ctx, "DELETE FROM pinned_messages WHERE message_id = ?", messageId); err != nil {
+			return
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE messages SET text = '', deleted = 1 WHERE id = ?", messageId)
+		return
+	})
+	return
+}
+
+// PinMessage pins or unpins message. Actor must have PermissionPinMessages in chat of the message.
+// It returns ErrMessageNotFound if message doesn't exist or is deleted.
+func (db SqlStorage) PinMessage(ctx context.Context, actorId int64, messageId int64, pinned bool) (err error) {
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		message, err := existingMessage(ctx, tx, messageId)
+		if err != nil {
+			return
+		}
+		role, err := memberRole(ctx, tx, actorId, message.ChatId)
+		if err != nil {
+			return
+		}
+		if err = checkPermission(role, PermissionPinMessages); err != nil {
+			return
+		}
+		if pinned {
+			_, err = tx.ExecContext(ctx,
+				"INSERT OR IGNORE INTO pinned_messages(message_id, pinned_by, pinned_at) VALUES(?, ?, ?)",
+				messageId, actorId, time.Now())
+			return
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM pinned_messages WHERE message_id = ?", messageId)
+		return
+	})
+	return
+}
+
+// existingMessage returns message if it exists and isn't deleted, otherwise ErrMessageNotFound.
+func existingMessage(ctx context.Context, tx *sql.Tx, messageId int64) (*Message, error) {
+	message, err := scanMessage(tx.QueryRowContext(ctx, messageSelect+" WHERE messages.id = ?", messageId))
+	if err == sql.ErrNoRows || err == nil && message.Deleted {
+		return nil, ErrMessageNotFound
+	}
+	return message, err
+}
+
+// changeableMessage is existingMessage that can be edited or deleted.
+// System messages can't be changed by anyone, ErrNotMessageAuthor is returned for them.
+func changeableMessage(ctx context.Context, tx *sql.Tx, messageId int64) (*Message, error) {
+	message, err := existingMessage(ctx, tx, messageId)
+	if err != nil {
+		return nil, err
+	}
+	if message.System != nil {
+		return nil, ErrNotMessageAuthor
+	}
+	return message, nil
+}
+
+// GetMessageEdits returns history of message ordered from earlier edit to later.
+// It returns ErrMessageNotFound if message doesn't exist.
+func (db SqlStorage) GetMessageEdits(ctx context.Context, messageId int64) ([]*MessageEdit, error) {
+	var id int64
+	err := db.QueryRowContext(ctx, "SELECT id FROM messages WHERE id = ?", messageId).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, "SELECT text, edited_at FROM message_edits WHERE message_id = ? ORDER BY id",
+		messageId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	edits := make([]*MessageEdit, 0)
+	for rows.Next() {
+		edit := &MessageEdit{MessageId: messageId}
+		if err := rows.Scan(&edit.Text, &edit.EditedAt); err != nil {
+			return nil, err
+		}
+		edits = append(edits, edit)
+	}
+	return edits, rows.Err()
+}
+
+// GetMessageReaders returns ids of chat members, except author, that have read the message, sorted by id.
+// It returns ErrMessageNotFound if message doesn't exist.
+func (db SqlStorage) GetMessageReaders(ctx context.Context, messageId int64) ([]int64, error) {
+	var chatId, authorId int64
+	err := db.QueryRowContext(ctx, "SELECT chat_id, author_id FROM messages WHERE id = ?",
+		messageId).Scan(&chatId, &authorId)
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, `SELECT user_id FROM users_chats
+		WHERE chat_id = ? AND last_read_message_id >= ? AND user_id != ? ORDER BY user_id`, chatId, messageId, authorId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	userIds := make([]int64, 0)
+	for rows.Next() {
+		var userId int64
+		if err := rows.Scan(&userId); err != nil {
+			return nil, err
+		}
+		userIds = append(userIds, userId)
+	}
+	return userIds, rows.Err()
+}
+
+// scanMessage reads message from row selected by messageSelect.
+func scanMessage(row interface{ Scan(...interface{}) error }) (*Message, error) {
+	message := &Message{}
+	var systemType sql.NullString
+	var systemUserId sql.NullInt64
+	err := row.Scan(&message.Id, &message.ChatId, &message.AuthorId, &message.Text, &message.CreatedAt,
+		&message.EditedAt, &message.Deleted, &message.Pinned, &systemType, &systemUserId)
+	if err != nil {
+		return nil, err
+	}
+	if systemType.Valid {
+		message.System = &SystemEvent{Type: systemType.String, UserId: systemUserId.Int64}
+	}
+	return message, nil
+}
+
+// scanMessages reads messages from rows selected by messageSelect and closes rows.
+func scanMessages(rows *sql.Rows) ([]*Message, error) {
+	defer rows.Close()
+	messages := make([]*Message, 0)
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+func isExistByError(err error) (bool, error) {
+	if err != nil {
+		if err != sql.ErrNoRows {
+			return false, err
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// isConstraintError reports whether err is sqlite violation of constraint of specified kind.
+func isConstraintError(err error, code sqlite3.ErrNoExtended) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == code
+}
+
+// searchIndexSchema creates FTS5 index over text of messages. Index doesn't store text itself
+// and triggers keep it in sync with messages table.
+const searchIndexSchema = `
+	CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(text, content='messages', content_rowid='id');
+	CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+	    INSERT INTO messages_fts(rowid, text) VALUES (new.id, new.text);
+	END;
+	CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+	    INSERT INTO messages_fts(messages_fts, rowid, text) VALUES ('delete', old.id, old.text);
+	END;
+	CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF text ON messages BEGIN
+	    INSERT INTO messages_fts(messages_fts, rowid, text) VALUES ('delete', old.id, old.text);
+	    INSERT INTO messages_fts(rowid, text) VALUES (new.id, new.text);
+	END;
+`
+
+// CheckSearch returns ErrSearchUnavailable if sqlite is built without FTS5, so server can refuse to start
+// instead of answering every search with error.
+func (db SqlStorage) CheckSearch() error {
+	isAvailable, err := db.isSearchAvailable(context.Background())
+	if err != nil {
+		return err
+	}
+	if !isAvailable {
+		return ErrSearchUnavailable
+	}
+	return nil
+}
+
+// isSearchAvailable reports whether sqlite is built with FTS5.
+func (db SqlStorage) isSearchAvailable(ctx context.Context) (bool, error) {
+	var isAvailable bool
+	err := db.QueryRowContext(ctx, "SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&isAvailable)
+	return isAvailable, err
+}
+
+// ensureSearchIndex creates search index if FTS5 is available and fills it with existing messages.
+// Index isn't migration, because availability depends on how binary is built, not on schema version.
+// Index is rebuilt only if some of its triggers are missing, for example after messages table was recreated.
+func (db SqlStorage) ensureSearchIndex() (err error) {
+	ctx := context.Background()
+	isAvailable, err := db.isSearchAvailable(ctx)
+	if err != nil || !isAvailable {
+		return
+	}
+	var triggers int
+	err = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger'
+		AND name IN ('messages_fts_insert', 'messages_fts_delete', 'messages_fts_update')`).Scan(&triggers)
+	if err != nil || triggers == 3 {
+		return
+	}
+	return db.inTransaction(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, searchIndexSchema); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "INSERT INTO messages_fts(messages_fts) VALUES ('rebuild')")
+		return err
+	})
+}
+
+// SearchMessages requires sqlite built with FTS5, server makes sure of it with CheckSearch on start.
+func (db SqlStorage) SearchMessages(ctx context.Context, userId int64, query SearchQuery) ([]*SearchResult, error) {
+	results := make([]*SearchResult, 0)
+	terms := searchTerms(query.Text)
+	if len(terms) == 0 {
+		return results, nil
+	}
+	// every term is quoted, so user can't use FTS5 query syntax
+	match := `"` + strings.Join(terms, `" "`) + `"`
+	stmt := `SELECT messages.id, messages.chat_id, messages.author_id, messages.text, messages.created_at,
+			messages.edited_at, EXISTS(SELECT 1 FROM pinned_messages WHERE message_id = messages.id),
+			snippet(messages_fts, 0, ?, ?, '...', 16)
+		FROM messages_fts
+		INNER JOIN messages ON messages.id = messages_fts.rowid
+		INNER JOIN users_chats ON users_chats.chat_id = messages.chat_id AND users_chats.user_id = ?
+		WHERE messages_fts MATCH ? AND NOT messages.deleted`
+	args := []interface{}{SnippetMatchStart, SnippetMatchEnd, userId, match}
+	if query.ChatId != 0 {
+		stmt += " AND messages.chat_id = ?"
+		args = append(args, query.ChatId)
+	}
+	if query.AuthorId != 0 {
+		stmt += " AND messages.author_id = ?"
+		args = append(args, query.AuthorId)
+	}
+	// times may be stored with different time zones, so they are compared as julian days
+	if !query.From.IsZero() {
+		stmt += " AND julianday(messages.created_at) >= julianday(?)"
+		args = append(args, query.From)
+	}
+	if !query.To.IsZero() {
+		stmt += " AND julianday(messages.created_at) < julianday(?)"
+		args = append(args, query.To)
+	}
+	stmt += " ORDER BY bm25(messages_fts), messages.id DESC LIMIT ? OFFSET ?"
+	args = append(args, query.Limit, query.Offset)
+
+	rows, err := db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		result := &SearchResult{}
+		err := rows.Scan(&result.Id, &result.ChatId, &result.AuthorId, &result.Text, &result.CreatedAt,
+			&result.EditedAt, &result.Pinned, &result.Snippet)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// AddWebhook registers webhook of the user. Webhook of chat (non-zero chatId) can be added only by member
+// with PermissionManageWebhooks, global one by anyone.
+func (db SqlStorage) AddWebhook(ctx context.Context, userId int64, chatId int64, url string, secret string,
+	events []string) (webhookId int64, err error) {
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		var chat interface{}
+		if chatId != 0 {
+			var role Role
+			role, err = memberRole(ctx, tx, userId, chatId)
+			if err != nil {
+				return
+			}
+			if err = checkPermission(role, PermissionManageWebhooks); err != nil {
+				return
+			}
+			chat = chatId
+		}
+		result, err := tx.ExecContext(ctx, `INSERT INTO webhooks(user_id, chat_id, url, secret, events, created_at)
+			VALUES(?, ?, ?, ?, ?, ?)`, userId, chat, url, secret, joinEvents(events), time.Now())
+		if isConstraintError(err, sqlite3.ErrConstraintForeignKey) {
+			err = ErrUserNotFound
+		}
+		if err != nil {
+			return
+		}
+		webhookId, err = result.LastInsertId()
+		return
+	})
+	return
+}
+
+// webhookSelect selects columns read by scanWebhooks. Statement can be continued with WHERE clause.
+const webhookSelect = `SELECT id, user_id, COALESCE(chat_id, 0), url, secret, events, created_at FROM webhooks`
+
+// GetUserWebhooks returns webhooks registered by the user ordered by id.
+func (db SqlStorage) GetUserWebhooks(ctx context.Context, userId int64) ([]*Webhook, error) {
+	rows, err := db.QueryContext(ctx, webhookSelect+" WHERE user_id = ? ORDER BY id", userId)
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhooks(rows)
+}
+
+// DeleteWebhook deletes webhook with its deliveries. Only user that registered webhook can delete it,
+// for others it is ErrWebhookNotFound.
+func (db SqlStorage) DeleteWebhook(ctx context.Context, userId int64, webhookId int64) error {
+	result, err := db.ExecContext(ctx, "DELETE FROM webhooks WHERE id = ? AND user_id = ?", webhookId, userId)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// GetChatWebhooks returns webhooks subscribed to event in the chat: webhooks of the chat and global webhooks
+// of its current members. They are ordered by id.
+func (db SqlStorage) GetChatWebhooks(ctx context.Context, chatId int64, event string) ([]*Webhook, error) {
+	rows, err := db.QueryContext(ctx, webhookSelect+` WHERE chat_id = ?
+		OR (chat_id IS NULL AND user_id IN (SELECT user_id FROM users_chats WHERE chat_id = ?))
+		ORDER BY id`, chatId, chatId)
+	if err != nil {
+		return nil, err
+	}
+	webhooks, err := scanWebhooks(rows)
+	if err != nil {
+		return nil, err
+	}
+	subscribed := make([]*Webhook, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		if webhook.Wants(event) {
+			subscribed = append(subscribed, webhook)
+		}
+	}
+	return subscribed, nil
+}
+
+// scanWebhooks reads webhooks from rows selected by webhookSelect and closes rows.
+func scanWebhooks(rows *sql.Rows) ([]*Webhook, error) {
+	defer rows.Close()
+	webhooks := make([]*Webhook, 0)
+	for rows.Next() {
+		webhook := &Webhook{}
+		var events string
+		err := rows.Scan(&webhook.Id, &webhook.UserId, &webhook.ChatId, &webhook.URL, &webhook.Secret,
+			&events, &webhook.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		webhook.Events = splitEvents(events)
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// AddWebhookDelivery queues payload of event for webhook. Delivery is due immediately.
+func (db SqlStorage) AddWebhookDelivery(ctx context.Context, webhookId int64, event string,
+	payload []byte) (int64, error) {
+	now := time.Now()
+	result, err := db.ExecContext(ctx,
+		`INSERT INTO webhook_deliveries(webhook_id, event, payload, status, next_attempt_at, created_at)
+		VALUES(?, ?, ?, ?, ?, ?)`, webhookId, event, string(payload), DeliveryPending, now, now)
+	if isConstraintError(err, sqlite3.ErrConstraintForeignKey) {
+		return 0, ErrWebhookNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// deliverySelect selects columns read by scanDelivery. Statement can be continued with WHERE clause.
+const deliverySelect = `SELECT webhook_deliveries.id, webhook_deliveries.webhook_id, webhook_deliveries.event,
+		webhook_deliveries.payload, webhook_deliveries.status, webhook_deliveries.attempt_count,
+		webhook_deliveries.next_attempt_at, webhook_deliveries.created_at, webhooks.url, webhooks.secret
+	FROM webhook_deliveries
+	INNER JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id`
+
+// ClaimDueWebhookDeliveries returns at most limit pending deliveries which next attempt isn't later than now,
+// from the most overdue one, and postpones their next attempt to claimedUntil. Dispatchers of other instances
+// sharing database don't get claimed deliveries, and if claiming one dies before its attempt is recorded,
+// deliveries are due again after claimedUntil. Deliveries have URL and Secret of their webhooks.
+func (db SqlStorage) ClaimDueWebhookDeliveries(ctx context.Context, now time.Time, claimedUntil time.Time,
+	limit int) (deliveries []*WebhookDelivery, err error) {
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		// times may be stored with different time zones, so they are compared as julian days
+		rows, err := tx.QueryContext(ctx, deliverySelect+` WHERE webhook_deliveries.status = ?
+			AND julianday(webhook_deliveries.next_attempt_at) <= julianday(?)
+			ORDER BY julianday(webhook_deliveries.next_attempt_at), webhook_deliveries.id LIMIT ?`,
+			DeliveryPending, now, limit)
+		if err != nil {
+			return
+		}
+		defer rows.Close()
+		deliveries = make([]*WebhookDelivery, 0)
+		for rows.Next() {
+			delivery, err := scanDelivery(rows)
+			if err != nil {
+				return err
+			}
+			deliveries = append(deliveries, delivery)
+		}
+		if err = rows.Err(); err != nil {
+			return
+		}
+		rows.Close()
+		for _, delivery := range deliveries {
+			if _, err = tx.ExecContext(ctx, "UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id = ?",
+				claimedUntil, delivery.Id); err != nil {
+				return
+			}
+			claimed := claimedUntil
+			delivery.NextAttemptAt = &claimed
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// AddWebhookAttempt records attempt of delivery and sets its new status.
+// Delivery that stays pending will be due at nextAttemptAt. It returns ErrNotFound if delivery doesn't exist.
+func (db SqlStorage) AddWebhookAttempt(ctx context.Context, attempt *WebhookAttempt, status string,
+	nextAttemptAt time.Time) (err error) {
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		var next interface{}
+		if status == DeliveryPending {
+			next = nextAttemptAt
+		}
+		result, err := tx.ExecContext(ctx, `UPDATE webhook_deliveries SET status = ?, attempt_count = attempt_count + 1,
+			next_attempt_at = ? WHERE id = ?`, status, next, attempt.DeliveryId)
+		if err != nil {
+			return
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return
+		}
+		if updated == 0 {
+			err = ErrNotFound
+			return
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO webhook_attempts(delivery_id, attempted_at, status_code, error) VALUES(?, ?, ?, ?)`,
+			attempt.DeliveryId, attempt.AttemptedAt, attempt.StatusCode, attempt.Error)
+		return
+	})
+	return
+}
+
+// GetWebhookDeliveries returns at most limit latest deliveries of webhook with their attempts, from the latest one.
+// Empty status matches any status. Only user that registered webhook can inspect it,
+// for others it is ErrWebhookNotFound.
+func (db SqlStorage) GetWebhookDeliveries(ctx context.Context, userId int64, webhookId int64, status string,
+	limit int) ([]*WebhookDelivery, error) {
+	var ownerId int64
+	err := db.QueryRowContext(ctx, "SELECT user_id FROM webhooks WHERE id = ?", webhookId).Scan(&ownerId)
+	if err == sql.ErrNoRows || (err == nil && ownerId != userId) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, deliverySelect+` WHERE webhook_deliveries.webhook_id = ?
+		AND (? = '' OR webhook_deliveries.status = ?)
+		ORDER BY webhook_deliveries.id DESC LIMIT ?`, webhookId, status, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := make([]*WebhookDelivery, 0)
+	byId := make(map[int64]*WebhookDelivery)
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		delivery.URL, delivery.Secret = "", ""
+		delivery.Attempts = make([]*WebhookAttempt, 0)
+		deliveries = append(deliveries, delivery)
+		byId[delivery.Id] = delivery
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return deliveries, nil
+	}
+	// attempts of all listed deliveries are loaded at once
+	attemptRows, err := db.QueryContext(ctx, `SELECT delivery_id, attempted_at, status_code, error FROM webhook_attempts
+		WHERE delivery_id IN (SELECT id FROM webhook_deliveries WHERE webhook_id = ? AND id >= ?)
+		ORDER BY id`, webhookId, deliveries[len(deliveries)-1].Id)
+	if err != nil {
+		return nil, err
+	}
+	defer attemptRows.Close()
+	for attemptRows.Next() {
+		attempt := &WebhookAttempt{}
+		err := attemptRows.Scan(&attempt.DeliveryId, &attempt.AttemptedAt, &attempt.StatusCode, &attempt.Error)
+		if err != nil {
+			return nil, err
+		}
+		if delivery, ok := byId[attempt.DeliveryId]; ok {
+			delivery.Attempts = append(delivery.Attempts, attempt)
+		}
+	}
+	return deliveries, attemptRows.Err()
+}
+
+// scanDelivery reads delivery from row selected by deliverySelect.
+func scanDelivery(row interface{ Scan(...interface{}) error }) (*WebhookDelivery, error) {
+	delivery := &WebhookDelivery{}
+	var payload []byte
+	err := row.Scan(&delivery.Id, &delivery.WebhookId, &delivery.Event, &payload, &delivery.Status,
+		&delivery.AttemptCount, &delivery.NextAttemptAt, &delivery.CreatedAt, &delivery.URL, &delivery.Secret)
+	if err != nil {
+		return nil, err
+	}
+	delivery.Payload = payload
+	return delivery, nil
+}
+
+// ReserveIdempotencyKey returns saved key with the same user, endpoint and key if it was created after
+// expiredBefore and, if it's still in progress, after abandonedBefore. Otherwise it saves key as in progress
+// (expired or abandoned one is replaced) and returns nil.
+func (db SqlStorage) ReserveIdempotencyKey(ctx context.Context, key *IdempotencyKey, expiredBefore time.Time,
+	abandonedBefore time.Time) (saved *IdempotencyKey, err error) {
+	err = db.inTransaction(ctx, func(tx *sql.Tx) (err error) {
+		// times may be stored with different time zones, so they are compared as julian days
+		stored := &IdempotencyKey{UserId: key.UserId, Endpoint: key.Endpoint, Key: key.Key}
+		err = tx.QueryRowContext(ctx, `SELECT request_hash, status, body, created_at FROM idempotency_keys
+			WHERE user_id = ? AND endpoint = ? AND idempotency_key = ? AND julianday(created_at) >= julianday(?)
+			    AND (status != 0 OR julianday(created_at) >= julianday(?))`,
+			key.UserId, key.Endpoint, key.Key, expiredBefore, abandonedBefore).
+			Scan(&stored.RequestHash, &stored.Status, &stored.Body, &stored.CreatedAt)
+		if err == nil {
+			saved = stored
+			return nil
+		}
+		if err != sql.ErrNoRows {
+			return
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT OR REPLACE INTO idempotency_keys(user_id, endpoint, idempotency_key, request_hash,
+			status, body, created_at) VALUES(?, ?, ?, ?, 0, ?, ?)`,
+			key.UserId, key.Endpoint, key.Key, key.RequestHash, []byte{}, time.Now())
+		return
+	})
+	return
+}
+
+// CompleteIdempotencyKey saves Status and Body of reserved key. It returns ErrNotFound if key isn't reserved.
+func (db SqlStorage) CompleteIdempotencyKey(ctx context.Context, key *IdempotencyKey) error {
+	// nil slice would be stored as NULL
+	body := append([]byte{}, key.Body...)
+	result, err := db.ExecContext(ctx, `UPDATE idempotency_keys SET status = ?, body = ?
+		WHERE user_id = ? AND endpoint = ? AND idempotency_key = ?`,
+		key.Status, body, key.UserId, key.Endpoint, key.Key)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey deletes key that is still in progress, so request with it can be retried.
+func (db SqlStorage) ReleaseIdempotencyKey(ctx context.Context, key *IdempotencyKey) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM idempotency_keys
+		WHERE user_id = ? AND endpoint = ? AND idempotency_key = ? AND status = 0`,
+		key.UserId, key.Endpoint, key.Key)
+	return err
+}
+
+// DeleteExpiredIdempotencyKeys deletes keys created before expiredBefore and returns their number.
+func (db SqlStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	result, err := db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE julianday(created_at) < julianday(?)",
+		expiredBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
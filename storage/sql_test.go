@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -33,19 +35,18 @@ func openDb() (sqlStorage SqlStorage, teardown func(), err error) {
 		return
 	}
 	tempFile.Close()
-	db, err := sql.Open("sqlite3", tempFile.Name())
+	sqlStorage, err = OpenSqlite(tempFile.Name())
 	if err != nil {
 		err = fmt.Errorf("Open db failed: %s", err)
 		return
 	}
-	sqlStorage = SqlStorage{db}
-	err = sqlStorage.Setup()
+	err = sqlStorage.Migrate()
 	if err != nil {
-		err = fmt.Errorf("Setup failed: %s", err)
+		err = fmt.Errorf("Migrate failed: %s", err)
 		return
 	}
 	teardown = func() {
-		db.Close()
+		sqlStorage.Close()
 		os.Remove(tempFile.Name())
 	}
 	return
@@ -64,10 +65,12 @@ func getSqlStorage(t *testing.T, clearTables []string) (SqlStorage, func()) {
 	}
 }
 
-func TestSetup(t *testing.T) {
+func TestMigrate(t *testing.T) {
 	sqlStorage, teardown := getSqlStorage(t, []string{})
 	defer teardown()
-	rows, err := sqlStorage.Query(`SELECT name FROM sqlite_master WHERE type = "table"`)
+	// search index exists only if sqlite is built with FTS5
+	rows, err := sqlStorage.Query(`SELECT name FROM sqlite_master WHERE type = "table"
+		AND name NOT LIKE 'messages_fts%'`)
 	if err != nil {
 		t.Fatal("Failed to query table names: ", err)
 	}
@@ -77,6 +80,17 @@ func TestSetup(t *testing.T) {
 		"chats",
 		"users_chats",
 		"messages",
+		"schema_migrations",
+		"user_tokens",
+		"message_edits",
+		"system_messages",
+		"pinned_messages",
+		"chat_last_messages",
+		"webhooks",
+		"webhook_deliveries",
+		"webhook_attempts",
+		"idempotency_keys",
+		"chat_slow_modes",
 	}
 	tablesPresented := make([]string, 0, len(tablesShouldExist))
 	for rows.Next() {
@@ -92,207 +106,128 @@ func TestSetup(t *testing.T) {
 	assert.Equal(t, tablesShouldExist, tablesPresented)
 }
 
-func TestIsUserExists(t *testing.T) {
-	sqlStorage, teardown := getSqlStorage(t, []string{"users"})
-	defer teardown()
-	_, err := sqlStorage.Exec(`INSERT INTO users(username, created_at) VALUES ("user1", "2019-01-01 10:00:00")`)
+func TestCheckReady(t *testing.T) {
+	sqlStorage, teardown, err := openDb()
 	if err != nil {
-		t.Fatal("Insert failed: ", err)
+		t.Fatal(err)
 	}
+	defer teardown()
+	assert.NoError(t, sqlStorage.CheckReady())
 
-	isExist, err := sqlStorage.IsUserExists("user1")
-	if err != nil {
-		t.Fatal("IsUserExists failed: ", err)
+	if _, err := sqlStorage.Migrator().Down(1); err != nil {
+		t.Fatal("Down failed: ", err)
 	}
-	assert.True(t, isExist)
+	assert.Error(t, sqlStorage.CheckReady())
 
-	isExist, err = sqlStorage.IsUserExists("not_user")
-	if err != nil {
-		t.Fatal("IsUserExists failed: ", err)
-	}
-	assert.False(t, isExist)
+	sqlStorage.Close()
+	assert.Error(t, sqlStorage.CheckReady())
 }
 
-func TestAreUsersExist(t *testing.T) {
-	sqlStorage, teardown := getSqlStorage(t, []string{"users"})
-	defer teardown()
-	_, err := sqlStorage.Exec(`INSERT INTO users(id, username, created_at) VALUES 
-		(1, "user1", "2019-01-01 10:00:00"),
-		(2, "user2", "2019-01-01 10:00:00"),
-		(3, "user3", "2019-01-01 10:00:00")`)
+func TestOpenSqliteWithOptions(t *testing.T) {
+	tempFile, err := ioutil.TempFile("", "sql-db-test-options-")
 	if err != nil {
-		t.Fatal("Insert failed: ", err)
+		t.Fatal("Creation temp file for db failed: ", err)
 	}
-
-	cases := []struct {
-		Ids      []int64
-		AreExist bool
-	}{
-		{[]int64{1, 2, 3}, true},
-		{[]int64{1, 3}, true},
-		{[]int64{3}, true},
-		{[]int64{1, 2, 4}, false},
-		{[]int64{5}, false},
-	}
-	for _, testCase := range cases {
-		testCase := testCase
-		t.Run(fmt.Sprintf("Users: %v", testCase.Ids), func(t *testing.T) {
-			areExist, err := sqlStorage.AreUsersExistByIds(testCase.Ids)
-			assert.NoError(t, err)
-			assert.Equal(t, testCase.AreExist, areExist)
-		})
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+	sqlStorage, err := OpenSqlite("file:" + tempFile.Name() + "?cache=shared")
+	if !assert.NoError(t, err) {
+		return
 	}
-
+	defer sqlStorage.Close()
+	var foreignKeys int
+	assert.NoError(t, sqlStorage.QueryRow("PRAGMA foreign_keys").Scan(&foreignKeys))
+	assert.Equal(t, 1, foreignKeys)
 }
 
-func TestAddUser(t *testing.T) {
+func TestWithTimeout(t *testing.T) {
 	sqlStorage, teardown := getSqlStorage(t, []string{"users"})
 	defer teardown()
-	type UserData struct {
-		Id   int64
-		Name string
-	}
-	users := []UserData{
-		UserData{0, "test_user"},
-		UserData{0, "some_another"},
-		UserData{0, "one_spare"},
-	}
-	timeBeforeInserting := time.Now()
-	usersMap := make(map[int64]UserData)
-	for _, user := range users {
-		id, err := sqlStorage.AddUser(user.Name)
-		assert.NoError(t, err, "Add user failed")
-		user.Id = id
-		usersMap[user.Id] = user
-	}
-	rows, err := sqlStorage.Query("SELECT id, username, created_at FROM users")
-	assert.NoError(t, err, "SELECT query failed")
-	defer rows.Close()
-	for rows.Next() {
-		var id int64
-		var name string
-		var createdAt time.Time
-		err := rows.Scan(&id, &name, &createdAt)
-		assert.NoError(t, err, "Scan failed")
-		user, ok := usersMap[id]
-		assert.True(t, ok, "Retrieve from users map failed")
-		assert.Equal(t, user.Name, name)
-		assert.False(t, createdAt.Before(timeBeforeInserting) || createdAt.After(time.Now()))
-	}
-
-	var numberOfUsers int
-	err = sqlStorage.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&numberOfUsers)
+	ctx := context.Background()
+	assert.Equal(t, Storage(sqlStorage), WithTimeout(sqlStorage, 0))
+
+	_, err := WithTimeout(sqlStorage, time.Nanosecond).AddUser(ctx, "user_1")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+	// cancellation of the caller is kept
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = WithTimeout(sqlStorage, time.Minute).AddUser(cancelled, "user_1")
+	assert.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+
+	_, err = WithTimeout(sqlStorage, time.Minute).AddUser(ctx, "user_1")
 	assert.NoError(t, err)
-	assert.Equal(t, len(users), numberOfUsers, "Number of inserted users not equal to number of users expected to be inserted")
-
 }
-func TestGetUserChats(t *testing.T) {
-	sqlStorage, teardown := getSqlStorage(t, []string{"users_chats", "chats", "users"})
-	defer teardown()
-	_, err := sqlStorage.Exec(`INSERT INTO users(id, username, created_at) VALUES 
-		(1, "user1", "2019-01-01 10:00:00"),
-		(2, "user2", "2019-01-01 10:00:00")`)
-	if err != nil {
-		t.Fatal("Insert user failed: ", err)
-	}
-	chats := []*Chat{
-		&Chat{11, "chat1", time.Date(2019, time.January, 1, 10, 0, 0, 0, time.UTC), nil},
-		&Chat{12, "chat2", time.Date(2019, time.January, 1, 11, 0, 0, 0, time.UTC), nil},
-		&Chat{13, "chat3", time.Date(2019, time.January, 1, 12, 0, 0, 0, time.UTC), nil},
-		&Chat{14, "chat4", time.Date(2019, time.January, 1, 13, 0, 0, 0, time.UTC), nil},
-	}
-	for _, chat := range chats {
-		_, err := sqlStorage.Exec(`INSERT INTO chats(id, name, created_at) VALUES (?, ?, ?)`, chat.Id, chat.Name, chat.CreatedAt)
-		if err != nil {
-			t.Fatal("Insert chats failed: ", err)
-		}
-	}
-	_, err = sqlStorage.Exec(`INSERT INTO users_chats(user_id, chat_id) VALUES
-		(1, 11),
-		(1, 12),
-		(1, 14),
-		(2, 12),
-		(2, 13)`)
-	if err != nil {
-		t.Fatal("Insert users_chats failed: ", err)
-	}
-
-	assertChats := func(expected, actual []*Chat) {
-		assert.Equal(t, len(expected), len(actual))
-		for i, chat := range expected {
-			assert.Equal(t, chat.Id, actual[i].Id)
-			assert.Equal(t, chat.Name, actual[i].Name)
-			assert.Equal(t, chat.CreatedAt, actual[i].CreatedAt)
-		}
 
+func isUserAdded(t *testing.T, db SqlStorage, username string) bool {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", username).Scan(&count); err != nil {
+		t.Fatal("Query users failed: ", err)
 	}
-	user1Chats, err := sqlStorage.GetUserChats(1)
-	assert.NoError(t, err)
-	assertChats([]*Chat{chats[0], chats[1], chats[3]}, user1Chats)
-
-	user2Chats, err := sqlStorage.GetUserChats(2)
-	assert.NoError(t, err)
-	assertChats([]*Chat{chats[1], chats[2]}, user2Chats)
-
+	return count > 0
 }
-func TestGetUserIdsFromChat(t *testing.T) {
-	sqlStorage, teardown := getSqlStorage(t, []string{"users_chats", "chats", "users"})
-	defer teardown()
-	_, err := sqlStorage.Exec(`INSERT INTO users(id, username, created_at) VALUES 
-		(1, "user1", "2019-01-01 10:00:00"),
-		(2, "user2", "2019-01-01 10:00:00"),
-		(3, "user3", "2019-01-01 10:00:00"),
-		(4, "user4", "2019-01-01 10:00:00")`)
-	if err != nil {
-		t.Fatal("Insert user failed: ", err)
-	}
-	_, err = sqlStorage.Exec(`INSERT INTO chats(id, name, created_at) VALUES 
-		(10, "chat1", "2019-01-01 10:00:00"),
-		(11, "chat2", "2019-01-01 10:00:00")`)
-	if err != nil {
-		t.Fatal("Insert chats failed: ", err)
-	}
-	_, err = sqlStorage.Exec(`INSERT INTO users_chats(user_id, chat_id) VALUES
-		(1, 10),
-		(2, 10),
-		(4, 10),
-		(3, 11),
-		(4, 11)`)
-	if err != nil {
-		t.Fatal("Insert users_chats failed: ", err)
-	}
 
-	userIds, err := sqlStorage.getUserIdsFromChat(10)
-	assert.NoError(t, err)
-	assert.Equal(t, []int64{1, 2, 4}, userIds)
-
-	userIds, err = sqlStorage.getUserIdsFromChat(11)
-	assert.NoError(t, err)
-	assert.Equal(t, []int64{3, 4}, userIds)
+func TestInTransaction(t *testing.T) {
+	sqlStorage, teardown := getSqlStorage(t, []string{"users"})
+	defer teardown()
+	ctx := context.Background()
 
+	failed := errors.New("failed")
+	err := sqlStorage.inTransaction(ctx, func(tx *sql.Tx) error {
+		if _, err := insertUser(ctx, tx, "user_1"); err != nil {
+			return err
+		}
+		return failed
+	})
+	assert.Equal(t, failed, err)
+	assert.False(t, isUserAdded(t, sqlStorage, "user_1"), "insert must be rolled back")
+
+	assert.Panics(t, func() {
+		sqlStorage.inTransaction(ctx, func(tx *sql.Tx) error {
+			insertUser(ctx, tx, "user_1")
+			panic("failed")
+		})
+	})
+	assert.False(t, isUserAdded(t, sqlStorage, "user_1"), "insert must be rolled back after panic")
+
+	assert.NoError(t, sqlStorage.inTransaction(ctx, func(tx *sql.Tx) error {
+		_, err := insertUser(ctx, tx, "user_1")
+		return err
+	}))
+	assert.True(t, isUserAdded(t, sqlStorage, "user_1"))
 }
-func TestSortChatsByLastMessage(t *testing.T) {
-	sqlStorage, teardown := getSqlStorage(t, []string{"messages", "chats", "users"})
+
+func TestGetUserChatsOrderByLastMessage(t *testing.T) {
+	ctx := context.Background()
+	sqlStorage, teardown := getSqlStorage(t, []string{"messages", "users_chats", "chats", "users"})
 	defer teardown()
 	_, err := sqlStorage.Exec(`INSERT INTO users(id, username, created_at) VALUES (10, "user", "2019-01-01 10:00:00")`)
 	if err != nil {
 		t.Fatal("Insert user failed: ", err)
 	}
 	chats := []*Chat{
-		&Chat{5, "chat5", time.Date(2019, time.January, 1, 14, 0, 0, 0, time.UTC), nil},
-		&Chat{4, "chat4", time.Date(2019, time.January, 1, 13, 0, 0, 0, time.UTC), nil},
-		&Chat{3, "chat3", time.Date(2019, time.January, 1, 12, 0, 0, 0, time.UTC), nil},
-		&Chat{2, "chat2", time.Date(2019, time.January, 1, 11, 0, 0, 0, time.UTC), nil},
-		&Chat{1, "chat1", time.Date(2019, time.January, 1, 10, 0, 0, 0, time.UTC), nil},
+		&Chat{Id: 5, Name: "chat5", CreatedAt: time.Date(2019, time.January, 1, 14, 0, 0, 0, time.UTC)},
+		&Chat{Id: 4, Name: "chat4", CreatedAt: time.Date(2019, time.January, 1, 13, 0, 0, 0, time.UTC)},
+		&Chat{Id: 3, Name: "chat3", CreatedAt: time.Date(2019, time.January, 1, 12, 0, 0, 0, time.UTC)},
+		// it is 11:30 in UTC, so chat must be ordered by time, not by its text
+		&Chat{Id: 6, Name: "chat6", CreatedAt: time.Date(2019, time.January, 1, 16, 30, 0, 0, time.FixedZone("", 5*60*60))},
+		&Chat{Id: 2, Name: "chat2", CreatedAt: time.Date(2019, time.January, 1, 11, 0, 0, 0, time.UTC)},
+		&Chat{Id: 1, Name: "chat1", CreatedAt: time.Date(2019, time.January, 1, 10, 0, 0, 0, time.UTC)},
 	}
 	for _, chat := range chats {
 		_, err := sqlStorage.Exec(`INSERT INTO chats(id, name, created_at) VALUES (?, ?, ?)`, chat.Id, chat.Name, chat.CreatedAt)
 		if err != nil {
 			t.Fatal("Insert chats failed: ", err)
 		}
+		_, err = sqlStorage.Exec(`INSERT INTO users_chats(user_id, chat_id) VALUES (10, ?)`, chat.Id)
+		if err != nil {
+			t.Fatal("Insert users_chats failed: ", err)
+		}
 	}
 
 	testOrder := func(expected []int64) {
+		t.Helper()
+		chats, err := sqlStorage.GetUserChats(ctx, 10)
+		assert.NoError(t, err)
 		actualOrder := make([]int64, len(chats))
 		for i, chat := range chats {
 			actualOrder[i] = chat.Id
@@ -300,247 +235,21 @@ func TestSortChatsByLastMessage(t *testing.T) {
 		assert.Equal(t, expected, actualOrder)
 	}
 
-	sqlStorage.sortChatsByLastMessage(chats)
-	testOrder([]int64{1, 2, 3, 4, 5})
+	testOrder([]int64{5, 4, 3, 6, 2, 1})
 
-	_, err = sqlStorage.Exec(`INSERT INTO messages(chat_id, author_id, text, created_at) VALUES(2, 10, "", "2019-01-01 16:00:00")`)
+	_, err = sqlStorage.AddMessage(ctx, 10, 2, "")
 	if err != nil {
-		t.Fatal("Insert message failed: ", err)
+		t.Fatal("Add message failed: ", err)
 	}
-	sqlStorage.sortChatsByLastMessage(chats)
-	testOrder([]int64{1, 3, 4, 5, 2})
+	testOrder([]int64{2, 5, 4, 3, 6, 1})
 
-	_, err = sqlStorage.Exec(`INSERT INTO messages(chat_id, author_id, text, created_at) VALUES(1, 10, "", "2019-01-01 15:00:00")`)
+	_, err = sqlStorage.AddMessage(ctx, 10, 1, "")
 	if err != nil {
-		t.Fatal("Insert message failed: ", err)
+		t.Fatal("Add message failed: ", err)
 	}
-	sqlStorage.sortChatsByLastMessage(chats)
-	testOrder([]int64{3, 4, 5, 1, 2})
-
+	testOrder([]int64{1, 2, 5, 4, 3, 6})
 }
-func TestIsChatExists(t *testing.T) {
-	sqlStorage, teardown := getSqlStorage(t, []string{"chats"})
-	defer teardown()
-	_, err := sqlStorage.Exec(`INSERT INTO chats(name, created_at) VALUES ("chat1", "2019-01-01 10:00:00")`)
-	if err != nil {
-		t.Fatal("Insert failed: ", err)
-	}
 
-	isExist, err := sqlStorage.IsChatExists("chat1")
-	if err != nil {
-		t.Fatal("IsChatExists failed: ", err)
-	}
-	assert.True(t, isExist)
-
-	isExist, err = sqlStorage.IsChatExists("not_chat")
-	if err != nil {
-		t.Fatal("IsChatExists failed: ", err)
-	}
-	assert.False(t, isExist)
-}
-
-func TestAddChat(t *testing.T) {
-	sqlStorage, teardown := getSqlStorage(t, []string{"users_chats", "users", "chats"})
-	defer teardown()
-	//add users
-	_, err := sqlStorage.Exec(`INSERT INTO users(id, username, created_at) VALUES 
-		(1, "my_favorite", "2019-01-01 10:00:00"),
-		(2, "another_one", "2019-01-01 10:00:00"),
-		(3, "one_I_dont_really_like", "2019-01-01 10:00:00")`)
-	if err != nil {
-		t.Fatal("Insert users failed: ", err)
-	}
-	type TestCase struct {
-		TestName   string
-		ChatName   string
-		UserIds    []int64
-		ShouldFail bool
-	}
-	testCases := []TestCase{
-		TestCase{
-			TestName: "Add chat with all 3 users",
-			ChatName: "Telegram_news",
-			UserIds:  []int64{1, 2, 3},
-		},
-		TestCase{
-			TestName: "Add chat with single user",
-			ChatName: "ethereum_future",
-			UserIds:  []int64{2},
-		},
-		TestCase{
-			TestName:   "Chat with non existent user",
-			ChatName:   "ethereum_future",
-			UserIds:    []int64{1, 3, 5},
-			ShouldFail: true,
-		},
-	}
-	var chatsCreated = 0
-	for _, testCase := range testCases {
-		testCase := testCase
-		t.Run(testCase.TestName, func(t *testing.T) {
-			timeBeforeInserting := time.Now()
-			chatId, err := sqlStorage.AddChat(testCase.ChatName, testCase.UserIds)
-			if testCase.ShouldFail {
-				assert.Error(t, err)
-				var chatsExist int
-				err = sqlStorage.QueryRow(`SELECT COUNT(*) FROM chats`).Scan(&chatsExist)
-				assert.NoError(t, err)
-				assert.Equal(t, chatsCreated, chatsExist)
-				return
-			} else {
-				if err != nil {
-					t.Fatal("AddChat failed, but should not")
-				}
-			}
-			chatsCreated++
-
-			var chatName string
-			var chatCreatedAt time.Time
-			err = sqlStorage.QueryRow("SELECT name, created_at FROM chats WHERE id = ?", chatId).
-				Scan(&chatName, &chatCreatedAt)
-			if err != nil {
-				t.Fatal("Select chat failed: ", err)
-			}
-			assert.Equal(t, testCase.ChatName, chatName)
-			assert.False(t, chatCreatedAt.Before(timeBeforeInserting) || chatCreatedAt.After(time.Now()))
-
-			rows, err := sqlStorage.Query("SELECT user_id FROM users_chats WHERE chat_id = ?", chatId)
-			if err != nil {
-				t.Fatal("Query users_chats failed: ", err)
-			}
-			defer rows.Close()
-			for rows.Next() {
-				var userId int64
-				err := rows.Scan(&userId)
-				if !assert.NoError(t, err) {
-					continue
-				}
-				assert.Contains(t, testCase.UserIds, userId)
-			}
-			var numberOfUsers int
-			err = sqlStorage.QueryRow(`SELECT COUNT(*) FROM users_chats WHERE chat_id = ?`, chatId).Scan(&numberOfUsers)
-			assert.NoError(t, err)
-			assert.Equal(t, len(testCase.UserIds), numberOfUsers)
-		})
-	}
-}
-func TestIsUserInChat(t *testing.T) {
-	sqlStorage, teardown := getSqlStorage(t, []string{"users_chats", "users", "chats"})
-	defer teardown()
-	userNotInChat, err := sqlStorage.AddUser("user_2")
-	if err != nil {
-		t.Fatal("Can not add user: ", err)
-	}
-	userInChat, err := sqlStorage.AddUser("user_1")
-	if err != nil {
-		t.Fatal("Can not add user: ", err)
-	}
-	chatId, err := sqlStorage.AddChat("chat_1", []int64{userInChat})
-	if err != nil {
-		t.Fatal("Can not create chat: ", err)
-	}
-
-	isInChat, err := sqlStorage.IsUserInChat(userInChat, chatId)
-	assert.NoError(t, err)
-	assert.True(t, isInChat)
-
-	isInChat, err = sqlStorage.IsUserInChat(userNotInChat, chatId)
-	assert.NoError(t, err)
-	assert.False(t, isInChat)
-}
-func TestAddMessage(t *testing.T) {
-	sqlStorage, teardown := getSqlStorage(t, []string{"messages", "users_chats", "users", "chats"})
-	defer teardown()
-	//add users
-	userNotInChat, err := sqlStorage.AddUser("user_2")
-	if err != nil {
-		t.Fatal("Can not add user: ", err)
-	}
-	userInChat, err := sqlStorage.AddUser("user_1")
-	if err != nil {
-		t.Fatal("Can not add user: ", err)
-	}
-	chatId, err := sqlStorage.AddChat("chat_1", []int64{userInChat})
-	if err != nil {
-		t.Fatal("Can not create chat: ", err)
-	}
-	type TestCase struct {
-		TestName   string
-		ChatId     int64
-		AuthorId   int64
-		Text       string
-		ShouldFail bool
-	}
-	testCases := []TestCase{
-		TestCase{
-			TestName: "Add message",
-			ChatId:   chatId,
-			AuthorId: userInChat,
-			Text:     "Hello, World!",
-		},
-		TestCase{
-			TestName:   "User not in chat",
-			ChatId:     chatId,
-			AuthorId:   userNotInChat,
-			Text:       "Hello, Hijackers!",
-			ShouldFail: true,
-		},
-		TestCase{
-			TestName:   "Nonexistent user",
-			ChatId:     chatId,
-			AuthorId:   1203,
-			Text:       "I'm not exist",
-			ShouldFail: true,
-		},
-		TestCase{
-			TestName:   "Nonexistent chat",
-			ChatId:     2313,
-			AuthorId:   userInChat,
-			Text:       "Helloo",
-			ShouldFail: true,
-		},
-	}
-	messagesAlreadyCreated := 0
-	for _, testCase := range testCases {
-		testCase := testCase
-		t.Run(testCase.TestName, func(t *testing.T) {
-			timeBeforeInserting := time.Now()
-			messageId, err := sqlStorage.AddMessage(testCase.AuthorId, testCase.ChatId, testCase.Text)
-			if testCase.ShouldFail {
-				assert.Error(t, err)
-				var messagesExist int
-				err = sqlStorage.QueryRow(`SELECT COUNT(*) FROM messages`).Scan(&messagesExist)
-				assert.NoError(t, err)
-				assert.Equal(t, messagesAlreadyCreated, messagesExist)
-				return
-			} else {
-				if err != nil {
-					t.Error("AddMessage failed: ", err)
-					return
-				}
-			}
-			messagesAlreadyCreated++
-
-			var chatId, authorId int64
-			var text string
-			var createdAt time.Time
-			err = sqlStorage.QueryRow("SELECT chat_id, author_id, text, created_at FROM messages WHERE id = ?", messageId).
-				Scan(&chatId, &authorId, &text, &createdAt)
-			if err != nil {
-				t.Fatal("Select message failed: ", err)
-			}
-			assert.Equal(t, testCase.ChatId, chatId)
-			assert.Equal(t, testCase.AuthorId, authorId)
-			assert.Equal(t, testCase.Text, text)
-			assert.False(t, createdAt.Before(timeBeforeInserting) || createdAt.After(time.Now()))
-
-			var messagesExist int
-			err = sqlStorage.QueryRow(`SELECT COUNT(*) FROM messages`).Scan(&messagesExist)
-			assert.NoError(t, err)
-			assert.Equal(t, messagesAlreadyCreated, messagesExist)
-		})
-	}
-}
 func TestGetMessagesFromChat(t *testing.T) {
 	sqlStorage, teardown := getSqlStorage(t, []string{"messages", "chats", "users"})
 	defer teardown()
@@ -592,10 +301,59 @@ func TestGetMessagesFromChat(t *testing.T) {
 	}
 	// last messages from another chat
 	expectedMessages = expectedMessages[:len(expectedMessages)-1]
-	actualMessages, err := sqlStorage.GetMessagesFromChat(10)
+	actualMessages, err := sqlStorage.GetMessagesFromChat(context.Background(), 10)
 	if err != nil {
 		t.Fatal("GetMessagesFromChat failed: ", err)
 	}
 	assert.Equal(t, expectedMessages, actualMessages)
 
 }
+
+func TestEnsureSearchIndex(t *testing.T) {
+	ctx := context.Background()
+	db, teardown := openEmptyDb(t)
+	defer teardown()
+	sqlStorage := SqlStorage{db}
+	isAvailable, err := sqlStorage.isSearchAvailable(ctx)
+	if err != nil {
+		t.Fatal("isSearchAvailable failed: ", err)
+	}
+	if !isAvailable {
+		t.Skip("Search is unavailable, run tests with -tags sqlite_fts5")
+	}
+	// messages added before index is created must be indexed
+	if _, err := sqlStorage.Migrator().Up(0); err != nil {
+		t.Fatal("Up failed: ", err)
+	}
+	userId, err := sqlStorage.AddUser(ctx, "user_1")
+	assert.NoError(t, err)
+	chatId, err := sqlStorage.AddChat(ctx, userId, "chat_1", nil)
+	assert.NoError(t, err)
+	oldId, err := sqlStorage.AddMessage(ctx, userId, chatId, "old message")
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, sqlStorage.Migrate())
+	}
+	newId, err := sqlStorage.AddMessage(ctx, userId, chatId, "new message")
+	assert.NoError(t, err)
+
+	results, err := sqlStorage.SearchMessages(ctx, userId, SearchQuery{Text: "message", Limit: 10})
+	if assert.NoError(t, err) && assert.Len(t, results, 2) {
+		assert.ElementsMatch(t, []int64{oldId, newId}, []int64{results[0].Id, results[1].Id})
+	}
+}
+
+func TestCheckSearch(t *testing.T) {
+	sqlStorage, teardown := getSqlStorage(t, []string{})
+	defer teardown()
+	isAvailable, err := sqlStorage.isSearchAvailable(context.Background())
+	if err != nil {
+		t.Fatal("isSearchAvailable failed: ", err)
+	}
+	if isAvailable {
+		assert.NoError(t, sqlStorage.CheckSearch())
+	} else {
+		assert.Equal(t, ErrSearchUnavailable, sqlStorage.CheckSearch())
+	}
+}
@@ -1,34 +1,165 @@
 package storage
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"time"
 )
 
+var (
+	// ErrNotFound is returned when requested entity doesn't exist.
+	// More specific errors below wrap it, so errors.Is(err, ErrNotFound) holds for them.
+	ErrNotFound        = errors.New("not found")
+	ErrUserNotFound    = fmt.Errorf("user %w", ErrNotFound)
+	ErrMessageNotFound = fmt.Errorf("message %w", ErrNotFound)
+	ErrMemberNotFound  = fmt.Errorf("chat member %w", ErrNotFound)
+	ErrWebhookNotFound = fmt.Errorf("webhook %w", ErrNotFound)
+
+	// ErrAlreadyExists is returned when entity violates uniqueness.
+	ErrAlreadyExists = errors.New("already exists")
+	ErrUserExists    = fmt.Errorf("user %w", ErrAlreadyExists)
+	ErrChatExists    = fmt.Errorf("chat %w", ErrAlreadyExists)
+	ErrTokenExists   = fmt.Errorf("token %w", ErrAlreadyExists)
+	ErrMemberExists  = fmt.Errorf("chat member %w", ErrAlreadyExists)
+
+	// ErrNotChatMember is returned when user acts in chat that he isn't member of (or chat doesn't exist).
+	ErrNotChatMember = errors.New("user is not member of the chat")
+	// ErrNotMessageAuthor is returned when user changes message of another user.
+	ErrNotMessageAuthor = errors.New("user is not author of the message")
+	// ErrPermissionDenied is returned when role of user in chat doesn't allow action.
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrOwnerCannotLeave is returned when owner leaves chat without transferring ownership.
+	ErrOwnerCannotLeave = errors.New("owner can't leave chat")
+	// ErrSlowMode is matched by *SlowModeError, that is returned when member posts too often in chat with slow mode.
+	ErrSlowMode = errors.New("slow mode")
+
+	// ErrSearchUnavailable is returned by CheckSearch of SqlStorage built without FTS5 (build tag "sqlite_fts5").
+	ErrSearchUnavailable = errors.New("full-text search is unavailable")
+)
+
+// SlowModeError tells when author can post in chat with slow mode again.
+type SlowModeError struct {
+	RetryAfter time.Duration
+}
+
+func (e *SlowModeError) Error() string {
+	return fmt.Sprintf("slow mode: next message is allowed in %s", e.RetryAfter)
+}
+
+func (e *SlowModeError) Is(target error) bool {
+	return target == ErrSlowMode
+}
+
+// Storage is implemented by SqlStorage, PostgresStorage and MemoryStorage.
+// Every method takes context of the request: cancelling it aborts running queries of database storages.
 type Storage interface {
-	IsUserExists(username string) (bool, error)
-	AreUsersExistByIds(userIds []int64) (bool, error)
-	AddUser(username string) (int64, error)
-	GetUserChats(userId int64) ([]*Chat, error)
+	AreUsersExistByIds(ctx context.Context, userIds []int64) (bool, error)
+	AddUser(ctx context.Context, username string) (int64, error)
+	AddUserToken(ctx context.Context, userId int64, tokenHash string) error
+	AddUserWithToken(ctx context.Context, username string, tokenHash string) (int64, error)
+	GetUserIdByToken(ctx context.Context, tokenHash string) (int64, error)
+	GetUserChats(ctx context.Context, userId int64) ([]*Chat, error)
 
-	IsChatExists(chatname string) (bool, error)
-	AddChat(chatname string, userIds []int64) (int64, error)
-	IsUserInChat(userId int64, chatId int64) (bool, error)
+	AddChat(ctx context.Context, ownerId int64, chatname string, memberIds []int64) (int64, error)
+	RenameChat(ctx context.Context, actorId int64, chatId int64, chatname string) error
+	SetChatSlowMode(ctx context.Context, actorId int64, chatId int64, seconds int) error
+	IsUserInChat(ctx context.Context, userId int64, chatId int64) (bool, error)
+	GetChatUserIds(ctx context.Context, chatId int64) ([]int64, error)
+	GetChatMembers(ctx context.Context, chatId int64) ([]*ChatMember, error)
+	MarkChatRead(ctx context.Context, userId int64, chatId int64, messageId int64) (int64, error)
+	AddChatMember(ctx context.Context, actorId int64, chatId int64, userId int64) (int64, error)
+	RemoveChatMember(ctx context.Context, actorId int64, chatId int64, userId int64) (int64, error)
+	SetChatMemberRole(ctx context.Context, actorId int64, chatId int64, userId int64, role Role) error
+	TransferChatOwnership(ctx context.Context, ownerId int64, chatId int64, userId int64) error
 
-	AddMessage(chatId int64, authorId int64, text string) (int64, error)
-	GetMessagesFromChat(chatId int64) ([]*Message, error)
+	AddMessage(ctx context.Context, authorId int64, chatId int64, text string) (int64, error)
+	GetMessage(ctx context.Context, messageId int64) (*Message, error)
+	GetMessagesFromChat(ctx context.Context, chatId int64) ([]*Message, error)
+	GetMessagesPage(ctx context.Context, chatId int64, query PageQuery) ([]*Message, error)
+	EditMessage(ctx context.Context, authorId int64, messageId int64, text string) error
+	DeleteMessage(ctx context.Context, actorId int64, messageId int64) error
+	PinMessage(ctx context.Context, actorId int64, messageId int64, pinned bool) error
+	GetMessageEdits(ctx context.Context, messageId int64) ([]*MessageEdit, error)
+	GetMessageReaders(ctx context.Context, messageId int64) ([]int64, error)
+	SearchMessages(ctx context.Context, userId int64, query SearchQuery) ([]*SearchResult, error)
+
+	AddWebhook(ctx context.Context, userId int64, chatId int64, url string, secret string,
+		events []string) (int64, error)
+	GetUserWebhooks(ctx context.Context, userId int64) ([]*Webhook, error)
+	DeleteWebhook(ctx context.Context, userId int64, webhookId int64) error
+	GetChatWebhooks(ctx context.Context, chatId int64, event string) ([]*Webhook, error)
+	AddWebhookDelivery(ctx context.Context, webhookId int64, event string, payload []byte) (int64, error)
+	ClaimDueWebhookDeliveries(ctx context.Context, now time.Time, claimedUntil time.Time,
+		limit int) ([]*WebhookDelivery, error)
+	AddWebhookAttempt(ctx context.Context, attempt *WebhookAttempt, status string, nextAttemptAt time.Time) error
+	GetWebhookDeliveries(ctx context.Context, userId int64, webhookId int64, status string,
+		limit int) ([]*WebhookDelivery, error)
+
+	ReserveIdempotencyKey(ctx context.Context, key *IdempotencyKey, expiredBefore time.Time,
+		abandonedBefore time.Time) (*IdempotencyKey, error)
+	CompleteIdempotencyKey(ctx context.Context, key *IdempotencyKey) error
+	ReleaseIdempotencyKey(ctx context.Context, key *IdempotencyKey) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, expiredBefore time.Time) (int64, error)
 }
 
+// PageQuery describes window of messages in chat. Messages are always returned in ascending order of id.
+// If BeforeId is set, page consists of the latest Limit messages with id less than BeforeId,
+// otherwise it consists of the earliest Limit messages with id greater than AfterId.
+// Limit must be positive.
+type PageQuery struct {
+	BeforeId int64
+	AfterId  int64
+	Limit    int
+}
+
+// Chat as seen by user that requested it. UnreadCount is number of messages of other members
+// after LastReadMessageId, deleted messages aren't counted.
+// LastMessage is preview of the latest message in chat, it and LastMessageAt are nil for empty chat.
+// SlowModeSeconds is minimal interval between messages of one member, zero if slow mode is off.
 type Chat struct {
-	Id        int64     `json:"id"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
-	UserIds   []int64   `json:"users"`
+	Id                int64      `json:"id"`
+	Name              string     `json:"name"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UserIds           []int64    `json:"users"`
+	LastReadMessageId int64      `json:"last_read_message_id"`
+	UnreadCount       int64      `json:"unread_count"`
+	LastMessageAt     *time.Time `json:"last_message_at,omitempty"`
+	LastMessage       *Message   `json:"last_message,omitempty"`
+	SlowModeSeconds   int        `json:"slow_mode_seconds"`
 }
 
+// Message is tombstone if it is deleted: its Text is empty and Deleted is set.
+// EditedAt is nil if message was never edited.
+// System is set for messages that record events in chat, their author is user that caused event.
 type Message struct {
-	Id        int64     `json:"id"`
-	ChatId    int64     `json:"chat"`
-	AuthorId  int64     `json:"author"`
+	Id        int64        `json:"id"`
+	ChatId    int64        `json:"chat"`
+	AuthorId  int64        `json:"author"`
+	Text      string       `json:"text"`
+	CreatedAt time.Time    `json:"created_at"`
+	EditedAt  *time.Time   `json:"edited_at,omitempty"`
+	Deleted   bool         `json:"deleted"`
+	Pinned    bool         `json:"pinned"`
+	System    *SystemEvent `json:"system,omitempty"`
+}
+
+// Types of SystemEvent.
+const (
+	MemberAdded   = "member_added"
+	MemberRemoved = "member_removed"
+	MemberLeft    = "member_left"
+)
+
+// SystemEvent describes event recorded by system message. UserId is user that event is about.
+type SystemEvent struct {
+	Type   string `json:"type"`
+	UserId int64  `json:"user"`
+}
+
+// MessageEdit is entry of message history. Text is text of message before it was edited at EditedAt.
+type MessageEdit struct {
+	MessageId int64     `json:"message"`
 	Text      string    `json:"text"`
-	CreatedAt time.Time `json:"created_at"`
+	EditedAt  time.Time `json:"edited_at"`
 }
@@ -0,0 +1,184 @@
+package storagetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+var chatTests = []conformanceTest{
+	{"AddChat", testAddChat},
+	{"AddChatDuplicateName", testAddChatDuplicateName},
+	{"AddChatUnknownUser", testAddChatUnknownUser},
+	{"IsUserInChat", testIsUserInChat},
+	{"GetChatUserIds", testGetChatUserIds},
+	{"GetUserChats", testGetUserChats},
+	{"GetUserChatsOrder", testGetUserChatsOrder},
+	{"GetUserChatsLastMessage", testGetUserChatsLastMessage},
+}
+
+func testAddChat(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "my_favorite", "another_one", "one_I_dont_really_like")
+
+	timeBeforeInserting := time.Now()
+	chatId, err := s.AddChat(ctx, ids[0], "telegram_news", ids[1:])
+	if !assert.NoError(t, err) {
+		return
+	}
+	chats, err := s.GetUserChats(ctx, ids[0])
+	assert.NoError(t, err)
+	if assert.Len(t, chats, 1) {
+		chat := chats[0]
+		assert.Equal(t, chatId, chat.Id)
+		assert.Equal(t, "telegram_news", chat.Name)
+		assert.Equal(t, ids, chat.UserIds)
+		assert.False(t, chat.CreatedAt.Before(timeBeforeInserting) || chat.CreatedAt.After(time.Now()))
+	}
+
+	singleChatId, err := s.AddChat(ctx, ids[1], "ethereum_future", nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, chatId, singleChatId)
+}
+
+func testAddChatDuplicateName(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user_1", "user_2")
+	addChat(t, s, "chat_1", ids[0])
+
+	_, err := s.AddChat(ctx, ids[1], "chat_1", nil)
+	assert.Equal(t, storage.ErrChatExists, err)
+	chats, err := s.GetUserChats(ctx, ids[1])
+	assert.NoError(t, err)
+	assert.Empty(t, chats)
+}
+
+func testAddChatUnknownUser(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user_1")
+
+	_, err := s.AddChat(ctx, ids[0], "chat_1", []int64{ids[0] + 100})
+	assert.Equal(t, storage.ErrUserNotFound, err)
+}
+
+func testIsUserInChat(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user_in_chat", "user_not_in_chat")
+	chatId := addChat(t, s, "chat_1", ids[0])
+
+	cases := []struct {
+		UserId   int64
+		ChatId   int64
+		IsInChat bool
+	}{
+		{ids[0], chatId, true},
+		{ids[1], chatId, false},
+		{ids[1] + 100, chatId, false},
+		{ids[0], chatId + 100, false},
+	}
+	for _, testCase := range cases {
+		isInChat, err := s.IsUserInChat(ctx, testCase.UserId, testCase.ChatId)
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.IsInChat, isInChat, "user %d, chat %d", testCase.UserId, testCase.ChatId)
+	}
+}
+
+func testGetChatUserIds(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user1", "user2", "user3", "user4")
+	chat1 := addChat(t, s, "chat1", ids[3], ids[0], ids[1])
+	chat2 := addChat(t, s, "chat2", ids[2], ids[3])
+
+	userIds, err := s.GetChatUserIds(ctx, chat1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{ids[0], ids[1], ids[3]}, userIds, "users must be sorted by id")
+
+	userIds, err = s.GetChatUserIds(ctx, chat2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{ids[2], ids[3]}, userIds)
+
+	userIds, err = s.GetChatUserIds(ctx, chat2+100)
+	assert.NoError(t, err)
+	assert.Empty(t, userIds)
+}
+
+func testGetUserChats(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user1", "user2", "user3")
+	chat1 := addChat(t, s, "chat1", ids[0])
+	chat2 := addChat(t, s, "chat2", ids[0], ids[1])
+	chat3 := addChat(t, s, "chat3", ids[1])
+
+	chats, err := s.GetUserChats(ctx, ids[0])
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []int64{chat1, chat2}, chatIds(chats))
+
+	chats, err = s.GetUserChats(ctx, ids[1])
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []int64{chat2, chat3}, chatIds(chats))
+
+	chats, err = s.GetUserChats(ctx, ids[2])
+	assert.NoError(t, err)
+	assert.NotNil(t, chats)
+	assert.Empty(t, chats)
+
+	chats, err = s.GetUserChats(ctx, ids[2]+100)
+	assert.NoError(t, err)
+	assert.Empty(t, chats)
+}
+
+// testGetUserChatsOrder checks that chats are ordered by time of the last message
+// or by creation time if chat is empty.
+func testGetUserChatsOrder(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user")
+	chat1 := addChat(t, s, "chat1", ids[0])
+	chat2 := addChat(t, s, "chat2", ids[0])
+	chat3 := addChat(t, s, "chat3", ids[0])
+
+	assertOrder := func(expected ...int64) {
+		t.Helper()
+		chats, err := s.GetUserChats(ctx, ids[0])
+		assert.NoError(t, err)
+		assert.Equal(t, expected, chatIds(chats))
+	}
+	assertOrder(chat3, chat2, chat1)
+
+	addMessage(t, s, ids[0], chat2, "")
+	assertOrder(chat2, chat3, chat1)
+
+	addMessage(t, s, ids[0], chat1, "")
+	assertOrder(chat1, chat2, chat3)
+}
+
+func testGetUserChatsLastMessage(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "invited")
+	chatId := addChat(t, s, "chat_1", ids[0])
+
+	chats, err := s.GetUserChats(ctx, ids[0])
+	if assert.NoError(t, err) && assert.Len(t, chats, 1) {
+		assert.Nil(t, chats[0].LastMessage)
+		assert.Nil(t, chats[0].LastMessageAt)
+	}
+
+	addMessage(t, s, ids[0], chatId, "Hello")
+	lastId := addMessage(t, s, ids[0], chatId, "Is anybody here?")
+	chats, err = s.GetUserChats(ctx, ids[0])
+	if assert.NoError(t, err) && assert.Len(t, chats, 1) && assert.NotNil(t, chats[0].LastMessage) {
+		assert.Equal(t, lastId, chats[0].LastMessage.Id)
+		assert.Equal(t, "Is anybody here?", chats[0].LastMessage.Text)
+		if assert.NotNil(t, chats[0].LastMessageAt) {
+			assert.True(t, chats[0].LastMessageAt.Equal(chats[0].LastMessage.CreatedAt))
+		}
+	}
+
+	// preview reflects changes of the message and system messages are last messages too
+	assert.NoError(t, s.EditMessage(ctx, ids[0], lastId, "Is anybody there?"))
+	chats, err = s.GetUserChats(ctx, ids[0])
+	if assert.NoError(t, err) && assert.Len(t, chats, 1) && assert.NotNil(t, chats[0].LastMessage) {
+		assert.Equal(t, "Is anybody there?", chats[0].LastMessage.Text)
+	}
+	systemMessageId, err := s.AddChatMember(ctx, ids[0], chatId, ids[1])
+	assert.NoError(t, err)
+	chats, err = s.GetUserChats(ctx, ids[1])
+	if assert.NoError(t, err) && assert.Len(t, chats, 1) && assert.NotNil(t, chats[0].LastMessage) {
+		assert.Equal(t, systemMessageId, chats[0].LastMessage.Id)
+		assert.Equal(t, &storage.SystemEvent{Type: storage.MemberAdded, UserId: ids[1]}, chats[0].LastMessage.System)
+	}
+}
@@ -0,0 +1,229 @@
+package storagetest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+var concurrencyTests = []conformanceTest{
+	{"ConcurrentAddUser", testConcurrentAddUser},
+	{"ConcurrentAddUserWithToken", testConcurrentAddUserWithToken},
+	{"ConcurrentAddChat", testConcurrentAddChat},
+	{"ConcurrentAddChatPartially", testConcurrentAddChatPartially},
+	{"ConcurrentAddMessage", testConcurrentAddMessage},
+	{"ConcurrentTransferChatOwnership", testConcurrentTransferChatOwnership},
+	{"ConcurrentSetChatMemberRole", testConcurrentSetChatMemberRole},
+	{"ConcurrentEditMessage", testConcurrentEditMessage},
+	{"ConcurrentClaimWebhookDeliveries", testConcurrentClaimWebhookDeliveries},
+}
+
+const concurrency = 20
+
+// runConcurrently calls f concurrently from many goroutines and returns number of calls without error.
+func runConcurrently(f func(i int) error) int {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := f(i); err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	return succeeded
+}
+
+func testConcurrentAddUser(t *testing.T, s storage.Storage) {
+	succeeded := runConcurrently(func(i int) error {
+		_, err := s.AddUser(ctx, "same_user")
+		return err
+	})
+	assert.Equal(t, 1, succeeded)
+}
+
+func testConcurrentAddChat(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user")
+	succeeded := runConcurrently(func(i int) error {
+		_, err := s.AddChat(ctx, ids[0], "same_chat", nil)
+		return err
+	})
+	assert.Equal(t, 1, succeeded)
+	chats, err := s.GetUserChats(ctx, ids[0])
+	assert.NoError(t, err)
+	assert.Len(t, chats, 1)
+}
+
+// testConcurrentAddUserWithToken checks that only token of the created user is saved.
+func testConcurrentAddUserWithToken(t *testing.T, s storage.Storage) {
+	succeeded := runConcurrently(func(i int) error {
+		_, err := s.AddUserWithToken(ctx, "same_user", fmt.Sprint("hash_", i))
+		return err
+	})
+	assert.Equal(t, 1, succeeded)
+
+	resolved := 0
+	for i := 0; i < concurrency; i++ {
+		if _, err := s.GetUserIdByToken(ctx, fmt.Sprint("hash_", i)); err == nil {
+			resolved++
+		}
+	}
+	assert.Equal(t, 1, resolved)
+}
+
+// testConcurrentAddChatPartially creates chats with the same name where half of requests contain unknown user.
+// Rejected requests must not leave chat without some of its members.
+func testConcurrentAddChatPartially(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user_1", "user_2")
+	unknown := ids[1] + 100
+	succeeded := runConcurrently(func(i int) error {
+		userIds := []int64{ids[1]}
+		if i%2 == 1 {
+			userIds = append(userIds, unknown)
+		}
+		_, err := s.AddChat(ctx, ids[0], "same_chat", userIds)
+		return err
+	})
+	assert.Equal(t, 1, succeeded)
+
+	chats, err := s.GetUserChats(ctx, ids[0])
+	assert.NoError(t, err)
+	if assert.Len(t, chats, 1) {
+		assert.ElementsMatch(t, ids, chats[0].UserIds)
+	}
+}
+
+func testConcurrentAddMessage(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user")
+	chatId := addChat(t, s, "chat", ids[0])
+	succeeded := runConcurrently(func(i int) error {
+		_, err := s.AddMessage(ctx, ids[0], chatId, fmt.Sprint(i))
+		return err
+	})
+	assert.Equal(t, concurrency, succeeded)
+
+	messages, err := s.GetMessagesFromChat(ctx, chatId)
+	assert.NoError(t, err)
+	assert.Len(t, messages, concurrency)
+	texts := make(map[string]bool)
+	for _, message := range messages {
+		texts[message.Text] = true
+	}
+	assert.Len(t, texts, concurrency)
+}
+
+// testConcurrentTransferChatOwnership transfers ownership to different members at once.
+// Only the first transfer is allowed, because previous owner becomes admin, and chat must keep single owner.
+func testConcurrentTransferChatOwnership(t *testing.T, s storage.Storage) {
+	usernames := make([]string, concurrency+1)
+	for i := range usernames {
+		usernames[i] = fmt.Sprint("user_", i)
+	}
+	ids := addUsers(t, s, usernames...)
+	chatId := addChat(t, s, "chat", ids...)
+	succeeded := runConcurrently(func(i int) error {
+		return s.TransferChatOwnership(ctx, ids[0], chatId, ids[i+1])
+	})
+	assert.Equal(t, 1, succeeded)
+
+	owners := 0
+	for _, role := range chatRoles(t, s, chatId) {
+		if role == storage.RoleOwner {
+			owners++
+		}
+	}
+	assert.Equal(t, 1, owners)
+}
+
+// testConcurrentSetChatMemberRole makes owner and admin change roles of each other at once.
+// Both members are checked in every call, so they must not block each other forever.
+func testConcurrentSetChatMemberRole(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "admin")
+	chatId := addChat(t, s, "chat", ids...)
+	if err := s.SetChatMemberRole(ctx, ids[0], chatId, ids[1], storage.RoleAdmin); err != nil {
+		t.Fatal("SetChatMemberRole failed: ", err)
+	}
+	var mu sync.Mutex
+	var unexpected []error
+	runConcurrently(func(i int) error {
+		var err, expected error
+		if i%2 == 0 {
+			err = s.SetChatMemberRole(ctx, ids[0], chatId, ids[1], storage.RoleAdmin)
+		} else {
+			err = s.SetChatMemberRole(ctx, ids[1], chatId, ids[0], storage.RoleMember)
+			expected = storage.ErrPermissionDenied
+		}
+		if err != expected {
+			mu.Lock()
+			unexpected = append(unexpected, err)
+			mu.Unlock()
+		}
+		return err
+	})
+	assert.Empty(t, unexpected)
+	assert.Equal(t, map[int64]storage.Role{ids[0]: storage.RoleOwner, ids[1]: storage.RoleAdmin},
+		chatRoles(t, s, chatId))
+}
+
+// testConcurrentEditMessage edits message at once. Every edit must save the text it replaced to history.
+func testConcurrentEditMessage(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user")
+	chatId := addChat(t, s, "chat", ids[0])
+	messageId := addMessage(t, s, ids[0], chatId, "original")
+	succeeded := runConcurrently(func(i int) error {
+		return s.EditMessage(ctx, ids[0], messageId, fmt.Sprint(i))
+	})
+	assert.Equal(t, concurrency, succeeded)
+
+	edits, err := s.GetMessageEdits(ctx, messageId)
+	assert.NoError(t, err)
+	message, err := s.GetMessage(ctx, messageId)
+	assert.NoError(t, err)
+	texts := map[string]bool{message.Text: true}
+	for _, edit := range edits {
+		texts[edit.Text] = true
+	}
+	assert.Len(t, edits, concurrency)
+	assert.Len(t, texts, concurrency+1)
+	assert.True(t, texts["original"])
+}
+
+// testConcurrentClaimWebhookDeliveries claims deliveries at once, as dispatchers of several instances do.
+// Every delivery must be claimed exactly once.
+func testConcurrentClaimWebhookDeliveries(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner")
+	webhookId := addWebhook(t, s, ids[0], 0)
+	for i := 0; i < concurrency; i++ {
+		if _, err := s.AddWebhookDelivery(ctx, webhookId, storage.WebhookMessageCreated, []byte(`{}`)); err != nil {
+			t.Fatal("AddWebhookDelivery failed: ", err)
+		}
+	}
+	now := time.Now()
+	var mu sync.Mutex
+	claimed := make([]int64, 0, concurrency)
+	succeeded := runConcurrently(func(i int) error {
+		deliveries, err := s.ClaimDueWebhookDeliveries(ctx, now, now.Add(time.Minute), 2)
+		mu.Lock()
+		claimed = append(claimed, deliveryIds(deliveries)...)
+		mu.Unlock()
+		return err
+	})
+	assert.Equal(t, concurrency, succeeded)
+	unique := make(map[int64]bool)
+	for _, id := range claimed {
+		unique[id] = true
+	}
+	assert.Len(t, claimed, concurrency)
+	assert.Len(t, unique, concurrency)
+}
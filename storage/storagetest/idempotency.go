@@ -0,0 +1,142 @@
+package storagetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+var idempotencyTests = []conformanceTest{
+	{"ReserveIdempotencyKey", testReserveIdempotencyKey},
+	{"AbandonedIdempotencyKey", testAbandonedIdempotencyKey},
+	{"ReleaseIdempotencyKey", testReleaseIdempotencyKey},
+	{"ExpiredIdempotencyKeys", testExpiredIdempotencyKeys},
+}
+
+func newIdempotencyKey(userId int64, key string) *storage.IdempotencyKey {
+	return &storage.IdempotencyKey{UserId: userId, Endpoint: "/messages/add", Key: key, RequestHash: "hash_" + key}
+}
+
+func testReserveIdempotencyKey(t *testing.T, s storage.Storage) {
+	expiredBefore := time.Now().Add(-time.Hour)
+	key := newIdempotencyKey(1, "key_1")
+	saved, err := s.ReserveIdempotencyKey(ctx, key, expiredBefore, expiredBefore)
+	assert.NoError(t, err)
+	assert.Nil(t, saved)
+
+	saved, err = s.ReserveIdempotencyKey(ctx, newIdempotencyKey(1, "key_1"), expiredBefore, expiredBefore)
+	if assert.NoError(t, err) && assert.NotNil(t, saved) {
+		assert.Equal(t, "hash_key_1", saved.RequestHash)
+		assert.Equal(t, 0, saved.Status, "key is in progress")
+	}
+
+	key.Status = 200
+	key.Body = []byte(`{"id":1}`)
+	assert.NoError(t, s.CompleteIdempotencyKey(ctx, key))
+	saved, err = s.ReserveIdempotencyKey(ctx, newIdempotencyKey(1, "key_1"), expiredBefore, expiredBefore)
+	if assert.NoError(t, err) && assert.NotNil(t, saved) {
+		assert.Equal(t, int64(1), saved.UserId)
+		assert.Equal(t, "/messages/add", saved.Endpoint)
+		assert.Equal(t, "key_1", saved.Key)
+		assert.Equal(t, 200, saved.Status)
+		assert.Equal(t, []byte(`{"id":1}`), saved.Body)
+		assert.WithinDuration(t, time.Now(), saved.CreatedAt, time.Minute)
+	}
+
+	// keys are scoped by user and endpoint
+	saved, err = s.ReserveIdempotencyKey(ctx, newIdempotencyKey(2, "key_1"), expiredBefore, expiredBefore)
+	assert.NoError(t, err)
+	assert.Nil(t, saved)
+	otherEndpoint := newIdempotencyKey(1, "key_1")
+	otherEndpoint.Endpoint = "/chats/add"
+	saved, err = s.ReserveIdempotencyKey(ctx, otherEndpoint, expiredBefore, expiredBefore)
+	assert.NoError(t, err)
+	assert.Nil(t, saved)
+
+	assert.Equal(t, storage.ErrNotFound, s.CompleteIdempotencyKey(ctx, newIdempotencyKey(3, "key_1")))
+}
+
+func testAbandonedIdempotencyKey(t *testing.T, s storage.Storage) {
+	expiredBefore := time.Now().Add(-time.Hour)
+	inProgress := newIdempotencyKey(1, "key_1")
+	completed := newIdempotencyKey(1, "key_2")
+	for _, key := range []*storage.IdempotencyKey{inProgress, completed} {
+		_, err := s.ReserveIdempotencyKey(ctx, key, expiredBefore, expiredBefore)
+		assert.NoError(t, err)
+	}
+	completed.Status = 200
+	assert.NoError(t, s.CompleteIdempotencyKey(ctx, completed))
+
+	// only key that is still in progress is replaced
+	abandonedBefore := time.Now().Add(time.Hour)
+	replacing := newIdempotencyKey(1, "key_1")
+	replacing.RequestHash = "other_hash"
+	saved, err := s.ReserveIdempotencyKey(ctx, replacing, expiredBefore, abandonedBefore)
+	assert.NoError(t, err)
+	assert.Nil(t, saved)
+	saved, err = s.ReserveIdempotencyKey(ctx, newIdempotencyKey(1, "key_1"), expiredBefore, expiredBefore)
+	if assert.NoError(t, err) && assert.NotNil(t, saved) {
+		assert.Equal(t, "other_hash", saved.RequestHash)
+	}
+	saved, err = s.ReserveIdempotencyKey(ctx, newIdempotencyKey(1, "key_2"), expiredBefore, abandonedBefore)
+	if assert.NoError(t, err) && assert.NotNil(t, saved) {
+		assert.Equal(t, 200, saved.Status)
+	}
+}
+
+func testReleaseIdempotencyKey(t *testing.T, s storage.Storage) {
+	expiredBefore := time.Now().Add(-time.Hour)
+	inProgress := newIdempotencyKey(1, "key_1")
+	completed := newIdempotencyKey(1, "key_2")
+	for _, key := range []*storage.IdempotencyKey{inProgress, completed} {
+		_, err := s.ReserveIdempotencyKey(ctx, key, expiredBefore, expiredBefore)
+		assert.NoError(t, err)
+	}
+	completed.Status = 200
+	assert.NoError(t, s.CompleteIdempotencyKey(ctx, completed))
+
+	assert.NoError(t, s.ReleaseIdempotencyKey(ctx, inProgress))
+	assert.NoError(t, s.ReleaseIdempotencyKey(ctx, completed))
+	saved, err := s.ReserveIdempotencyKey(ctx, newIdempotencyKey(1, "key_1"), expiredBefore, expiredBefore)
+	assert.NoError(t, err)
+	assert.Nil(t, saved, "released key can be reserved again")
+	saved, err = s.ReserveIdempotencyKey(ctx, newIdempotencyKey(1, "key_2"), expiredBefore, expiredBefore)
+	if assert.NoError(t, err) && assert.NotNil(t, saved) {
+		assert.Equal(t, 200, saved.Status, "completed key isn't released")
+	}
+}
+
+func testExpiredIdempotencyKeys(t *testing.T, s storage.Storage) {
+	key := newIdempotencyKey(1, "key_1")
+	_, err := s.ReserveIdempotencyKey(ctx, key, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	key.Status = 201
+	assert.NoError(t, s.CompleteIdempotencyKey(ctx, key))
+
+	// key that is older than window is replaced
+	replacing := newIdempotencyKey(1, "key_1")
+	replacing.RequestHash = "other_hash"
+	saved, err := s.ReserveIdempotencyKey(ctx, replacing, time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Nil(t, saved)
+	saved, err = s.ReserveIdempotencyKey(ctx, newIdempotencyKey(1, "key_1"), time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+	if assert.NoError(t, err) && assert.NotNil(t, saved) {
+		assert.Equal(t, "other_hash", saved.RequestHash)
+		assert.Equal(t, 0, saved.Status)
+	}
+
+	_, err = s.ReserveIdempotencyKey(ctx, newIdempotencyKey(2, "key_2"), time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	deleted, err := s.DeleteExpiredIdempotencyKeys(ctx, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), deleted)
+	deleted, err = s.DeleteExpiredIdempotencyKeys(ctx, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+	saved, err = s.ReserveIdempotencyKey(ctx, newIdempotencyKey(2, "key_2"), time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Nil(t, saved)
+}
@@ -0,0 +1,143 @@
+package storagetest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+var memberTests = []conformanceTest{
+	{"AddChatMember", testAddChatMember},
+	{"AddChatMemberRejected", testAddChatMemberRejected},
+	{"RemoveChatMember", testRemoveChatMember},
+	{"RemoveChatMemberRejected", testRemoveChatMemberRejected},
+	{"SystemMessageIsImmutable", testSystemMessageIsImmutable},
+}
+
+func testAddChatMember(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "invited")
+	chatId := addChat(t, s, "chat_1", ids[0])
+
+	messageId, err := s.AddChatMember(ctx, ids[0], chatId, ids[1])
+	if !assert.NoError(t, err) {
+		return
+	}
+	isUserInChat, err := s.IsUserInChat(ctx, ids[1], chatId)
+	assert.NoError(t, err)
+	assert.True(t, isUserInChat)
+	userIds, err := s.GetChatUserIds(ctx, chatId)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{ids[0], ids[1]}, userIds)
+	chats, err := s.GetUserChats(ctx, ids[1])
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{chatId}, chatIds(chats))
+
+	messages, err := s.GetMessagesFromChat(ctx, chatId)
+	if assert.NoError(t, err) && assert.Len(t, messages, 1) {
+		assert.Equal(t, messageId, messages[0].Id)
+		assert.Equal(t, ids[0], messages[0].AuthorId)
+		assert.Equal(t, &storage.SystemEvent{Type: storage.MemberAdded, UserId: ids[1]}, messages[0].System)
+	}
+	message, err := s.GetMessage(ctx, messageId)
+	if assert.NoError(t, err) {
+		assert.Equal(t, &storage.SystemEvent{Type: storage.MemberAdded, UserId: ids[1]}, message.System)
+	}
+
+	// new member can write to chat
+	addMessage(t, s, ids[1], chatId, "Hello")
+	messages, err = s.GetMessagesPage(ctx, chatId, storage.PageQuery{Limit: 10})
+	if assert.NoError(t, err) && assert.Len(t, messages, 2) {
+		assert.NotNil(t, messages[0].System)
+		assert.Nil(t, messages[1].System)
+	}
+}
+
+func testAddChatMemberRejected(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "member", "stranger")
+	chatId := addChat(t, s, "chat_1", ids[0], ids[1])
+
+	_, err := s.AddChatMember(ctx, ids[2], chatId, ids[2])
+	assert.Equal(t, storage.ErrNotChatMember, err, "stranger can't invite")
+	_, err = s.AddChatMember(ctx, ids[0], chatId+100, ids[2])
+	assert.Equal(t, storage.ErrNotChatMember, err, "chat doesn't exist")
+	_, err = s.AddChatMember(ctx, ids[0], chatId, ids[1])
+	assert.Equal(t, storage.ErrMemberExists, err)
+	_, err = s.AddChatMember(ctx, ids[0], chatId, ids[2]+100)
+	assert.Equal(t, storage.ErrUserNotFound, err)
+
+	userIds, err := s.GetChatUserIds(ctx, chatId)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{ids[0], ids[1]}, userIds)
+	messages, err := s.GetMessagesFromChat(ctx, chatId)
+	assert.NoError(t, err)
+	assert.Empty(t, messages, "rejected changes must not be recorded")
+}
+
+func testRemoveChatMember(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "removed", "leaving")
+	chatId := addChat(t, s, "chat_1", ids[0], ids[1], ids[2])
+
+	removedId, err := s.RemoveChatMember(ctx, ids[0], chatId, ids[1])
+	assert.NoError(t, err)
+	leftId, err := s.RemoveChatMember(ctx, ids[2], chatId, ids[2])
+	assert.NoError(t, err)
+
+	for _, userId := range ids[1:] {
+		isUserInChat, err := s.IsUserInChat(ctx, userId, chatId)
+		assert.NoError(t, err)
+		assert.False(t, isUserInChat)
+		chats, err := s.GetUserChats(ctx, userId)
+		assert.NoError(t, err)
+		assert.Empty(t, chats)
+		_, err = s.AddMessage(ctx, userId, chatId, "Am I still here?")
+		assert.Equal(t, storage.ErrNotChatMember, err)
+	}
+	userIds, err := s.GetChatUserIds(ctx, chatId)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{ids[0]}, userIds)
+
+	messages, err := s.GetMessagesFromChat(ctx, chatId)
+	if assert.NoError(t, err) && assert.Len(t, messages, 2) {
+		assert.Equal(t, removedId, messages[0].Id)
+		assert.Equal(t, ids[0], messages[0].AuthorId)
+		assert.Equal(t, &storage.SystemEvent{Type: storage.MemberRemoved, UserId: ids[1]}, messages[0].System)
+		assert.Equal(t, leftId, messages[1].Id)
+		assert.Equal(t, ids[2], messages[1].AuthorId)
+		assert.Equal(t, &storage.SystemEvent{Type: storage.MemberLeft, UserId: ids[2]}, messages[1].System)
+	}
+
+	// removed user can be invited again
+	_, err = s.AddChatMember(ctx, ids[0], chatId, ids[1])
+	assert.NoError(t, err)
+}
+
+func testRemoveChatMemberRejected(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "member", "stranger")
+	chatId := addChat(t, s, "chat_1", ids[0], ids[1])
+
+	_, err := s.RemoveChatMember(ctx, ids[2], chatId, ids[1])
+	assert.Equal(t, storage.ErrNotChatMember, err, "stranger can't remove")
+	_, err = s.RemoveChatMember(ctx, ids[2], chatId, ids[2])
+	assert.Equal(t, storage.ErrNotChatMember, err, "stranger can't leave")
+	_, err = s.RemoveChatMember(ctx, ids[0], chatId, ids[2])
+	assert.Equal(t, storage.ErrMemberNotFound, err)
+
+	userIds, err := s.GetChatUserIds(ctx, chatId)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{ids[0], ids[1]}, userIds)
+	messages, err := s.GetMessagesFromChat(ctx, chatId)
+	assert.NoError(t, err)
+	assert.Empty(t, messages, "rejected changes must not be recorded")
+}
+
+func testSystemMessageIsImmutable(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "invited")
+	chatId := addChat(t, s, "chat_1", ids[0])
+	messageId, err := s.AddChatMember(ctx, ids[0], chatId, ids[1])
+	assert.NoError(t, err)
+
+	assert.Equal(t, storage.ErrNotMessageAuthor, s.EditMessage(ctx, ids[0], messageId, "Nobody was invited"))
+	assert.Equal(t, storage.ErrNotMessageAuthor, s.DeleteMessage(ctx, ids[0], messageId))
+}
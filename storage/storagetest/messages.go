@@ -0,0 +1,246 @@
+package storagetest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+var messageTests = []conformanceTest{
+	{"AddMessage", testAddMessage},
+	{"AddMessageRejected", testAddMessageRejected},
+	{"GetMessage", testGetMessage},
+	{"GetMessagesFromChat", testGetMessagesFromChat},
+	{"GetMessagesPage", testGetMessagesPage},
+	{"EditMessage", testEditMessage},
+	{"EditMessageRejected", testEditMessageRejected},
+	{"DeleteMessage", testDeleteMessage},
+}
+
+func testAddMessage(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user_1")
+	chatId := addChat(t, s, "chat_1", ids[0])
+
+	timeBeforeInserting := time.Now()
+	messageId, err := s.AddMessage(ctx, ids[0], chatId, "Hello, World!")
+	if !assert.NoError(t, err) {
+		return
+	}
+	emptyMessageId, err := s.AddMessage(ctx, ids[0], chatId, "")
+	assert.NoError(t, err)
+	assert.True(t, messageId < emptyMessageId, "message ids must grow")
+
+	message, err := s.GetMessage(ctx, messageId)
+	if assert.NoError(t, err) {
+		assert.Equal(t, messageId, message.Id)
+		assert.Equal(t, chatId, message.ChatId)
+		assert.Equal(t, ids[0], message.AuthorId)
+		assert.Equal(t, "Hello, World!", message.Text)
+		assert.False(t, message.CreatedAt.Before(timeBeforeInserting) || message.CreatedAt.After(time.Now()))
+	}
+}
+
+func testAddMessageRejected(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user_in_chat", "user_not_in_chat")
+	chatId := addChat(t, s, "chat_1", ids[0])
+
+	cases := []struct {
+		TestName string
+		AuthorId int64
+		ChatId   int64
+	}{
+		{"User not in chat", ids[1], chatId},
+		{"Nonexistent user", ids[1] + 100, chatId},
+		{"Nonexistent chat", ids[0], chatId + 100},
+	}
+	for _, testCase := range cases {
+		_, err := s.AddMessage(ctx, testCase.AuthorId, testCase.ChatId, "Hello, Hijackers!")
+		assert.Equal(t, storage.ErrNotChatMember, err, testCase.TestName)
+	}
+	messages, err := s.GetMessagesFromChat(ctx, chatId)
+	assert.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+func testGetMessage(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user_1")
+	chatId := addChat(t, s, "chat_1", ids[0])
+	messageId := addMessage(t, s, ids[0], chatId, "Hello")
+
+	message, err := s.GetMessage(ctx, messageId)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Hello", message.Text)
+	}
+	_, err = s.GetMessage(ctx, messageId+100)
+	assert.Equal(t, storage.ErrNotFound, err)
+}
+
+func testGetMessagesFromChat(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "my_favorite", "another_one")
+	chat1 := addChat(t, s, "chat_1", ids[0], ids[1])
+	chat2 := addChat(t, s, "chat_2", ids[0])
+
+	expected := []*storage.Message{
+		{ChatId: chat1, AuthorId: ids[0], Text: "Hello"},
+		{ChatId: chat2, AuthorId: ids[0], Text: "Another chat"},
+		{ChatId: chat1, AuthorId: ids[1], Text: "Hello, how are you?"},
+		{ChatId: chat1, AuthorId: ids[0], Text: "I can travel in time"},
+	}
+	for _, message := range expected {
+		message.Id = addMessage(t, s, message.AuthorId, message.ChatId, message.Text)
+	}
+
+	messages, err := s.GetMessagesFromChat(ctx, chat1)
+	assert.NoError(t, err)
+	if assert.Len(t, messages, 3) {
+		for i, message := range []*storage.Message{expected[0], expected[2], expected[3]} {
+			assert.Equal(t, message.Id, messages[i].Id)
+			assert.Equal(t, message.ChatId, messages[i].ChatId)
+			assert.Equal(t, message.AuthorId, messages[i].AuthorId)
+			assert.Equal(t, message.Text, messages[i].Text)
+		}
+	}
+
+	messages, err = s.GetMessagesFromChat(ctx, chat2+100)
+	assert.NoError(t, err)
+	assert.NotNil(t, messages)
+	assert.Empty(t, messages)
+}
+
+func testGetMessagesPage(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user")
+	chat1 := addChat(t, s, "chat_1", ids[0])
+	chat2 := addChat(t, s, "chat_2", ids[0])
+	var m [6]int64
+	for i := range m {
+		chatId := chat1
+		if i == 2 {
+			chatId = chat2
+		}
+		m[i] = addMessage(t, s, ids[0], chatId, fmt.Sprint(i))
+	}
+	cases := []struct {
+		TestName    string
+		Query       storage.PageQuery
+		ExpectedIds []int64
+	}{
+		{"First page", storage.PageQuery{Limit: 2}, []int64{m[0], m[1]}},
+		{"After id", storage.PageQuery{AfterId: m[1], Limit: 2}, []int64{m[3], m[4]}},
+		{"After id to the end", storage.PageQuery{AfterId: m[4], Limit: 2}, []int64{m[5]}},
+		{"After last", storage.PageQuery{AfterId: m[5], Limit: 2}, []int64{}},
+		{"Before id", storage.PageQuery{BeforeId: m[5], Limit: 2}, []int64{m[3], m[4]}},
+		{"Before id to the start", storage.PageQuery{BeforeId: m[3], Limit: 10}, []int64{m[0], m[1]}},
+		{"Before first", storage.PageQuery{BeforeId: m[0], Limit: 10}, []int64{}},
+	}
+	for _, testCase := range cases {
+		testCase := testCase
+		t.Run(testCase.TestName, func(t *testing.T) {
+			messages, err := s.GetMessagesPage(ctx, chat1, testCase.Query)
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.ExpectedIds, messageIds(messages))
+			for _, message := range messages {
+				assert.Equal(t, chat1, message.ChatId)
+			}
+		})
+	}
+}
+
+func testEditMessage(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user_1")
+	chatId := addChat(t, s, "chat_1", ids[0])
+	messageId := addMessage(t, s, ids[0], chatId, "Helo")
+
+	edits, err := s.GetMessageEdits(ctx, messageId)
+	assert.NoError(t, err)
+	assert.NotNil(t, edits)
+	assert.Empty(t, edits)
+
+	timeBeforeEditing := time.Now()
+	assert.NoError(t, s.EditMessage(ctx, ids[0], messageId, "Hello"))
+	assert.NoError(t, s.EditMessage(ctx, ids[0], messageId, "Hello, World"))
+
+	message, err := s.GetMessage(ctx, messageId)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Hello, World", message.Text)
+		assert.False(t, message.Deleted)
+		if assert.NotNil(t, message.EditedAt) {
+			assert.False(t, message.EditedAt.Before(timeBeforeEditing) || message.EditedAt.After(time.Now()))
+		}
+	}
+	messages, err := s.GetMessagesFromChat(ctx, chatId)
+	if assert.NoError(t, err) && assert.Len(t, messages, 1) {
+		assert.Equal(t, "Hello, World", messages[0].Text)
+		assert.NotNil(t, messages[0].EditedAt)
+	}
+
+	edits, err = s.GetMessageEdits(ctx, messageId)
+	if assert.NoError(t, err) && assert.Len(t, edits, 2) {
+		assert.Equal(t, "Helo", edits[0].Text)
+		assert.Equal(t, "Hello", edits[1].Text)
+		assert.Equal(t, messageId, edits[1].MessageId)
+		assert.True(t, edits[1].EditedAt.Equal(*message.EditedAt))
+		assert.False(t, edits[1].EditedAt.Before(edits[0].EditedAt))
+	}
+}
+
+func testEditMessageRejected(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "author", "another_user")
+	chatId := addChat(t, s, "chat_1", ids[0], ids[1])
+	messageId := addMessage(t, s, ids[0], chatId, "Hello")
+
+	assert.Equal(t, storage.ErrNotMessageAuthor, s.EditMessage(ctx, ids[1], messageId, "Hacked"))
+	assert.Equal(t, storage.ErrNotMessageAuthor, s.DeleteMessage(ctx, ids[1], messageId))
+	assert.Equal(t, storage.ErrMessageNotFound, s.EditMessage(ctx, ids[0], messageId+100, "Hello"))
+	assert.Equal(t, storage.ErrMessageNotFound, s.DeleteMessage(ctx, ids[0], messageId+100))
+	_, err := s.GetMessageEdits(ctx, messageId+100)
+	assert.Equal(t, storage.ErrMessageNotFound, err)
+
+	message, err := s.GetMessage(ctx, messageId)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Hello", message.Text)
+		assert.Nil(t, message.EditedAt)
+		assert.False(t, message.Deleted)
+	}
+}
+
+func testDeleteMessage(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user_1")
+	chatId := addChat(t, s, "chat_1", ids[0])
+	firstId := addMessage(t, s, ids[0], chatId, "Oops, secret password")
+	secondId := addMessage(t, s, ids[0], chatId, "Hello")
+	assert.NoError(t, s.EditMessage(ctx, ids[0], firstId, "Oops, another secret"))
+
+	assert.NoError(t, s.DeleteMessage(ctx, ids[0], firstId))
+	assert.Equal(t, storage.ErrMessageNotFound, s.DeleteMessage(ctx, ids[0], firstId), "message is already deleted")
+	assert.Equal(t, storage.ErrMessageNotFound, s.EditMessage(ctx, ids[0], firstId, "Restored"), "message is deleted")
+
+	message, err := s.GetMessage(ctx, firstId)
+	if assert.NoError(t, err) {
+		assert.True(t, message.Deleted)
+		assert.Empty(t, message.Text)
+	}
+	messages, err := s.GetMessagesFromChat(ctx, chatId)
+	if assert.NoError(t, err) && assert.Len(t, messages, 2) {
+		assert.Equal(t, firstId, messages[0].Id)
+		assert.True(t, messages[0].Deleted)
+		assert.Empty(t, messages[0].Text)
+		assert.False(t, messages[1].Deleted)
+	}
+	messages, err = s.GetMessagesPage(ctx, chatId, storage.PageQuery{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{firstId, secondId}, messageIds(messages))
+
+	edits, err := s.GetMessageEdits(ctx, firstId)
+	assert.NoError(t, err)
+	assert.Empty(t, edits, "history of deleted message must be erased")
+
+	if isSearchAvailable(s) {
+		results, err := s.SearchMessages(ctx, ids[0], storage.SearchQuery{Text: "secret", Limit: 10})
+		assert.NoError(t, err)
+		assert.Empty(t, results)
+	}
+}
@@ -0,0 +1,121 @@
+package storagetest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+var readTests = []conformanceTest{
+	{"UnreadCount", testUnreadCount},
+	{"MarkChatRead", testMarkChatRead},
+	{"MarkChatReadRejected", testMarkChatReadRejected},
+	{"InvitedMemberReadsHistory", testInvitedMemberReadsHistory},
+	{"GetMessageReaders", testGetMessageReaders},
+}
+
+// userChat returns the only chat of the user.
+func userChat(t *testing.T, s storage.Storage, userId int64) *storage.Chat {
+	chats, err := s.GetUserChats(ctx, userId)
+	if !assert.NoError(t, err) || !assert.Len(t, chats, 1) {
+		t.FailNow()
+	}
+	return chats[0]
+}
+
+func testUnreadCount(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "writer", "reader")
+	chatId := addChat(t, s, "chat_1", ids[0], ids[1])
+	assert.Equal(t, int64(0), userChat(t, s, ids[1]).UnreadCount)
+
+	addMessage(t, s, ids[0], chatId, "first")
+	deletedId := addMessage(t, s, ids[0], chatId, "second")
+	addMessage(t, s, ids[1], chatId, "own message")
+	addMessage(t, s, ids[0], chatId, "third")
+	assert.NoError(t, s.DeleteMessage(ctx, ids[0], deletedId))
+
+	chat := userChat(t, s, ids[1])
+	assert.Equal(t, int64(0), chat.LastReadMessageId)
+	assert.Equal(t, int64(2), chat.UnreadCount, "own and deleted messages aren't unread")
+	assert.Equal(t, int64(1), userChat(t, s, ids[0]).UnreadCount)
+}
+
+func testMarkChatRead(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "writer", "reader")
+	chatId := addChat(t, s, "chat_1", ids[0], ids[1])
+	firstId := addMessage(t, s, ids[0], chatId, "first")
+	secondId := addMessage(t, s, ids[0], chatId, "second")
+	thirdId := addMessage(t, s, ids[0], chatId, "third")
+
+	lastReadId, err := s.MarkChatRead(ctx, ids[1], chatId, secondId)
+	assert.NoError(t, err)
+	assert.Equal(t, secondId, lastReadId)
+	chat := userChat(t, s, ids[1])
+	assert.Equal(t, secondId, chat.LastReadMessageId)
+	assert.Equal(t, int64(1), chat.UnreadCount)
+
+	lastReadId, err = s.MarkChatRead(ctx, ids[1], chatId, firstId)
+	assert.NoError(t, err)
+	assert.Equal(t, secondId, lastReadId, "last read message never moves backward")
+
+	lastReadId, err = s.MarkChatRead(ctx, ids[1], chatId, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, thirdId, lastReadId)
+	chat = userChat(t, s, ids[1])
+	assert.Equal(t, thirdId, chat.LastReadMessageId)
+	assert.Equal(t, int64(0), chat.UnreadCount)
+
+	assert.Equal(t, int64(0), userChat(t, s, ids[0]).LastReadMessageId, "reads are kept per user")
+}
+
+func testMarkChatReadRejected(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user_1", "stranger")
+	chatId := addChat(t, s, "chat_1", ids[0])
+	otherChatId := addChat(t, s, "chat_2", ids[1])
+	messageId := addMessage(t, s, ids[0], chatId, "Hello")
+	otherMessageId := addMessage(t, s, ids[1], otherChatId, "Hello")
+
+	_, err := s.MarkChatRead(ctx, ids[1], chatId, messageId)
+	assert.Equal(t, storage.ErrNotChatMember, err)
+	_, err = s.MarkChatRead(ctx, ids[0], chatId, otherMessageId)
+	assert.Equal(t, storage.ErrMessageNotFound, err, "message is from another chat")
+	_, err = s.MarkChatRead(ctx, ids[0], chatId, otherMessageId+100)
+	assert.Equal(t, storage.ErrMessageNotFound, err)
+	assert.Equal(t, int64(0), userChat(t, s, ids[0]).LastReadMessageId)
+}
+
+func testInvitedMemberReadsHistory(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "invited")
+	chatId := addChat(t, s, "chat_1", ids[0])
+	addMessage(t, s, ids[0], chatId, "Old news")
+	systemMessageId, err := s.AddChatMember(ctx, ids[0], chatId, ids[1])
+	assert.NoError(t, err)
+	addMessage(t, s, ids[0], chatId, "Welcome")
+
+	chat := userChat(t, s, ids[1])
+	assert.Equal(t, systemMessageId, chat.LastReadMessageId)
+	assert.Equal(t, int64(1), chat.UnreadCount)
+}
+
+func testGetMessageReaders(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "author", "fast_reader", "slow_reader")
+	chatId := addChat(t, s, "chat_1", ids...)
+	firstId := addMessage(t, s, ids[0], chatId, "first")
+	secondId := addMessage(t, s, ids[0], chatId, "second")
+	_, err := s.MarkChatRead(ctx, ids[1], chatId, secondId)
+	assert.NoError(t, err)
+	_, err = s.MarkChatRead(ctx, ids[2], chatId, firstId)
+	assert.NoError(t, err)
+
+	readers, err := s.GetMessageReaders(ctx, firstId)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{ids[1], ids[2]}, readers)
+	readers, err = s.GetMessageReaders(ctx, secondId)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{ids[1]}, readers)
+
+	_, err = s.GetMessageReaders(ctx, secondId+100)
+	assert.Equal(t, storage.ErrMessageNotFound, err)
+}
@@ -0,0 +1,264 @@
+package storagetest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+var roleTests = []conformanceTest{
+	{"ChatRoles", testChatRoles},
+	{"ReadOnlyMember", testReadOnlyMember},
+	{"RemoveChatMemberByRole", testRemoveChatMemberByRole},
+	{"SetChatMemberRole", testSetChatMemberRole},
+	{"TransferChatOwnership", testTransferChatOwnership},
+	{"RenameChat", testRenameChat},
+	{"PinMessage", testPinMessage},
+	{"DeleteMessageByModerator", testDeleteMessageByModerator},
+	{"SlowMode", testSlowMode},
+}
+
+// chatRoles returns roles of chat members by their ids.
+func chatRoles(t *testing.T, s storage.Storage, chatId int64) map[int64]storage.Role {
+	members, err := s.GetChatMembers(ctx, chatId)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	roles := make(map[int64]storage.Role, len(members))
+	for _, member := range members {
+		roles[member.UserId] = member.Role
+	}
+	return roles
+}
+
+func testChatRoles(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "member", "invited")
+	chatId := addChat(t, s, "chat_1", ids[0], ids[1])
+	_, err := s.AddChatMember(ctx, ids[1], chatId, ids[2])
+	assert.NoError(t, err)
+
+	members, err := s.GetChatMembers(ctx, chatId)
+	assert.NoError(t, err)
+	assert.Equal(t, []*storage.ChatMember{
+		{UserId: ids[0], Role: storage.RoleOwner},
+		{UserId: ids[1], Role: storage.RoleMember},
+		{UserId: ids[2], Role: storage.RoleMember},
+	}, members)
+
+	members, err = s.GetChatMembers(ctx, chatId+100)
+	assert.NoError(t, err)
+	assert.Empty(t, members)
+}
+
+func testReadOnlyMember(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "reader", "stranger")
+	chatId := addChat(t, s, "chat_1", ids[0], ids[1])
+	messageId := addMessage(t, s, ids[1], chatId, "Hello")
+	assert.NoError(t, s.SetChatMemberRole(ctx, ids[0], chatId, ids[1], storage.RoleReadOnly))
+
+	_, err := s.AddMessage(ctx, ids[1], chatId, "Can I write?")
+	assert.Equal(t, storage.ErrPermissionDenied, err)
+	assert.Equal(t, storage.ErrPermissionDenied, s.EditMessage(ctx, ids[1], messageId, "Hello, World"))
+	_, err = s.AddChatMember(ctx, ids[1], chatId, ids[2])
+	assert.Equal(t, storage.ErrPermissionDenied, err)
+
+	// reader still can delete his messages and leave
+	assert.NoError(t, s.DeleteMessage(ctx, ids[1], messageId))
+	_, err = s.RemoveChatMember(ctx, ids[1], chatId, ids[1])
+	assert.NoError(t, err)
+}
+
+func testRemoveChatMemberByRole(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "admin", "other_admin", "member", "other_member")
+	chatId := addChat(t, s, "chat_1", ids...)
+	assert.NoError(t, s.SetChatMemberRole(ctx, ids[0], chatId, ids[1], storage.RoleAdmin))
+	assert.NoError(t, s.SetChatMemberRole(ctx, ids[0], chatId, ids[2], storage.RoleAdmin))
+
+	cases := []struct {
+		ActorId  int64
+		UserId   int64
+		Expected error
+	}{
+		{ids[3], ids[4], storage.ErrPermissionDenied},
+		{ids[3], ids[1], storage.ErrPermissionDenied},
+		{ids[1], ids[2], storage.ErrPermissionDenied},
+		{ids[1], ids[0], storage.ErrPermissionDenied},
+		{ids[0], ids[0], storage.ErrOwnerCannotLeave},
+		{ids[1], ids[4], nil},
+		{ids[0], ids[2], nil},
+		{ids[3], ids[3], nil},
+	}
+	for _, testCase := range cases {
+		_, err := s.RemoveChatMember(ctx, testCase.ActorId, chatId, testCase.UserId)
+		assert.Equal(t, testCase.Expected, err, "%d removes %d", testCase.ActorId, testCase.UserId)
+	}
+	userIds, err := s.GetChatUserIds(ctx, chatId)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{ids[0], ids[1]}, userIds)
+}
+
+func testSetChatMemberRole(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "admin", "member", "stranger")
+	chatId := addChat(t, s, "chat_1", ids[0], ids[1], ids[2])
+	assert.NoError(t, s.SetChatMemberRole(ctx, ids[0], chatId, ids[1], storage.RoleAdmin))
+
+	cases := []struct {
+		ActorId  int64
+		UserId   int64
+		Role     storage.Role
+		Expected error
+	}{
+		{ids[3], ids[2], storage.RoleReadOnly, storage.ErrNotChatMember},
+		{ids[0], ids[3], storage.RoleReadOnly, storage.ErrMemberNotFound},
+		{ids[2], ids[2], storage.RoleAdmin, storage.ErrPermissionDenied},
+		{ids[1], ids[2], storage.RoleAdmin, storage.ErrPermissionDenied},
+		{ids[1], ids[0], storage.RoleMember, storage.ErrPermissionDenied},
+		{ids[0], ids[2], storage.RoleOwner, storage.ErrPermissionDenied},
+		{ids[1], ids[2], storage.RoleReadOnly, nil},
+		{ids[1], ids[2], storage.RoleMember, nil},
+	}
+	for _, testCase := range cases {
+		err := s.SetChatMemberRole(ctx, testCase.ActorId, chatId, testCase.UserId, testCase.Role)
+		assert.Equal(t, testCase.Expected, err, "%d gives %s to %d", testCase.ActorId, testCase.Role, testCase.UserId)
+	}
+	assert.Error(t, s.SetChatMemberRole(ctx, ids[0], chatId, ids[2], "superuser"))
+
+	assert.Equal(t, map[int64]storage.Role{
+		ids[0]: storage.RoleOwner,
+		ids[1]: storage.RoleAdmin,
+		ids[2]: storage.RoleMember,
+	}, chatRoles(t, s, chatId))
+}
+
+func testTransferChatOwnership(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "member", "stranger")
+	chatId := addChat(t, s, "chat_1", ids[0], ids[1])
+
+	assert.Equal(t, storage.ErrPermissionDenied, s.TransferChatOwnership(ctx, ids[1], chatId, ids[1]))
+	assert.Equal(t, storage.ErrNotChatMember, s.TransferChatOwnership(ctx, ids[2], chatId, ids[2]))
+	assert.Equal(t, storage.ErrMemberNotFound, s.TransferChatOwnership(ctx, ids[0], chatId, ids[2]))
+	assert.NoError(t, s.TransferChatOwnership(ctx, ids[0], chatId, ids[0]))
+
+	assert.NoError(t, s.TransferChatOwnership(ctx, ids[0], chatId, ids[1]))
+	assert.Equal(t, map[int64]storage.Role{
+		ids[0]: storage.RoleAdmin,
+		ids[1]: storage.RoleOwner,
+	}, chatRoles(t, s, chatId))
+
+	// former owner can leave now
+	_, err := s.RemoveChatMember(ctx, ids[0], chatId, ids[0])
+	assert.NoError(t, err)
+}
+
+func testRenameChat(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "member")
+	chatId := addChat(t, s, "chat_1", ids[0], ids[1])
+	addChat(t, s, "chat_2", ids[0])
+
+	assert.Equal(t, storage.ErrPermissionDenied, s.RenameChat(ctx, ids[1], chatId, "my_chat"))
+	assert.Equal(t, storage.ErrChatExists, s.RenameChat(ctx, ids[0], chatId, "chat_2"))
+	assert.NoError(t, s.RenameChat(ctx, ids[0], chatId, "chat_1"))
+	assert.NoError(t, s.RenameChat(ctx, ids[0], chatId, "renamed"))
+
+	chats, err := s.GetUserChats(ctx, ids[1])
+	if assert.NoError(t, err) && assert.Len(t, chats, 1) {
+		assert.Equal(t, "renamed", chats[0].Name)
+	}
+	// old name is free
+	addChat(t, s, "chat_1", ids[1])
+}
+
+func testPinMessage(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "member", "stranger")
+	chatId := addChat(t, s, "chat_1", ids[0], ids[1])
+	messageId := addMessage(t, s, ids[1], chatId, "Rules of the chat")
+
+	assert.Equal(t, storage.ErrPermissionDenied, s.PinMessage(ctx, ids[1], messageId, true))
+	assert.Equal(t, storage.ErrNotChatMember, s.PinMessage(ctx, ids[2], messageId, true))
+	assert.Equal(t, storage.ErrMessageNotFound, s.PinMessage(ctx, ids[0], messageId+100, true))
+
+	assert.NoError(t, s.PinMessage(ctx, ids[0], messageId, true))
+	assert.NoError(t, s.PinMessage(ctx, ids[0], messageId, true), "pinning is idempotent")
+	messages, err := s.GetMessagesFromChat(ctx, chatId)
+	if assert.NoError(t, err) && assert.Len(t, messages, 1) {
+		assert.True(t, messages[0].Pinned)
+	}
+	assert.NoError(t, s.PinMessage(ctx, ids[0], messageId, false))
+	message, err := s.GetMessage(ctx, messageId)
+	if assert.NoError(t, err) {
+		assert.False(t, message.Pinned)
+	}
+
+	// deleted message is unpinned
+	assert.NoError(t, s.PinMessage(ctx, ids[0], messageId, true))
+	assert.NoError(t, s.DeleteMessage(ctx, ids[1], messageId))
+	message, err = s.GetMessage(ctx, messageId)
+	if assert.NoError(t, err) {
+		assert.False(t, message.Pinned)
+	}
+	assert.Equal(t, storage.ErrMessageNotFound, s.PinMessage(ctx, ids[0], messageId, true))
+}
+
+func testDeleteMessageByModerator(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "admin", "member")
+	chatId := addChat(t, s, "chat_1", ids...)
+	assert.NoError(t, s.SetChatMemberRole(ctx, ids[0], chatId, ids[1], storage.RoleAdmin))
+	ownerMessageId := addMessage(t, s, ids[0], chatId, "Welcome")
+	memberMessageId := addMessage(t, s, ids[2], chatId, "Spam")
+
+	assert.Equal(t, storage.ErrNotMessageAuthor, s.DeleteMessage(ctx, ids[2], ownerMessageId))
+	assert.NoError(t, s.DeleteMessage(ctx, ids[1], memberMessageId))
+	assert.Equal(t, storage.ErrNotMessageAuthor, s.EditMessage(ctx, ids[1], ownerMessageId, "Bye"),
+		"moderators can't edit messages of other members")
+
+	message, err := s.GetMessage(ctx, memberMessageId)
+	if assert.NoError(t, err) {
+		assert.True(t, message.Deleted)
+	}
+}
+
+func testSlowMode(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "admin", "member", "invited", "stranger")
+	chatId := addChat(t, s, "chat_1", ids[0], ids[1], ids[2])
+	if err := s.SetChatMemberRole(ctx, ids[0], chatId, ids[1], storage.RoleAdmin); err != nil {
+		t.Fatal("SetChatMemberRole failed: ", err)
+	}
+
+	assert.Equal(t, storage.ErrPermissionDenied, s.SetChatSlowMode(ctx, ids[2], chatId, 60))
+	assert.Equal(t, storage.ErrNotChatMember, s.SetChatSlowMode(ctx, ids[4], chatId, 60))
+	assert.NoError(t, s.SetChatSlowMode(ctx, ids[0], chatId, 60))
+	chats, err := s.GetUserChats(ctx, ids[2])
+	if assert.NoError(t, err) && assert.Len(t, chats, 1) {
+		assert.Equal(t, 60, chats[0].SlowModeSeconds)
+	}
+
+	// system message about invitation isn't posted by member
+	if _, err := s.AddChatMember(ctx, ids[2], chatId, ids[3]); err != nil {
+		t.Fatal("AddChatMember failed: ", err)
+	}
+	addMessage(t, s, ids[2], chatId, "Hello")
+	_, err = s.AddMessage(ctx, ids[2], chatId, "Hello again")
+	assert.True(t, errors.Is(err, storage.ErrSlowMode))
+	var slowModeErr *storage.SlowModeError
+	if assert.True(t, errors.As(err, &slowModeErr)) {
+		assert.True(t, slowModeErr.RetryAfter > 0 && slowModeErr.RetryAfter <= time.Minute,
+			"unexpected RetryAfter %s", slowModeErr.RetryAfter)
+	}
+	// other members aren't affected, moderators aren't limited
+	addMessage(t, s, ids[3], chatId, "Hi")
+	addMessage(t, s, ids[1], chatId, "Welcome")
+	addMessage(t, s, ids[1], chatId, "Be polite")
+	addMessage(t, s, ids[0], chatId, "Welcome")
+	addMessage(t, s, ids[0], chatId, "Be polite")
+
+	assert.NoError(t, s.SetChatSlowMode(ctx, ids[1], chatId, 0))
+	addMessage(t, s, ids[2], chatId, "Hello again")
+	chats, err = s.GetUserChats(ctx, ids[2])
+	if assert.NoError(t, err) && assert.Len(t, chats, 1) {
+		assert.Equal(t, 0, chats[0].SlowModeSeconds)
+	}
+}
@@ -0,0 +1,146 @@
+package storagetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+var searchTests = []conformanceTest{
+	{"SearchMessages", testSearchMessages},
+	{"SearchMessagesFilters", testSearchMessagesFilters},
+	{"SearchMessagesRanking", testSearchMessagesRanking},
+	{"SearchMessagesQuerySyntax", testSearchMessagesQuerySyntax},
+}
+
+// isSearchAvailable reports whether s can search. Storages whose search depends on how binary is built
+// tell it with CheckSearch, the same way server checks them on start.
+func isSearchAvailable(s storage.Storage) bool {
+	checker, ok := s.(interface{ CheckSearch() error })
+	return !ok || checker.CheckSearch() == nil
+}
+
+// skipWithoutSearch skips test if storage is built without search.
+func skipWithoutSearch(t *testing.T, s storage.Storage) {
+	if !isSearchAvailable(s) {
+		t.Skip("Search is unavailable, run tests with -tags sqlite_fts5")
+	}
+}
+
+// searchMessages calls SearchMessages and skips test if storage is built without search.
+func searchMessages(t *testing.T, s storage.Storage, userId int64, query storage.SearchQuery) []*storage.SearchResult {
+	skipWithoutSearch(t, s)
+	results, err := s.SearchMessages(ctx, userId, query)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return results
+}
+
+func resultIds(results []*storage.SearchResult) []int64 {
+	ids := make([]int64, len(results))
+	for i, result := range results {
+		ids[i] = result.Id
+	}
+	return ids
+}
+
+func testSearchMessages(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user_1", "user_2")
+	chatId := addChat(t, s, "chat_1", ids[0])
+	otherChatId := addChat(t, s, "chat_2", ids[1])
+	helloId := addMessage(t, s, ids[0], chatId, "Hello, brave new world!")
+	addMessage(t, s, ids[0], chatId, "Goodbye, world")
+	addMessage(t, s, ids[1], otherChatId, "Hello from another chat")
+
+	results := searchMessages(t, s, ids[0], storage.SearchQuery{Text: "HELLO", Limit: 10})
+	if assert.Len(t, results, 1) {
+		message, err := s.GetMessage(ctx, helloId)
+		assert.NoError(t, err)
+		assert.Equal(t, message.Id, results[0].Id)
+		assert.Equal(t, message.ChatId, results[0].ChatId)
+		assert.Equal(t, message.AuthorId, results[0].AuthorId)
+		assert.Equal(t, message.Text, results[0].Text)
+		assert.True(t, message.CreatedAt.Equal(results[0].CreatedAt))
+		assert.Contains(t, results[0].Snippet, storage.SnippetMatchStart+"Hello"+storage.SnippetMatchEnd)
+	}
+
+	results = searchMessages(t, s, ids[0], storage.SearchQuery{Text: "world hello", Limit: 10})
+	assert.Equal(t, []int64{helloId}, resultIds(results), "every word must match")
+
+	results = searchMessages(t, s, ids[0], storage.SearchQuery{Text: "wor", Limit: 10})
+	assert.Empty(t, results, "only whole words match")
+
+	results = searchMessages(t, s, ids[1], storage.SearchQuery{Text: "world", Limit: 10})
+	assert.Empty(t, results, "messages from chats of other users must not be found")
+}
+
+func testSearchMessagesFilters(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user_1", "user_2")
+	chatId := addChat(t, s, "chat_1", ids[0], ids[1])
+	otherChatId := addChat(t, s, "chat_2", ids[0])
+	firstId := addMessage(t, s, ids[0], chatId, "news")
+	time.Sleep(10 * time.Millisecond)
+	from := time.Now()
+	secondId := addMessage(t, s, ids[1], chatId, "news")
+	thirdId := addMessage(t, s, ids[0], otherChatId, "news")
+	time.Sleep(10 * time.Millisecond)
+	to := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	fourthId := addMessage(t, s, ids[0], chatId, "news")
+
+	cases := []struct {
+		TestName string
+		Query    storage.SearchQuery
+		Expected []int64
+	}{
+		{"Without filters", storage.SearchQuery{}, []int64{fourthId, thirdId, secondId, firstId}},
+		{"Chat", storage.SearchQuery{ChatId: chatId}, []int64{fourthId, secondId, firstId}},
+		{"Author", storage.SearchQuery{AuthorId: ids[1]}, []int64{secondId}},
+		{"From", storage.SearchQuery{From: from}, []int64{fourthId, thirdId, secondId}},
+		{"To", storage.SearchQuery{To: to}, []int64{thirdId, secondId, firstId}},
+		{"Date range", storage.SearchQuery{From: from, To: to}, []int64{thirdId, secondId}},
+		{"Everything", storage.SearchQuery{ChatId: chatId, AuthorId: ids[0], To: to}, []int64{firstId}},
+	}
+	for _, testCase := range cases {
+		testCase.Query.Text = "news"
+		testCase.Query.Limit = 10
+		results := searchMessages(t, s, ids[0], testCase.Query)
+		assert.Equal(t, testCase.Expected, resultIds(results), testCase.TestName)
+	}
+}
+
+func testSearchMessagesRanking(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user_1")
+	chatId := addChat(t, s, "chat_1", ids[0])
+	rareId := addMessage(t, s, ids[0], chatId, "I have seen a cat today, it was sitting near the old tree in the park")
+	frequentId := addMessage(t, s, ids[0], chatId, "cat cat cat")
+
+	results := searchMessages(t, s, ids[0], storage.SearchQuery{Text: "cat", Limit: 10})
+	assert.Equal(t, []int64{frequentId, rareId}, resultIds(results))
+
+	results = searchMessages(t, s, ids[0], storage.SearchQuery{Text: "cat", Limit: 1})
+	assert.Equal(t, []int64{frequentId}, resultIds(results))
+	results = searchMessages(t, s, ids[0], storage.SearchQuery{Text: "cat", Limit: 1, Offset: 1})
+	assert.Equal(t, []int64{rareId}, resultIds(results))
+	results = searchMessages(t, s, ids[0], storage.SearchQuery{Text: "cat", Limit: 1, Offset: 2})
+	assert.Empty(t, results)
+}
+
+func testSearchMessagesQuerySyntax(t *testing.T, s storage.Storage) {
+	skipWithoutSearch(t, s)
+	ids := addUsers(t, s, "user_1")
+	chatId := addChat(t, s, "chat_1", ids[0])
+	messageId := addMessage(t, s, ids[0], chatId, `He said "NEAR" OR not`)
+
+	for _, text := range []string{`"near`, `NEAR(or`, `he*`, `not:said`, `-`, `'); DROP TABLE messages; --`} {
+		results, err := s.SearchMessages(ctx, ids[0], storage.SearchQuery{Text: text, Limit: 10})
+		assert.NoError(t, err, text)
+		assert.NotNil(t, results, text)
+	}
+	results := searchMessages(t, s, ids[0], storage.SearchQuery{Text: `NEAR(or`, Limit: 10})
+	assert.Equal(t, []int64{messageId}, resultIds(results))
+}
@@ -0,0 +1,88 @@
+// Package storagetest contains conformance tests that every storage.Storage implementation must pass.
+package storagetest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// Factory returns new empty storage and function that releases it.
+type Factory func(t *testing.T) (storage.Storage, func())
+
+// ctx is passed to every storage call of conformance tests.
+var ctx = context.Background()
+
+type conformanceTest struct {
+	Name string
+	Test func(t *testing.T, s storage.Storage)
+}
+
+// Run runs every conformance test as subtest of t. Each subtest gets its own storage from factory.
+func Run(t *testing.T, factory Factory) {
+	var tests []conformanceTest
+	tests = append(tests, userTests...)
+	tests = append(tests, chatTests...)
+	tests = append(tests, memberTests...)
+	tests = append(tests, roleTests...)
+	tests = append(tests, messageTests...)
+	tests = append(tests, readTests...)
+	tests = append(tests, searchTests...)
+	tests = append(tests, webhookTests...)
+	tests = append(tests, idempotencyTests...)
+	tests = append(tests, concurrencyTests...)
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			s, teardown := factory(t)
+			defer teardown()
+			test.Test(t, s)
+		})
+	}
+}
+
+func addUsers(t *testing.T, s storage.Storage, usernames ...string) []int64 {
+	userIds := make([]int64, len(usernames))
+	for i, username := range usernames {
+		userId, err := s.AddUser(ctx, username)
+		if err != nil {
+			t.Fatalf("AddUser(%q) failed: %s", username, err)
+		}
+		userIds[i] = userId
+	}
+	return userIds
+}
+
+// addChat creates chat owned by the first of users.
+func addChat(t *testing.T, s storage.Storage, chatname string, userIds ...int64) int64 {
+	chatId, err := s.AddChat(ctx, userIds[0], chatname, userIds[1:])
+	if err != nil {
+		t.Fatalf("AddChat(%q) failed: %s", chatname, err)
+	}
+	return chatId
+}
+
+func addMessage(t *testing.T, s storage.Storage, authorId int64, chatId int64, text string) int64 {
+	messageId, err := s.AddMessage(ctx, authorId, chatId, text)
+	if err != nil {
+		t.Fatalf("AddMessage(%q) failed: %s", text, err)
+	}
+	return messageId
+}
+
+func messageIds(messages []*storage.Message) []int64 {
+	ids := make([]int64, len(messages))
+	for i, message := range messages {
+		ids[i] = message.Id
+	}
+	return ids
+}
+
+func chatIds(chats []*storage.Chat) []int64 {
+	ids := make([]int64, len(chats))
+	for i, chat := range chats {
+		ids[i] = chat.Id
+	}
+	return ids
+}
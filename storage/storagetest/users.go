@@ -0,0 +1,104 @@
+package storagetest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+var userTests = []conformanceTest{
+	{"AddUser", testAddUser},
+	{"AddUserDuplicate", testAddUserDuplicate},
+	{"AreUsersExistByIds", testAreUsersExistByIds},
+	{"UserTokens", testUserTokens},
+	{"AddUserWithToken", testAddUserWithToken},
+}
+
+func testAddUser(t *testing.T, s storage.Storage) {
+	usernames := []string{"test_user", "some_another", "one_spare"}
+	ids := make(map[int64]bool)
+	var userIds []int64
+	for _, username := range usernames {
+		userId, err := s.AddUser(ctx, username)
+		assert.NoError(t, err)
+		assert.False(t, ids[userId], "id is repeated")
+		ids[userId] = true
+		userIds = append(userIds, userId)
+	}
+	exist, err := s.AreUsersExistByIds(ctx, userIds)
+	assert.NoError(t, err)
+	assert.True(t, exist)
+}
+
+func testAddUserDuplicate(t *testing.T, s storage.Storage) {
+	addUsers(t, s, "user_1")
+	_, err := s.AddUser(ctx, "user_1")
+	assert.Equal(t, storage.ErrUserExists, err)
+
+	// usernames are case sensitive
+	_, err = s.AddUser(ctx, "User_1")
+	assert.NoError(t, err)
+}
+
+func testAreUsersExistByIds(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user1", "user2", "user3")
+	unknown := ids[2] + 100
+	cases := []struct {
+		Ids      []int64
+		AreExist bool
+	}{
+		{[]int64{ids[0], ids[1], ids[2]}, true},
+		{[]int64{ids[0], ids[2]}, true},
+		{[]int64{ids[2]}, true},
+		{[]int64{ids[0], ids[1], unknown}, false},
+		{[]int64{unknown}, false},
+		{[]int64{ids[0], ids[0]}, false},
+	}
+	for _, testCase := range cases {
+		testCase := testCase
+		t.Run(fmt.Sprintf("Users: %v", testCase.Ids), func(t *testing.T) {
+			areExist, err := s.AreUsersExistByIds(ctx, testCase.Ids)
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.AreExist, areExist)
+		})
+	}
+}
+
+func testUserTokens(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "user_1", "user_2")
+	assert.NoError(t, s.AddUserToken(ctx, ids[0], "hash_1"))
+	assert.NoError(t, s.AddUserToken(ctx, ids[0], "hash_2"))
+	assert.NoError(t, s.AddUserToken(ctx, ids[1], "hash_3"))
+	assert.Equal(t, storage.ErrTokenExists, s.AddUserToken(ctx, ids[1], "hash_1"), "token hash must be unique")
+	assert.Equal(t, storage.ErrUserNotFound, s.AddUserToken(ctx, ids[1]+100, "hash_4"), "user must exist")
+
+	for hash, expectedId := range map[string]int64{"hash_1": ids[0], "hash_2": ids[0], "hash_3": ids[1]} {
+		userId, err := s.GetUserIdByToken(ctx, hash)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedId, userId)
+	}
+	_, err := s.GetUserIdByToken(ctx, "hash_4")
+	assert.Equal(t, storage.ErrNotFound, err)
+}
+
+func testAddUserWithToken(t *testing.T, s storage.Storage) {
+	userId, err := s.AddUserWithToken(ctx, "user_1", "hash_1")
+	assert.NoError(t, err)
+	tokenUserId, err := s.GetUserIdByToken(ctx, "hash_1")
+	assert.NoError(t, err)
+	assert.Equal(t, userId, tokenUserId)
+
+	_, err = s.AddUserWithToken(ctx, "user_1", "hash_2")
+	assert.Equal(t, storage.ErrUserExists, err)
+	_, err = s.GetUserIdByToken(ctx, "hash_2")
+	assert.Equal(t, storage.ErrNotFound, err, "token of rejected user must not be saved")
+
+	// user is not created if its token can't be saved
+	_, err = s.AddUserWithToken(ctx, "user_2", "hash_1")
+	assert.Equal(t, storage.ErrTokenExists, err)
+	_, err = s.AddUser(ctx, "user_2")
+	assert.NoError(t, err, "username of rejected user must be free")
+}
@@ -0,0 +1,263 @@
+package storagetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+var webhookTests = []conformanceTest{
+	{"AddWebhook", testAddWebhook},
+	{"GetChatWebhooks", testGetChatWebhooks},
+	{"DeleteWebhook", testDeleteWebhook},
+	{"WebhookDeliveries", testWebhookDeliveries},
+	{"ClaimWebhookDeliveries", testClaimWebhookDeliveries},
+	{"GetWebhookDeliveries", testGetWebhookDeliveries},
+}
+
+func addWebhook(t *testing.T, s storage.Storage, userId int64, chatId int64, events ...string) int64 {
+	webhookId, err := s.AddWebhook(ctx, userId, chatId, "http://localhost/hook", "secret", events)
+	if err != nil {
+		t.Fatalf("AddWebhook(%d, %d) failed: %s", userId, chatId, err)
+	}
+	return webhookId
+}
+
+func webhookIds(webhooks []*storage.Webhook) []int64 {
+	ids := make([]int64, len(webhooks))
+	for i, webhook := range webhooks {
+		ids[i] = webhook.Id
+	}
+	return ids
+}
+
+func testAddWebhook(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "member", "stranger")
+	chatId := addChat(t, s, "chat_1", ids[0], ids[1])
+
+	_, err := s.AddWebhook(ctx, ids[1], chatId, "http://localhost/hook", "secret", nil)
+	assert.Equal(t, storage.ErrPermissionDenied, err)
+	_, err = s.AddWebhook(ctx, ids[2], chatId, "http://localhost/hook", "secret", nil)
+	assert.Equal(t, storage.ErrNotChatMember, err)
+	_, err = s.AddWebhook(ctx, ids[2]+100, 0, "http://localhost/hook", "secret", nil)
+	assert.Equal(t, storage.ErrUserNotFound, err)
+
+	chatWebhookId, err := s.AddWebhook(ctx, ids[0], chatId, "http://localhost/chat", "chat_secret",
+		[]string{storage.WebhookMessageCreated, storage.MemberAdded})
+	assert.NoError(t, err)
+	globalWebhookId, err := s.AddWebhook(ctx, ids[0], 0, "http://localhost/global", "global_secret", nil)
+	assert.NoError(t, err)
+	addWebhook(t, s, ids[1], 0)
+
+	webhooks, err := s.GetUserWebhooks(ctx, ids[0])
+	if assert.NoError(t, err) && assert.Len(t, webhooks, 2) {
+		chatWebhook, globalWebhook := webhooks[0], webhooks[1]
+		assert.Equal(t, chatWebhookId, chatWebhook.Id)
+		assert.Equal(t, ids[0], chatWebhook.UserId)
+		assert.Equal(t, chatId, chatWebhook.ChatId)
+		assert.Equal(t, "http://localhost/chat", chatWebhook.URL)
+		assert.Equal(t, "chat_secret", chatWebhook.Secret)
+		assert.Equal(t, []string{storage.WebhookMessageCreated, storage.MemberAdded}, chatWebhook.Events)
+		assert.WithinDuration(t, time.Now(), chatWebhook.CreatedAt, time.Minute)
+
+		assert.Equal(t, globalWebhookId, globalWebhook.Id)
+		assert.Equal(t, int64(0), globalWebhook.ChatId)
+		assert.Empty(t, globalWebhook.Events)
+	}
+	webhooks, err = s.GetUserWebhooks(ctx, ids[2])
+	assert.NoError(t, err)
+	assert.Empty(t, webhooks)
+}
+
+func testGetChatWebhooks(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "member", "stranger")
+	chatId := addChat(t, s, "chat_1", ids[0], ids[1])
+	otherChatId := addChat(t, s, "chat_2", ids[2])
+
+	chatWebhookId := addWebhook(t, s, ids[0], chatId, storage.WebhookMessageEdited)
+	ownerWebhookId := addWebhook(t, s, ids[0], 0)
+	memberWebhookId := addWebhook(t, s, ids[1], 0, storage.WebhookMessageCreated)
+	addWebhook(t, s, ids[2], 0)
+	addWebhook(t, s, ids[2], otherChatId)
+
+	webhooks, err := s.GetChatWebhooks(ctx, chatId, storage.WebhookMessageCreated)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{ownerWebhookId, memberWebhookId}, webhookIds(webhooks))
+	webhooks, err = s.GetChatWebhooks(ctx, chatId, storage.WebhookMessageEdited)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{chatWebhookId, ownerWebhookId}, webhookIds(webhooks))
+
+	// global webhook follows membership of its user
+	_, err = s.RemoveChatMember(ctx, ids[1], chatId, ids[1])
+	assert.NoError(t, err)
+	webhooks, err = s.GetChatWebhooks(ctx, chatId, storage.WebhookMessageCreated)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{ownerWebhookId}, webhookIds(webhooks))
+}
+
+func testDeleteWebhook(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "stranger")
+	webhookId := addWebhook(t, s, ids[0], 0)
+	_, err := s.AddWebhookDelivery(ctx, webhookId, storage.WebhookMessageCreated, []byte(`{}`))
+	assert.NoError(t, err)
+
+	assert.Equal(t, storage.ErrWebhookNotFound, s.DeleteWebhook(ctx, ids[1], webhookId))
+	assert.NoError(t, s.DeleteWebhook(ctx, ids[0], webhookId))
+	assert.Equal(t, storage.ErrWebhookNotFound, s.DeleteWebhook(ctx, ids[0], webhookId))
+
+	webhooks, err := s.GetUserWebhooks(ctx, ids[0])
+	assert.NoError(t, err)
+	assert.Empty(t, webhooks)
+	deliveries, err := s.ClaimDueWebhookDeliveries(ctx, time.Now(), time.Now(), 10)
+	assert.NoError(t, err)
+	assert.Empty(t, deliveries, "deliveries are deleted with webhook")
+}
+
+func testWebhookDeliveries(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner")
+	webhookId := addWebhook(t, s, ids[0], 0)
+	_, err := s.AddWebhookDelivery(ctx, webhookId+100, storage.WebhookMessageCreated, []byte(`{}`))
+	assert.Equal(t, storage.ErrWebhookNotFound, err)
+
+	firstId, err := s.AddWebhookDelivery(ctx, webhookId, storage.WebhookMessageCreated, []byte(`{"n": 1}`))
+	assert.NoError(t, err)
+	secondId, err := s.AddWebhookDelivery(ctx, webhookId, storage.WebhookMessageDeleted, []byte(`{"n": 2}`))
+	assert.NoError(t, err)
+
+	now := time.Now()
+	deliveries, err := s.ClaimDueWebhookDeliveries(ctx, now, now, 10)
+	if assert.NoError(t, err) && assert.Len(t, deliveries, 2) {
+		delivery := deliveries[0]
+		assert.Equal(t, firstId, delivery.Id)
+		assert.Equal(t, webhookId, delivery.WebhookId)
+		assert.Equal(t, storage.WebhookMessageCreated, delivery.Event)
+		assert.JSONEq(t, `{"n": 1}`, string(delivery.Payload))
+		assert.Equal(t, storage.DeliveryPending, delivery.Status)
+		assert.Equal(t, 0, delivery.AttemptCount)
+		assert.Equal(t, "http://localhost/hook", delivery.URL)
+		assert.Equal(t, "secret", delivery.Secret)
+		assert.Equal(t, secondId, deliveries[1].Id)
+	}
+	deliveries, err = s.ClaimDueWebhookDeliveries(ctx, now, now, 1)
+	assert.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+
+	// failed delivery is due again later, the other one is delivered
+	retryAt := now.Add(time.Minute)
+	err = s.AddWebhookAttempt(ctx, &storage.WebhookAttempt{DeliveryId: firstId, AttemptedAt: now, StatusCode: 500,
+		Error: "unexpected status 500"}, storage.DeliveryPending, retryAt)
+	assert.NoError(t, err)
+	err = s.AddWebhookAttempt(ctx, &storage.WebhookAttempt{DeliveryId: secondId, AttemptedAt: now, StatusCode: 200},
+		storage.DeliveryDelivered, time.Time{})
+	assert.NoError(t, err)
+	err = s.AddWebhookAttempt(ctx, &storage.WebhookAttempt{DeliveryId: secondId + 100, AttemptedAt: now},
+		storage.DeliveryDead, time.Time{})
+	assert.Equal(t, storage.ErrNotFound, err)
+
+	deliveries, err = s.ClaimDueWebhookDeliveries(ctx, now, now, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, deliveries)
+	deliveries, err = s.ClaimDueWebhookDeliveries(ctx, retryAt.Add(time.Second), retryAt.Add(time.Second), 10)
+	if assert.NoError(t, err) && assert.Len(t, deliveries, 1) {
+		assert.Equal(t, firstId, deliveries[0].Id)
+		assert.Equal(t, 1, deliveries[0].AttemptCount)
+	}
+
+	err = s.AddWebhookAttempt(ctx, &storage.WebhookAttempt{DeliveryId: firstId, AttemptedAt: retryAt,
+		Error: "connection refused"}, storage.DeliveryDead, time.Time{})
+	assert.NoError(t, err)
+	deliveries, err = s.ClaimDueWebhookDeliveries(ctx, retryAt.Add(time.Hour), retryAt.Add(time.Hour), 10)
+	assert.NoError(t, err)
+	assert.Empty(t, deliveries, "dead delivery isn't retried")
+}
+
+func testClaimWebhookDeliveries(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner")
+	webhookId := addWebhook(t, s, ids[0], 0)
+	firstId, err := s.AddWebhookDelivery(ctx, webhookId, storage.WebhookMessageCreated, []byte(`{}`))
+	assert.NoError(t, err)
+	secondId, err := s.AddWebhookDelivery(ctx, webhookId, storage.WebhookMessageCreated, []byte(`{}`))
+	assert.NoError(t, err)
+
+	now := time.Now()
+	claimedUntil := now.Add(time.Minute)
+	deliveries, err := s.ClaimDueWebhookDeliveries(ctx, now, claimedUntil, 1)
+	if assert.NoError(t, err) && assert.Len(t, deliveries, 1) {
+		assert.Equal(t, firstId, deliveries[0].Id)
+		assert.True(t, claimedUntil.Equal(*deliveries[0].NextAttemptAt))
+	}
+	deliveries, err = s.ClaimDueWebhookDeliveries(ctx, now, claimedUntil, 10)
+	if assert.NoError(t, err) && assert.Len(t, deliveries, 1) {
+		assert.Equal(t, secondId, deliveries[0].Id, "claimed delivery isn't returned again")
+	}
+	deliveries, err = s.ClaimDueWebhookDeliveries(ctx, now, claimedUntil, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, deliveries)
+
+	// claim of dispatcher that didn't record attempt expires
+	deliveries, err = s.ClaimDueWebhookDeliveries(ctx, claimedUntil, claimedUntil.Add(time.Minute), 10)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []int64{firstId, secondId}, deliveryIds(deliveries))
+}
+
+func testGetWebhookDeliveries(t *testing.T, s storage.Storage) {
+	ids := addUsers(t, s, "owner", "stranger")
+	webhookId := addWebhook(t, s, ids[0], 0)
+	otherWebhookId := addWebhook(t, s, ids[0], 0)
+	deadId, err := s.AddWebhookDelivery(ctx, webhookId, storage.WebhookMessageCreated, []byte(`{}`))
+	assert.NoError(t, err)
+	pendingId, err := s.AddWebhookDelivery(ctx, webhookId, storage.WebhookMessageEdited, []byte(`{}`))
+	assert.NoError(t, err)
+	_, err = s.AddWebhookDelivery(ctx, otherWebhookId, storage.WebhookMessageCreated, []byte(`{}`))
+	assert.NoError(t, err)
+
+	attemptedAt := time.Date(2019, time.January, 1, 10, 0, 0, 0, time.UTC)
+	assert.NoError(t, s.AddWebhookAttempt(ctx, &storage.WebhookAttempt{DeliveryId: deadId, AttemptedAt: attemptedAt,
+		StatusCode: 500, Error: "unexpected status 500"}, storage.DeliveryPending, attemptedAt.Add(time.Minute)))
+	assert.NoError(t, s.AddWebhookAttempt(ctx, &storage.WebhookAttempt{DeliveryId: deadId,
+		AttemptedAt: attemptedAt.Add(time.Minute), Error: "timeout"}, storage.DeliveryDead, time.Time{}))
+
+	_, err = s.GetWebhookDeliveries(ctx, ids[1], webhookId, "", 10)
+	assert.Equal(t, storage.ErrWebhookNotFound, err)
+	_, err = s.GetWebhookDeliveries(ctx, ids[0], otherWebhookId+100, "", 10)
+	assert.Equal(t, storage.ErrWebhookNotFound, err)
+
+	deliveries, err := s.GetWebhookDeliveries(ctx, ids[0], webhookId, "", 10)
+	if assert.NoError(t, err) && assert.Len(t, deliveries, 2) {
+		assert.Equal(t, pendingId, deliveries[0].Id)
+		assert.Empty(t, deliveries[0].Attempts)
+		assert.NotNil(t, deliveries[0].NextAttemptAt)
+
+		dead := deliveries[1]
+		assert.Equal(t, deadId, dead.Id)
+		assert.Equal(t, storage.DeliveryDead, dead.Status)
+		assert.Equal(t, 2, dead.AttemptCount)
+		assert.Nil(t, dead.NextAttemptAt)
+		assert.Empty(t, dead.Secret)
+		if assert.Len(t, dead.Attempts, 2) {
+			assert.Equal(t, 500, dead.Attempts[0].StatusCode)
+			assert.Equal(t, "unexpected status 500", dead.Attempts[0].Error)
+			assert.True(t, attemptedAt.Equal(dead.Attempts[0].AttemptedAt))
+			assert.Equal(t, 0, dead.Attempts[1].StatusCode)
+			assert.Equal(t, "timeout", dead.Attempts[1].Error)
+		}
+	}
+
+	deliveries, err = s.GetWebhookDeliveries(ctx, ids[0], webhookId, storage.DeliveryDead, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{deadId}, deliveryIds(deliveries))
+	deliveries, err = s.GetWebhookDeliveries(ctx, ids[0], webhookId, "", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{pendingId}, deliveryIds(deliveries))
+}
+
+func deliveryIds(deliveries []*storage.WebhookDelivery) []int64 {
+	ids := make([]int64, len(deliveries))
+	for i, delivery := range deliveries {
+		ids[i] = delivery.Id
+	}
+	return ids
+}
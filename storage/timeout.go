@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// timeoutStorage limits duration of every call to wrapped storage.
+type timeoutStorage struct {
+	storage Storage
+	timeout time.Duration
+}
+
+// WithTimeout returns storage that cancels calls to s which take longer than timeout.
+// The deadline is added to context of the call, so the earlier deadline of the caller still applies.
+// Zero timeout leaves s as is. It's meant for background workers, requests of server put the deadline
+// on their context instead, so it applies to all their calls together.
+func WithTimeout(s Storage, timeout time.Duration) Storage {
+	if timeout <= 0 {
+		return s
+	}
+	return &timeoutStorage{storage: s, timeout: timeout}
+}
+func (s *timeoutStorage) AreUsersExistByIds(ctx context.Context, userIds []int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.AreUsersExistByIds(ctx, userIds)
+}
+
+func (s *timeoutStorage) AddUser(ctx context.Context, username string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.AddUser(ctx, username)
+}
+
+func (s *timeoutStorage) AddUserToken(ctx context.Context, userId int64, tokenHash string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.AddUserToken(ctx, userId, tokenHash)
+}
+
+func (s *timeoutStorage) AddUserWithToken(ctx context.Context, username string, tokenHash string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.AddUserWithToken(ctx, username, tokenHash)
+}
+
+func (s *timeoutStorage) GetUserIdByToken(ctx context.Context, tokenHash string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.GetUserIdByToken(ctx, tokenHash)
+}
+
+func (s *timeoutStorage) GetUserChats(ctx context.Context, userId int64) ([]*Chat, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.GetUserChats(ctx, userId)
+}
+
+func (s *timeoutStorage) AddChat(ctx context.Context, ownerId int64, chatname string, memberIds []int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.AddChat(ctx, ownerId, chatname, memberIds)
+}
+
+func (s *timeoutStorage) RenameChat(ctx context.Context, actorId int64, chatId int64, chatname string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.RenameChat(ctx, actorId, chatId, chatname)
+}
+
+func (s *timeoutStorage) SetChatSlowMode(ctx context.Context, actorId int64, chatId int64, seconds int) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.SetChatSlowMode(ctx, actorId, chatId, seconds)
+}
+
+func (s *timeoutStorage) IsUserInChat(ctx context.Context, userId int64, chatId int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.IsUserInChat(ctx, userId, chatId)
+}
+
+func (s *timeoutStorage) GetChatUserIds(ctx context.Context, chatId int64) ([]int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.GetChatUserIds(ctx, chatId)
+}
+
+func (s *timeoutStorage) GetChatMembers(ctx context.Context, chatId int64) ([]*ChatMember, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.GetChatMembers(ctx, chatId)
+}
+
+func (s *timeoutStorage) MarkChatRead(ctx context.Context, userId int64, chatId int64, messageId int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.MarkChatRead(ctx, userId, chatId, messageId)
+}
+
+func (s *timeoutStorage) AddChatMember(ctx context.Context, actorId int64, chatId int64, userId int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.AddChatMember(ctx, actorId, chatId, userId)
+}
+
+func (s *timeoutStorage) RemoveChatMember(ctx context.Context, actorId int64, chatId int64, userId int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.RemoveChatMember(ctx, actorId, chatId, userId)
+}
+
+func (s *timeoutStorage) SetChatMemberRole(ctx context.Context, actorId int64, chatId int64, userId int64, role Role) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.SetChatMemberRole(ctx, actorId, chatId, userId, role)
+}
+
+func (s *timeoutStorage) TransferChatOwnership(ctx context.Context, ownerId int64, chatId int64, userId int64) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.TransferChatOwnership(ctx, ownerId, chatId, userId)
+}
+
+func (s *timeoutStorage) AddMessage(ctx context.Context, authorId int64, chatId int64, text string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.AddMessage(ctx, authorId, chatId, text)
+}
+
+func (s *timeoutStorage) GetMessage(ctx context.Context, messageId int64) (*Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.GetMessage(ctx, messageId)
+}
+
+func (s *timeoutStorage) GetMessagesFromChat(ctx context.Context, chatId int64) ([]*Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.GetMessagesFromChat(ctx, chatId)
+}
+
+func (s *timeoutStorage) GetMessagesPage(ctx context.Context, chatId int64, query PageQuery) ([]*Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.GetMessagesPage(ctx, chatId, query)
+}
+
+func (s *timeoutStorage) EditMessage(ctx context.Context, authorId int64, messageId int64, text string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.EditMessage(ctx, authorId, messageId, text)
+}
+
+func (s *timeoutStorage) DeleteMessage(ctx context.Context, actorId int64, messageId int64) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.DeleteMessage(ctx, actorId, messageId)
+}
+
+func (s *timeoutStorage) PinMessage(ctx context.Context, actorId int64, messageId int64, pinned bool) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.PinMessage(ctx, actorId, messageId, pinned)
+}
+
+func (s *timeoutStorage) GetMessageEdits(ctx context.Context, messageId int64) ([]*MessageEdit, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.GetMessageEdits(ctx, messageId)
+}
+
+func (s *timeoutStorage) GetMessageReaders(ctx context.Context, messageId int64) ([]int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.GetMessageReaders(ctx, messageId)
+}
+
+func (s *timeoutStorage) SearchMessages(ctx context.Context, userId int64, query SearchQuery) ([]*SearchResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.SearchMessages(ctx, userId, query)
+}
+
+func (s *timeoutStorage) AddWebhook(ctx context.Context, userId int64, chatId int64, url string, secret string, events []string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.AddWebhook(ctx, userId, chatId, url, secret, events)
+}
+
+func (s *timeoutStorage) GetUserWebhooks(ctx context.Context, userId int64) ([]*Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.GetUserWebhooks(ctx, userId)
+}
+
+func (s *timeoutStorage) DeleteWebhook(ctx context.Context, userId int64, webhookId int64) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.DeleteWebhook(ctx, userId, webhookId)
+}
+
+func (s *timeoutStorage) GetChatWebhooks(ctx context.Context, chatId int64, event string) ([]*Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.GetChatWebhooks(ctx, chatId, event)
+}
+
+func (s *timeoutStorage) AddWebhookDelivery(ctx context.Context, webhookId int64, event string, payload []byte) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.AddWebhookDelivery(ctx, webhookId, event, payload)
+}
+
+func (s *timeoutStorage) ClaimDueWebhookDeliveries(ctx context.Context, now time.Time, claimedUntil time.Time, limit int) ([]*WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.ClaimDueWebhookDeliveries(ctx, now, claimedUntil, limit)
+}
+
+func (s *timeoutStorage) AddWebhookAttempt(ctx context.Context, attempt *WebhookAttempt, status string, nextAttemptAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.AddWebhookAttempt(ctx, attempt, status, nextAttemptAt)
+}
+
+func (s *timeoutStorage) GetWebhookDeliveries(ctx context.Context, userId int64, webhookId int64, status string, limit int) ([]*WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.GetWebhookDeliveries(ctx, userId, webhookId, status, limit)
+}
+
+func (s *timeoutStorage) ReserveIdempotencyKey(ctx context.Context, key *IdempotencyKey, expiredBefore time.Time, abandonedBefore time.Time) (*IdempotencyKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.ReserveIdempotencyKey(ctx, key, expiredBefore, abandonedBefore)
+}
+
+func (s *timeoutStorage) CompleteIdempotencyKey(ctx context.Context, key *IdempotencyKey) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.CompleteIdempotencyKey(ctx, key)
+}
+
+func (s *timeoutStorage) ReleaseIdempotencyKey(ctx context.Context, key *IdempotencyKey) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.ReleaseIdempotencyKey(ctx, key)
+}
+
+func (s *timeoutStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.storage.DeleteExpiredIdempotencyKeys(ctx, expiredBefore)
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Webhook events about messages. Membership changes are reported with types of SystemEvent.
+const (
+	WebhookMessageCreated = "message_created"
+	WebhookMessageEdited  = "message_edited"
+	WebhookMessageDeleted = "message_deleted"
+)
+
+// WebhookEvents are all events that webhook can be subscribed to.
+var WebhookEvents = []string{
+	WebhookMessageCreated, WebhookMessageEdited, WebhookMessageDeleted,
+	MemberAdded, MemberRemoved, MemberLeft,
+}
+
+// Statuses of WebhookDelivery. Dead delivery has exhausted its attempts and isn't retried anymore.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// Webhook is HTTP endpoint that is notified about events in chats.
+// Webhook with zero ChatId is global: it gets events from all chats its user is member of.
+// Empty Events means all events. Secret signs payloads, so it's shown to user only once.
+type Webhook struct {
+	Id        int64     `json:"id"`
+	UserId    int64     `json:"user"`
+	ChatId    int64     `json:"chat,omitempty"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Wants reports whether webhook is subscribed to event.
+func (w *Webhook) Wants(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, wanted := range w.Events {
+		if wanted == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is event payload queued for webhook. NextAttemptAt is set only for pending delivery.
+// Attempts are loaded only by GetWebhookDeliveries, URL and Secret of webhook only by ClaimDueWebhookDeliveries.
+type WebhookDelivery struct {
+	Id            int64             `json:"id"`
+	WebhookId     int64             `json:"webhook"`
+	Event         string            `json:"event"`
+	Payload       json.RawMessage   `json:"payload"`
+	Status        string            `json:"status"`
+	AttemptCount  int               `json:"attempt_count"`
+	NextAttemptAt *time.Time        `json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+	Attempts      []*WebhookAttempt `json:"attempts,omitempty"`
+	URL           string            `json:"-"`
+	Secret        string            `json:"-"`
+}
+
+// WebhookAttempt is result of one attempt to deliver. StatusCode is zero if there was no response.
+type WebhookAttempt struct {
+	DeliveryId  int64     `json:"-"`
+	AttemptedAt time.Time `json:"attempted_at"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// joinEvents and splitEvents convert events of webhook to column value and back.
+func joinEvents(events []string) string {
+	return strings.Join(events, ",")
+}
+
+func splitEvents(joined string) []string {
+	if joined == "" {
+		return []string{}
+	}
+	return strings.Split(joined, ",")
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Darkclainer/avito_exercise/hub"
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+const (
+	// streamSendBuffer is number of events that can wait for slow client before it will be disconnected
+	streamSendBuffer = 64
+	// resumePageSize is page size used to load messages missed by reconnecting client
+	resumePageSize = 100
+	// maxResumeMessages limits number of missed messages that are sent to reconnecting client,
+	// client that missed more must load them with /messages/get
+	maxResumeMessages = 1000
+	// resumeWindow is how long before message with last id received by client messages are sent again on resume.
+	// Messages of different chats may be published out of order, so client may have got message with last id
+	// before messages with lower ids and then disconnected.
+	resumeWindow = time.Minute
+)
+
+// errTooManyMissed is returned by loadMessagesAfter if client missed more than maxResumeMessages.
+var errTooManyMissed = errors.New("too many missed messages")
+
+// eventWriter sends events to streaming client. It is implemented for WebSocket and Server-Sent Events.
+type eventWriter interface {
+	WriteEvent(event *hub.Event) error
+	Ping() error
+	// TooSlow tells client that it is disconnected because it doesn't keep up with events.
+	TooSlow()
+	// GoingAway tells client that it is disconnected because server is shutting down.
+	GoingAway()
+}
+
+// streamEvents sends missed messages, then events from subscription and pings until closed or stopping is closed.
+// Live messages that were already sent as missed ones are skipped. Other live messages are sent even if their ids
+// are lower than ids of already sent ones, because messages of different chats may be published out of order.
+func streamEvents(writer eventWriter, sub *hub.Subscription, missed []*storage.Message, pingPeriod time.Duration,
+	closed <-chan struct{}, stopping <-chan struct{}, logger *logrus.Entry) {
+	sentMissed := make(map[int64]bool, len(missed))
+	for _, message := range missed {
+		if err := writer.WriteEvent(hub.NewMessageEvent(message)); err != nil {
+			logger.WithField("error", err).Debug("Stream write failed")
+			return
+		}
+		sentMissed[message.Id] = true
+	}
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case event := <-sub.Events():
+			if event.Type == hub.EventMessage && sentMissed[event.Message.Id] {
+				// every message is published once, so it can't be duplicated again
+				delete(sentMissed, event.Message.Id)
+				continue
+			}
+			if err := writer.WriteEvent(event); err != nil {
+				logger.WithField("error", err).Debug("Stream write failed")
+				return
+			}
+		case <-sub.Done():
+			logger.Debug("Stream client is too slow, disconnecting")
+			writer.TooSlow()
+			return
+		case <-ticker.C:
+			if err := writer.Ping(); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-stopping:
+			logger.Debug("Server is shutting down, disconnecting stream")
+			writer.GoingAway()
+			return
+		}
+	}
+}
+
+// loadMessagesAfter returns messages that client with lastId may have missed from all chats of the user
+// ordered by id: messages with greater id and messages with lower id created within resumeWindow before
+// message with lastId. The latter ones may be already known to client, it skips them by id.
+// It returns errTooManyMissed instead of loading more than maxResumeMessages.
+// Loading is limited by RequestTimeout, though the stream itself isn't.
+func (s *Server) loadMessagesAfter(ctx context.Context, userId int64, lastId int64) ([]*storage.Message, error) {
+	ctx, cancel := s.storageContext(ctx, 0)
+	defer cancel()
+	chats, err := s.Storage.GetUserChats(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("GetUserChats failed: %s", err)
+	}
+	// window is skipped if client sent unknown id
+	var since *time.Time
+	last, err := s.Storage.GetMessage(ctx, lastId)
+	switch {
+	case err == nil:
+		windowStart := last.CreatedAt.Add(-resumeWindow)
+		since = &windowStart
+	case !errors.Is(err, storage.ErrNotFound):
+		return nil, fmt.Errorf("GetMessage failed: %s", err)
+	}
+	messages := make([]*storage.Message, 0)
+	for _, chat := range chats {
+		if since != nil {
+			if messages, err = s.loadRecentMessages(ctx, messages, chat.Id, lastId, *since); err != nil {
+				return nil, err
+			}
+		}
+		query := storage.PageQuery{AfterId: lastId, Limit: resumePageSize}
+		for {
+			page, err := s.Storage.GetMessagesPage(ctx, chat.Id, query)
+			if err != nil {
+				return nil, fmt.Errorf("GetMessagesPage failed: %s", err)
+			}
+			messages = append(messages, page...)
+			if len(messages) > maxResumeMessages {
+				return nil, errTooManyMissed
+			}
+			if len(page) < query.Limit {
+				break
+			}
+			query.AfterId = page[len(page)-1].Id
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].Id < messages[j].Id
+	})
+	return messages, nil
+}
+
+// loadRecentMessages appends to messages ones of the chat with id not greater than lastId that were created
+// not earlier than since. Chat is read backward from lastId until older message is met.
+func (s *Server) loadRecentMessages(ctx context.Context, messages []*storage.Message, chatId int64, lastId int64,
+	since time.Time) ([]*storage.Message, error) {
+	query := storage.PageQuery{BeforeId: lastId + 1, Limit: resumePageSize}
+	for {
+		page, err := s.Storage.GetMessagesPage(ctx, chatId, query)
+		if err != nil {
+			return nil, fmt.Errorf("GetMessagesPage failed: %s", err)
+		}
+		for i := len(page) - 1; i >= 0; i-- {
+			if page[i].CreatedAt.Before(since) {
+				return messages, nil
+			}
+			messages = append(messages, page[i])
+			if len(messages) > maxResumeMessages {
+				return nil, errTooManyMissed
+			}
+		}
+		if len(page) < query.Limit {
+			return messages, nil
+		}
+		query.BeforeId = page[0].Id
+	}
+}
@@ -2,11 +2,21 @@ package main
 
 import (
 	"gopkg.in/go-playground/validator.v9"
+	"reflect"
 	"regexp"
+	"strings"
 )
 
 func NewValidate() *validator.Validate {
 	validate := validator.New()
+	// fields in validation errors are named as in json, because they are reported to client
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
 	validate.RegisterValidation("identificator", validateRegexp(`^[a-zA-Z]\w*$`))
 	validate.RegisterAlias("username", "identificator,min=1,max=32")
 	validate.RegisterAlias("chatname", "identificator,min=1,max=32")
@@ -0,0 +1,112 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// DefaultDeniedNetworks are networks webhooks aren't delivered to by default: loopback, private, link-local
+// (including cloud metadata address 169.254.169.254) and other addresses that aren't reachable from Internet.
+var DefaultDeniedNetworks = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+// ErrAddressDenied is returned for webhook URL or address in denied network.
+var ErrAddressDenied = errors.New("address of webhook is denied")
+
+// AddressPolicy restricts addresses webhooks are delivered to, so users can't make server send requests
+// to its internal network. Address is denied if it's in one of Denied networks and not in any of Allowed ones.
+type AddressPolicy struct {
+	Allowed []*net.IPNet
+	Denied  []*net.IPNet
+}
+
+// NewAddressPolicy parses networks in CIDR notation.
+func NewAddressPolicy(allowed []string, denied []string) (*AddressPolicy, error) {
+	policy := &AddressPolicy{}
+	var err error
+	if policy.Allowed, err = parseNetworks(allowed); err != nil {
+		return nil, err
+	}
+	if policy.Denied, err = parseNetworks(denied); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// CheckIP returns ErrAddressDenied if ip is denied.
+func (p *AddressPolicy) CheckIP(ip net.IP) error {
+	if containsIP(p.Allowed, ip) || !containsIP(p.Denied, ip) {
+		return nil
+	}
+	return ErrAddressDenied
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckURL returns ErrAddressDenied if host of webhook URL is denied IP address or "localhost".
+// Other host names aren't resolved: they may point to other address at delivery time,
+// so addresses are checked again when connection is made, see Control.
+func (p *AddressPolicy) CheckURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+	if ip := net.ParseIP(host); ip != nil {
+		return p.CheckIP(ip)
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return p.CheckIP(net.IPv4(127, 0, 0, 1))
+	}
+	return nil
+}
+
+// Control checks address right before connection is made, it's used as net.Dialer.Control.
+func (p *AddressPolicy) Control(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("unexpected address %q", address)
+	}
+	if err := p.CheckIP(ip); err != nil {
+		return fmt.Errorf("%w: %s", err, ip)
+	}
+	return nil
+}
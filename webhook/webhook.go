@@ -0,0 +1,311 @@
+// Package webhook delivers chat events to registered HTTP endpoints.
+// Deliveries are queued in storage, so they survive restarts. Failed deliveries are retried
+// with exponential backoff and become dead after the last attempt.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+// Headers of webhook request. SignatureHeader is "sha256=" followed by hex of HMAC-SHA256 of body
+// with secret of webhook.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// Payload is body of webhook request. Message is state of message right after event.
+type Payload struct {
+	Event      string           `json:"event"`
+	ChatId     int64            `json:"chat"`
+	Message    *storage.Message `json:"message"`
+	OccurredAt time.Time        `json:"occurred_at"`
+}
+
+// Dispatcher queues events for webhooks and delivers them. Settings must not be changed after Run.
+type Dispatcher struct {
+	Storage storage.Storage
+	// Client must connect only to addresses allowed by Addresses, as Client of New does
+	Client    *http.Client
+	Addresses *AddressPolicy
+	Logger    *logrus.Logger
+	// MaxAttempts is number of failed attempts after which delivery is dead
+	MaxAttempts int
+	// Retry of delivery is delayed by MinBackoff, doubled after every failed attempt up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// PollInterval is how often queue is checked for retries
+	PollInterval time.Duration
+	// BatchSize is maximum number of deliveries loaded from queue at once
+	BatchSize int
+	// Workers is maximum number of webhooks batch is delivered to at once
+	Workers int
+	// ClaimTimeout is how long loaded deliveries are hidden from dispatchers of other instances.
+	// It must be longer than attempts of the whole batch take, otherwise deliveries may be sent twice.
+	ClaimTimeout time.Duration
+
+	wake chan struct{}
+	now  func() time.Time
+}
+
+// New returns Dispatcher with default settings. Webhooks aren't delivered to DefaultDeniedNetworks.
+func New(s storage.Storage, logger *logrus.Logger) *Dispatcher {
+	addresses, err := NewAddressPolicy(nil, DefaultDeniedNetworks)
+	if err != nil {
+		panic(err)
+	}
+	d := &Dispatcher{
+		Storage:      s,
+		Addresses:    addresses,
+		Logger:       logger,
+		MaxAttempts:  8,
+		MinBackoff:   10 * time.Second,
+		MaxBackoff:   time.Hour,
+		PollInterval: 5 * time.Second,
+		BatchSize:    20,
+		Workers:      4,
+		ClaimTimeout: 5 * time.Minute,
+		wake:         make(chan struct{}, 1),
+		now:          time.Now,
+	}
+	// address is checked when connection is made, so host name can't be resolved to denied address
+	// after webhook is registered, and redirects to denied addresses fail as well
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			return d.Addresses.Control(network, address, conn)
+		},
+	}
+	d.Client = &http.Client{
+		Timeout: 10 * time.Second,
+		// proxy isn't used, otherwise address of proxy would be checked instead of address of webhook
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+	return d
+}
+
+// Sign returns value of SignatureHeader for payload.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify queues event about message for every webhook subscribed to it in chat of the message
+// and wakes delivery.
+func (d *Dispatcher) Notify(ctx context.Context, event string, message *storage.Message) error {
+	webhooks, err := d.Storage.GetChatWebhooks(ctx, message.ChatId, event)
+	if err != nil {
+		return fmt.Errorf("GetChatWebhooks failed: %w", err)
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(Payload{
+		Event:      event,
+		ChatId:     message.ChatId,
+		Message:    message,
+		OccurredAt: d.now(),
+	})
+	if err != nil {
+		return err
+	}
+	for _, webhook := range webhooks {
+		if _, err := d.Storage.AddWebhookDelivery(ctx, webhook.Id, event, payload); err != nil {
+			return fmt.Errorf("AddWebhookDelivery failed: %w", err)
+		}
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run delivers queued events until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-timer.C:
+		}
+		for {
+			delivered, err := d.DeliverDue(ctx)
+			if err != nil {
+				d.Logger.WithField("error", err).Error("Can not deliver webhooks")
+			}
+			// full batch means that more deliveries may be due
+			if err != nil || delivered < d.BatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(d.PollInterval)
+	}
+}
+
+// DeliverDue makes attempt for every delivery that is due and returns number of attempts.
+// Deliveries of different webhooks are sent concurrently by at most Workers goroutines, deliveries of one webhook
+// are sent one by one in order of queue, so slow webhook delays only its own deliveries.
+func (d *Dispatcher) DeliverDue(ctx context.Context) (int, error) {
+	now := d.now()
+	deliveries, err := d.Storage.ClaimDueWebhookDeliveries(ctx, now, now.Add(d.ClaimTimeout), d.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("ClaimDueWebhookDeliveries failed: %w", err)
+	}
+	var queues [][]*storage.WebhookDelivery
+	queueOf := make(map[int64]int)
+	for _, delivery := range deliveries {
+		i, ok := queueOf[delivery.WebhookId]
+		if !ok {
+			i = len(queues)
+			queueOf[delivery.WebhookId] = i
+			queues = append(queues, nil)
+		}
+		queues[i] = append(queues[i], delivery)
+	}
+
+	workers := d.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(queues) {
+		workers = len(queues)
+	}
+	jobs := make(chan []*storage.WebhookDelivery)
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		attempted int
+		firstErr  error
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for queue := range jobs {
+				n, err := d.deliverQueue(ctx, queue)
+				mu.Lock()
+				attempted += n
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, queue := range queues {
+		jobs <- queue
+	}
+	close(jobs)
+	wg.Wait()
+	return attempted, firstErr
+}
+
+// deliverQueue makes attempts for deliveries of one webhook one by one and returns number of attempts.
+func (d *Dispatcher) deliverQueue(ctx context.Context, deliveries []*storage.WebhookDelivery) (int, error) {
+	for i, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return i, nil
+		}
+		attempt := d.attempt(ctx, delivery)
+		if ctx.Err() != nil {
+			// interrupted attempt isn't counted, delivery is due again when its claim expires
+			return i, nil
+		}
+		status, nextAttemptAt := d.nextStatus(delivery.AttemptCount+1, attempt)
+		if err := d.Storage.AddWebhookAttempt(ctx, attempt, status, nextAttemptAt); err != nil {
+			return i, fmt.Errorf("AddWebhookAttempt failed: %w", err)
+		}
+		d.Logger.WithFields(logrus.Fields{
+			"delivery_id": delivery.Id,
+			"webhook_id":  delivery.WebhookId,
+			"status":      status,
+			"error":       attempt.Error,
+		}).Debug("Webhook delivery attempted")
+	}
+	return len(deliveries), nil
+}
+
+// attempt sends delivery to its webhook. Any 2xx responce is success.
+func (d *Dispatcher) attempt(ctx context.Context, delivery *storage.WebhookDelivery) *storage.WebhookAttempt {
+	attempt := &storage.WebhookAttempt{DeliveryId: delivery.Id, AttemptedAt: d.now()}
+	request, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EventHeader, delivery.Event)
+	request.Header.Set(DeliveryHeader, fmt.Sprint(delivery.Id))
+	request.Header.Set(SignatureHeader, Sign(delivery.Secret, delivery.Payload))
+	responce, err := d.Client.Do(request)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer responce.Body.Close()
+	// body is drained, so connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(responce.Body, 64*1024))
+	attempt.StatusCode = responce.StatusCode
+	if responce.StatusCode < 200 || responce.StatusCode >= 300 {
+		attempt.Error = fmt.Sprintf("unexpected status %d", responce.StatusCode)
+	}
+	return attempt
+}
+
+// nextStatus returns status of delivery after attempt with specified number and time of the next attempt.
+func (d *Dispatcher) nextStatus(attemptNumber int, attempt *storage.WebhookAttempt) (string, time.Time) {
+	if attempt.Error == "" {
+		return storage.DeliveryDelivered, time.Time{}
+	}
+	if attemptNumber >= d.MaxAttempts {
+		return storage.DeliveryDead, time.Time{}
+	}
+	return storage.DeliveryPending, attempt.AttemptedAt.Add(d.backoff(attemptNumber))
+}
+
+// backoff returns delay after failed attempt with specified number.
+func (d *Dispatcher) backoff(attemptNumber int) time.Duration {
+	delay := d.MinBackoff
+	for i := 1; i < attemptNumber && delay < d.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxBackoff {
+		delay = d.MaxBackoff
+	}
+	return delay
+}
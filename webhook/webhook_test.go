@@ -0,0 +1,259 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Darkclainer/avito_exercise/storage"
+)
+
+type received struct {
+	Header http.Header
+	Body   []byte
+}
+
+// newReceiver starts server that responds with status and passes requests to returned channel.
+func newReceiver(status int) (*httptest.Server, <-chan received) {
+	requests := make(chan received, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- received{r.Header, body}
+		w.WriteHeader(status)
+	}))
+	return server, requests
+}
+
+// setup creates chat with message and webhook of its owner, that points to url.
+func setup(t *testing.T, url string, events ...string) (*storage.MemoryStorage, *storage.Message, int64) {
+	ctx := context.Background()
+	s := storage.NewMemoryStorage()
+	userId, err := s.AddUser(ctx, "owner")
+	assert.NoError(t, err)
+	chatId, err := s.AddChat(ctx, userId, "chat_1", nil)
+	assert.NoError(t, err)
+	messageId, err := s.AddMessage(ctx, userId, chatId, "Hello")
+	assert.NoError(t, err)
+	message, err := s.GetMessage(ctx, messageId)
+	assert.NoError(t, err)
+	webhookId, err := s.AddWebhook(ctx, userId, chatId, url, "secret", events)
+	assert.NoError(t, err)
+	return s, message, webhookId
+}
+
+func newTestDispatcher(s storage.Storage) *Dispatcher {
+	logger := logrus.New()
+	logger.Level = logrus.ErrorLevel
+	d := New(s, logger)
+	// receivers of tests listen on loopback
+	d.Addresses.Allowed = d.Addresses.Denied
+	return d
+}
+
+func TestDeliver(t *testing.T) {
+	ctx := context.Background()
+	receiver, requests := newReceiver(http.StatusNoContent)
+	defer receiver.Close()
+	s, message, webhookId := setup(t, receiver.URL)
+	d := newTestDispatcher(s)
+
+	assert.NoError(t, d.Notify(ctx, storage.WebhookMessageCreated, message))
+	delivered, err := d.DeliverDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+
+	request := <-requests
+	assert.Equal(t, "application/json", request.Header.Get("Content-Type"))
+	assert.Equal(t, storage.WebhookMessageCreated, request.Header.Get(EventHeader))
+	assert.NotEmpty(t, request.Header.Get(DeliveryHeader))
+	assert.Equal(t, Sign("secret", request.Body), request.Header.Get(SignatureHeader))
+	var payload Payload
+	if assert.NoError(t, json.Unmarshal(request.Body, &payload)) {
+		assert.Equal(t, storage.WebhookMessageCreated, payload.Event)
+		assert.Equal(t, message.ChatId, payload.ChatId)
+		assert.Equal(t, message.Id, payload.Message.Id)
+		assert.Equal(t, "Hello", payload.Message.Text)
+	}
+
+	deliveries, err := s.GetWebhookDeliveries(ctx, 1, webhookId, "", 10)
+	if assert.NoError(t, err) && assert.Len(t, deliveries, 1) {
+		assert.Equal(t, storage.DeliveryDelivered, deliveries[0].Status)
+		if assert.Len(t, deliveries[0].Attempts, 1) {
+			assert.Equal(t, http.StatusNoContent, deliveries[0].Attempts[0].StatusCode)
+			assert.Empty(t, deliveries[0].Attempts[0].Error)
+		}
+	}
+	delivered, err = d.DeliverDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, delivered)
+}
+
+func TestNotifySkipsUnsubscribedEvents(t *testing.T) {
+	ctx := context.Background()
+	s, message, webhookId := setup(t, "http://localhost/hook", storage.WebhookMessageDeleted)
+	d := newTestDispatcher(s)
+
+	assert.NoError(t, d.Notify(ctx, storage.WebhookMessageCreated, message))
+	deliveries, err := s.GetWebhookDeliveries(ctx, 1, webhookId, "", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, deliveries)
+}
+
+func TestRetriesUntilDead(t *testing.T) {
+	ctx := context.Background()
+	receiver, requests := newReceiver(http.StatusInternalServerError)
+	defer receiver.Close()
+	s, message, webhookId := setup(t, receiver.URL)
+	d := newTestDispatcher(s)
+	d.MaxAttempts = 3
+
+	assert.NoError(t, d.Notify(ctx, storage.WebhookMessageEdited, message))
+	now := time.Now()
+	d.now = func() time.Time { return now }
+	for attempt := 1; attempt <= 3; attempt++ {
+		delivered, err := d.DeliverDue(ctx)
+		assert.NoError(t, err)
+		if !assert.Equal(t, 1, delivered, "attempt %d", attempt) {
+			t.FailNow()
+		}
+		<-requests
+		// retry isn't due until backoff elapses
+		delivered, err = d.DeliverDue(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, delivered)
+		now = now.Add(d.backoff(attempt))
+	}
+	now = now.Add(d.MaxBackoff)
+	delivered, err := d.DeliverDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, delivered, "dead delivery isn't retried")
+
+	deliveries, err := s.GetWebhookDeliveries(ctx, 1, webhookId, storage.DeliveryDead, 10)
+	if assert.NoError(t, err) && assert.Len(t, deliveries, 1) {
+		assert.Equal(t, 3, deliveries[0].AttemptCount)
+		if assert.Len(t, deliveries[0].Attempts, 3) {
+			assert.Equal(t, http.StatusInternalServerError, deliveries[0].Attempts[2].StatusCode)
+			assert.Equal(t, "unexpected status 500", deliveries[0].Attempts[2].Error)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := newTestDispatcher(nil)
+	d.MinBackoff = 10 * time.Second
+	d.MaxBackoff = time.Minute
+	expected := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute}
+	for i, delay := range expected {
+		assert.Equal(t, delay, d.backoff(i+1), "attempt %d", i+1)
+	}
+}
+
+func TestRun(t *testing.T) {
+	receiver, requests := newReceiver(http.StatusOK)
+	defer receiver.Close()
+	s, message, _ := setup(t, receiver.URL)
+	d := newTestDispatcher(s)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(stopped)
+	}()
+
+	assert.NoError(t, d.Notify(ctx, storage.MemberAdded, message))
+	select {
+	case request := <-requests:
+		assert.Equal(t, storage.MemberAdded, request.Header.Get(EventHeader))
+	case <-time.After(5 * time.Second):
+		t.Error("Webhook wasn't delivered")
+	}
+	cancel()
+	<-stopped
+}
+
+func TestDeliverToDeniedAddress(t *testing.T) {
+	ctx := context.Background()
+	receiver, requests := newReceiver(http.StatusNoContent)
+	defer receiver.Close()
+	// host name doesn't tell that webhook points to loopback, it's found out only when connection is made
+	url := strings.Replace(receiver.URL, "127.0.0.1", "localhost", 1)
+	s, message, webhookId := setup(t, url)
+	d := newTestDispatcher(s)
+	d.Addresses.Allowed = nil
+
+	assert.NoError(t, d.Notify(ctx, storage.WebhookMessageCreated, message))
+	delivered, err := d.DeliverDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Empty(t, requests)
+	deliveries, err := s.GetWebhookDeliveries(ctx, 1, webhookId, "", 10)
+	if assert.NoError(t, err) && assert.Len(t, deliveries, 1) && assert.Len(t, deliveries[0].Attempts, 1) {
+		assert.Contains(t, deliveries[0].Attempts[0].Error, ErrAddressDenied.Error())
+	}
+}
+
+func TestAddressPolicy(t *testing.T) {
+	policy, err := NewAddressPolicy([]string{"10.1.0.0/16"}, DefaultDeniedNetworks)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, policy.CheckIP(net.ParseIP("93.184.216.34")))
+	assert.NoError(t, policy.CheckIP(net.ParseIP("10.1.2.3")), "allowed network")
+	for _, ip := range []string{"127.0.0.1", "10.2.0.1", "192.168.1.1", "169.254.169.254", "::1", "::ffff:127.0.0.1",
+		"fd00::1"} {
+		assert.Equal(t, ErrAddressDenied, policy.CheckIP(net.ParseIP(ip)), ip)
+	}
+
+	assert.NoError(t, policy.CheckURL("https://example.com/hook"))
+	assert.NoError(t, policy.CheckURL("https://10.1.0.5/hook"))
+	for _, url := range []string{"http://127.0.0.1:8080/hook", "http://[::1]/hook", "http://localhost/hook",
+		"http://api.LOCALHOST./hook", "http://169.254.169.254/latest/meta-data"} {
+		assert.Equal(t, ErrAddressDenied, policy.CheckURL(url), url)
+	}
+
+	_, err = NewAddressPolicy(nil, []string{"10.0.0.0"})
+	assert.Error(t, err)
+}
+
+func TestDeliverConcurrently(t *testing.T) {
+	ctx := context.Background()
+	// slow receiver responds only after fast one got its request, it fails if deliveries are sequential
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+			w.WriteHeader(http.StatusNoContent)
+		case <-time.After(5 * time.Second):
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(release)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer fast.Close()
+	s, message, slowId := setup(t, slow.URL)
+	fastId, err := s.AddWebhook(ctx, message.AuthorId, message.ChatId, fast.URL, "secret", nil)
+	assert.NoError(t, err)
+	d := newTestDispatcher(s)
+
+	assert.NoError(t, d.Notify(ctx, storage.WebhookMessageCreated, message))
+	delivered, err := d.DeliverDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, delivered)
+	for _, webhookId := range []int64{slowId, fastId} {
+		deliveries, err := s.GetWebhookDeliveries(ctx, message.AuthorId, webhookId, storage.DeliveryDelivered, 10)
+		assert.NoError(t, err)
+		assert.Len(t, deliveries, 1, "webhook %d", webhookId)
+	}
+}